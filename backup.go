@@ -0,0 +1,122 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package sqlitestdb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+
+	"braces.dev/errtrace"
+)
+
+// backupInto clones the template database opened as baseDB into the instance
+// database with SQLite's Online Backup API, for [CloneBackup]. It reports false,
+// without cloning, if the connections of the driver don't expose the API, or
+// the databases are encrypted with a [Config.Key].
+//
+// sqlitestdb doesn't depend on any specific driver, so the raw connections are
+// probed for the methods of the drivers known to expose the API, see
+// [newBackup], instead of asserting their types.
+func backupInto(ctx context.Context, baseDB *sql.DB, instance Config) (ok bool, err error) {
+	// The destination is opened by the driver, without the key, and SQLCipher
+	// can't back up an encrypted database into it.
+	if instance.Key != "" {
+		return false, nil
+	}
+
+	conn, err := baseDB.Conn(ctx)
+	if err != nil {
+		return false, errtrace.Wrap(err)
+	}
+	defer conn.Close()
+
+	err = conn.Raw(func(raw any) error {
+		bk, err := newBackup(baseDB.Driver(), DriverConn(raw), instance.URI())
+		if err != nil || bk == nil {
+			return errtrace.Wrap(err)
+		}
+
+		ok = true
+		return errtrace.Wrap(bk.run())
+	})
+
+	return ok, errtrace.Wrap(err)
+}
+
+// backup is an online backup in progress, started by [newBackup].
+type backup struct {
+	step   reflect.Value // func(pages int) (bool, error), with any integer type.
+	finish func() error
+	close  func() error
+}
+
+// newBackup starts an online backup of the raw connection src into the database
+// at dstURI, or returns nil if the driver doesn't support it. Supported are:
+//
+//   - modernc.org/sqlite, with (*conn).NewBackup(dstURI string) (*Backup, error).
+//   - github.com/mattn/go-sqlite3, with (*SQLiteConn).Backup(dest string,
+//     srcConn *SQLiteConn, src string) (*SQLiteBackup, error), which is called
+//     on a new connection to the destination.
+func newBackup(drv driver.Driver, src any, dstURI string) (*backup, error) {
+	v := reflect.ValueOf(src)
+
+	if m := v.MethodByName("NewBackup"); m.IsValid() && m.Type().NumIn() == 1 && m.Type().In(0).Kind() == reflect.String {
+		return errtrace.Wrap2(backupFrom(m.Call([]reflect.Value{reflect.ValueOf(dstURI)}), nil))
+	}
+
+	if m := v.MethodByName("Backup"); m.IsValid() && m.Type().NumIn() == 3 && m.Type().In(1) == v.Type() {
+		dst, err := drv.Open(dstURI)
+		if err != nil {
+			return nil, errtrace.Wrap(err)
+		}
+
+		main := reflect.ValueOf("main")
+		bk, err := backupFrom(reflect.ValueOf(dst).MethodByName("Backup").Call([]reflect.Value{main, v, main}), dst.Close)
+		if err != nil {
+			dst.Close()
+			return nil, errtrace.Wrap(err)
+		}
+		return bk, nil
+	}
+
+	return nil, nil
+}
+
+// backupFrom wraps the results of the method starting a backup.
+func backupFrom(out []reflect.Value, closeDst func() error) (*backup, error) {
+	if len(out) != 2 {
+		return nil, errtrace.Errorf("unsupported backup method returning %d values", len(out))
+	}
+	if err, _ := out[1].Interface().(error); err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+
+	step := out[0].MethodByName("Step")
+	finisher, ok := out[0].Interface().(interface{ Finish() error })
+	if !step.IsValid() || step.Type().NumIn() != 1 || !step.Type().In(0).ConvertibleTo(reflect.TypeOf(0)) || !ok {
+		return nil, errtrace.Errorf("unsupported backup type %s", out[0].Type())
+	}
+
+	bk := &backup{step: step, finish: finisher.Finish, close: closeDst}
+	if bk.close == nil {
+		bk.close = func() error { return nil }
+	}
+	return bk, nil
+}
+
+// run copies all pages in a single step, as the template isn't written to, and
+// releases the backup.
+func (bk *backup) run() error {
+	out := bk.step.Call([]reflect.Value{reflect.ValueOf(-1).Convert(bk.step.Type().In(0))})
+
+	var stepErr error
+	if len(out) == 2 {
+		stepErr, _ = out[1].Interface().(error)
+	}
+
+	return errtrace.Wrap(errors.Join(stepErr, bk.finish(), bk.close()))
+}
@@ -0,0 +1,145 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package sqlitestdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+
+	"braces.dev/errtrace"
+)
+
+// Bench creates instance databases inside the loop of a benchmark. Unlike [New],
+// it doesn't log the URI of each instance database, or register a cleanup for
+// each of them. Instead, they are all closed and removed once the benchmark
+// has finished.
+type Bench struct {
+	b     *testing.B
+	o     *options
+	tpl   templateState
+	tplDB *sql.DB
+
+	mu        sync.Mutex
+	instances []string
+	dbs       []*sql.DB
+}
+
+// NewForBench creates the template for the migrator, or reuses an existing one,
+// and resets the timer of the benchmark, so that creating the template isn't
+// part of the results. If there is an error creating the template, the
+// benchmark will be immediately failed with [testing.TB.Fatalf].
+//
+//	func BenchmarkQuery(b *testing.B) {
+//		bench := sqlitestdb.NewForBench(b, config, migrator)
+//		for i := 0; i < b.N; i++ {
+//			db := bench.New()
+//			// ...
+//			db.Close()
+//		}
+//	}
+func NewForBench(b *testing.B, config Config, migrator Migrator, opts ...Option) *Bench {
+	b.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bench := &Bench{b: b, o: newOptions(opts)}
+	bench.o.logf = b.Logf
+	tpl, err := loadTemplate(ctx, config, migrator, bench.o)
+	if err != nil {
+		b.Fatalf("could not create template database: %+v", fdError(err))
+	}
+	bench.tpl = *tpl
+
+	bench.tplDB, err = tpl.connect()
+	if err != nil {
+		b.Fatalf("could not open template database: %+v", err)
+	}
+
+	b.Cleanup(func() {
+		if err := bench.close(); err != nil {
+			b.Fatalf("%+v", err)
+		}
+	})
+
+	b.ResetTimer()
+	return bench
+}
+
+// CloneInstance clones the template into a new instance database, and returns
+// its configuration. This is the only step of [Bench.New] that takes
+// noticeable time, so benchmarks that want to exclude it can surround it with
+// [testing.B.StopTimer] and [testing.B.StartTimer].
+func (bn *Bench) CloneInstance() *Config {
+	bn.b.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir := bn.tpl.dir
+	if bn.o.instanceDir != "" {
+		dir = bn.o.instanceDir
+	}
+
+	instance, err := createInstance(ctx, bn.tplDB, bn.tpl, dir, bn.b.Name(), bn.o)
+	if err != nil {
+		bn.b.Fatalf("could not create instance: %+v", fdError(err))
+	}
+
+	bn.mu.Lock()
+	bn.instances = append(bn.instances, instance.Database)
+	bn.mu.Unlock()
+
+	return instance
+}
+
+// New clones the template into a new instance database, and connects to it. The
+// connection may be closed by the benchmark, and is otherwise closed once the
+// benchmark has finished. Keeping many connections open at the same time may
+// exhaust the file descriptors of the process.
+func (bn *Bench) New() *sql.DB {
+	bn.b.Helper()
+	instance := bn.CloneInstance()
+
+	db, err := instance.Connect()
+	if err != nil {
+		bn.b.Fatalf("could not connect to instance database: %+v", err)
+	}
+	limitPool(db)
+	trackDB(db, instance)
+
+	bn.mu.Lock()
+	bn.dbs = append(bn.dbs, db)
+	bn.mu.Unlock()
+
+	if err := bn.o.seed(context.Background(), db); err != nil {
+		bn.b.Fatalf("seed failed for instance database %q: %+v", instance.Database, err)
+	}
+
+	return db
+}
+
+// close closes the connections, and removes the instance databases, created
+// during the benchmark.
+func (bn *Bench) close() error {
+	bn.mu.Lock()
+	defer bn.mu.Unlock()
+
+	var errs []error
+	for _, db := range bn.dbs {
+		if err := closeDB(db); err != nil {
+			errs = append(errs, errtrace.Wrap(err))
+		}
+	}
+	if err := bn.tplDB.Close(); err != nil {
+		errs = append(errs, errtrace.Wrap(err))
+	}
+
+	for _, path := range bn.instances {
+		removeInstance(bn.b, path)
+	}
+
+	return errors.Join(errs...)
+}
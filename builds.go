@@ -0,0 +1,70 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package sqlitestdb
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"braces.dev/errtrace"
+)
+
+// buildSlots bounds the number of templates migrated at the same time by this
+// program, each migration holding one of the slots of the channel. A nil
+// channel doesn't bound them.
+var buildSlots atomic.Pointer[chan struct{}]
+
+// buildSlotsFromEnv sets the bound from the SQLITESTDB_MAX_TEMPLATE_BUILDS
+// environment variable, unless [SetMaxConcurrentTemplateBuilds] was called
+// first.
+var buildSlotsFromEnv sync.Once
+
+// SetMaxConcurrentTemplateBuilds bounds the number of templates this program
+// migrates at the same time to n, so that test runs using many different
+// migrators don't overwhelm the machine with I/O. Templates that already exist,
+// whether created by this program or another, are used without waiting. A
+// value of n less than 1 removes the bound, which is the default.
+//
+// The bound can also be set with the environment variable
+// SQLITESTDB_MAX_TEMPLATE_BUILDS. It applies to migrations started after it is
+// set.
+func SetMaxConcurrentTemplateBuilds(n int) {
+	buildSlotsFromEnv.Do(func() {})
+	setBuildSlots(n)
+}
+
+func setBuildSlots(n int) {
+	if n < 1 {
+		buildSlots.Store(nil)
+		return
+	}
+
+	slots := make(chan struct{}, n)
+	buildSlots.Store(&slots)
+}
+
+// acquireBuild waits for a slot to migrate a template, as bounded by
+// [SetMaxConcurrentTemplateBuilds], and returns a function releasing it.
+func acquireBuild(ctx context.Context) (release func(), err error) {
+	buildSlotsFromEnv.Do(func() {
+		if n, err := strconv.Atoi(os.Getenv("SQLITESTDB_MAX_TEMPLATE_BUILDS")); err == nil {
+			setBuildSlots(n)
+		}
+	})
+
+	slots := buildSlots.Load()
+	if slots == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case *slots <- struct{}{}:
+		return func() { <-*slots }, nil
+	case <-ctx.Done():
+		return nil, errtrace.Wrap(ctx.Err())
+	}
+}
@@ -0,0 +1,280 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package sqlitestdb
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"braces.dev/errtrace"
+)
+
+// defaultTemplateMaxAge and defaultInstanceMaxAge are the ages after which the
+// automatic sweeps enabled by SQLITESTDB_CLEAN_TEMPLATES and
+// SQLITESTDB_CLEAN_INSTANCES remove files, unless they specify a duration.
+const (
+	defaultTemplateMaxAge = 7 * 24 * time.Hour
+	defaultInstanceMaxAge = 24 * time.Hour
+)
+
+// templateName matches the names of template databases, including the temporary
+// files of templates still being created, and instanceName the names of the
+// instance databases cloned from them.
+var (
+	templateName = regexp.MustCompile(`^sqlitestdb_tpl_.+\.sqlite(\.tmp\.[0-9]+)?$`)
+	instanceName = regexp.MustCompile(`^sqlitestdb_tpl_.+_inst_(.+_)?[0-9a-f]+\.sqlite$`)
+)
+
+// activeTemplates contains the paths of the templates used by this program, so
+// that they are never removed by [CleanTemplates].
+var activeTemplates sync.Map // map[string]struct{}
+
+// CleanTemplates removes template databases from [os.TempDir] whose
+// modification time is older than olderThan, along with their sidecar files.
+// Reusing a template refreshes its modification time, so templates still used
+// by other test runs are kept. The lock files of templates that don't exist,
+// such as because their migrations failed, are removed once they are as old,
+// unless another process holds them.
+//
+// Only files that can be positively identified as sqlitestdb templates, by both
+// their name and the application ID in their SQLite file header, are removed.
+// Templates used by this program, including the one currently being created,
+// and instance databases are never removed. Errors removing individual files
+// are collected and returned, after attempting to remove all stale templates.
+//
+// Setting the environment variable SQLITESTDB_CLEAN_TEMPLATES runs a sweep,
+// best-effort, when the first template of a program is created. Its value is
+// either a duration accepted by [time.ParseDuration], or a boolean such as "1"
+// to remove templates older than 7 days.
+func CleanTemplates(olderThan time.Duration) error {
+	dir := os.TempDir()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	var errs []error
+	cutoff := time.Now().Add(-olderThan)
+	for _, entry := range entries {
+		if name, ok := strings.CutSuffix(entry.Name(), ".lock"); ok && entry.Type().IsRegular() && isTemplateName(name) {
+			if err := cleanLockFile(filepath.Join(dir, name), entry, cutoff); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		if !entry.Type().IsRegular() || !isTemplateName(entry.Name()) {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		if _, active := activeTemplates.Load(path); active {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+
+		if !isStamped(path) {
+			continue
+		}
+
+		if err := removeDatabase(path); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := removeLockFile(path); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errtrace.Wrap(errors.Join(errs...))
+}
+
+// cleanLockFile removes the lock file entry of the template at path, for
+// [CleanTemplates], if it is older than cutoff, and the template doesn't exist,
+// such as when its migrations failed. Lock files are empty, and a lock file
+// still held by another process is kept.
+func cleanLockFile(path string, entry fs.DirEntry, cutoff time.Time) error {
+	if _, active := activeTemplates.Load(path); active {
+		return nil
+	}
+
+	info, err := entry.Info()
+	if err != nil || !info.ModTime().Before(cutoff) || info.Size() != 0 {
+		return nil
+	}
+
+	if _, err := os.Lstat(path); !errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	return errtrace.Wrap(removeLockFile(path))
+}
+
+// CleanInstances removes instance databases from [os.TempDir] whose
+// modification time is older than olderThan, along with their sidecar files.
+// Instance databases are removed by the cleanup of the test using them, so only
+// those of test binaries that were killed, or crashed, before their cleanups
+// ran are left behind, and pile up over time.
+//
+// Only files that can be positively identified as sqlitestdb instance
+// databases, by both their name and the application ID in their SQLite file
+// header, are removed. Templates, and the instance databases of this program,
+// including those kept for a failed test, are never removed. An instance
+// database still open by another process is only removed if it is older than
+// olderThan, and platforms that refuse to remove open files, such as Windows,
+// report it as an error. Errors removing individual files are collected and
+// returned, after attempting to remove all stale instance databases.
+//
+// Setting the environment variable SQLITESTDB_CLEAN_INSTANCES runs a sweep,
+// best-effort, when the first template of a program is created. Its value is
+// either a duration accepted by [time.ParseDuration], or a boolean such as "1"
+// to remove instance databases older than a day.
+func CleanInstances(olderThan time.Duration) error {
+	dir := os.TempDir()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	var errs []error
+	cutoff := time.Now().Add(-olderThan)
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !instanceName.MatchString(entry.Name()) {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		if _, ours := instances.Load(path); ours {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+
+		if !isStamped(path) {
+			continue
+		}
+
+		if err := removeDatabase(path); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errtrace.Wrap(errors.Join(errs...))
+}
+
+// CleanupTemplates removes the template databases used by this program, along
+// with their sidecar files, so that no sqlitestdb files are left behind after the
+// tests have run. It is intended to be called from TestMain after all tests have
+// run, and stops the pools of [WithInstancePool] first, like [ClosePools].
+//
+//	func TestMain(m *testing.M) {
+//		code := m.Run()
+//		if err := sqlitestdb.CleanupTemplates(false); err != nil {
+//			fmt.Fprintln(os.Stderr, err)
+//		}
+//		os.Exit(code)
+//	}
+//
+// Templates are cached across runs, so removing them means the next run has to
+// run the migrations again. Templates reused from a previous run, or created by
+// another process, may still be needed by a test binary running concurrently,
+// such as another package of the same "go test ./..." invocation, and are only
+// removed if force is true. Templates created by this program are always
+// removed, along with their lock files, as are the lock files of templates this
+// program could not create. Errors removing individual templates are collected
+// and returned, after attempting to remove all of them.
+func CleanupTemplates(force bool) error {
+	ClosePools()
+
+	var errs []error
+	templates.Range(func(key string, tpl *templateState) bool {
+		if !tpl.created && !force {
+			return true
+		}
+
+		if err := removeDatabase(tpl.config.Database); err != nil {
+			errs = append(errs, err)
+			return true
+		}
+		if err := removeLockFile(tpl.config.Database); err != nil {
+			errs = append(errs, err)
+		}
+
+		// Tests still running, which they shouldn't be, rebuild the template.
+		templates.Forget(key)
+		activeTemplates.Delete(tpl.config.Database)
+		return true
+	})
+
+	// The templates that could not be created leave their lock files behind.
+	activeTemplates.Range(func(key, _ any) bool {
+		path := key.(string)
+		if _, err := os.Lstat(path); errors.Is(err, fs.ErrNotExist) {
+			if err := removeLockFile(path); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return true
+	})
+
+	return errtrace.Wrap(errors.Join(errs...))
+}
+
+// sweepOnce guards the automatic sweeps, so they run at most once per program.
+var sweepOnce sync.Once
+
+// sweep runs [CleanTemplates] and [CleanInstances] if the
+// SQLITESTDB_CLEAN_TEMPLATES and SQLITESTDB_CLEAN_INSTANCES environment variables
+// enable them, at most once per program execution. As the sweeps are
+// best-effort, errors are ignored.
+func sweep() {
+	sweepOnce.Do(func() {
+		if olderThan, ok := sweepAge("SQLITESTDB_CLEAN_TEMPLATES", defaultTemplateMaxAge); ok {
+			CleanTemplates(olderThan)
+		}
+		if olderThan, ok := sweepAge("SQLITESTDB_CLEAN_INSTANCES", defaultInstanceMaxAge); ok {
+			CleanInstances(olderThan)
+		}
+	})
+}
+
+// sweepAge returns the age of the files removed by the sweep enabled by the
+// environment variable key, either a duration, or a boolean to use defaultAge.
+// It reports false if the sweep isn't enabled.
+func sweepAge(key string, defaultAge time.Duration) (time.Duration, bool) {
+	value := os.Getenv(key)
+	if value == "" {
+		return 0, false
+	}
+
+	olderThan, err := time.ParseDuration(value)
+	if err != nil {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil || !enabled {
+			return 0, false
+		}
+		olderThan = defaultAge
+	}
+
+	return olderThan, true
+}
+
+// isTemplateName reports whether name is the file name of a template database,
+// as created by getOrCreateTemplate, and not of an instance database.
+func isTemplateName(name string) bool {
+	return templateName.MatchString(name) && !instanceName.MatchString(name)
+}
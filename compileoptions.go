@@ -0,0 +1,102 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package sqlitestdb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"braces.dev/errtrace"
+)
+
+// CompileOptions returns the options SQLite was compiled with, as reported by
+// "PRAGMA compile_options", sorted, and without their "SQLITE_" prefix, such as
+// "ENABLE_FTS5" or "THREADSAFE=1". Which extensions are available, such as FTS5
+// or RTREE, depends on the driver, and on the tags it was built with.
+//
+// For databases returned by [New] and the other constructors of this package,
+// the options of their driver are cached, so no query is run.
+func CompileOptions(ctx context.Context, db *sql.DB) ([]string, error) {
+	if instance := ConfigFor(db); instance != nil {
+		if engine, err := engines.Get(instance.Driver); engine != nil && err == nil {
+			return slices.Clone(engine.compileOptions), nil
+		}
+	}
+
+	return errtrace.Wrap2(readCompileOptions(ctx, db))
+}
+
+// HasCompileOption reports whether SQLite was compiled with the option, with or
+// without its "SQLITE_" prefix, see [CompileOptions]. An option without a value,
+// such as "THREADSAFE", matches the option with any value, such as
+// "THREADSAFE=1".
+func HasCompileOption(ctx context.Context, db *sql.DB, opt string) (bool, error) {
+	options, err := CompileOptions(ctx, db)
+	if err != nil {
+		return false, errtrace.Wrap(err)
+	}
+
+	return hasCompileOption(options, opt), nil
+}
+
+// SkipIfMissing skips the test with [testing.TB.Skipf], unless SQLite was
+// compiled with the option, see [HasCompileOption], such as "ENABLE_FTS5" for
+// tests using full-text search. If the options can't be determined, the test
+// fails with [testing.TB.Fatalf].
+func SkipIfMissing(t testing.TB, db *sql.DB, opt string) {
+	t.Helper()
+	options, err := CompileOptions(context.Background(), db)
+	if err != nil {
+		t.Fatalf("could not determine SQLite compile options: %+v", err)
+	}
+
+	if !hasCompileOption(options, opt) {
+		driver := "the driver"
+		if instance := ConfigFor(db); instance != nil {
+			driver = fmt.Sprintf("the driver %q", instance.Driver)
+		}
+		t.Skipf("SQLite of %s was not compiled with %s (compile options: %s)", driver, strings.TrimPrefix(opt, "SQLITE_"), strings.Join(options, ", "))
+	}
+}
+
+// hasCompileOption reports whether options include opt, see [HasCompileOption].
+func hasCompileOption(options []string, opt string) bool {
+	opt = strings.TrimPrefix(opt, "SQLITE_")
+	for _, option := range options {
+		name, _, _ := strings.Cut(option, "=")
+		if strings.EqualFold(option, opt) || !strings.Contains(opt, "=") && strings.EqualFold(name, opt) {
+			return true
+		}
+	}
+	return false
+}
+
+// readCompileOptions runs "PRAGMA compile_options" on db, and returns the
+// options sorted.
+func readCompileOptions(ctx context.Context, db *sql.DB) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "PRAGMA compile_options")
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+	defer rows.Close()
+
+	var options []string
+	for rows.Next() {
+		var option string
+		if err := rows.Scan(&option); err != nil {
+			return nil, errtrace.Wrap(err)
+		}
+		options = append(options, option)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+
+	slices.Sort(options)
+	return options, nil
+}
@@ -0,0 +1,133 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package sqlitestdb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"slices"
+	"strings"
+
+	"braces.dev/errtrace"
+)
+
+// knownDriver is a SQLite driver supported by sqlitestdb, and the package
+// registering it, which is suggested when the driver isn't registered.
+type knownDriver struct {
+	name string
+	pkg  string
+}
+
+// knownDrivers are the SQLite drivers supported by sqlitestdb, in order of
+// preference.
+var knownDrivers = []knownDriver{
+	{name: "sqlite3", pkg: "github.com/mattn/go-sqlite3"},
+	{name: "sqlite", pkg: "modernc.org/sqlite"},
+	{name: "libsql", pkg: "github.com/tursodatabase/go-libsql"},
+}
+
+// registeredDriver returns the name of the first supported driver registered
+// by the program.
+func registeredDriver() (string, bool) {
+	registered := sql.Drivers()
+	for _, driver := range knownDrivers {
+		if slices.Contains(registered, driver.name) {
+			return driver.name, true
+		}
+	}
+	return "", false
+}
+
+// resolveDriver returns the driver of config, or if it is empty, the name of the
+// only supported driver registered by the program, see [Config.Driver]. It
+// fails if the driver isn't registered, unless the config has a Connector.
+func resolveDriver(config Config) (string, error) {
+	if config.Driver == "" {
+		return errtrace.Wrap2(detectDriver(sql.Drivers()))
+	}
+	if config.Connector != nil {
+		return config.Driver, nil
+	}
+
+	return config.Driver, errtrace.Wrap(checkDriver(config.Driver, sql.Drivers()))
+}
+
+// detectDriver returns the only supported driver among the registered drivers.
+// If none or several are registered, the error lists the registered drivers, so
+// that the right one can be set in [Config.Driver].
+func detectDriver(registered []string) (string, error) {
+	var found []string
+	for _, driver := range knownDrivers {
+		if slices.Contains(registered, driver.name) {
+			found = append(found, driver.name)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return "", errtrace.Errorf("no driver is set in Config.Driver, and no SQLite driver is registered; import one, such as _ \"github.com/mattn/go-sqlite3\" or _ \"modernc.org/sqlite\" (registered drivers: %q)", registered)
+	case 1:
+		return found[0], nil
+	default:
+		return "", errtrace.Errorf("no driver is set in Config.Driver, and several SQLite drivers are registered, %q; set Config.Driver to the one to use (registered drivers: %q)", found, registered)
+	}
+}
+
+// checkDriver returns an error matching [ErrDriverOpen] if driver isn't among
+// the registered drivers, suggesting the import that registers it, or the names
+// of the supported drivers, instead of the "sql: unknown driver" of [sql.Open].
+func checkDriver(driver string, registered []string) error {
+	if slices.Contains(registered, driver) {
+		return nil
+	}
+
+	for _, known := range knownDrivers {
+		if known.name == driver {
+			return errtrace.Errorf("%w: driver %q is not registered; did you forget to import _ %q? registered drivers: %q", ErrDriverOpen, driver, known.pkg, registered)
+		}
+	}
+
+	names := make([]string, 0, len(knownDrivers))
+	for _, known := range knownDrivers {
+		names = append(names, fmt.Sprintf("%q (%s)", known.name, known.pkg))
+	}
+	return errtrace.Errorf("%w: driver %q is not registered, and isn't a known SQLite driver, such as %s; registered drivers: %q", ErrDriverOpen, driver, strings.Join(names, ", "), registered)
+}
+
+// DriverConnector returns a [driver.DriverContext] for [Config.Connector],
+// which opens the databases with drv, such as a *sqlite3.SQLiteDriver of
+// mattn/go-sqlite3 with a ConnectHook, without registering it.
+func DriverConnector(drv driver.Driver) driver.DriverContext {
+	if dc, ok := drv.(driver.DriverContext); ok {
+		return dc
+	}
+	return dsnDriver{drv: drv}
+}
+
+// dsnDriver opens [dsnConnector] connectors for drivers that don't implement
+// [driver.DriverContext].
+type dsnDriver struct {
+	drv driver.Driver
+}
+
+func (d dsnDriver) OpenConnector(dsn string) (driver.Connector, error) {
+	return dsnConnector{drv: d.drv, dsn: dsn}, nil
+}
+
+// dsnConnector is the [driver.Connector] of drivers that don't implement
+// [driver.DriverContext].
+type dsnConnector struct {
+	drv driver.Driver
+	dsn string
+}
+
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) {
+	return errtrace.Wrap2(c.drv.Open(c.dsn))
+}
+
+func (c dsnConnector) Driver() driver.Driver {
+	return c.drv
+}
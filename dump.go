@@ -0,0 +1,254 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package sqlitestdb
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+
+	"braces.dev/errtrace"
+)
+
+// DumpSchema returns a canonical text representation of the schema of the
+// database, for golden tests, or comparing the schemas created by migrations.
+// The output is the same for every driver, and only changes with the schema.
+//
+// It starts with the application_id and user_version PRAGMAs, followed by the
+// statements creating the tables, indexes, triggers and views of the database,
+// one per line, sorted by type and then by name. Runs of whitespace in the
+// statements, outside of quoted strings and identifiers, are collapsed to a
+// single space.
+//
+// The internal objects of SQLite, whose names start with "sqlite_", such as
+// sqlite_sequence, are left out, as are the excluded tables, along with their
+// indexes and triggers, such as the tables in which migration frameworks
+// record the applied migrations. So is the bookkeeping of sqlitestdb in
+// templates and instance databases: the table recording their [Meta], and
+// their application ID, which is reported as 0, so that their dump is the
+// same as that of a database migrated without sqlitestdb.
+func DumpSchema(ctx context.Context, db *sql.DB, exclude ...string) (string, error) {
+	var b strings.Builder
+	for _, pragma := range []string{"application_id", "user_version"} {
+		var value int64
+		if err := db.QueryRowContext(ctx, "PRAGMA "+pragma).Scan(&value); err != nil {
+			return "", errtrace.Wrap(err)
+		}
+		if pragma == "application_id" && value == applicationID {
+			value = 0
+		}
+		fmt.Fprintf(&b, "PRAGMA %s = %d;\n", pragma, value)
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT tbl_name, sql
+		FROM sqlite_master
+		WHERE name NOT LIKE 'sqlite\_%' ESCAPE '\' AND sql IS NOT NULL
+		ORDER BY type, name`)
+	if err != nil {
+		return "", errtrace.Wrap(err)
+	}
+	defer rows.Close()
+
+	skip := map[string]bool{markerTable: true}
+	for _, table := range exclude {
+		skip[table] = true
+	}
+
+	for rows.Next() {
+		var table, stmt string
+		if err := rows.Scan(&table, &stmt); err != nil {
+			return "", errtrace.Wrap(err)
+		}
+		if skip[table] {
+			continue
+		}
+		fmt.Fprintf(&b, "%s;\n", normalizeSQL(stmt))
+	}
+	if err := rows.Err(); err != nil {
+		return "", errtrace.Wrap(err)
+	}
+
+	return b.String(), nil
+}
+
+// Dump writes a text dump of the database to w, like the ".dump" command of
+// the sqlite3 shell, such as to attach the database of a failed test to a bug
+// report. Executing the dump, such as with [SchemaFile], creates a copy of the
+// database.
+//
+// The dump starts with the user_version PRAGMA, if set, followed by a
+// transaction with the statements creating each table, in the order they were
+// created, followed by the INSERT statements of its rows. The rows of
+// sqlite_sequence, recording the last rowid of AUTOINCREMENT tables, replace
+// those created by the inserts, and the indexes, triggers and views are
+// created last. Values are written as SQL literals by SQLite's quote function,
+// so that the dump is the same for every driver, and two dumps can be diffed.
+//
+// The rows are streamed to w, inside a transaction of db, so that the dump is
+// consistent. The internal objects of SQLite are left out, except for
+// sqlite_sequence, as are the shadow tables of virtual tables, whose rows are
+// inserted through the virtual tables instead, and the table recording the
+// [Meta] of templates and instance databases.
+func Dump(ctx context.Context, db *sql.DB, w io.Writer) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+	defer tx.Rollback()
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString("PRAGMA foreign_keys=OFF;\n")
+	var userVersion int64
+	if err := tx.QueryRowContext(ctx, "PRAGMA user_version").Scan(&userVersion); err != nil {
+		return errtrace.Wrap(err)
+	}
+	if userVersion != 0 {
+		fmt.Fprintf(bw, "PRAGMA user_version=%d;\n", userVersion)
+	}
+	bw.WriteString("BEGIN TRANSACTION;\n")
+
+	tables, err := queryStrings(ctx, tx, `
+		SELECT name, sql
+		FROM sqlite_master
+		WHERE type = 'table' AND sql IS NOT NULL AND name != ?
+			AND (name NOT LIKE 'sqlite\_%' ESCAPE '\' OR name = 'sqlite_sequence')
+			AND name NOT IN (SELECT name FROM pragma_table_list WHERE schema = 'main' AND type = 'shadow')
+		ORDER BY name = 'sqlite_sequence', rowid`, markerTable)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+	for i := 0; i < len(tables); i += 2 {
+		name, stmt := tables[i], tables[i+1]
+		if name == "sqlite_sequence" {
+			bw.WriteString("DELETE FROM sqlite_sequence;\n")
+		} else {
+			fmt.Fprintf(bw, "%s;\n", stmt)
+		}
+		if err := dumpRows(ctx, tx, bw, name); err != nil {
+			return errtrace.Errorf("table %s: %w", name, err)
+		}
+	}
+
+	others, err := queryStrings(ctx, tx, `
+		SELECT sql
+		FROM sqlite_master
+		WHERE type IN ('index', 'trigger', 'view') AND sql IS NOT NULL AND tbl_name != ?
+		ORDER BY type DESC, rowid`, markerTable)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+	for _, stmt := range others {
+		fmt.Fprintf(bw, "%s;\n", stmt)
+	}
+	bw.WriteString("COMMIT;\n")
+
+	return errtrace.Wrap(bw.Flush())
+}
+
+// dumpRows writes an INSERT statement for each row of the table. The generated
+// columns, and the hidden columns of virtual tables, are left out, as they
+// can't be inserted, and VALUES doesn't list them.
+func dumpRows(ctx context.Context, tx *sql.Tx, w *bufio.Writer, table string) error {
+	columns, err := queryStrings(ctx, tx, "SELECT name FROM pragma_table_xinfo(?) WHERE hidden = 0 ORDER BY cid", table)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	values := make([]string, len(columns))
+	for i, column := range columns {
+		values[i] = "quote(" + quoteIdentifier(column) + ")"
+	}
+	rows, err := tx.QueryContext(ctx, "SELECT "+strings.Join(values, " || ',' || ")+" FROM "+quoteIdentifier(table))
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+	defer rows.Close()
+
+	// sqlite_sequence is named as the sqlite3 shell does, so that SchemaFile
+	// recognizes its rows.
+	name := quoteIdentifier(table)
+	if table == "sqlite_sequence" {
+		name = table
+	}
+	for rows.Next() {
+		var row string
+		if err := rows.Scan(&row); err != nil {
+			return errtrace.Wrap(err)
+		}
+		fmt.Fprintf(w, "INSERT INTO %s VALUES(%s);\n", name, row)
+	}
+
+	return errtrace.Wrap(rows.Err())
+}
+
+// queryStrings returns the columns of every row of the query, in order.
+func queryStrings(ctx context.Context, tx *sql.Tx, query string, args ...any) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+
+	var out []string
+	for rows.Next() {
+		row := make([]string, len(columns))
+		dest := make([]any, len(columns))
+		for i := range row {
+			dest[i] = &row[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, errtrace.Wrap(err)
+		}
+		out = append(out, row...)
+	}
+
+	return out, errtrace.Wrap(rows.Err())
+}
+
+// quoteIdentifier quotes the name of a table or column for a statement.
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// normalizeSQL collapses the runs of whitespace of a statement outside of
+// quoted strings and identifiers, and trims the statement.
+func normalizeSQL(stmt string) string {
+	var (
+		b     strings.Builder
+		quote rune
+		space bool
+	)
+	for _, r := range strings.TrimSpace(stmt) {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+		case r == '[':
+			quote = ']'
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			space = true
+			continue
+		}
+
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
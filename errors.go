@@ -0,0 +1,60 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package sqlitestdb
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The classes of errors returned, and reported to the test, when a database
+// can't be created. They are matched with [errors.Is], as each is wrapped with
+// the error that caused it.
+var (
+	// ErrSQLiteTooOld is matched by a [*SQLiteVersionError].
+	ErrSQLiteTooOld = errors.New("SQLite version too old")
+	// ErrTemplateCreate is matched by a [*TemplateError].
+	ErrTemplateCreate = errors.New("could not create template")
+	// ErrClone is returned when the template can't be cloned into an instance
+	// database.
+	ErrClone = errors.New("could not clone template")
+	// ErrDriverOpen is returned when the driver can't open a database.
+	ErrDriverOpen = errors.New("could not open database")
+)
+
+// SQLiteVersionError is returned when the SQLite library used by the driver is
+// older than the minimum supported version.
+type SQLiteVersionError struct {
+	Found   string // The version of the driver's SQLite library, such as "v3.7.17".
+	Minimum string // The minimum supported version.
+}
+
+func (e *SQLiteVersionError) Error() string {
+	return fmt.Sprintf("SQLite version too old (found %s, minimium required %s)", e.Found, e.Minimum)
+}
+
+// Is reports whether target is [ErrSQLiteTooOld].
+func (e *SQLiteVersionError) Is(target error) bool {
+	return target == ErrSQLiteTooOld
+}
+
+// TemplateError is returned when the template at Path can't be created, such
+// as when the migrator fails.
+type TemplateError struct {
+	Path string
+	Err  error
+}
+
+func (e *TemplateError) Error() string {
+	return fmt.Sprintf("template %q: %v", e.Path, e.Err)
+}
+
+func (e *TemplateError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is [ErrTemplateCreate].
+func (e *TemplateError) Is(target error) bool {
+	return target == ErrTemplateCreate
+}
@@ -0,0 +1,47 @@
+package sqlitestdb_test
+
+import (
+	"database/sql"
+	"io"
+	"net/http"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/terinjokes/sqlitestdb"
+)
+
+// ExampleHandler should be called "TestCatsHandler" in your code, but is
+// renamed here for GoDoc.
+func ExampleHandler() {
+	t := &testing.T{}
+	t.Parallel()
+	conf := sqlitestdb.Config{Driver: "sqlite3"}
+
+	// The handler under test is built with the test's own database.
+	srv := sqlitestdb.Handler(t, conf, defaultMigrator(), func(db *sql.DB) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var name string
+			err := db.QueryRowContext(r.Context(), "SELECT name FROM cats WHERE id = ?", r.URL.Query().Get("id")).Scan(&name)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			io.WriteString(w, name)
+		})
+	})
+
+	resp, err := srv.Client().Get(srv.URL + "/cats?id=1")
+	if err != nil {
+		t.Fatalf("expected nil error: %+v\n", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("expected nil error: %+v\n", err)
+	}
+
+	if string(body) != "daisy" {
+		t.Fatalf("expected cat to be 'daisy'")
+	}
+}
@@ -0,0 +1,54 @@
+package sqlitestdb_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/terinjokes/sqlitestdb"
+)
+
+// createSchema builds the schema with Go code, such as generated DDL helpers.
+func createSchema(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, "CREATE TABLE greetings (message TEXT)")
+	return err
+}
+
+// ExampleMigrateFunc should be called "TestMigrateFunc" in your code, but is
+// renamed here for GoDoc.
+func ExampleMigrateFunc() {
+	t := &testing.T{}
+	t.Parallel()
+	conf := sqlitestdb.Config{Driver: "sqlite3"}
+
+	// The hash must be changed whenever createSchema is, otherwise the template
+	// created by the previous version is reused.
+	migrator := sqlitestdb.MigrateFunc("greetings-v1", createSchema)
+	db := sqlitestdb.New(t, conf, migrator)
+
+	if _, err := db.Exec("INSERT INTO greetings VALUES ('hellord!')"); err != nil {
+		t.Fatalf("expected nil error: %+v\n", err)
+	}
+}
+
+// ExampleMigrateFuncKeyed should be called "TestMigrateFuncKeyed" in your code,
+// but is renamed here for GoDoc.
+func ExampleMigrateFuncKeyed() {
+	t := &testing.T{}
+	t.Parallel()
+	conf := sqlitestdb.Config{Driver: "sqlite3"}
+
+	// The hash is computed from the DDL the function executes, so changing
+	// the DDL creates a new template.
+	ddl := "CREATE TABLE greetings (message TEXT)"
+	migrator := sqlitestdb.MigrateFuncKeyed(func(ctx context.Context, db *sql.DB) error {
+		_, err := db.ExecContext(ctx, ddl)
+		return err
+	}, ddl)
+	db := sqlitestdb.New(t, conf, migrator)
+
+	if _, err := db.Exec("INSERT INTO greetings VALUES ('hellord!')"); err != nil {
+		t.Fatalf("expected nil error: %+v\n", err)
+	}
+}
@@ -0,0 +1,37 @@
+package sqlitestdb_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/terinjokes/sqlitestdb"
+)
+
+// ExampleWithSeed should be called "TestSeed" in your code, but is renamed here
+// for GoDoc.
+func ExampleWithSeed() {
+	t := &testing.T{}
+	t.Parallel()
+	conf := sqlitestdb.Config{Driver: "sqlite3"}
+
+	// The seeder runs against each test's own database, so the rows it inserts
+	// are not part of the template, and changing them doesn't cause the
+	// migrations to run again.
+	seeder := sqlitestdb.SeedFunc(func(ctx context.Context, db *sql.DB) error {
+		_, err := db.ExecContext(ctx, "CREATE TABLE greetings (message TEXT); INSERT INTO greetings VALUES ('hellord!')")
+		return err
+	})
+	db := sqlitestdb.New(t, conf, sqlitestdb.NoopMigrator{}, sqlitestdb.WithSeed(seeder))
+
+	var message string
+	err := db.QueryRow("SELECT message FROM greetings").Scan(&message)
+	if err != nil {
+		t.Fatalf("expected nil error: %+v\n", err)
+	}
+
+	if message != "hellord!" {
+		t.Fatalf("expected message to be 'hellord!'")
+	}
+}
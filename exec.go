@@ -0,0 +1,43 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package sqlitestdb
+
+import (
+	"context"
+	"database/sql"
+
+	"braces.dev/errtrace"
+	"github.com/terinjokes/sqlitestdb/migrators/common"
+)
+
+// Execer executes statements, such as a [sql.DB], [sql.Conn], or [sql.Tx].
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// ExecSplit splits a SQL script into its statements, and executes them one by
+// one, as libsql only runs the first statement of [sql.DB.ExecContext]. It is
+// meant for migrators executing scripts, such as a [MigrateFunc].
+//
+// Semicolons in string literals, quoted identifiers, comments, and the bodies
+// of CREATE TRIGGER statements don't end a statement. If a statement fails, the
+// error names its position in the script, its line, and its text, shortened.
+func ExecSplit(ctx context.Context, db Execer, script string) error {
+	for i, stmt := range common.SplitStatements(script) {
+		if err := execStatement(ctx, db, i, stmt); err != nil {
+			return errtrace.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+// execStatement executes stmt, the statement at index i of a script.
+func execStatement(ctx context.Context, db Execer, i int, stmt common.Statement) error {
+	if _, err := db.ExecContext(ctx, stmt.SQL); err != nil {
+		return errtrace.Errorf("statement %d, line %d: %s: %w", i+1, stmt.Line, stmt.Summary(), err)
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package sqlitestdb
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"syscall"
+	"time"
+
+	"braces.dev/errtrace"
+)
+
+// limitPool sets conservative connection pool limits on a database handed out
+// to a test, so that many instances alive at the same time don't exhaust the
+// file descriptors of the process with idle connections. Tests may raise the
+// limits again on the returned handle.
+func limitPool(db *sql.DB) {
+	db.SetMaxIdleConns(1)
+	db.SetConnMaxIdleTime(time.Second)
+}
+
+// fdError explains err if it was caused by the process running out of file
+// descriptors, which SQLite usually reports as the less helpful "unable to open
+// database file". Other errors are returned unchanged.
+func fdError(err error) error {
+	if err == nil || !isFDExhausted(err) {
+		return err
+	}
+
+	used, limit, ok := fileDescriptors()
+	if !ok {
+		return errtrace.Errorf("too many open files, close databases when done, reduce the number of parallel tests with -parallel, or raise the limit with `ulimit -n`: %w", err)
+	}
+
+	return errtrace.Errorf("too many open files (%d open, limit %d), close databases when done, reduce the number of parallel tests with -parallel, or raise the limit with `ulimit -n`: %w", used, limit, err)
+}
+
+// isFDExhausted reports whether err was caused by the process running out of
+// file descriptors. As SQLite doesn't report the errno when it fails to open a
+// file, "unable to open database file" errors are attributed to running out of
+// file descriptors if the process is close to its limit.
+func isFDExhausted(err error) bool {
+	if errors.Is(err, syscall.EMFILE) || strings.Contains(err.Error(), "too many open files") {
+		return true
+	}
+
+	if !strings.Contains(err.Error(), "unable to open database file") {
+		return false
+	}
+
+	used, limit, ok := fileDescriptors()
+	return ok && used+8 >= limit
+}
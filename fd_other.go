@@ -0,0 +1,11 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+//go:build !unix
+
+package sqlitestdb
+
+// fileDescriptors is not supported on this platform.
+func fileDescriptors() (used, limit uint64, ok bool) {
+	return 0, 0, false
+}
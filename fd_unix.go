@@ -0,0 +1,27 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+//go:build unix
+
+package sqlitestdb
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileDescriptors returns the number of file descriptors open in the process,
+// and the soft limit on the number of open file descriptors.
+func fileDescriptors() (used, limit uint64, ok bool) {
+	var rlimit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlimit); err != nil {
+		return 0, 0, false
+	}
+
+	entries, err := os.ReadDir("/dev/fd")
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return uint64(len(entries)), uint64(rlimit.Cur), true
+}
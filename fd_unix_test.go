@@ -0,0 +1,58 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+//go:build unix
+
+package sqlitestdb_test
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+
+	"github.com/terinjokes/sqlitestdb"
+	"gotest.tools/v3/assert"
+)
+
+func TestManyInstancesWithLowFileLimit(t *testing.T) {
+	t.Parallel()
+	if testing.Short() {
+		t.Skip("skipping stress test in short mode")
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHelperManyInstances$", "-test.count=1", "-test.parallel=64")
+	cmd.Env = append(os.Environ(), "SQLITESTDB_HELPER_NOFILE=256")
+	out, err := cmd.CombinedOutput()
+	assert.NilError(t, err, string(out))
+}
+
+// TestHelperManyInstances is run as a subprocess by
+// TestManyInstancesWithLowFileLimit, as the file limit applies to the entire
+// process.
+func TestHelperManyInstances(t *testing.T) {
+	nofile := os.Getenv("SQLITESTDB_HELPER_NOFILE")
+	if nofile == "" {
+		t.Skip("only run as a helper process")
+	}
+
+	var rlimit syscall.Rlimit
+	assert.NilError(t, syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlimit))
+	// The limit is scanned into the field itself, as its type differs between
+	// platforms, such as int64 on FreeBSD and uint64 on Linux.
+	_, err := fmt.Sscan(nofile, &rlimit.Cur)
+	assert.NilError(t, err)
+	assert.NilError(t, syscall.Setrlimit(syscall.RLIMIT_NOFILE, &rlimit))
+
+	for i := 0; i < 300; i++ {
+		t.Run(fmt.Sprintf("instance_%d", i), func(t *testing.T) {
+			t.Parallel()
+			db := sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, defaultMigrator())
+
+			var count int
+			assert.NilError(t, db.QueryRow("SELECT COUNT(*) FROM cats").Scan(&count))
+			assert.Equal(t, 2, count)
+		})
+	}
+}
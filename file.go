@@ -0,0 +1,154 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package sqlitestdb
+
+import (
+	"context"
+	"crypto/md5"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"braces.dev/errtrace"
+)
+
+// TemplateFromFile returns a [Migrator] that creates the template by cloning an
+// existing SQLite database file, such as an anonymized snapshot of production
+// data, instead of running migrations.
+//
+// The hash of the migrator is the hash of the file's contents, so a template is
+// rebuilt whenever the file changes. If the file is in WAL mode, any
+// write-ahead log next to it is included in both the hash and the template. The
+// file itself is never modified.
+func TemplateFromFile(path string) Migrator {
+	return &fileMigrator{path: path}
+}
+
+type fileMigrator struct {
+	path string
+
+	mu    sync.Mutex
+	hash  string
+	stamp string
+}
+
+// Hash returns the hash of the contents of the file and its write-ahead log.
+// As hashing a large file is slow, the result is reused for as long as the
+// sizes and modification times of the files don't change.
+func (m *fileMigrator) Hash() (string, error) {
+	stamp, err := m.fileStamp()
+	if err != nil {
+		return "", errtrace.Wrap(err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.hash != "" && m.stamp == stamp {
+		return m.hash, nil
+	}
+
+	h := md5.New()
+	for _, suffix := range []string{"", "-wal"} {
+		if err := hashFile(h, m.path+suffix); err != nil {
+			if suffix != "" && os.IsNotExist(err) {
+				continue
+			}
+			return "", errtrace.Wrap(err)
+		}
+	}
+
+	m.hash = hex.EncodeToString(h.Sum(nil))
+	m.stamp = stamp
+	return m.hash, nil
+}
+
+// fileStamp summarizes the sizes and modification times of the file and its
+// write-ahead log, which change whenever either is written to.
+func (m *fileMigrator) fileStamp() (string, error) {
+	var stamp string
+	for _, suffix := range []string{"", "-wal"} {
+		info, err := os.Stat(m.path + suffix)
+		if err != nil {
+			if suffix != "" && os.IsNotExist(err) {
+				continue
+			}
+			return "", errtrace.Wrap(err)
+		}
+		stamp += fmt.Sprintf("%s:%d:%d;", suffix, info.Size(), info.ModTime().UnixNano())
+	}
+
+	return stamp, nil
+}
+
+func hashFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return errtrace.Wrap(err)
+}
+
+// Migrate clones the file into the template database with "VACUUM INTO".
+//
+// Opening a database in WAL mode may checkpoint it, so the file and its
+// write-ahead log are first copied to a temporary directory, and the copy is
+// cloned instead.
+func (m *fileMigrator) Migrate(ctx context.Context, _ *sql.DB, templateConfig Config) error {
+	dir, err := os.MkdirTemp("", "sqlitestdb_src_")
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := templateConfig
+	src.Database = filepath.Join(dir, filepath.Base(m.path))
+	for _, suffix := range []string{"", "-wal"} {
+		if err := copyFile(src.Database+suffix, m.path+suffix); err != nil {
+			if suffix != "" && os.IsNotExist(err) {
+				continue
+			}
+			return errtrace.Wrap(err)
+		}
+	}
+
+	db, err := src.Connect()
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+	defer db.Close()
+
+	if _, err := db.ExecContext(ctx, "VACUUM INTO ?", templateConfig.URI()); err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	return errtrace.Wrap(db.Close())
+}
+
+func copyFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return errtrace.Wrap(err)
+	}
+
+	return errtrace.Wrap(out.Close())
+}
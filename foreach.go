@@ -0,0 +1,48 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package sqlitestdb
+
+import (
+	"database/sql"
+	"slices"
+	"testing"
+)
+
+// ForEachDriver runs body as a subtest for each of the SQLite drivers supported
+// by sqlitestdb, such as "sqlite3" of mattn/go-sqlite3 and "sqlite" of
+// modernc.org/sqlite, named after the driver. Each subtest gets its own instance
+// database, created with [New], and its configuration. The subtests of the
+// drivers the program doesn't register are skipped, so the same test runs with
+// whichever drivers are imported.
+//
+// The templates are keyed by the driver, so the subtests never share one. The
+// subtests run one after the other, unless body calls [testing.T.Parallel].
+func ForEachDriver(t *testing.T, migrator Migrator, body func(t *testing.T, db *sql.DB, conf Config), opts ...Option) {
+	t.Helper()
+	configs := make([]Config, 0, len(knownDrivers))
+	for _, driver := range knownDrivers {
+		configs = append(configs, Config{Driver: driver.name})
+	}
+
+	ForEachConfig(t, configs, migrator, body, opts...)
+}
+
+// ForEachConfig is like [ForEachDriver], but runs body for each of configs,
+// such as to set a [Config.Connector], or to test drivers sqlitestdb doesn't
+// know. The subtests are named after the drivers of the configs. Configs with a
+// driver the program doesn't register, and without a Connector, are skipped.
+func ForEachConfig(t *testing.T, configs []Config, migrator Migrator, body func(t *testing.T, db *sql.DB, conf Config), opts ...Option) {
+	t.Helper()
+	for _, config := range configs {
+		t.Run(config.Driver, func(t *testing.T) {
+			t.Helper()
+			if config.Driver != "" && config.Connector == nil && !slices.Contains(sql.Drivers(), config.Driver) {
+				t.Skipf("driver %q is not registered", config.Driver)
+			}
+
+			db := New(t, config, migrator, opts...)
+			body(t, db, *ConfigFor(db))
+		})
+	}
+}
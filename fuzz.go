@@ -0,0 +1,88 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package sqlitestdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// Fuzz is an instance database shared by the iterations of a fuzz test, created
+// with [NewForFuzz].
+type Fuzz struct {
+	mu       sync.Mutex
+	instance *Config
+	db       *sql.DB
+}
+
+// NewForFuzz is like [New], but for fuzz tests. Instead of cloning a database
+// for every input, a single instance database is cloned for each process
+// running the fuzz test, and each input is run in a transaction that is rolled
+// back afterwards, see [Fuzz.Reset]. The URI of the instance database is only
+// logged if the fuzz test fails.
+//
+//	func FuzzCats(f *testing.F) {
+//		fz := sqlitestdb.NewForFuzz(f, config, migrator)
+//		f.Fuzz(func(t *testing.T, name string) {
+//			tx := fz.Reset(t)
+//			// ...
+//		})
+//	}
+//
+// If this method succeeds and the fuzz test succeeds, the database will be
+// removed as part of the test cleanup process.
+func NewForFuzz(f *testing.F, config Config, migrator Migrator, opts ...Option) *Fuzz {
+	f.Helper()
+	o := newOptions(opts)
+	o.quietLogging = true
+
+	instance, db := create(f, config, migrator, o)
+
+	// Only one transaction is ever active, so a single connection is enough.
+	db.SetMaxOpenConns(1)
+
+	return &Fuzz{instance: instance, db: db}
+}
+
+// Reset begins a transaction on the instance database for a single input of the
+// fuzz test, and is intended to be called at the top of the function passed to
+// [testing.F.Fuzz]. The transaction is rolled back as part of the cleanup
+// process of t, undoing any changes made for this input, and must not be
+// committed or rolled back by the fuzz function.
+//
+// If the input fails, the URI of the instance database is logged, but the
+// changes made for the input are still rolled back.
+func (fz *Fuzz) Reset(t *testing.T) *sql.Tx {
+	t.Helper()
+	fz.mu.Lock()
+
+	// The transaction must not be bound to a context that is cancelled before
+	// the cleanup, as database/sql rolls back transactions when their context
+	// is cancelled.
+	tx, err := fz.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		fz.mu.Unlock()
+		t.Fatalf("could not begin transaction: %+v", err)
+	}
+
+	t.Cleanup(func() {
+		defer fz.mu.Unlock()
+
+		if t.Failed() {
+			t.Logf("sqlitestdb: %s (rolled back)", fz.instance.URI())
+		}
+
+		if err := tx.Rollback(); err != nil {
+			if errors.Is(err, sql.ErrTxDone) {
+				t.Fatalf("sqlitestdb: transaction was committed or rolled back by the fuzz function")
+			}
+			t.Fatalf("could not roll back transaction: %+v", err)
+		}
+	})
+
+	return tx
+}
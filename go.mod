@@ -6,9 +6,9 @@ require (
 	braces.dev/errtrace v0.3.0
 	github.com/jmoiron/sqlx v1.4.0
 	github.com/mattn/go-sqlite3 v1.14.24
-	github.com/peterldowns/pgtestdb v0.1.1
 	github.com/tursodatabase/go-libsql v0.0.0-20241113154718-293fe7f21b08
 	golang.org/x/mod v0.22.0
+	golang.org/x/sys v0.27.0
 	gotest.tools/v3 v3.5.1
 	modernc.org/sqlite v1.33.1
 )
@@ -24,7 +24,7 @@ require (
 	github.com/ncruces/go-strftime v0.1.9 // indirect
 	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
 	golang.org/x/exp v0.0.0-20240325151524-a685a6edb6d8 // indirect
-	golang.org/x/sys v0.27.0 // indirect
+	golang.org/x/sync v0.8.0 // indirect
 	modernc.org/gc/v3 v3.0.0-20240107210532-573471604cb6 // indirect
 	modernc.org/libc v1.55.3 // indirect
 	modernc.org/mathutil v1.6.0 // indirect
@@ -0,0 +1,105 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package sqlitestdb
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"braces.dev/errtrace"
+	"github.com/terinjokes/sqlitestdb/internal/diff"
+)
+
+// AssertSchemaGolden migrates a scratch database with the migrator, and
+// compares the dump of its schema, see [DumpSchema], with the golden file at
+// goldenPath, typically committed along with the migrations. It fails the test
+// with [testing.TB.Fatalf] and a unified diff if they differ, so that changes of
+// the schema are noticed, and reviewed along with the golden file.
+//
+// When the SQLITESTDB_UPDATE_GOLDEN environment variable is true, or the test
+// binary defines an -update flag, as many do, and it is set, the golden file is
+// written with the dump instead, creating its directory if needed.
+//
+// Like [CheckIdempotent], the scratch database is created in a temporary
+// directory of the test, with the driver of config, and isn't a template.
+func AssertSchemaGolden(t testing.TB, config Config, migrator Migrator, goldenPath string) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	driver, err := resolveDriver(config)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	config.Driver = driver
+	config.Database = filepath.Join(t.TempDir(), "golden.sqlite")
+	if err := migrateOnce(ctx, config, migrator); err != nil {
+		t.Fatalf("could not migrate scratch database: %+v", err)
+	}
+	dump, err := dumpDatabase(ctx, config)
+	if err != nil {
+		t.Fatalf("could not dump schema: %+v", err)
+	}
+
+	if updateGolden() {
+		if err := writeGolden(goldenPath, dump); err != nil {
+			t.Fatalf("could not update golden file: %+v", err)
+		}
+		return
+	}
+
+	golden, err := os.ReadFile(goldenPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("golden file %s doesn't exist, set SQLITESTDB_UPDATE_GOLDEN=1 to create it", goldenPath)
+	} else if err != nil {
+		t.Fatalf("could not read golden file: %+v", err)
+	}
+
+	if changes := diff.Unified(goldenPath, "schema of "+migratorName(migrator), string(golden), dump); changes != "" {
+		t.Fatalf("schema differs from golden file %s, set SQLITESTDB_UPDATE_GOLDEN=1 to update it:\n%s", goldenPath, changes)
+	}
+}
+
+// dumpDatabase returns the dump of the schema of the database, on a connection
+// of its own.
+func dumpDatabase(ctx context.Context, config Config) (string, error) {
+	db, err := config.Connect()
+	if err != nil {
+		return "", errtrace.Wrap(err)
+	}
+	defer db.Close()
+
+	return errtrace.Wrap2(DumpSchema(ctx, db))
+}
+
+// updateGolden reports whether golden files should be written, instead of
+// compared, either with the SQLITESTDB_UPDATE_GOLDEN environment variable, or
+// with the -update flag of the test binary, if it defines one.
+func updateGolden() bool {
+	if update, err := strconv.ParseBool(os.Getenv("SQLITESTDB_UPDATE_GOLDEN")); err == nil {
+		return update
+	}
+
+	if f := flag.Lookup("update"); f != nil {
+		if getter, ok := f.Value.(flag.Getter); ok {
+			update, _ := getter.Get().(bool)
+			return update
+		}
+	}
+	return false
+}
+
+func writeGolden(path, dump string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	return errtrace.Wrap(os.WriteFile(path, []byte(dump), 0o644))
+}
@@ -0,0 +1,38 @@
+module github.com/terinjokes/sqlitestdb/gormtestdb
+
+go 1.22.0
+
+replace (
+	github.com/terinjokes/sqlitestdb => ..
+	github.com/terinjokes/sqlitestdb/migrators/gormmigrator => ../migrators/gormmigrator
+)
+
+require (
+	github.com/glebarez/sqlite v1.11.0
+	github.com/mattn/go-sqlite3 v1.14.24
+	github.com/terinjokes/sqlitestdb v0.1.0
+	github.com/terinjokes/sqlitestdb/migrators/gormmigrator v0.1.0
+	gorm.io/driver/sqlite v1.5.7
+	gorm.io/gorm v1.25.12
+	gotest.tools/v3 v3.5.1
+)
+
+require (
+	braces.dev/errtrace v0.3.0 // indirect
+	github.com/dustin/go-humanize v1.0.1 // indirect
+	github.com/glebarez/go-sqlite v1.21.2 // indirect
+	github.com/google/go-cmp v0.6.0 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/jinzhu/inflection v1.0.0 // indirect
+	github.com/jinzhu/now v1.1.5 // indirect
+	github.com/mattn/go-isatty v0.0.20 // indirect
+	github.com/ncruces/go-strftime v0.1.9 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
+	golang.org/x/mod v0.22.0 // indirect
+	golang.org/x/sys v0.27.0 // indirect
+	golang.org/x/text v0.18.0 // indirect
+	modernc.org/libc v1.55.3 // indirect
+	modernc.org/mathutil v1.6.0 // indirect
+	modernc.org/memory v1.8.0 // indirect
+	modernc.org/sqlite v1.33.1 // indirect
+)
@@ -0,0 +1,96 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+// Package gormtestdb creates test databases with sqlitestdb, and opens them
+// with [GORM]. It is its own module, so that programs using sqlitestdb without
+// GORM don't depend on it.
+//
+// [GORM]: https://gorm.io
+package gormtestdb
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/terinjokes/sqlitestdb"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// Dialector returns the [gorm.Dialector] that opens the test database over db,
+// the connection pool opened by sqlitestdb with the driver of the [sqlitestdb.Config].
+type Dialector func(db *sql.DB) gorm.Dialector
+
+// dialectorOption is the [gorm.Option] returned by [WithDialector]. It is
+// removed from the options before they are passed to [gorm.Open].
+type dialectorOption struct {
+	dialector Dialector
+}
+
+func (dialectorOption) Apply(*gorm.Config) error {
+	return nil
+}
+
+func (dialectorOption) AfterInitialize(*gorm.DB) error {
+	return nil
+}
+
+// WithDialector sets the GORM SQLite driver used by [New], for drivers other
+// than gorm.io/driver/sqlite, which uses the cgo mattn/go-sqlite3. For example,
+// the pure-Go github.com/glebarez/sqlite, along with the "sqlite" driver in the
+// [sqlitestdb.Config]:
+//
+//	gormtestdb.WithDialector(func(db *sql.DB) gorm.Dialector {
+//		return &sqlite.Dialector{Conn: db}
+//	})
+func WithDialector(dialector Dialector) gorm.Option {
+	return dialectorOption{dialector: dialector}
+}
+
+// New creates a fresh SQLite database with [sqlitestdb.Custom], and opens it
+// with GORM, passing gormOpts to [gorm.Open]. If there is an error, the test
+// will be immediately failed with [testing.TB.Fatalf].
+//
+// By default, the database is opened with gorm.io/driver/sqlite, which works
+// with the "sqlite3" driver of mattn/go-sqlite3; use [WithDialector] for the
+// pure-Go drivers.
+//
+// The migrator can be any [sqlitestdb.Migrator], such as the one of
+// github.com/terinjokes/sqlitestdb/migrators/gormmigrator, which uses the
+// AutoMigrate of GORM, or one running SQL files.
+//
+// The underlying [sql.DB] is closed as part of the test cleanup process, before
+// the database is removed.
+func New(t testing.TB, config sqlitestdb.Config, migrator sqlitestdb.Migrator, gormOpts ...gorm.Option) *gorm.DB {
+	t.Helper()
+
+	dialector := Dialector(func(db *sql.DB) gorm.Dialector {
+		return sqlite.New(sqlite.Config{Conn: db})
+	})
+	opts := make([]gorm.Option, 0, len(gormOpts))
+	for _, opt := range gormOpts {
+		if d, ok := opt.(dialectorOption); ok {
+			dialector = d.dialector
+			continue
+		}
+		opts = append(opts, opt)
+	}
+
+	instance := sqlitestdb.Custom(t, config, migrator)
+	db, err := instance.Connect()
+	if err != nil {
+		t.Fatalf("could not connect to test database %q: %+v", instance.Database, err)
+	}
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("could not close test database %q: %+v", instance.Database, err)
+		}
+	})
+
+	gdb, err := gorm.Open(dialector(db), opts...)
+	if err != nil {
+		t.Fatalf("could not open test database %q with GORM: %+v", instance.Database, err)
+	}
+
+	return gdb
+}
@@ -0,0 +1,74 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package gormtestdb_test
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+
+	glebarez "github.com/glebarez/sqlite"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/terinjokes/sqlitestdb"
+	"github.com/terinjokes/sqlitestdb/gormtestdb"
+	"github.com/terinjokes/sqlitestdb/migrators/gormmigrator"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gotest.tools/v3/assert"
+)
+
+type Cat struct {
+	ID   uint
+	Name string
+}
+
+func TestGormMigrator(t *testing.T) {
+	t.Parallel()
+	gm := gormmigrator.New(&Cat{}, gormmigrator.WithReflectedHash())
+	db := gormtestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, gm, &gorm.Config{
+		Logger: logger.Discard,
+	})
+
+	assert.NilError(t, db.Create(&Cat{Name: "daisy"}).Error)
+	var cats []Cat
+	assert.NilError(t, db.Find(&cats).Error)
+	assert.DeepEqual(t, []Cat{{ID: 1, Name: "daisy"}}, cats)
+}
+
+func TestSchemaFile(t *testing.T) {
+	t.Parallel()
+	db := gormtestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, sqlitestdb.SchemaFile(os.DirFS("testdata"), "schema.sql"))
+
+	var count int64
+	assert.NilError(t, db.Model(&Cat{}).Count(&count).Error)
+	assert.Equal(t, int64(2), count)
+}
+
+func TestWithDialector(t *testing.T) {
+	t.Parallel()
+	gm := gormmigrator.New(&Cat{}, gormmigrator.WithReflectedHash())
+	db := gormtestdb.New(t, sqlitestdb.Config{Driver: "sqlite"}, gm, gormtestdb.WithDialector(func(db *sql.DB) gorm.Dialector {
+		return &glebarez.Dialector{Conn: db}
+	}))
+
+	assert.Equal(t, "sqlite", db.Dialector.Name())
+
+	assert.NilError(t, db.Create(&Cat{Name: "sunny"}).Error)
+	var cat Cat
+	assert.NilError(t, db.First(&cat).Error)
+	assert.Equal(t, "sunny", cat.Name)
+}
+
+func TestClosesDB(t *testing.T) {
+	t.Parallel()
+	var sqlDB *sql.DB
+	t.Run("instance", func(t *testing.T) {
+		db := gormtestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, sqlitestdb.SchemaFile(os.DirFS("testdata"), "schema.sql"))
+		var err error
+		sqlDB, err = db.DB()
+		assert.NilError(t, err)
+	})
+
+	assert.ErrorContains(t, sqlDB.Ping(), "sql: database is closed")
+}
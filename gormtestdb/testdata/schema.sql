@@ -0,0 +1,5 @@
+CREATE TABLE cats (
+	id INTEGER PRIMARY KEY,
+	name TEXT NOT NULL
+);
+INSERT INTO cats (name) VALUES ('daisy'), ('sunny');
@@ -0,0 +1,29 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package sqlitestdb
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Handler is like [New], but passes the instance database to build, and serves
+// the returned handler with an [httptest.Server], which is returned.
+//
+// As part of the test cleanup process, the server is closed first, waiting for
+// outstanding requests, before the database is closed and, if the test
+// succeeded, removed.
+func Handler(t testing.TB, config Config, migrator Migrator, build func(*sql.DB) http.Handler, opts ...Option) *httptest.Server {
+	t.Helper()
+	_, db := create(t, config, migrator, newOptions(opts))
+
+	// Cleanups run in reverse order, so registering this after create closes
+	// the server before the database.
+	srv := httptest.NewServer(build(db))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
@@ -0,0 +1,140 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package sqlitestdb
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"braces.dev/errtrace"
+)
+
+// CheckIdempotent runs the migrator twice against a scratch database, and fails
+// the test with [testing.TB.Fatalf] if the second run returns an error, or
+// changes the schema or the number of rows of any table. Migrations that aren't
+// idempotent, such as a "repeatable" migration using CREATE TABLE without IF NOT
+// EXISTS, fail the second run, and migrators that track the applied versions
+// must skip them the second time.
+//
+// The scratch database is created in a temporary directory of the test, with
+// the driver of config, and removed once the test finishes. It isn't a template,
+// and doesn't affect the templates used by [New].
+func CheckIdempotent(t testing.TB, config Config, migrator Migrator) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	driver, err := resolveDriver(config)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	config.Driver = driver
+	config.Database = filepath.Join(t.TempDir(), "idempotent.sqlite")
+
+	if err := migrateOnce(ctx, config, migrator); err != nil {
+		t.Fatalf("could not migrate scratch database: %+v", err)
+	}
+	before, err := describeDatabase(ctx, config)
+	if err != nil {
+		t.Fatalf("could not describe scratch database: %+v", err)
+	}
+
+	if err := migrateOnce(ctx, config, migrator); err != nil {
+		t.Fatalf("migrator %T is not idempotent, migrating a second time failed: %+v", migrator, err)
+	}
+	after, err := describeDatabase(ctx, config)
+	if err != nil {
+		t.Fatalf("could not describe scratch database: %+v", err)
+	}
+
+	if changes := diffLines(before, after); len(changes) > 0 {
+		t.Fatalf("migrator %T is not idempotent, migrating a second time changed the database:\n%s", migrator, strings.Join(changes, "\n"))
+	}
+}
+
+// migrateOnce runs the migrator against the database, on a connection of its
+// own.
+func migrateOnce(ctx context.Context, config Config, migrator Migrator) error {
+	db, err := config.Connect()
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+	defer db.Close()
+
+	return errtrace.Wrap(migrator.Migrate(ctx, db, config))
+}
+
+// describeDatabase returns the schema of the database, and the number of rows
+// of each of its tables, one per line.
+func describeDatabase(ctx context.Context, config Config) ([]string, error) {
+	db, err := config.Connect()
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(ctx, "SELECT type, name, coalesce(sql, '') FROM sqlite_master ORDER BY type, name")
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+	defer rows.Close()
+
+	var (
+		lines  []string
+		tables []string
+	)
+	for rows.Next() {
+		var typ, name, sql string
+		if err := rows.Scan(&typ, &name, &sql); err != nil {
+			return nil, errtrace.Wrap(err)
+		}
+		lines = append(lines, fmt.Sprintf("%s %s: %s", typ, name, sql))
+		if typ == "table" {
+			tables = append(tables, name)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+
+	for _, table := range tables {
+		var count int
+		err := db.QueryRowContext(ctx, "SELECT count(*) FROM "+quoteIdentifier(table)).Scan(&count)
+		if err != nil {
+			return nil, errtrace.Wrap(err)
+		}
+		lines = append(lines, fmt.Sprintf("rows of %s: %d", table, count))
+	}
+
+	return lines, nil
+}
+
+// diffLines returns the lines only found in before, prefixed with "-", and
+// those only found in after, prefixed with "+".
+func diffLines(before, after []string) []string {
+	inBefore := make(map[string]bool, len(before))
+	for _, line := range before {
+		inBefore[line] = true
+	}
+	inAfter := make(map[string]bool, len(after))
+	for _, line := range after {
+		inAfter[line] = true
+	}
+
+	var changes []string
+	for _, line := range before {
+		if !inAfter[line] {
+			changes = append(changes, "- "+line)
+		}
+	}
+	for _, line := range after {
+		if !inBefore[line] {
+			changes = append(changes, "+ "+line)
+		}
+	}
+	return changes
+}
@@ -0,0 +1,59 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package sqlitestdb
+
+import (
+	"reflect"
+
+	"braces.dev/errtrace"
+	"github.com/terinjokes/sqlitestdb/migrators/hash"
+)
+
+// NamedMigrator is a [Migrator] that names the kind of schema it creates. The
+// name is part of the identity of its templates, see [TemplateHash], instead of
+// the type of the migrator.
+//
+// Migrators wrapping another migrator of any kind can implement it to pass the
+// name of the wrapped migrator along.
+type NamedMigrator interface {
+	Migrator
+	Name() string
+}
+
+// TemplateHash returns the hash identifying the templates created by the
+// migrator. It is the hash included in their file names, and reported by
+// [Stats].
+//
+// Migrators of different kinds can return the same hash, such as two migrators
+// hashing the same migration files, yet create different schemas, such as when
+// one of them also creates a table tracking the applied versions. So the hash
+// combines the hash of the migrator with its name, if it is a [NamedMigrator],
+// or with the package path and name of its type otherwise.
+func TemplateHash(migrator Migrator) (string, error) {
+	mhash, err := migrator.Hash()
+	if err != nil {
+		return "", errtrace.Wrap(err)
+	}
+
+	return hash.NewRecursiveHash(
+		hash.Field("Migrator", migratorName(migrator)),
+		hash.Field("Hash", mhash),
+	).String(), nil
+}
+
+// migratorName returns the name of the migrator, or of its type.
+func migratorName(migrator Migrator) string {
+	if named, ok := migrator.(NamedMigrator); ok {
+		return named.Name()
+	}
+
+	t := reflect.TypeOf(migrator)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Name() == "" {
+		return t.String()
+	}
+	return t.PkgPath() + "." + t.Name()
+}
@@ -0,0 +1,145 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+// Package diff computes line-based diffs of text, for the failure messages of
+// the test helpers.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// context is the number of unchanged lines shown around each change by
+// [Unified].
+const context = 3
+
+// edit is a line of a diff: kept, if kind is a space, removed, if it is "-",
+// or added, if it is "+".
+type edit struct {
+	kind byte
+	line string
+}
+
+// Lines returns a line-based diff from a to b, in which the lines only in a are
+// prefixed with "-", those only in b with "+", and those in both with a space.
+func Lines(a, b string) string {
+	var out strings.Builder
+	for _, e := range edits(a, b) {
+		out.WriteString(string(e.kind) + " " + e.line + "\n")
+	}
+
+	return out.String()
+}
+
+// Unified returns a unified diff from a, named from, to b, named to, with three
+// lines of context around each change, or an empty string if a and b are equal.
+func Unified(from, to, a, b string) string {
+	es := edits(a, b)
+
+	// offsets[i] is the number of lines of a and of b before es[i].
+	offsets := make([][2]int, len(es)+1)
+	for i, e := range es {
+		offsets[i+1] = offsets[i]
+		if e.kind != '+' {
+			offsets[i+1][0]++
+		}
+		if e.kind != '-' {
+			offsets[i+1][1]++
+		}
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(es); {
+		if es[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		// The hunk extends until the next change is further away than twice
+		// the context, so that the context of two hunks never overlaps.
+		start, end := max(0, i-context), i
+		for j := i; j < len(es) && j <= end+2*context+1; j++ {
+			if es[j].kind != ' ' {
+				end = j
+			}
+		}
+		end = min(len(es), end+context+1)
+
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", from, to)
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n",
+			hunkRange(offsets[start][0], offsets[end][0]),
+			hunkRange(offsets[start][1], offsets[end][1]))
+		for _, e := range es[start:end] {
+			out.WriteString(string(e.kind) + e.line + "\n")
+		}
+
+		i = end
+	}
+
+	return out.String()
+}
+
+// hunkRange formats the range of lines of a hunk, from the lines before it to
+// the lines up to its end. Empty ranges start at the line before them.
+func hunkRange(before, through int) string {
+	n := through - before
+	if n == 0 {
+		return fmt.Sprintf("%d,0", before)
+	}
+
+	return fmt.Sprintf("%d,%d", before+1, n)
+}
+
+// edits returns the edits from a to b, keeping their longest common
+// subsequence of lines.
+func edits(a, b string) []edit {
+	x := splitLines(a)
+	y := splitLines(b)
+
+	// lcs[i][j] is the length of the longest common subsequence of x[i:] and
+	// y[j:].
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var es []edit
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			es = append(es, edit{' ', x[i]})
+			i++
+			j++
+		case j == len(y) || (i < len(x) && lcs[i+1][j] >= lcs[i][j+1]):
+			es = append(es, edit{'-', x[i]})
+			i++
+		default:
+			es = append(es, edit{'+', y[j]})
+			j++
+		}
+	}
+
+	return es
+}
+
+// splitLines returns the lines of s, without their line endings.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
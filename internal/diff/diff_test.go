@@ -0,0 +1,65 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package diff_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/terinjokes/sqlitestdb/internal/diff"
+	"gotest.tools/v3/assert"
+)
+
+func TestLines(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, diff.Lines("a\nb\nc\n", "a\nc\nd\n"), "  a\n- b\n  c\n+ d\n")
+	assert.Equal(t, diff.Lines("", "a\n"), "+ a\n")
+}
+
+func TestUnified(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, diff.Unified("a", "b", "x\ny\n", "x\ny\n"), "")
+
+	// Changes further apart than twice the context are in hunks of their own,
+	// and closer ones share a hunk.
+	lines := func(changes map[int]string) string {
+		var b strings.Builder
+		for i := 1; i <= 20; i++ {
+			if line, ok := changes[i]; ok {
+				b.WriteString(line + "\n")
+				continue
+			}
+			b.WriteString(strings.Repeat("l", i) + "\n")
+		}
+		return b.String()
+	}
+	a := lines(nil)
+	b := lines(map[int]string{2: "two", 9: "nine", 20: "twenty"})
+	assert.Equal(t, diff.Unified("old", "new", a, b), "--- old\n+++ new\n"+
+		"@@ -1,12 +1,12 @@\n"+
+		" l\n"+
+		"-ll\n"+
+		"+two\n"+
+		" lll\n"+
+		" llll\n"+
+		" lllll\n"+
+		" llllll\n"+
+		" lllllll\n"+
+		" llllllll\n"+
+		"-lllllllll\n"+
+		"+nine\n"+
+		" llllllllll\n"+
+		" lllllllllll\n"+
+		" llllllllllll\n"+
+		"@@ -17,4 +17,4 @@\n"+
+		" lllllllllllllllll\n"+
+		" llllllllllllllllll\n"+
+		" lllllllllllllllllll\n"+
+		"-llllllllllllllllllll\n"+
+		"+twenty\n")
+
+	assert.Equal(t, diff.Unified("old", "new", "", "a\n"), "--- old\n+++ new\n@@ -0,0 +1,1 @@\n+a\n")
+}
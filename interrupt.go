@@ -0,0 +1,277 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package sqlitestdb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"reflect"
+
+	"braces.dev/errtrace"
+)
+
+// openInterruptible opens the database at config, like [Config.Connect], but
+// every statement run on it is also interrupted once ctx is done, even if it
+// was started without a context, such as with [sql.DB.Exec].
+//
+// The mattn and modernc drivers call sqlite3_interrupt when the context of a
+// statement is done, so a statement stuck in a long-running query, such as a
+// runaway recursive CTE in a migration, aborts promptly, instead of only being
+// noticed by database/sql between statements. The connections are wrapped to
+// hand that context to the driver. The contexts of the statements are released
+// once ctx is done, so it must be canceled once the database is no longer used.
+func openInterruptible(ctx context.Context, config Config) (*sql.DB, error) {
+	connector, err := config.connector(config.URI())
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+
+	return sql.OpenDB(&interruptConnector{Connector: connector, ctx: ctx}), nil
+}
+
+type interruptConnector struct {
+	driver.Connector
+	ctx context.Context
+}
+
+func (c *interruptConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+
+	return &interruptConn{Conn: conn, ctx: c.ctx}, nil
+}
+
+// Close closes the connector of the driver, if it holds any resources, such as
+// the connector of libsql.
+func (c *interruptConnector) Close() error {
+	if closer, ok := c.Connector.(io.Closer); ok {
+		return errtrace.Wrap(closer.Close())
+	}
+	return nil
+}
+
+// interruptConn passes a context that is also done once the context of the
+// connector is done to the driver, for every statement.
+type interruptConn struct {
+	driver.Conn
+	ctx context.Context
+}
+
+// DriverConn returns the connection of the driver for raw, a connection passed
+// to the function of [sql.Conn.Raw]. The connections of the database handed to
+// [Migrator.Migrate] are wrapped by sqlitestdb to interrupt their statements,
+// so migrators reaching for the connection of the driver, such as a
+// *sqlite3.SQLiteConn, must unwrap it first. Other connections are returned as
+// is.
+func DriverConn(raw any) any {
+	if c, ok := raw.(*interruptConn); ok {
+		return c.Conn
+	}
+	return raw
+}
+
+// noInterruptKey marks the contexts returned by [withoutInterrupt].
+type noInterruptKey struct{}
+
+// withoutInterrupt returns a context that is never done, for statements that
+// must still run on a database from [openInterruptible] once its context is
+// done, such as rolling back a transaction.
+func withoutInterrupt(ctx context.Context) context.Context {
+	return context.WithValue(context.WithoutCancel(ctx), noInterruptKey{}, true)
+}
+
+// bind returns a context that is done once either ctx, or the context of the
+// connection, is done.
+func (c *interruptConn) bind(ctx context.Context) (context.Context, context.CancelFunc) {
+	if ctx.Value(noInterruptKey{}) != nil {
+		return ctx, func() {}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(c.ctx, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+func (c *interruptConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	ctx, cancel := c.bind(ctx)
+	defer cancel()
+	return execer.ExecContext(ctx, query, args)
+}
+
+func (c *interruptConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	// The rows are read after QueryContext returns, so the context is only
+	// released once they are closed.
+	ctx, cancel := c.bind(ctx)
+	rows, err := queryer.QueryContext(ctx, query, args)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &interruptRows{Rows: rows, cancel: cancel}, nil
+}
+
+func (c *interruptConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var stmt driver.Stmt
+	var err error
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = preparer.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &interruptStmt{Stmt: stmt, conn: c}, nil
+}
+
+func (c *interruptConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *interruptConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *interruptConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+type interruptStmt struct {
+	driver.Stmt
+	conn *interruptConn
+}
+
+func (s *interruptStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := s.Stmt.(driver.StmtExecContext)
+	if !ok {
+		return s.Stmt.Exec(values(args))
+	}
+
+	ctx, cancel := s.conn.bind(ctx)
+	defer cancel()
+	return execer.ExecContext(ctx, args)
+}
+
+func (s *interruptStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := s.Stmt.(driver.StmtQueryContext)
+	if !ok {
+		return s.Stmt.Query(values(args))
+	}
+
+	// See [interruptConn.QueryContext].
+	ctx, cancel := s.conn.bind(ctx)
+	rows, err := queryer.QueryContext(ctx, args)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &interruptRows{Rows: rows, cancel: cancel}, nil
+}
+
+func (s *interruptStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return s.conn.CheckNamedValue(nv)
+}
+
+// interruptRows releases the context of the statement that returned them once
+// they are closed. The optional interfaces of the rows of the driver are passed
+// through, with the defaults of database/sql for the rows that don't implement
+// them.
+type interruptRows struct {
+	driver.Rows
+	cancel context.CancelFunc
+}
+
+func (r *interruptRows) Close() error {
+	defer r.cancel()
+	return r.Rows.Close()
+}
+
+func (r *interruptRows) HasNextResultSet() bool {
+	if next, ok := r.Rows.(driver.RowsNextResultSet); ok {
+		return next.HasNextResultSet()
+	}
+	return false
+}
+
+func (r *interruptRows) NextResultSet() error {
+	if next, ok := r.Rows.(driver.RowsNextResultSet); ok {
+		return next.NextResultSet()
+	}
+	return io.EOF
+}
+
+func (r *interruptRows) ColumnTypeScanType(index int) reflect.Type {
+	if rows, ok := r.Rows.(driver.RowsColumnTypeScanType); ok {
+		return rows.ColumnTypeScanType(index)
+	}
+	return reflect.TypeFor[any]()
+}
+
+func (r *interruptRows) ColumnTypeDatabaseTypeName(index int) string {
+	if rows, ok := r.Rows.(driver.RowsColumnTypeDatabaseTypeName); ok {
+		return rows.ColumnTypeDatabaseTypeName(index)
+	}
+	return ""
+}
+
+func (r *interruptRows) ColumnTypeLength(index int) (int64, bool) {
+	if rows, ok := r.Rows.(driver.RowsColumnTypeLength); ok {
+		return rows.ColumnTypeLength(index)
+	}
+	return 0, false
+}
+
+func (r *interruptRows) ColumnTypeNullable(index int) (bool, bool) {
+	if rows, ok := r.Rows.(driver.RowsColumnTypeNullable); ok {
+		return rows.ColumnTypeNullable(index)
+	}
+	return false, false
+}
+
+func (r *interruptRows) ColumnTypePrecisionScale(index int) (int64, int64, bool) {
+	if rows, ok := r.Rows.(driver.RowsColumnTypePrecisionScale); ok {
+		return rows.ColumnTypePrecisionScale(index)
+	}
+	return 0, 0, false
+}
+
+// values converts the named arguments of a statement for the methods of
+// drivers that don't support them.
+func values(args []driver.NamedValue) []driver.Value {
+	vals := make([]driver.Value, len(args))
+	for i, arg := range args {
+		vals[i] = arg.Value
+	}
+	return vals
+}
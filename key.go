@@ -0,0 +1,82 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package sqlitestdb
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"braces.dev/errtrace"
+)
+
+// keyed returns dsn with the Key in its "key" parameter, which SQLCipher reads
+// when the database is opened. Unlike "PRAGMA key", this also keys the
+// statements the driver runs as it opens the connection, such as the PRAGMAs
+// of mattn/go-sqlite3. Only "file:" URIs are keyed, and not the in-memory
+// databases opened to describe the driver.
+func (c Config) keyed(dsn string) string {
+	if c.Key == "" || !strings.HasPrefix(dsn, "file:") {
+		return dsn
+	}
+
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	return dsn + sep + "key=" + escapeURIParam(c.Key)
+}
+
+// escapeURIParam percent-encodes the value of a parameter of a SQLite URI.
+// Unlike [url.QueryEscape], spaces are encoded as "%20", as SQLite doesn't
+// decode "+".
+func escapeURIParam(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '-', c == '.', c == '_', c == '~':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// checkCipher returns an error if the driver of config wasn't built with
+// SQLCipher, as other builds of SQLite silently ignore the key, and would create
+// databases that aren't encrypted.
+func checkCipher(ctx context.Context, config Config) error {
+	db, err := config.open(":memory:")
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+	defer db.Close()
+
+	var version string
+	err = db.QueryRowContext(ctx, "PRAGMA cipher_version").Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && version == "" {
+		return errtrace.Errorf("Config.Key is set, but the driver %q wasn't built with SQLCipher", config.Driver)
+	}
+
+	return errtrace.Wrap(err)
+}
+
+// templateDriver returns the name of the driver of config as used to name and
+// key its templates. With a Key, it includes a digest of the key, so that the
+// templates encrypted with different keys, or not encrypted at all, are kept
+// apart.
+func (c Config) templateDriver() string {
+	if c.Key == "" {
+		return c.Driver
+	}
+
+	sum := sha256.Sum256([]byte(c.Key))
+	return c.Driver + "-key-" + hex.EncodeToString(sum[:4])
+}
@@ -0,0 +1,118 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package sqlitestdb
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"runtime"
+
+	"braces.dev/errtrace"
+)
+
+// lockTemplate takes the inter-process lock guarding the creation of the
+// template at path, held on a sidecar ".lock" file, waiting for as long as
+// another process holds it, until ctx is done. The lock is released by calling
+// unlock, or when the process exits.
+//
+// Lock files are removed by [CleanTemplates] and [CleanupTemplates], while
+// holding the lock, so a lock file that was removed after it was opened is
+// opened again, as another process may lock the new one.
+//
+// On platforms without file locking, the lock is always taken, and processes
+// creating the same template at the same time each run the migrations.
+func lockTemplate(ctx context.Context, path string) (unlock func(), err error) {
+	for {
+		f, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0o666)
+		if err != nil {
+			return nil, errtrace.Wrap(err)
+		}
+
+		if err := lockFileContext(ctx, f); err != nil {
+			return nil, errtrace.Wrap(err)
+		}
+
+		if isLockFile(f, path+".lock") {
+			return func() { f.Close() }, nil
+		}
+		f.Close()
+	}
+}
+
+// lockFileContext takes an exclusive lock on f, waiting until ctx is done. If
+// it fails, f is closed. As waiting for the lock can't be interrupted, once ctx
+// is done the lock is released as soon as it is taken.
+func lockFileContext(ctx context.Context, f *os.File) error {
+	locked, err := tryLockFile(f)
+	if err != nil || locked {
+		if err != nil {
+			f.Close()
+		}
+		return errtrace.Wrap(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- lockFile(f)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			f.Close()
+		}
+		return errtrace.Wrap(err)
+	case <-ctx.Done():
+		go func() {
+			<-done
+			f.Close()
+		}()
+		return errtrace.Wrap(ctx.Err())
+	}
+}
+
+// isLockFile reports whether the open file f is still the lock file at path.
+func isLockFile(f *os.File, path string) bool {
+	opened, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+
+	return os.SameFile(opened, current)
+}
+
+// removeLockFile removes the lock file of the template at path, unless another
+// process holds the lock, such as while it creates the template.
+func removeLockFile(path string) error {
+	f, err := os.OpenFile(path+".lock", os.O_RDWR, 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+	defer f.Close()
+
+	locked, err := tryLockFile(f)
+	if err != nil || !locked {
+		return errtrace.Wrap(err)
+	}
+
+	if runtime.GOOS == "windows" {
+		// Open files can't be removed, so the lock is released first. At worst,
+		// a process creating the template at the same time also runs the
+		// migrations.
+		f.Close()
+	}
+	if err := os.Remove(path + ".lock"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return errtrace.Wrap(err)
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd || windows)
+
+package sqlitestdb
+
+import "os"
+
+// tryLockFile is not supported on this platform, and always succeeds.
+func tryLockFile(*os.File) (bool, error) {
+	return true, nil
+}
+
+// lockFile is not supported on this platform, and always succeeds.
+func lockFile(*os.File) error {
+	return nil
+}
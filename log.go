@@ -0,0 +1,52 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package sqlitestdb
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+// Logger receives the message logged when an instance database is created for
+// a test, which includes the SQLite URI of the database.
+type Logger func(t testing.TB, msg string)
+
+var logger atomic.Pointer[Logger]
+
+// SetLogger routes the message logged for every instance database to l, instead
+// of [testing.TB.Logf]. Passing nil restores the default behavior.
+//
+// Regardless of the logger, the message is always logged with
+// [testing.TB.Logf] when the test fails, so that the database can be found and
+// inspected.
+func SetLogger(l Logger) {
+	if l == nil {
+		logger.Store(nil)
+		return
+	}
+	logger.Store(&l)
+}
+
+// logInstance logs msg for the instance database of a test, unless silenced by
+// [WithQuietLogging] or routed elsewhere with [SetLogger], in which case it is
+// only logged with [testing.TB.Logf] if the test fails.
+func logInstance(t testing.TB, o *options, msg string) {
+	t.Helper()
+
+	l := logger.Load()
+	if l == nil && !o.quietLogging {
+		t.Logf("%s", msg)
+		return
+	}
+
+	if l != nil && !o.quietLogging {
+		(*l)(t, msg)
+	}
+
+	t.Cleanup(func() {
+		if t.Failed() {
+			t.Logf("%s", msg)
+		}
+	})
+}
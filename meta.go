@@ -0,0 +1,141 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package sqlitestdb
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
+
+	"braces.dev/errtrace"
+)
+
+// applicationID is stored as the "PRAGMA application_id" of every template, and
+// so of every instance database cloned from it, identifying the files created
+// by sqlitestdb. It is the ASCII string "STDB", which isn't used by any of the
+// formats listed in SQLite's [magic.txt].
+//
+// [magic.txt]: https://www.sqlite.org/src/file/magic.txt
+const applicationID = 0x53544442
+
+// sqliteHeader is the header string at the start of every SQLite database.
+var sqliteHeader = []byte("SQLite format 3\x00")
+
+// applicationIDOffset is the offset of the application ID in the header of a
+// SQLite database file, stored as a big-endian 32-bit integer.
+const applicationIDOffset = 68
+
+// Meta is the metadata sqlitestdb records in every template database, and so in
+// every instance database cloned from it, in the sqlitestdb_meta table.
+type Meta struct {
+	Hash      string    // The hash of the migrator the template was created with.
+	Driver    string    // The driver the template was created with.
+	CreatedAt time.Time // When the template was migrated.
+	Version   string    // The version of sqlitestdb that created the template.
+}
+
+// ReadMeta returns the metadata of the template or instance database at path,
+// opening it read-only with the first of the "sqlite3", "sqlite", and "libsql"
+// drivers registered by the program. It fails if the database wasn't created
+// by sqlitestdb.
+func ReadMeta(path string) (*Meta, error) {
+	if !isStamped(path) {
+		return nil, errtrace.Errorf("%q was not created by sqlitestdb", path)
+	}
+
+	driver, ok := registeredDriver()
+	if !ok {
+		return nil, errtrace.New("no supported SQLite driver is registered")
+	}
+
+	config := Config{Driver: driver, Database: path}
+	db, err := sql.Open(driver, config.URI()+"?mode=ro")
+	if err != nil {
+		return nil, errtrace.Errorf("%w: %w", ErrDriverOpen, err)
+	}
+	defer db.Close()
+
+	var meta Meta
+	var createdAt string
+	row := db.QueryRow("SELECT hash, driver, created_at, version FROM " + markerTable)
+	if err := row.Scan(&meta.Hash, &meta.Driver, &createdAt, &meta.Version); err != nil {
+		return nil, errtrace.Errorf("could not read the metadata of %q: %w", path, err)
+	}
+
+	meta.CreatedAt, err = time.Parse(time.RFC3339Nano, createdAt)
+	if err != nil {
+		return nil, errtrace.Errorf("could not read the metadata of %q: %w", path, err)
+	}
+
+	return &meta, errtrace.Wrap(db.Close())
+}
+
+// markTemplate stamps a template with the application ID, and records the
+// migrator hash in its marker table, along with the rest of its [Meta].
+//
+// The statements are run one by one, as libsql only runs the first statement
+// of [sql.DB.ExecContext].
+func markTemplate(ctx context.Context, db *sql.DB, driver, mhash string) error {
+	if _, err := db.ExecContext(ctx, "PRAGMA application_id="+strconv.Itoa(applicationID)); err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	_, err := db.ExecContext(ctx, "CREATE TABLE "+markerTable+" (hash TEXT NOT NULL, driver TEXT NOT NULL, created_at TEXT NOT NULL, version TEXT NOT NULL)")
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	_, err = db.ExecContext(ctx, "INSERT INTO "+markerTable+" (hash, driver, created_at, version) VALUES (?, ?, ?, ?)",
+		mhash, driver, time.Now().UTC().Format(time.RFC3339Nano), moduleVersion())
+	return errtrace.Wrap(err)
+}
+
+// moduleVersion returns the version of sqlitestdb built into the program, or
+// "(devel)" if it isn't known, such as in its own tests.
+var moduleVersion = sync.OnceValue(func() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "(devel)"
+	}
+
+	const path = "github.com/terinjokes/sqlitestdb"
+	if info.Main.Path == path && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == path {
+			if dep.Replace != nil {
+				dep = dep.Replace
+			}
+			if dep.Version != "" {
+				return dep.Version
+			}
+		}
+	}
+	return "(devel)"
+})
+
+// isStamped reports whether the file at path is a SQLite database stamped with
+// the application ID of sqlitestdb, by reading its header.
+func isStamped(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	header := make([]byte, applicationIDOffset+4)
+	if _, err := f.ReadAt(header, 0); err != nil {
+		return false
+	}
+
+	return bytes.Equal(header[:len(sqliteHeader)], sqliteHeader) &&
+		binary.BigEndian.Uint32(header[applicationIDOffset:]) == applicationID
+}
@@ -0,0 +1,85 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package sqlitestdb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"braces.dev/errtrace"
+	"github.com/terinjokes/sqlitestdb/migrators/hash"
+)
+
+// MigrateFunc returns a [Migrator] that creates the template by calling fn,
+// for schemas built with Go code instead of migration files.
+//
+// As the code of fn can't be hashed, hash identifies the schema it creates,
+// such as a version number, and must not be empty. It must be changed whenever
+// fn is changed. Otherwise, the template created by the previous version of fn
+// is reused, and the tests silently run against a stale schema. See
+// [MigrateFuncKeyed] to derive the hash from values that change along with fn.
+func MigrateFunc(hash string, fn func(context.Context, *sql.DB) error) Migrator {
+	return &funcMigrator{
+		fn: fn,
+		key: func() (string, error) {
+			if hash == "" {
+				return "", errtrace.New("MigrateFunc requires a non-empty hash")
+			}
+			return hash, nil
+		},
+	}
+}
+
+// MigrateFuncKeyed is like [MigrateFunc], but the hash is computed from keys,
+// such as the generated DDL fn executes, or a [fmt.Stringer] describing it.
+// Keys are formatted with the %v verb each time the migrator is hashed, except
+// for byte slices, whose contents are hashed. At least one key is required.
+//
+// Keys that don't change when fn does, such as a constant that is not updated
+// along with it, lead to the same stale templates as an unchanged hash.
+func MigrateFuncKeyed(fn func(context.Context, *sql.DB) error, keys ...any) Migrator {
+	return &funcMigrator{
+		fn: fn,
+		key: func() (string, error) {
+			if len(keys) == 0 {
+				return "", errtrace.New("MigrateFuncKeyed requires at least one key")
+			}
+
+			h := hash.NewRecursiveHash()
+			for _, key := range keys {
+				switch key := key.(type) {
+				case []byte:
+					h.Add(key)
+				default:
+					h.Add([]byte(fmt.Sprintf("%v", key)))
+				}
+			}
+			return h.String(), nil
+		},
+	}
+}
+
+type funcMigrator struct {
+	fn  func(context.Context, *sql.DB) error
+	key func() (string, error)
+}
+
+// Hash returns a hash of the key, which is hashed again so that it is safe to
+// use in the file name of the template, whatever characters it contains.
+func (m *funcMigrator) Hash() (string, error) {
+	key, err := m.key()
+	if err != nil {
+		return "", errtrace.Wrap(err)
+	}
+
+	return hash.NewRecursiveHash(hash.Field("MigrateFunc", key)).String(), nil
+}
+
+func (m *funcMigrator) Migrate(ctx context.Context, db *sql.DB, _ Config) error {
+	if m.fn == nil {
+		return errtrace.New("migrate func is nil")
+	}
+	return errtrace.Wrap(m.fn(ctx, db))
+}
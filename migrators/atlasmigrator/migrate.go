@@ -0,0 +1,229 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package atlasmigrator
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"strings"
+
+	"braces.dev/errtrace"
+	"github.com/terinjokes/sqlitestdb"
+	"github.com/terinjokes/sqlitestdb/migrators/common"
+)
+
+// SumFile is the name of the file Atlas stores the checksums of the migration
+// files in, in the migration directory.
+const SumFile = "atlas.sum"
+
+// Option provides a way to configure the AtlasMigrator struct and its behavior.
+//
+// Atlas documentation: https://atlasgo.io/versioned/intro
+type Option func(*AtlasMigrator)
+
+// WithFS specifies a [fs.FS] from which to read the migration files.
+// If not specified as an option to [New], the migrator will read from
+// the real filesystem.
+func WithFS(dir fs.FS) Option {
+	return func(am *AtlasMigrator) {
+		am.FS = dir
+	}
+}
+
+// AtlasMigrator is a [sqlitestdb.Migrator] that applies the versioned
+// migrations of an Atlas migration directory, without needing the atlas binary.
+//
+// The migration files are verified against the atlas.sum file of the directory,
+// as by "atlas migrate apply", and then executed in lexical order, each in its
+// own transaction. Unlike Atlas, the applied migrations aren't recorded in the
+// template database, as it is never migrated again.
+//
+// The files are split into statements, which are executed one by one, as
+// libsql only runs the first statement of [sql.DB.ExecContext].
+//
+// Because [Hash] requires calculating a unique hash based on the contents of
+// the migrations, this implementation only supports reading migration files
+// from disk or an embedded filesystem.
+type AtlasMigrator struct {
+	MigrationsDir string
+	FS            fs.FS
+}
+
+// New returns an [AtlasMigrator], which implements sqlitestdb.Migrator
+// by applying the migrations in migrationsDir.
+func New(migrationsDir string, opts ...Option) *AtlasMigrator {
+	am := &AtlasMigrator{MigrationsDir: migrationsDir}
+	for _, opt := range opts {
+		opt(am)
+	}
+
+	return am
+}
+
+// Hash returns the checksum of the migration directory, as recorded on the first
+// line of atlas.sum, hex-encoded instead of base64-encoded to be safe to use in
+// file names. It fails if atlas.sum doesn't match the migration files.
+func (am *AtlasMigrator) Hash() (string, error) {
+	_, sum, err := am.files()
+	if err != nil {
+		return "", errtrace.Wrap(err)
+	}
+
+	return hex.EncodeToString(sum), nil
+}
+
+// Migrate executes the migration files in lexical order, each in a
+// transaction, once they have been verified against atlas.sum.
+func (am *AtlasMigrator) Migrate(ctx context.Context, db *sql.DB, _ sqlitestdb.Config) error {
+	files, _, err := am.files()
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	for _, file := range files {
+		if err := apply(ctx, db, file); err != nil {
+			return errtrace.Errorf("migration %s: %w", file.name, err)
+		}
+	}
+
+	return nil
+}
+
+// apply executes the statements of a migration file in a transaction, with
+// [sqlitestdb.ExecSplit]. Errors name the position, line and text of the
+// statement that failed.
+func apply(ctx context.Context, db *sql.DB, file migrationFile) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+	defer tx.Rollback()
+
+	if err := sqlitestdb.ExecSplit(ctx, tx, string(file.contents)); err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	return errtrace.Wrap(tx.Commit())
+}
+
+// migrationFile is a migration file and its checksum, that of the directory up
+// to, and including, it.
+type migrationFile struct {
+	name     string
+	contents []byte
+	sum      string
+}
+
+// files returns the migration files of the directory, in lexical order, and
+// the checksum of the directory, once verified against atlas.sum.
+//
+// Like Atlas, the checksum of each file is the SHA-256 of the names and
+// contents of all the files up to, and including, it, and the checksum of the
+// directory the SHA-256 of the names and base64-encoded checksums of the files.
+func (am *AtlasMigrator) files() ([]migrationFile, []byte, error) {
+	source := am.source()
+	names, err := source.List("*.sql")
+	if err != nil {
+		return nil, nil, errtrace.Wrap(err)
+	}
+
+	files := make([]migrationFile, 0, len(names))
+	h := sha256.New()
+	for _, name := range names {
+		contents, err := source.ReadFile(name)
+		if err != nil {
+			return nil, nil, errtrace.Wrap(err)
+		}
+
+		h.Write([]byte(name))
+		h.Write(contents)
+		files = append(files, migrationFile{
+			name:     name,
+			contents: contents,
+			sum:      base64.StdEncoding.EncodeToString(h.Sum(nil)),
+		})
+	}
+
+	dirHash := sha256.New()
+	for _, file := range files {
+		dirHash.Write([]byte(file.name))
+		dirHash.Write([]byte(file.sum))
+	}
+	sum := dirHash.Sum(nil)
+
+	recorded, err := source.ReadFile(SumFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, errtrace.Errorf("%s not found in %q, create it with \"atlas migrate hash\"", SumFile, am.MigrationsDir)
+	} else if err != nil {
+		return nil, nil, errtrace.Wrap(err)
+	}
+
+	if err := verify(recorded, files, base64.StdEncoding.EncodeToString(sum)); err != nil {
+		return nil, nil, errtrace.Errorf("%s doesn't match the migration files in %q, update it with \"atlas migrate hash\": %w", SumFile, am.MigrationsDir, err)
+	}
+
+	return files, sum, nil
+}
+
+// verify compares the checksums recorded in atlas.sum to those of the files,
+// reporting the first file that differs.
+func verify(recorded []byte, files []migrationFile, sum string) error {
+	scanner := bufio.NewScanner(bytes.NewReader(recorded))
+	if !scanner.Scan() {
+		return errtrace.New("the file is empty")
+	}
+	recordedSum, ok := strings.CutPrefix(scanner.Text(), "h1:")
+	if !ok {
+		return errtrace.Errorf("unexpected first line %q", scanner.Text())
+	}
+
+	sums := make(map[string]string)
+	var order []string
+	for scanner.Scan() {
+		name, fileSum, ok := strings.Cut(scanner.Text(), " h1:")
+		if !ok {
+			return errtrace.Errorf("unexpected line %q", scanner.Text())
+		}
+		sums[name] = fileSum
+		order = append(order, name)
+	}
+	if err := scanner.Err(); err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	if recordedSum == sum && len(order) == len(files) {
+		return nil
+	}
+
+	for _, file := range files {
+		fileSum, ok := sums[file.name]
+		switch {
+		case !ok:
+			return errtrace.Errorf("%s is not listed", file.name)
+		case fileSum != file.sum:
+			return errtrace.Errorf("%s, or a file before it, was changed", file.name)
+		}
+		delete(sums, file.name)
+	}
+	for _, name := range order {
+		if _, ok := sums[name]; ok {
+			return errtrace.Errorf("%s is listed, but was removed", name)
+		}
+	}
+
+	return errtrace.Errorf("checksum %q, expected %q", sum, recordedSum)
+}
+
+// source returns the [common.Source] the migrations are read from, so that
+// embedded and on-disk migrations are handled identically.
+func (am *AtlasMigrator) source() common.Source {
+	return common.NewSource(am.FS, am.MigrationsDir)
+}
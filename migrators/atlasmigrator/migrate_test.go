@@ -0,0 +1,173 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package atlasmigrator_test
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/base64"
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/terinjokes/sqlitestdb"
+	"github.com/terinjokes/sqlitestdb/migrators/atlasmigrator"
+	"gotest.tools/v3/assert"
+)
+
+//go:embed migrations
+var exampleFS embed.FS
+
+func TestMigrateFromDisk(t *testing.T) {
+	t.Parallel()
+	am := atlasmigrator.New("migrations")
+	db := sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, am)
+	testDB(t, db)
+}
+
+func TestMigrateFromEmbeddedFS(t *testing.T) {
+	t.Parallel()
+	am := atlasmigrator.New("migrations", atlasmigrator.WithFS(exampleFS))
+	db := sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, am)
+	testDB(t, db)
+}
+
+func TestHashIsDirectorySum(t *testing.T) {
+	t.Parallel()
+	hash, err := atlasmigrator.New("migrations").Hash()
+	assert.NilError(t, err)
+	// The checksum on the first line of atlas.sum, hex-encoded.
+	assert.Equal(t, "0dcccb4272171ff98a79e1c3db79573d25c494c9cedf0249a01fa30d5546557a", hash)
+}
+
+// TestAtlasSum checks the migrator against the atlas.sum written by "atlas
+// migrate hash" for the migrations in testdata/atlas.
+func TestAtlasSum(t *testing.T) {
+	t.Parallel()
+
+	recorded, err := os.ReadFile(filepath.Join("testdata", "atlas", atlasmigrator.SumFile))
+	assert.NilError(t, err)
+	first, _, _ := strings.Cut(string(recorded), "\n")
+	sum, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(first, "h1:"))
+	assert.NilError(t, err)
+
+	am := atlasmigrator.New(filepath.Join("testdata", "atlas"))
+	hash, err := am.Hash()
+	assert.NilError(t, err)
+	assert.Equal(t, hex.EncodeToString(sum), hash)
+
+	db := sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, am)
+	var numBooks int
+	err = db.QueryRowContext(context.Background(), "SELECT count(*) FROM books").Scan(&numBooks)
+	assert.NilError(t, err)
+	assert.Equal(t, 0, numBooks)
+}
+
+func TestSumMismatch(t *testing.T) {
+	t.Parallel()
+
+	files := func() fstest.MapFS {
+		files := fstest.MapFS{}
+		err := fs.WalkDir(exampleFS, "migrations", func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			data, err := exampleFS.ReadFile(path)
+			files[path] = &fstest.MapFile{Data: data}
+			return err
+		})
+		assert.NilError(t, err)
+		return files
+	}
+
+	cases := map[string]struct {
+		change func(fstest.MapFS)
+		err    string
+	}{
+		"changed": {
+			change: func(files fstest.MapFS) {
+				files["migrations/20240102000000_cats.sql"].Data = append(files["migrations/20240102000000_cats.sql"].Data, "-- changed\n"...)
+			},
+			err: "20240102000000_cats.sql, or a file before it, was changed",
+		},
+		"added": {
+			change: func(files fstest.MapFS) {
+				files["migrations/20240103000000_dogs.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE dogs (id integer);\n")}
+			},
+			err: "20240103000000_dogs.sql is not listed",
+		},
+		"removed": {
+			change: func(files fstest.MapFS) {
+				delete(files, "migrations/20240102000000_cats.sql")
+			},
+			err: "20240102000000_cats.sql is listed, but was removed",
+		},
+		"missing": {
+			change: func(files fstest.MapFS) {
+				delete(files, "migrations/atlas.sum")
+			},
+			err: `atlas.sum not found in "migrations"`,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			changed := files()
+			c.change(changed)
+
+			_, err := atlasmigrator.New("migrations", atlasmigrator.WithFS(changed)).Hash()
+			assert.ErrorContains(t, err, c.err)
+			if name != "missing" {
+				assert.ErrorContains(t, err, `atlas.sum doesn't match the migration files in "migrations"`)
+			}
+		})
+	}
+}
+
+func TestMigrateError(t *testing.T) {
+	t.Parallel()
+	files := fstest.MapFS{
+		"migrations/0001_cats.sql": {Data: []byte("CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT);\n")},
+		"migrations/0002_seed.sql": {Data: []byte("-- Seed the cats.\n" +
+			"INSERT INTO cats (name) VALUES ('daisy');\n" +
+			"INSERT INTO dogs (name)\n  VALUES ('rex');\n")},
+		"migrations/atlas.sum": {Data: []byte("h1:0g+b3KarRDQftPVNgaOy5BofQIumYcy9SzQ77bnBOZc=\n" +
+			"0001_cats.sql h1:lcTYLtiIGh9egiIDO4EHAsGyFlk2nmWgz07blHG852w=\n" +
+			"0002_seed.sql h1:zPWigRuHHyBBEi/rqGKjRKbNptVGTD/GvD1TF9+nDr4=\n")},
+	}
+	am := atlasmigrator.New("migrations", atlasmigrator.WithFS(files))
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.sqlite"))
+	assert.NilError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	err = am.Migrate(context.Background(), db, sqlitestdb.Config{Driver: "sqlite3"})
+	assert.Error(t, err, "migration 0002_seed.sql: statement 2, line 3: INSERT INTO dogs (name) VALUES ('rex'): no such table: dogs")
+}
+
+func testDB(t *testing.T, db *sql.DB) {
+	ctx := context.Background()
+
+	var numUsers int
+	err := db.QueryRowContext(ctx, "SELECT count(*) FROM users").Scan(&numUsers)
+	assert.NilError(t, err)
+	assert.Equal(t, 0, numUsers)
+
+	var numCats int
+	err = db.QueryRowContext(ctx, "SELECT count(*) FROM cats").Scan(&numCats)
+	assert.NilError(t, err)
+	assert.Equal(t, 0, numCats)
+
+	var numBlogPosts int
+	err = db.QueryRowContext(ctx, "SELECT count(*) FROM blog_posts").Scan(&numBlogPosts)
+	assert.NilError(t, err)
+	assert.Equal(t, 0, numBlogPosts)
+}
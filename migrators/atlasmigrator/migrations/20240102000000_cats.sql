@@ -0,0 +1,5 @@
+-- Create "cats" table
+CREATE TABLE `cats` (
+  `id` integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+  `name` text NULL
+);
@@ -0,0 +1,6 @@
+-- Create "authors" table
+CREATE TABLE `authors` (
+  `id` integer NOT NULL,
+  `name` text NOT NULL,
+  PRIMARY KEY (`id`)
+);
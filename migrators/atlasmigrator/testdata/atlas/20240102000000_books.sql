@@ -0,0 +1,8 @@
+-- Create "books" table
+CREATE TABLE `books` (
+  `id` integer NOT NULL,
+  `title` text NOT NULL,
+  `author_id` integer NOT NULL,
+  PRIMARY KEY (`id`),
+  CONSTRAINT `author_fk` FOREIGN KEY (`author_id`) REFERENCES `authors` (`id`) ON UPDATE NO ACTION ON DELETE CASCADE
+);
@@ -0,0 +1,2 @@
+-- Create index "books_title" to table: "books"
+CREATE INDEX `books_title` ON `books` (`title`);
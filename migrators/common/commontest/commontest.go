@@ -0,0 +1,112 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+// Package commontest contains conformance tests for migrators built on
+// [common.Source], so that every first-party migrator handles embedded, on-disk,
+// and in-memory filesystems identically.
+package commontest
+
+import (
+	"database/sql"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/terinjokes/sqlitestdb"
+	"github.com/terinjokes/sqlitestdb/migrators/common"
+)
+
+// Conformance describes a migrator under test.
+type Conformance struct {
+	// Files contains the migrations, in the directory Dir.
+	Files fstest.MapFS
+	Dir   string
+
+	// Config is used to create the instance databases.
+	Config sqlitestdb.Config
+
+	// New returns a migrator that reads its migrations from the source.
+	New func(common.Source) sqlitestdb.Migrator
+
+	// Check asserts that an instance database was correctly migrated.
+	Check func(t *testing.T, db *sql.DB)
+}
+
+// Run runs the conformance tests as subtests of t:
+//
+//   - the hash is the same whether the files are read from a [fstest.MapFS],
+//     an [os.DirFS], or the real filesystem.
+//   - equivalent spellings of the directory produce the same hash.
+//   - changing the contents of a migration changes the hash.
+//   - migrating from each of the filesystems produces the expected database.
+func (c Conformance) Run(t *testing.T) {
+	t.Helper()
+
+	root := t.TempDir()
+	for name, file := range c.Files {
+		dst := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			t.Fatalf("could not create directory: %+v", err)
+		}
+		if err := os.WriteFile(dst, file.Data, 0o644); err != nil {
+			t.Fatalf("could not write migration: %+v", err)
+		}
+	}
+
+	sources := map[string]common.Source{
+		"MapFS":  common.NewSource(c.Files, c.Dir),
+		"DirFS":  common.NewSource(os.DirFS(root), c.Dir),
+		"OS":     common.NewSource(nil, filepath.Join(root, filepath.FromSlash(c.Dir))),
+		"Dotted": common.NewSource(c.Files, "./"+c.Dir+"/"),
+	}
+
+	want := c.hash(t, sources["MapFS"])
+
+	t.Run("SameHash", func(t *testing.T) {
+		for name, source := range sources {
+			if got := c.hash(t, source); got != want {
+				t.Errorf("%s: hash %q, expected %q", name, got, want)
+			}
+		}
+	})
+
+	t.Run("ChangedContents", func(t *testing.T) {
+		names, err := common.NewSource(c.Files, c.Dir).List("*")
+		if err != nil || len(names) == 0 {
+			t.Fatalf("could not list migrations: %+v", err)
+		}
+
+		changed := fstest.MapFS{}
+		for name, file := range c.Files {
+			changed[name] = file
+		}
+		first := path.Join(c.Dir, names[0])
+		changed[first] = &fstest.MapFile{Data: append(append([]byte{}, c.Files[first].Data...), "\n-- changed\n"...)}
+
+		if got := c.hash(t, common.NewSource(changed, c.Dir)); got == want {
+			t.Errorf("hash %q did not change with the contents of the migrations", got)
+		}
+	})
+
+	for name, source := range sources {
+		source := source
+		t.Run("Migrate"+name, func(t *testing.T) {
+			t.Parallel()
+			db := sqlitestdb.New(t, c.Config, c.New(source))
+			c.Check(t, db)
+		})
+	}
+}
+
+func (c Conformance) hash(t *testing.T, source common.Source) string {
+	t.Helper()
+
+	hash, err := c.New(source).Hash()
+	if err != nil {
+		t.Fatalf("could not hash migrations: %+v", err)
+	}
+
+	return hash
+}
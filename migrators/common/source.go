@@ -0,0 +1,102 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+// Package common contains helpers shared by the first-party migrators, so that
+// they all read migration files the same way.
+package common
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"braces.dev/errtrace"
+	"github.com/terinjokes/sqlitestdb/migrators/hash"
+)
+
+// Source is a directory of migration files. The files are read from FS, or
+// from the real filesystem if FS is nil.
+//
+// Dir is always interpreted relative to the root of FS, using forward slashes,
+// so "migrations", "./migrations", and "migrations/" all refer to the same
+// directory, whether FS is an [embed.FS], an [os.DirFS], or a [fstest.MapFS].
+//
+// [embed.FS]: https://pkg.go.dev/embed#FS
+// [fstest.MapFS]: https://pkg.go.dev/testing/fstest#MapFS
+type Source struct {
+	FS  fs.FS
+	Dir string
+}
+
+// NewSource returns a [Source] for the directory dir of fsys. If fsys is nil,
+// the directory is read from the real filesystem.
+func NewSource(fsys fs.FS, dir string) Source {
+	return Source{FS: fsys, Dir: dir}
+}
+
+// Sub returns an [fs.FS] rooted at the directory of the source.
+func (s Source) Sub() (fs.FS, error) {
+	if s.FS == nil {
+		return os.DirFS(filepath.FromSlash(s.dir())), nil
+	}
+
+	return errtrace.Wrap2(fs.Sub(s.FS, s.dir()))
+}
+
+// List returns the names of the files in the directory matching pattern, in
+// lexical order. The names are relative to the directory, and always use
+// forward slashes.
+func (s Source) List(pattern string) ([]string, error) {
+	sub, err := s.Sub()
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+
+	names, err := fs.Glob(sub, pattern)
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ReadFile reads the named file from the directory.
+func (s Source) ReadFile(name string) ([]byte, error) {
+	sub, err := s.Sub()
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+
+	return errtrace.Wrap2(fs.ReadFile(sub, path.Clean(filepath.ToSlash(name))))
+}
+
+// Hash returns a hash of the contents of the files in the directory matching
+// pattern, in lexical order.
+func (s Source) Hash(pattern string) (string, error) {
+	sub, err := s.Sub()
+	if err != nil {
+		return "", errtrace.Wrap(err)
+	}
+
+	return errtrace.Wrap2(hash.HashFS(sub, pattern))
+}
+
+// HashFiles returns a hash of the contents of the named files of the directory,
+// in order, such as a subset of the files listed by [Source.List]. The hash of
+// all of the files matching a pattern, in lexical order, is that returned by
+// [Source.Hash].
+func (s Source) HashFiles(names ...string) (string, error) {
+	sub, err := s.Sub()
+	if err != nil {
+		return "", errtrace.Wrap(err)
+	}
+
+	return errtrace.Wrap2(hash.HashFiles(sub, names...))
+}
+
+// dir returns the cleaned, slash-separated directory of the source.
+func (s Source) dir() string {
+	return path.Clean(filepath.ToSlash(s.Dir))
+}
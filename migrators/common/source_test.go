@@ -0,0 +1,54 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package common_test
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/terinjokes/sqlitestdb/migrators/common"
+	"gotest.tools/v3/assert"
+)
+
+func TestSource(t *testing.T) {
+	t.Parallel()
+
+	fsys := fstest.MapFS{
+		"migrations/0002_cats.sql":  {Data: []byte("CREATE TABLE cats (id INTEGER PRIMARY KEY);")},
+		"migrations/0001_users.sql": {Data: []byte("CREATE TABLE users (id INTEGER PRIMARY KEY);")},
+		"migrations/README":         {Data: []byte("not a migration")},
+	}
+
+	for _, dir := range []string{"migrations", "./migrations", "migrations/", "migrations/../migrations"} {
+		t.Run(dir, func(t *testing.T) {
+			source := common.NewSource(fsys, dir)
+
+			names, err := source.List("*.sql")
+			assert.NilError(t, err)
+			assert.DeepEqual(t, names, []string{"0001_users.sql", "0002_cats.sql"})
+
+			contents, err := source.ReadFile("./0002_cats.sql")
+			assert.NilError(t, err)
+			assert.Equal(t, string(contents), "CREATE TABLE cats (id INTEGER PRIMARY KEY);")
+		})
+	}
+
+	hash1, err := common.NewSource(fsys, "migrations").Hash("*.sql")
+	assert.NilError(t, err)
+	hash2, err := common.NewSource(fsys, "./migrations/").Hash("*.sql")
+	assert.NilError(t, err)
+	assert.Equal(t, hash1, hash2)
+
+	hash3, err := common.NewSource(fsys, "migrations").Hash("*")
+	assert.NilError(t, err)
+	assert.Assert(t, hash1 != hash3)
+
+	// Hashing all of the files matching a pattern is hashing the pattern.
+	all, err := common.NewSource(fsys, "migrations").HashFiles("0001_users.sql", "0002_cats.sql")
+	assert.NilError(t, err)
+	assert.Equal(t, hash1, all)
+	first, err := common.NewSource(fsys, "migrations").HashFiles("0001_users.sql")
+	assert.NilError(t, err)
+	assert.Assert(t, hash1 != first)
+}
@@ -0,0 +1,179 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package common
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Statement is a single SQL statement of a migration file.
+type Statement struct {
+	// SQL is the text of the statement, without the terminating semicolon.
+	SQL string
+
+	// Line is the line of the file the statement starts on, counting from 1.
+	Line int
+}
+
+// Summary returns the text of the statement on a single line, shortened, for
+// use in error messages.
+func (s Statement) Summary() string {
+	const maxLen = 80
+
+	summary := strings.Join(strings.Fields(s.SQL), " ")
+	if len(summary) > maxLen {
+		summary = summary[:maxLen-3] + "..."
+	}
+	return summary
+}
+
+// SplitStatements splits the contents of a migration file into its statements,
+// so that they can be executed one by one, as libsql only runs the first
+// statement of [sql.DB.ExecContext].
+//
+// Semicolons in string literals, quoted identifiers, comments, and the bodies
+// of CREATE TRIGGER statements don't end a statement. Statements that are empty,
+// or only contain comments, are omitted.
+//
+// [sql.DB.ExecContext]: https://pkg.go.dev/database/sql#DB.ExecContext
+func SplitStatements(contents string) []Statement {
+	var (
+		statements []Statement
+		start      int // offset of the current statement
+		line       = 1
+		words      []string // leading keywords of the current statement
+		trigger    bool     // the current statement is a CREATE TRIGGER
+		depth      int      // BEGIN and CASE blocks open in a trigger
+	)
+
+	emit := func(end int) {
+		stmt := contents[start:end]
+		if trimmed := trimStatement(stmt); trimmed != "" {
+			lead := stmt[:len(strings.TrimRightFunc(stmt, unicode.IsSpace))-len(trimmed)]
+			statements = append(statements, Statement{
+				SQL:  trimmed,
+				Line: line - strings.Count(stmt, "\n") + strings.Count(lead, "\n"),
+			})
+		}
+		words, trigger, depth = nil, false, 0
+	}
+
+	for i := 0; i < len(contents); {
+		c := contents[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`' || c == '[':
+			end := byte(c)
+			if c == '[' {
+				end = ']'
+			}
+			j := i + 1
+			for j < len(contents) {
+				if contents[j] == end {
+					// A doubled quote is an escaped quote.
+					if end != ']' && j+1 < len(contents) && contents[j+1] == end {
+						j += 2
+						continue
+					}
+					break
+				}
+				j++
+			}
+			j = min(j+1, len(contents))
+			line += strings.Count(contents[i:j], "\n")
+			i = j
+		case c == '-' && strings.HasPrefix(contents[i:], "--"):
+			j := strings.IndexByte(contents[i:], '\n')
+			if j < 0 {
+				j = len(contents) - i
+			}
+			i += j
+		case c == '/' && strings.HasPrefix(contents[i:], "/*"):
+			j := strings.Index(contents[i+2:], "*/")
+			if j < 0 {
+				j = len(contents)
+			} else {
+				j += i + 4
+			}
+			line += strings.Count(contents[i:j], "\n")
+			i = j
+		case c == ';':
+			if trigger && depth > 0 {
+				i++
+				continue
+			}
+			emit(i)
+			i++
+			start = i
+		case isWordByte(c):
+			j := i
+			for j < len(contents) && isWordByte(contents[j]) {
+				j++
+			}
+			word := strings.ToUpper(contents[i:j])
+			if len(words) < 4 {
+				words = append(words, word)
+				trigger = trigger || isCreateTrigger(words)
+			}
+			if trigger {
+				switch word {
+				case "BEGIN", "CASE":
+					depth++
+				case "END":
+					depth--
+				}
+			}
+			i = j
+		default:
+			if c == '\n' {
+				line++
+			}
+			i++
+		}
+	}
+	emit(len(contents))
+
+	return statements
+}
+
+// isCreateTrigger reports whether the leading keywords of a statement are those
+// of a CREATE TRIGGER statement.
+func isCreateTrigger(words []string) bool {
+	if len(words) < 2 || words[0] != "CREATE" {
+		return false
+	}
+	if words[1] == "TEMP" || words[1] == "TEMPORARY" {
+		return len(words) >= 3 && words[2] == "TRIGGER"
+	}
+	return words[1] == "TRIGGER"
+}
+
+// trimStatement trims the whitespace and comments before a statement, and the
+// whitespace after it. It returns an empty string if the statement only
+// contains whitespace and comments.
+func trimStatement(stmt string) string {
+	rest := strings.TrimSpace(stmt)
+	for {
+		switch {
+		case strings.HasPrefix(rest, "--"):
+			j := strings.IndexByte(rest, '\n')
+			if j < 0 {
+				return ""
+			}
+			rest = strings.TrimSpace(rest[j:])
+		case strings.HasPrefix(rest, "/*"):
+			j := strings.Index(rest, "*/")
+			if j < 0 {
+				return ""
+			}
+			rest = strings.TrimSpace(rest[j+2:])
+		default:
+			return rest
+		}
+	}
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
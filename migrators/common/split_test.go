@@ -0,0 +1,128 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package common_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/terinjokes/sqlitestdb/migrators/common"
+	"gotest.tools/v3/assert"
+)
+
+func TestSplitStatements(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name     string
+		contents string
+		want     []common.Statement
+	}{
+		{
+			name:     "Empty",
+			contents: "\n-- nothing to see here\n/* or here; */\n",
+		},
+		{
+			name:     "Single",
+			contents: "CREATE TABLE cats (id INTEGER PRIMARY KEY)",
+			want:     []common.Statement{{SQL: "CREATE TABLE cats (id INTEGER PRIMARY KEY)", Line: 1}},
+		},
+		{
+			name:     "Multiple",
+			contents: "-- cats\nCREATE TABLE cats (id INTEGER PRIMARY KEY);\n\n/* and dogs */ CREATE TABLE dogs (id INTEGER PRIMARY KEY);;\n",
+			want: []common.Statement{
+				{SQL: "CREATE TABLE cats (id INTEGER PRIMARY KEY)", Line: 2},
+				{SQL: "CREATE TABLE dogs (id INTEGER PRIMARY KEY)", Line: 4},
+			},
+		},
+		{
+			name:     "Quoted",
+			contents: "INSERT INTO \"semi;colons\" VALUES ('it''s; fine', `a;b`, [c;d]);\nSELECT 1;",
+			want: []common.Statement{
+				{SQL: "INSERT INTO \"semi;colons\" VALUES ('it''s; fine', `a;b`, [c;d])", Line: 1},
+				{SQL: "SELECT 1", Line: 2},
+			},
+		},
+		{
+			name:     "MultilineLiteral",
+			contents: "INSERT INTO notes VALUES ('one;\ntwo');\nSELECT 1;",
+			want: []common.Statement{
+				{SQL: "INSERT INTO notes VALUES ('one;\ntwo')", Line: 1},
+				{SQL: "SELECT 1", Line: 3},
+			},
+		},
+		{
+			name: "Trigger",
+			contents: "CREATE TEMP TRIGGER cats_audit AFTER INSERT ON cats BEGIN\n" +
+				"  INSERT INTO audit VALUES (CASE WHEN NEW.id > 0 THEN 'ok' ELSE 'odd' END);\n" +
+				"  UPDATE cats SET seen = 1 WHERE id = NEW.id;\n" +
+				"END;\n" +
+				"BEGIN;\nSELECT 1;\nCOMMIT;",
+			want: []common.Statement{
+				{
+					SQL: "CREATE TEMP TRIGGER cats_audit AFTER INSERT ON cats BEGIN\n" +
+						"  INSERT INTO audit VALUES (CASE WHEN NEW.id > 0 THEN 'ok' ELSE 'odd' END);\n" +
+						"  UPDATE cats SET seen = 1 WHERE id = NEW.id;\n" +
+						"END",
+					Line: 1,
+				},
+				{SQL: "BEGIN", Line: 5},
+				{SQL: "SELECT 1", Line: 6},
+				{SQL: "COMMIT", Line: 7},
+			},
+		},
+		{
+			name: "TriggerWithLiterals",
+			contents: "CREATE TRIGGER \"log;end\" AFTER DELETE ON cats BEGIN\n" +
+				"  INSERT INTO log VALUES ('END;', \"BEGIN;\");\n" +
+				"  -- END; in a comment\n" +
+				"  /* and END; in a block */ DELETE FROM owners WHERE id = OLD.owner_id;\n" +
+				"END;\n" +
+				"SELECT 'after';",
+			want: []common.Statement{
+				{
+					SQL: "CREATE TRIGGER \"log;end\" AFTER DELETE ON cats BEGIN\n" +
+						"  INSERT INTO log VALUES ('END;', \"BEGIN;\");\n" +
+						"  -- END; in a comment\n" +
+						"  /* and END; in a block */ DELETE FROM owners WHERE id = OLD.owner_id;\n" +
+						"END",
+					Line: 1,
+				},
+				{SQL: "SELECT 'after'", Line: 6},
+			},
+		},
+		{
+			name:     "QuoteInComment",
+			contents: "-- it's a comment; really\nSELECT 1; /* don't */ SELECT 2;\n",
+			want: []common.Statement{
+				{SQL: "SELECT 1", Line: 2},
+				{SQL: "SELECT 2", Line: 2},
+			},
+		},
+		{
+			name:     "UnterminatedLiteral",
+			contents: "SELECT 1;\nINSERT INTO notes VALUES ('oops; never closed);\nSELECT 2;",
+			want: []common.Statement{
+				{SQL: "SELECT 1", Line: 1},
+				{SQL: "INSERT INTO notes VALUES ('oops; never closed);\nSELECT 2;", Line: 2},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			assert.DeepEqual(t, common.SplitStatements(tc.contents), tc.want)
+		})
+	}
+}
+
+func TestStatementSummary(t *testing.T) {
+	t.Parallel()
+
+	stmt := common.Statement{SQL: "INSERT INTO cats (name)\n  VALUES ('daisy')"}
+	assert.Equal(t, stmt.Summary(), "INSERT INTO cats (name) VALUES ('daisy')")
+
+	stmt = common.Statement{SQL: "CREATE TABLE cats (" + strings.Repeat("name TEXT, ", 10) + "id INTEGER PRIMARY KEY)"}
+	assert.Equal(t, len(stmt.Summary()), 80)
+	assert.Assert(t, strings.HasSuffix(stmt.Summary(), "..."))
+}
@@ -0,0 +1,308 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+// Package csvseed loads reference data from CSV files into tables, as a
+// [sqlitestdb.Migrator] or a [sqlitestdb.Seeder].
+package csvseed
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+
+	"braces.dev/errtrace"
+	"github.com/terinjokes/sqlitestdb"
+	"github.com/terinjokes/sqlitestdb/migrators/hash"
+)
+
+// DefaultBatchSize is the number of rows inserted by each statement, unless set
+// with [WithBatchSize].
+const DefaultBatchSize = 100
+
+// maxVariables is the maximum number of parameters of a statement in the
+// oldest version of SQLite supported by sqlitestdb.
+const maxVariables = 999
+
+// Option provides a way to configure the Loader struct and its behavior.
+type Option func(*Loader)
+
+// WithEmptyAsNull inserts empty cells as NULL, instead of as empty strings.
+func WithEmptyAsNull() Option {
+	return func(l *Loader) {
+		l.EmptyAsNull = true
+	}
+}
+
+// WithBatchSize specifies the number of rows inserted by each statement. It is
+// lowered if needed, so that a statement has at most 999 parameters. If not
+// specified as an option to [New], [DefaultBatchSize] is used.
+func WithBatchSize(n int) Option {
+	return func(l *Loader) {
+		l.BatchSize = n
+	}
+}
+
+// Loader is a [sqlitestdb.Migrator] and a [sqlitestdb.Seeder] that inserts the
+// rows of CSV files into tables.
+//
+// The header row of each file names the columns the cells are inserted into.
+// The cells are inserted as strings, so that they are converted according to
+// the type affinity of their columns by SQLite.
+//
+// The tables are loaded in the order of their names, in a single transaction,
+// in which foreign keys are only checked once all the files are loaded.
+type Loader struct {
+	FS          fs.FS
+	Tables      map[string]string
+	EmptyAsNull bool
+	BatchSize   int
+}
+
+// New returns a [Loader] inserting each CSV file of mapping, from table names
+// to file paths, into its table. The files are read from fsys, or from the real
+// filesystem if fsys is nil.
+func New(fsys fs.FS, mapping map[string]string, opts ...Option) *Loader {
+	l := &Loader{FS: fsys, Tables: mapping, BatchSize: DefaultBatchSize}
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	return l
+}
+
+// Hash returns a hash of the tables, and of the contents of their files. The
+// batch size doesn't change the loaded rows, and isn't included.
+func (l *Loader) Hash() (string, error) {
+	h := hash.NewRecursiveHash(hash.Field("EmptyAsNull", l.EmptyAsNull))
+	for _, table := range l.tables() {
+		contents, err := l.readFile(l.Tables[table])
+		if err != nil {
+			return "", errtrace.Wrap(err)
+		}
+		h.AddField("Table", table)
+		h.Add(contents)
+	}
+
+	return h.String(), nil
+}
+
+// Migrate loads the CSV files into the template database.
+func (l *Loader) Migrate(ctx context.Context, db *sql.DB, _ sqlitestdb.Config) error {
+	return errtrace.Wrap(l.Seed(ctx, db))
+}
+
+// Seed loads the CSV files into an instance database.
+func (l *Loader) Seed(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "PRAGMA defer_foreign_keys = ON"); err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	for _, table := range l.tables() {
+		if err := l.load(ctx, tx, table, l.Tables[table]); err != nil {
+			return errtrace.Wrap(err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errtrace.Errorf("could not commit CSV files: %w", err)
+	}
+	return nil
+}
+
+// load inserts the rows of the file at path into the table, in batches.
+func (l *Loader) load(ctx context.Context, tx *sql.Tx, table, path string) error {
+	contents, err := l.readFile(path)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	r := csv.NewReader(strings.NewReader(string(contents)))
+	header, err := r.Read()
+	if errors.Is(err, io.EOF) {
+		return errtrace.Errorf("%s: missing header row", path)
+	} else if err != nil {
+		return errtrace.Errorf("%s: %w", path, err)
+	}
+	columns := append([]string(nil), header...)
+
+	batchSize := max(1, min(l.BatchSize, maxVariables/len(columns)))
+	b := &batch{
+		ctx:     ctx,
+		tx:      tx,
+		table:   table,
+		path:    path,
+		columns: columns,
+		stmts:   make(map[int]*sql.Stmt),
+	}
+	defer b.close()
+
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return errtrace.Errorf("%s: %w", path, err)
+		}
+
+		line, _ := r.FieldPos(0)
+		b.add(line, l.values(record))
+		if len(b.lines) == batchSize {
+			if err := b.flush(); err != nil {
+				return errtrace.Wrap(err)
+			}
+		}
+	}
+
+	return errtrace.Wrap(b.flush())
+}
+
+// values returns the parameters inserting the cells of a record.
+func (l *Loader) values(record []string) []any {
+	values := make([]any, len(record))
+	for i, cell := range record {
+		if cell == "" && l.EmptyAsNull {
+			continue
+		}
+		values[i] = cell
+	}
+	return values
+}
+
+// tables returns the names of the tables, in order.
+func (l *Loader) tables() []string {
+	tables := make([]string, 0, len(l.Tables))
+	for table := range l.Tables {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	return tables
+}
+
+func (l *Loader) readFile(path string) ([]byte, error) {
+	if l.FS == nil {
+		return errtrace.Wrap2(os.ReadFile(path))
+	}
+	return errtrace.Wrap2(fs.ReadFile(l.FS, path))
+}
+
+// batch is the rows of a file waiting to be inserted into its table.
+type batch struct {
+	ctx     context.Context
+	tx      *sql.Tx
+	table   string
+	path    string
+	columns []string
+
+	lines  []int
+	values []any
+
+	// stmts are the prepared statements inserting a number of rows.
+	stmts map[int]*sql.Stmt
+}
+
+func (b *batch) add(line int, values []any) {
+	b.lines = append(b.lines, line)
+	b.values = append(b.values, values...)
+}
+
+// flush inserts the rows of the batch. If the statement fails, the rows are
+// inserted one by one, to report the one that failed.
+func (b *batch) flush() error {
+	if len(b.lines) == 0 {
+		return nil
+	}
+	defer func() {
+		b.lines, b.values = b.lines[:0], b.values[:0]
+	}()
+
+	stmt, err := b.stmt(len(b.lines))
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+	if _, err = stmt.ExecContext(b.ctx, b.values...); err == nil {
+		return nil
+	}
+
+	single, sErr := b.stmt(1)
+	if sErr != nil {
+		return errtrace.Errorf("%s: %w", b.path, err)
+	}
+	n := len(b.columns)
+	for i, line := range b.lines {
+		row := b.values[i*n : (i+1)*n]
+		if _, err := single.ExecContext(b.ctx, row...); err != nil {
+			return errtrace.Errorf("%s:%d: %s: %w", b.path, line, b.describe(row, err), err)
+		}
+	}
+
+	// The batch failed, but each of its rows can be inserted.
+	return errtrace.Errorf("%s: %w", b.path, err)
+}
+
+// describe names the failing value of a row, if the error names its column, or
+// all of the values of the row otherwise.
+func (b *batch) describe(row []any, err error) string {
+	for i, column := range b.columns {
+		if strings.Contains(err.Error(), b.table+"."+column) {
+			return fmt.Sprintf("column %s, value %s", column, formatValue(row[i]))
+		}
+	}
+
+	values := make([]string, len(row))
+	for i, v := range row {
+		values[i] = formatValue(v)
+	}
+	return fmt.Sprintf("values (%s)", strings.Join(values, ", "))
+}
+
+// stmt returns the prepared statement inserting n rows.
+func (b *batch) stmt(n int) (*sql.Stmt, error) {
+	if stmt, ok := b.stmts[n]; ok {
+		return stmt, nil
+	}
+
+	columns := make([]string, len(b.columns))
+	for i, column := range b.columns {
+		columns[i] = quoteIdentifier(column)
+	}
+	row := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(b.columns)), ", ") + ")"
+	query := "INSERT INTO " + quoteIdentifier(b.table) + " (" + strings.Join(columns, ", ") + ") VALUES " +
+		strings.TrimSuffix(strings.Repeat(row+", ", n), ", ")
+
+	stmt, err := b.tx.PrepareContext(b.ctx, query)
+	if err != nil {
+		return nil, errtrace.Errorf("%s: %w", b.path, err)
+	}
+	b.stmts[n] = stmt
+	return stmt, nil
+}
+
+func (b *batch) close() {
+	for _, stmt := range b.stmts {
+		stmt.Close()
+	}
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func formatValue(v any) string {
+	if v == nil {
+		return "NULL"
+	}
+	return fmt.Sprintf("%q", v)
+}
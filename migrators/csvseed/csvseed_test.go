@@ -0,0 +1,179 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package csvseed_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/terinjokes/sqlitestdb"
+	"github.com/terinjokes/sqlitestdb/migrators/csvseed"
+	"gotest.tools/v3/assert"
+)
+
+// schema creates the tables the CSV files are loaded into.
+var schema = sqlitestdb.MigrateFunc("csvseed-owners-cats-v1", func(ctx context.Context, db *sql.DB) error {
+	for _, stmt := range []string{
+		"CREATE TABLE owners (id INTEGER PRIMARY KEY, name TEXT NOT NULL)",
+		"CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT NOT NULL, weight REAL, owner_id INTEGER REFERENCES owners (id))",
+	} {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+})
+
+var files = fstest.MapFS{
+	"data/owners.csv": {Data: []byte("id,name\n1,terin\n2,\"o'brien, jr.\"\n")},
+	// The columns are in another order than in the table, and the cats are
+	// loaded before the owners they reference.
+	"data/cats.csv": {Data: []byte("owner_id,name,weight,id\n1,daisy,4.5,1\n2,sunny,,2\n")},
+}
+
+var mapping = map[string]string{"cats": "data/cats.csv", "owners": "data/owners.csv"}
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	l := csvseed.New(files, mapping)
+	db := sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, sqlitestdb.Sequence(schema, l))
+
+	var name string
+	assert.NilError(t, db.QueryRowContext(ctx, "SELECT name FROM owners WHERE id = 2").Scan(&name))
+	assert.Equal(t, "o'brien, jr.", name)
+
+	// The cells are converted according to the affinity of the columns.
+	var weightType, ownerType string
+	assert.NilError(t, db.QueryRowContext(ctx, "SELECT typeof(weight), typeof(owner_id) FROM cats WHERE id = 1").Scan(&weightType, &ownerType))
+	assert.Equal(t, "real", weightType)
+	assert.Equal(t, "integer", ownerType)
+
+	// Empty cells are empty strings, unless WithEmptyAsNull is used.
+	assert.NilError(t, db.QueryRowContext(ctx, "SELECT typeof(weight) FROM cats WHERE id = 2").Scan(&weightType))
+	assert.Equal(t, "text", weightType)
+
+	db = sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, schema, sqlitestdb.WithSeed(csvseed.New(files, mapping, csvseed.WithEmptyAsNull())))
+	assert.NilError(t, db.QueryRowContext(ctx, "SELECT typeof(weight) FROM cats WHERE id = 2").Scan(&weightType))
+	assert.Equal(t, "null", weightType)
+}
+
+func TestHash(t *testing.T) {
+	t.Parallel()
+
+	hash, err := csvseed.New(files, mapping).Hash()
+	assert.NilError(t, err)
+
+	batched, err := csvseed.New(files, mapping, csvseed.WithBatchSize(1)).Hash()
+	assert.NilError(t, err)
+	assert.Equal(t, hash, batched)
+
+	nulls, err := csvseed.New(files, mapping, csvseed.WithEmptyAsNull()).Hash()
+	assert.NilError(t, err)
+	assert.Assert(t, hash != nulls)
+
+	changed := fstest.MapFS{
+		"data/owners.csv": files["data/owners.csv"],
+		"data/cats.csv":   {Data: []byte("owner_id,name,weight,id\n1,daisy,4.5,1\n")},
+	}
+	changedHash, err := csvseed.New(changed, mapping).Hash()
+	assert.NilError(t, err)
+	assert.Assert(t, hash != changedHash)
+
+	// The files are read from the real filesystem without fsys.
+	dir := t.TempDir()
+	onDisk := make(map[string]string)
+	for table, path := range mapping {
+		onDisk[table] = filepath.Join(dir, table+".csv")
+		assert.NilError(t, os.WriteFile(onDisk[table], files[path].Data, 0o644))
+	}
+	diskHash, err := csvseed.New(nil, onDisk).Hash()
+	assert.NilError(t, err)
+	assert.Equal(t, hash, diskHash)
+}
+
+func TestLoadErrors(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		cats string
+		err  string
+	}{
+		"value": {
+			cats: "id,name,owner_id\n1,daisy,1\n2,,1\n3,sunny,1\n",
+			err:  `data/cats.csv:3: column name, value NULL: NOT NULL constraint failed: cats.name`,
+		},
+		"fields": {
+			cats: "id,name,owner_id\n1,daisy,1\n2,sunny\n",
+			err:  `data/cats.csv: record on line 3: wrong number of fields`,
+		},
+		"column": {
+			cats: "id,nmae\n1,daisy\n",
+			err:  `data/cats.csv: table cats has no column named nmae`,
+		},
+		"foreign key": {
+			cats: "id,name,owner_id\n1,daisy,3\n",
+			err:  `could not commit CSV files: FOREIGN KEY constraint failed`,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			files := fstest.MapFS{
+				"data/owners.csv": files["data/owners.csv"],
+				"data/cats.csv":   {Data: []byte(c.cats)},
+			}
+			l := csvseed.New(files, mapping, csvseed.WithEmptyAsNull())
+
+			db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "test.sqlite")+"?_foreign_keys=1")
+			assert.NilError(t, err)
+			defer db.Close()
+			assert.NilError(t, schema.Migrate(context.Background(), db, sqlitestdb.Config{Driver: "sqlite3"}))
+
+			err = l.Seed(context.Background(), db)
+			assert.ErrorContains(t, err, c.err)
+
+			// Nothing was inserted.
+			var count int
+			assert.NilError(t, db.QueryRow("SELECT COUNT(*) FROM owners").Scan(&count))
+			assert.Equal(t, 0, count)
+		})
+	}
+}
+
+func TestLoadManyRows(t *testing.T) {
+	t.Parallel()
+	const rows = 5000
+
+	var b strings.Builder
+	b.WriteString("id,name,weight,owner_id\n")
+	for i := 1; i <= rows; i++ {
+		fmt.Fprintf(&b, "%d,cat %d,%d.5,%d\n", i, i, i%10, i%2+1)
+	}
+	many := fstest.MapFS{
+		"data/owners.csv": files["data/owners.csv"],
+		"data/cats.csv":   {Data: []byte(b.String())},
+	}
+
+	start := time.Now()
+	db := sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, sqlitestdb.Sequence(schema, csvseed.New(many, mapping)), sqlitestdb.WithForceRebuild())
+	t.Logf("loaded %d rows in %v", rows, time.Since(start))
+
+	var count int
+	assert.NilError(t, db.QueryRow("SELECT COUNT(*) FROM cats").Scan(&count))
+	assert.Equal(t, rows, count)
+	var sum float64
+	assert.NilError(t, db.QueryRow("SELECT SUM(weight) FROM cats WHERE owner_id = 1").Scan(&sum))
+	assert.Assert(t, sum > 0)
+}
@@ -0,0 +1,87 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package dbmatemigrator
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"io/fs"
+	"net/url"
+	"path/filepath"
+
+	"braces.dev/errtrace"
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	_ "github.com/amacneil/dbmate/v2/pkg/driver/sqlite" // sqlite driver
+	"github.com/terinjokes/sqlitestdb"
+	"github.com/terinjokes/sqlitestdb/migrators/common"
+)
+
+// Option provides a way to configure the DbmateMigrator struct and its behavior.
+//
+// dbmate documentation: https://github.com/amacneil/dbmate
+type Option func(*DbmateMigrator)
+
+// WithFS specifies a [fs.FS] from which to read the migration files.
+// If not specified as an option to [New], the migrator will read from
+// the real filesystem.
+func WithFS(dir fs.FS) Option {
+	return func(dm *DbmateMigrator) {
+		dm.FS = dir
+	}
+}
+
+// DbmateMigrator is a [sqlitestdb.Migrator] that uses dbmate to perform migrations.
+// dbmate records the applied migrations in the schema_migrations table.
+//
+// Because [Hash] requires calculating a unique hash based on the contents of
+// the migrations, this implementation only supports reading migration files
+// from disk or an embedded filesystem.
+type DbmateMigrator struct {
+	MigrationsDir string
+	FS            fs.FS
+}
+
+// New returns a [DbmateMigrator], which implements sqlitestdb.Migrator
+// using dbmate to perform up migrations.
+func New(migrationsDir string, opts ...Option) *DbmateMigrator {
+	dm := &DbmateMigrator{MigrationsDir: migrationsDir}
+	for _, opt := range opts {
+		opt(dm)
+	}
+
+	return dm
+}
+
+func (dm *DbmateMigrator) Hash() (string, error) {
+	return errtrace.Wrap2(dm.source().Hash("*.sql"))
+}
+
+// Migrate runs dbmate's CreateAndMigrate to migrate the template database.
+//
+// dbmate opens the database itself, from a "sqlite:" URL. The URL is built
+// from the path of the template, instead of being parsed, so that dbmate
+// migrates exactly that file. Dumping the schema, which dbmate does by default
+// after migrating, is disabled.
+func (dm *DbmateMigrator) Migrate(_ context.Context, _ *sql.DB, templateConfig sqlitestdb.Config) error {
+	sub, err := dm.source().Sub()
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	u := &url.URL{Scheme: "sqlite", Path: filepath.ToSlash(templateConfig.Database)}
+	db := dbmate.New(u)
+	db.FS = sub
+	db.MigrationsDir = []string{"."}
+	db.AutoDumpSchema = false
+	db.Log = io.Discard
+
+	return errtrace.Wrap(db.CreateAndMigrate())
+}
+
+// source returns the [common.Source] the migrations are read from, so that
+// embedded and on-disk migrations are handled identically.
+func (dm *DbmateMigrator) source() common.Source {
+	return common.NewSource(dm.FS, dm.MigrationsDir)
+}
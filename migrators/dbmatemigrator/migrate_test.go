@@ -0,0 +1,94 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package dbmatemigrator_test
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"testing"
+	"testing/fstest"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/terinjokes/sqlitestdb"
+	"github.com/terinjokes/sqlitestdb/migrators/common"
+	"github.com/terinjokes/sqlitestdb/migrators/common/commontest"
+	"github.com/terinjokes/sqlitestdb/migrators/dbmatemigrator"
+	"gotest.tools/v3/assert"
+)
+
+//go:embed migrations/*.sql
+var exampleFS embed.FS
+
+func TestMigrateFromDisk(t *testing.T) {
+	t.Parallel()
+	dm := dbmatemigrator.New("migrations")
+	db := sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, dm)
+	testDB(t, db)
+}
+
+func TestMigrateFromEmbeddedFS(t *testing.T) {
+	t.Parallel()
+	dm := dbmatemigrator.New("migrations", dbmatemigrator.WithFS(exampleFS))
+	db := sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, dm)
+	testDB(t, db)
+}
+
+func TestSourceConformance(t *testing.T) {
+	t.Parallel()
+	commontest.Conformance{
+		Files: fstest.MapFS{
+			"db/migrations/20240101000000_init.sql": {Data: []byte("-- migrate:up\nCREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);\n-- migrate:down\nDROP TABLE users;\n")},
+			"db/migrations/20240102000000_cats.sql": {Data: []byte("-- migrate:up\nCREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT);\n-- migrate:down\nDROP TABLE cats;\n")},
+		},
+		Dir:    "db/migrations",
+		Config: sqlitestdb.Config{Driver: "sqlite3"},
+		New: func(source common.Source) sqlitestdb.Migrator {
+			return dbmatemigrator.New(source.Dir, dbmatemigrator.WithFS(source.FS))
+		},
+		Check: func(t *testing.T, db *sql.DB) {
+			var version string
+			err := db.QueryRow("SELECT MAX(version) FROM schema_migrations").Scan(&version)
+			assert.NilError(t, err)
+			assert.Equal(t, "20240102000000", version)
+
+			var numCats int
+			err = db.QueryRow("SELECT count(*) FROM cats").Scan(&numCats)
+			assert.NilError(t, err)
+			assert.Equal(t, 0, numCats)
+		},
+	}.Run(t)
+}
+
+func testDB(t *testing.T, db *sql.DB) {
+	ctx := context.Background()
+
+	rows, err := db.QueryContext(ctx, "SELECT version FROM schema_migrations ORDER BY version")
+	assert.NilError(t, err)
+	defer rows.Close()
+
+	var versions []string
+	for rows.Next() {
+		var version string
+		assert.NilError(t, rows.Scan(&version))
+		versions = append(versions, version)
+	}
+	assert.NilError(t, rows.Err())
+	assert.DeepEqual(t, []string{"20240101000000", "20240102000000"}, versions)
+
+	var numUsers int
+	err = db.QueryRowContext(ctx, "SELECT count(*) FROM users").Scan(&numUsers)
+	assert.NilError(t, err)
+	assert.Equal(t, 0, numUsers)
+
+	var numCats int
+	err = db.QueryRowContext(ctx, "SELECT count(*) FROM cats").Scan(&numCats)
+	assert.NilError(t, err)
+	assert.Equal(t, 0, numCats)
+
+	var numBlogPosts int
+	err = db.QueryRowContext(ctx, "SELECT count(*) FROM blog_posts").Scan(&numBlogPosts)
+	assert.NilError(t, err)
+	assert.Equal(t, 0, numBlogPosts)
+}
@@ -0,0 +1,8 @@
+-- migrate:up
+CREATE TABLE cats (
+       id INTEGER PRIMARY KEY AUTOINCREMENT,
+       name TEXT
+);
+
+-- migrate:down
+DROP TABLE cats;
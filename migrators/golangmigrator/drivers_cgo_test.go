@@ -0,0 +1,10 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+//go:build cgo
+
+package golangmigrator_test
+
+// drivers are the database/sql drivers the tests are run with. mattn/go-sqlite3
+// requires cgo.
+var drivers = []string{"sqlite3", "sqlite"}
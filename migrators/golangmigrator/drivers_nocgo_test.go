@@ -0,0 +1,10 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+//go:build !cgo
+
+package golangmigrator_test
+
+// drivers are the database/sql drivers the tests are run with. Without cgo,
+// only modernc.org/sqlite is available.
+var drivers = []string{"sqlite"}
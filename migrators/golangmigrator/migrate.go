@@ -7,17 +7,30 @@ package golangmigrator
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"io/fs"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
 
 	"braces.dev/errtrace"
 	"github.com/golang-migrate/migrate/v4"
-	_ "github.com/golang-migrate/migrate/v4/database/sqlite3" // sqlite3 driver
-	_ "github.com/golang-migrate/migrate/v4/source/file"      // "file://"" source driver
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite"  // sqlite driver, using modernc.org/sqlite
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite3" // sqlite3 driver, using mattn/go-sqlite3
+	"github.com/golang-migrate/migrate/v4/source"
 	"github.com/golang-migrate/migrate/v4/source/iofs"
-	"github.com/peterldowns/pgtestdb/migrators/common"
 	"github.com/terinjokes/sqlitestdb"
+	"github.com/terinjokes/sqlitestdb/migrators/common"
+	"github.com/terinjokes/sqlitestdb/migrators/hash"
 )
 
+// DefaultHashPattern is the pattern of the migration files included in
+// [GolangMigrator.Hash], unless set with [WithHashPattern]. It includes the
+// down migrations, which are never run against the template.
+const DefaultHashPattern = "*.sql"
+
 // Option provides a way to configure the GolangMigrator struct and its behavior.
 //
 // golang-migrate documentation: https://github.com/golang-migrate/migrate
@@ -32,6 +45,54 @@ func WithFS(dir fs.FS) Option {
 	}
 }
 
+// WithHashPattern specifies the pattern, in the syntax of [path.Match], of the
+// migration files included in [GolangMigrator.Hash]. If not specified as an
+// option to [New], [DefaultHashPattern] is used.
+func WithHashPattern(pattern string) Option {
+	return func(gm *GolangMigrator) {
+		gm.HashPattern = pattern
+	}
+}
+
+// WithGlob is the same as [WithHashPattern], under the name of the option of
+// the other migrators. Files that don't match the pattern, such as helper
+// scripts kept alongside the migrations, are neither hashed nor, as long as
+// their names aren't those of migrations, applied.
+func WithGlob(pattern string) Option {
+	return WithHashPattern(pattern)
+}
+
+// WithDirs specifies directories of migrations applied after those of the
+// directory passed to [New], one directory after the other, in order.
+//
+// The versions of each directory are tracked separately, so that their
+// numbering doesn't need to be shared: the first directory uses the
+// schema_migrations table, and each of the following ones a table named after
+// it, such as schema_migrations_addons for the "db/addons" directory, so their
+// last elements must differ.
+func WithDirs(dirs ...string) Option {
+	return func(gm *GolangMigrator) {
+		gm.Dirs = append(gm.Dirs, dirs...)
+	}
+}
+
+// WithForceOnDirty recovers a database left dirty by a migration that failed
+// before, such as a template of a run that crashed: the dirty version is forced
+// back to the previous one, and the migrations are retried once. Without it,
+// migrating a dirty database fails.
+func WithForceOnDirty() Option {
+	return func(gm *GolangMigrator) {
+		gm.ForceOnDirty = true
+	}
+}
+
+// WithIgnoreDownMigrations only includes the up migrations in
+// [GolangMigrator.Hash], as the down migrations are never run against the
+// template, so that editing them doesn't create a new template.
+func WithIgnoreDownMigrations() Option {
+	return WithHashPattern("*.up.sql")
+}
+
 // GolangMigrator is a [sqlitestdb.Migrator] that uses golang-migrate to perform migrations.
 //
 // Because [Hash] requires calculating a unique hash based on the contents of
@@ -39,13 +100,16 @@ func WithFS(dir fs.FS) Option {
 // from disk or an embedded filesystem.
 type GolangMigrator struct {
 	MigrationsDir string
+	Dirs          []string
 	FS            fs.FS
+	HashPattern   string
+	ForceOnDirty  bool
 }
 
 // New returns a [GolangMigrator], which implements sqlitestdb.Migrator
 // using golang-migrate to perform up migrations.
 func New(migrationsDir string, opts ...Option) *GolangMigrator {
-	gm := &GolangMigrator{MigrationsDir: migrationsDir}
+	gm := &GolangMigrator{MigrationsDir: migrationsDir, HashPattern: DefaultHashPattern}
 	for _, opt := range opts {
 		opt(gm)
 	}
@@ -53,34 +117,384 @@ func New(migrationsDir string, opts ...Option) *GolangMigrator {
 	return gm
 }
 
+// Hash returns a hash of the migration files matching the hash pattern, of each
+// directory in the order they are applied, along with the table tracking its
+// versions.
 func (gm *GolangMigrator) Hash() (string, error) {
-	return common.HashDirs(gm.FS, "*.sql", gm.MigrationsDir)
+	tables, err := migrationsTables(gm.dirs())
+	if err != nil {
+		return "", errtrace.Wrap(err)
+	}
+
+	h := hash.NewRecursiveHash()
+	for i, dir := range gm.dirs() {
+		dirHash, err := gm.source(dir).Hash(gm.hashPattern())
+		if err != nil {
+			return "", errtrace.Wrap(err)
+		}
+		h.AddField(tables[i], dirHash)
+	}
+
+	return h.String(), nil
 }
 
-// Migrate runs migrate.Up() to migrate the template database.
-func (gm *GolangMigrator) Migrate(_ context.Context, _ *sql.DB, templateConfig sqlitestdb.Config) error {
-	var m *migrate.Migrate
-	dsn := "sqlite3://" + templateConfig.Database
+// Migrate migrates the template database one migration at a time, with
+// migrate.Steps(1), for each directory of migrations in turn, and reports each
+// migration with [sqlitestdb.ReportProgress]. The migrations are counted for
+// each directory.
+//
+// golang-migrate opens the database itself, with its database driver matching
+// the driver of the template: "sqlite3" for mattn/go-sqlite3, and "sqlite",
+// which uses modernc.org/sqlite and doesn't require cgo, otherwise. As
+// golang-migrate has no libsql driver, templates for libsql are also migrated
+// with modernc.org/sqlite.
+func (gm *GolangMigrator) Migrate(ctx context.Context, _ *sql.DB, templateConfig sqlitestdb.Config) error {
+	dsns, err := gm.databaseURLs(templateConfig)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
 
-	switch {
-	case gm.FS != nil:
-		d, err := iofs.New(gm.FS, gm.MigrationsDir)
+	for i, dir := range gm.dirs() {
+		if err := gm.up(ctx, dir, dsns[i], -1); err != nil {
+			return errtrace.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+// HashAt returns a hash of the migration files matching the hash pattern, up
+// to, and including, version, and of the other files matching it, such as
+// helper scripts. It isn't supported with [WithDirs], as the versions of each
+// directory are numbered separately.
+func (gm *GolangMigrator) HashAt(version int64) (string, error) {
+	if len(gm.Dirs) > 0 {
+		return "", errtrace.New("migrating to a version isn't supported with WithDirs")
+	}
+
+	src := gm.source(gm.MigrationsDir)
+	names, err := src.List(gm.hashPattern())
+	if err != nil {
+		return "", errtrace.Wrap(err)
+	}
+	var kept []string
+	for _, name := range names {
+		if m, err := source.DefaultParse(path.Base(name)); err == nil && int64(m.Version) > version {
+			continue
+		}
+		kept = append(kept, name)
+	}
+
+	dirHash, err := src.HashFiles(kept...)
+	if err != nil {
+		return "", errtrace.Wrap(err)
+	}
+
+	h := hash.NewRecursiveHash(hash.Field("Version", version))
+	h.AddField("schema_migrations", dirHash)
+	return h.String(), nil
+}
+
+// MigrateTo migrates the template database up to, and including, version, like
+// [GolangMigrator.Migrate], so that templates can be created at that version
+// with [sqlitestdb.NewAtVersion]. It isn't supported with [WithDirs].
+func (gm *GolangMigrator) MigrateTo(ctx context.Context, _ *sql.DB, templateConfig sqlitestdb.Config, version int64) error {
+	if len(gm.Dirs) > 0 {
+		return errtrace.New("migrating to a version isn't supported with WithDirs")
+	}
+	if version < 0 {
+		return errtrace.Errorf("invalid version %d", version)
+	}
+
+	dsns, err := gm.databaseURLs(templateConfig)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	return errtrace.Wrap(gm.up(ctx, gm.MigrationsDir, dsns[0], version))
+}
+
+// Down runs migrate.Down() to revert all of the migrations of the database,
+// for each directory of migrations in turn, in the reverse order. It is used to
+// check that the down migrations revert the up migrations, see
+// [migtest.CheckReversible].
+//
+// [migtest.CheckReversible]: https://pkg.go.dev/github.com/terinjokes/sqlitestdb/migrators/migtest#CheckReversible
+func (gm *GolangMigrator) Down(_ context.Context, _ *sql.DB, config sqlitestdb.Config) error {
+	dsns, err := gm.databaseURLs(config)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	dirs := gm.dirs()
+	for i := len(dirs) - 1; i >= 0; i-- {
+		m, _, _, err := gm.open(dirs[i], dsns[i])
 		if err != nil {
 			return errtrace.Wrap(err)
 		}
+		err = m.Down()
+		m.Close()
+		if err != nil && !errors.Is(err, migrate.ErrNoChange) {
+			return errtrace.Errorf("migrations of %q: %w", dirs[i], err)
+		}
+	}
+
+	return nil
+}
+
+// VersionTables returns the names of the tables golang-migrate records the
+// applied migrations of each directory in.
+func (gm *GolangMigrator) VersionTables() []string {
+	tables, _ := migrationsTables(gm.dirs())
+	return tables
+}
+
+// databaseURLs returns the URLs golang-migrate opens the database with, for
+// each directory of migrations.
+func (gm *GolangMigrator) databaseURLs(config sqlitestdb.Config) ([]string, error) {
+	scheme, err := databaseScheme(config.Driver)
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+
+	dsn, err := databaseURL(scheme, config.Database)
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+
+	tables, err := migrationsTables(gm.dirs())
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+
+	dsns := make([]string, len(tables))
+	for i, table := range tables {
+		dsns[i] = dsn
+		if i > 0 {
+			dsns[i] += "?" + url.Values{"x-migrations-table": {table}}.Encode()
+		}
+	}
+
+	return dsns, nil
+}
 
-		m, err = migrate.NewWithSourceInstance("iofs", d, dsn)
+// open returns a migrate.Migrate for the migrations of dir and the database at
+// dsn, along with the source of the migrations.
+func (gm *GolangMigrator) open(dir, dsn string) (*migrate.Migrate, source.Driver, fs.FS, error) {
+	sub, err := gm.source(dir).Sub()
+	if err != nil {
+		return nil, nil, nil, errtrace.Wrap(err)
+	}
+
+	d, err := iofs.New(sub, ".")
+	if err != nil {
+		return nil, nil, nil, errtrace.Wrap(err)
+	}
+
+	m, err := migrate.NewWithSourceInstance("iofs", d, dsn)
+	if err != nil {
+		return nil, nil, nil, errtrace.Wrap(err)
+	}
+
+	return m, d, sub, nil
+}
+
+// up applies the migrations of dir to the database at dsn, up to, and
+// including, version to, or all of them if to is negative.
+func (gm *GolangMigrator) up(ctx context.Context, dir, dsn string, to int64) error {
+	m, d, sub, err := gm.open(dir, dsn)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	defer m.Close()
+
+	if to >= 0 {
+		r, _, err := d.ReadUp(uint(to))
 		if err != nil {
+			return errtrace.Errorf("no up migration with version %d in %q: %w", to, dir, err)
+		}
+		r.Close()
+	}
+
+	err = steps(ctx, m, d, dir, sub, to)
+	var dirty migrate.ErrDirty
+	if errors.As(err, &dirty) {
+		if !gm.ForceOnDirty {
+			return errtrace.Errorf("database is dirty at migration %s, from a previous run that failed; force its version, or use WithForceOnDirty: %w",
+				migrationName(sub, dirty.Version), err)
+		}
+
+		// Forcing the previous version, or no version at all before the first
+		// migration, runs the dirty migration again.
+		version := -1
+		if prev, err := d.Prev(uint(dirty.Version)); err == nil {
+			version = int(prev)
+		} else if !errors.Is(err, fs.ErrNotExist) {
 			return errtrace.Wrap(err)
 		}
-	default:
-		var err error
-		m, err = migrate.New("file://"+gm.MigrationsDir, dsn)
+		if err := m.Force(version); err != nil {
+			return errtrace.Wrap(err)
+		}
+		err = steps(ctx, m, d, dir, sub, to)
+	}
+	if err == nil {
+		return nil
+	}
+
+	// The migration that failed is left as the dirty version of the database.
+	version, isDirty, vErr := m.Version()
+	if vErr != nil || !isDirty {
+		return errtrace.Wrap(err)
+	}
+	return errtrace.Errorf("migration %s failed, leaving the database dirty: %w", migrationName(sub, int(version)), err)
+}
+
+// steps applies the pending migrations one at a time, like [migrate.Migrate.Up],
+// up to, and including, version to, or all of them if to is negative, reporting
+// each of them with [sqlitestdb.ReportProgress].
+func steps(ctx context.Context, m *migrate.Migrate, d source.Driver, dir string, sub fs.FS, to int64) error {
+	total, err := pending(m, d, to)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	for applied := 1; applied <= total; applied++ {
+		if err := m.Steps(1); err != nil {
+			return errtrace.Wrap(err)
+		}
+
+		version, _, err := m.Version()
 		if err != nil {
 			return errtrace.Wrap(err)
 		}
+		sqlitestdb.ReportProgress(ctx, sqlitestdb.Event{
+			Kind:      sqlitestdb.MigrationApplied,
+			Migration: path.Join(filepath.ToSlash(dir), migrationName(sub, int(version))),
+			Applied:   applied,
+			Total:     total,
+		})
 	}
 
-	defer m.Close()
-	return errtrace.Wrap(m.Up())
+	return nil
+}
+
+// pending returns the number of migrations after the version of the database,
+// up to, and including, version to, or all of them if to is negative.
+func pending(m *migrate.Migrate, d source.Driver, to int64) (int, error) {
+	version, dirty, err := m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		version, err = d.First()
+	} else if err == nil && dirty {
+		return 0, errtrace.Wrap(migrate.ErrDirty{Version: int(version)})
+	} else if err == nil {
+		version, err = d.Next(version)
+	}
+
+	var n int
+	for ; err == nil && (to < 0 || int64(version) <= to); n++ {
+		version, err = d.Next(version)
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return 0, errtrace.Wrap(err)
+	}
+
+	return n, nil
+}
+
+// migrationName returns the name of the file of the up migration for version,
+// or the version if there is no such file.
+func migrationName(fsys fs.FS, version int) string {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err == nil {
+		for _, e := range entries {
+			m, err := source.DefaultParse(e.Name())
+			if err == nil && m.Direction == source.Up && m.Version == uint(version) {
+				return e.Name()
+			}
+		}
+	}
+
+	return fmt.Sprintf("version %d", version)
+}
+
+// migrationsTables returns the names of the tables tracking the versions of
+// each directory: schema_migrations for the first one, and a table named after
+// the last element of the directory for each of the following ones.
+func migrationsTables(dirs []string) ([]string, error) {
+	tables := make([]string, len(dirs))
+	seen := make(map[string]string, len(dirs))
+	for i, dir := range dirs {
+		tables[i] = "schema_migrations"
+		if i > 0 {
+			tables[i] += "_" + strings.Map(func(r rune) rune {
+				if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+					return r
+				}
+				return '_'
+			}, path.Base(path.Clean(filepath.ToSlash(dir))))
+		}
+
+		if other, ok := seen[tables[i]]; ok {
+			return nil, errtrace.Errorf("migration directories %q and %q would share the table %s", other, dir, tables[i])
+		}
+		seen[tables[i]] = dir
+	}
+
+	return tables, nil
+}
+
+// databaseURL returns the URL golang-migrate opens the database at path with.
+//
+// golang-migrate parses the URL, and passes it to the database/sql driver
+// without its scheme. The path is written as a "file:" URI, which SQLite
+// decodes, so that it is escaped: otherwise, spaces, '#', '?', and '%' would be
+// misread, as would the backslashes of a Windows path.
+func databaseURL(scheme, path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", errtrace.Wrap(err)
+	}
+
+	path = filepath.ToSlash(path)
+	if !strings.HasPrefix(path, "/") {
+		// A Windows path starting with a drive letter.
+		path = "/" + path
+	}
+
+	file := url.URL{Path: path}
+	u := url.URL{Scheme: scheme, Opaque: "//file:" + file.EscapedPath()}
+	return u.String(), nil
+}
+
+// databaseScheme returns the scheme of the golang-migrate database driver used
+// to migrate templates for the database/sql driver.
+func databaseScheme(driver string) (string, error) {
+	switch driver {
+	case "sqlite3":
+		return "sqlite3", nil
+	case "sqlite", "libsql":
+		return "sqlite", nil
+	default:
+		return "", errtrace.Errorf("golang-migrate has no database driver for %q", driver)
+	}
+}
+
+// hashPattern returns the pattern of the migration files included in the hash.
+func (gm *GolangMigrator) hashPattern() string {
+	if gm.HashPattern == "" {
+		return DefaultHashPattern
+	}
+	return gm.HashPattern
+}
+
+// dirs returns the directories of migrations, in the order they are applied.
+func (gm *GolangMigrator) dirs() []string {
+	return append([]string{gm.MigrationsDir}, gm.Dirs...)
+}
+
+// source returns the [common.Source] the migrations of dir are read from, so
+// that embedded and on-disk migrations are handled identically.
+func (gm *GolangMigrator) source(dir string) common.Source {
+	return common.NewSource(gm.FS, dir)
 }
@@ -8,29 +8,408 @@ import (
 	"context"
 	"database/sql"
 	"embed"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
 	"testing"
+	"testing/fstest"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/terinjokes/sqlitestdb"
+	"github.com/terinjokes/sqlitestdb/migrators/common"
+	"github.com/terinjokes/sqlitestdb/migrators/common/commontest"
 	"github.com/terinjokes/sqlitestdb/migrators/golangmigrator"
+	"github.com/terinjokes/sqlitestdb/migrators/migtest"
 	"gotest.tools/v3/assert"
+	_ "modernc.org/sqlite"
 )
 
 //go:embed migrations/*.sql
 var exampleFS embed.FS
 
+//go:embed testdata/core/*.sql testdata/addons/*.sql
+var dirsFS embed.FS
+
+//go:embed testdata/broken/*.sql
+var brokenFS embed.FS
+
+//go:embed testdata/reversible/*.sql
+var reversibleFS embed.FS
+
 func TestMigrateFromDisk(t *testing.T) {
 	t.Parallel()
-	gm := golangmigrator.New("migrations")
-	db := sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, gm)
-	testDB(t, db)
+	for _, driver := range drivers {
+		t.Run(driver, func(t *testing.T) {
+			t.Parallel()
+			gm := golangmigrator.New("migrations")
+			db := sqlitestdb.New(t, sqlitestdb.Config{Driver: driver}, gm)
+			testDB(t, db)
+		})
+	}
 }
 
 func TestMigrateFromEmbeddedFS(t *testing.T) {
 	t.Parallel()
+	for _, driver := range drivers {
+		t.Run(driver, func(t *testing.T) {
+			t.Parallel()
+			gm := golangmigrator.New("migrations", golangmigrator.WithFS(exampleFS))
+			db := sqlitestdb.New(t, sqlitestdb.Config{Driver: driver}, gm)
+			testDB(t, db)
+		})
+	}
+}
+
+func TestMigratePathWithSpecialCharacters(t *testing.T) {
+	t.Parallel()
+	for _, driver := range drivers {
+		for _, name := range []string{"with space", "with#hash", "with%percent", "with%20escape", "with?question"} {
+			t.Run(driver+"/"+name, func(t *testing.T) {
+				t.Parallel()
+				dir := filepath.Join(t.TempDir(), name)
+				assert.NilError(t, os.Mkdir(dir, 0o755))
+				path := filepath.Join(dir, "template.sqlite")
+
+				gm := golangmigrator.New("migrations")
+				err := gm.Migrate(context.Background(), nil, sqlitestdb.Config{Driver: driver, Database: path})
+				assert.NilError(t, err)
+
+				// The template was created at the path, and nowhere else.
+				entries, err := os.ReadDir(dir)
+				assert.NilError(t, err)
+				assert.Equal(t, len(entries), 1)
+				assert.Equal(t, entries[0].Name(), "template.sqlite")
+
+				uri := url.URL{Scheme: "file", Opaque: (&url.URL{Path: filepath.ToSlash(path)}).EscapedPath()}
+				db, err := sql.Open(driver, uri.String())
+				assert.NilError(t, err)
+				defer db.Close()
+				testDB(t, db)
+			})
+		}
+	}
+}
+
+func TestWithIgnoreDownMigrations(t *testing.T) {
+	t.Parallel()
+	files := fstest.MapFS{
+		"migrations/0001_cats.up.sql":   {Data: []byte("CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT);")},
+		"migrations/0001_cats.down.sql": {Data: []byte("DROP TABLE cats;")},
+	}
+	touched := fstest.MapFS{
+		"migrations/0001_cats.up.sql":   files["migrations/0001_cats.up.sql"],
+		"migrations/0001_cats.down.sql": {Data: []byte("DROP TABLE IF EXISTS cats;")},
+	}
+	hash := func(files fstest.MapFS, opts ...golangmigrator.Option) string {
+		t.Helper()
+		hash, err := golangmigrator.New("migrations", append(opts, golangmigrator.WithFS(files))...).Hash()
+		assert.NilError(t, err)
+		return hash
+	}
+
+	// By default, touching a down migration changes the hash.
+	assert.Assert(t, hash(files) != hash(touched))
+
+	// Unless they are ignored.
+	assert.Equal(t, hash(files, golangmigrator.WithIgnoreDownMigrations()), hash(touched, golangmigrator.WithIgnoreDownMigrations()))
+
+	// Up migrations are always included.
+	touched["migrations/0001_cats.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE cats (id INTEGER PRIMARY KEY);")}
+	assert.Assert(t, hash(files, golangmigrator.WithIgnoreDownMigrations()) != hash(touched, golangmigrator.WithIgnoreDownMigrations()))
+}
+
+func TestWithDirs(t *testing.T) {
+	t.Parallel()
+	for _, driver := range drivers {
+		t.Run(driver, func(t *testing.T) {
+			t.Parallel()
+			gm := golangmigrator.New("testdata/core",
+				golangmigrator.WithDirs("testdata/addons"),
+				golangmigrator.WithFS(dirsFS),
+				golangmigrator.WithGlob("*.up.sql"),
+			)
+			db := sqlitestdb.New(t, sqlitestdb.Config{Driver: driver}, gm)
+
+			// The versions of each directory are tracked in their own table.
+			for table, want := range map[string]int{
+				"schema_migrations":        1,
+				"schema_migrations_addons": 2,
+			} {
+				var version int
+				err := db.QueryRow("SELECT version FROM " + table).Scan(&version)
+				assert.NilError(t, err)
+				assert.Equal(t, want, version, table)
+			}
+
+			// The addons were applied after the core migrations.
+			_, err := db.Exec("INSERT INTO owners (name, email) VALUES ('terin', 'terin@example.com')")
+			assert.NilError(t, err)
+			_, err = db.Exec("INSERT INTO pets (owner_id, name) VALUES (1, 'daisy')")
+			assert.NilError(t, err)
+		})
+	}
+}
+
+func TestWithDirsHash(t *testing.T) {
+	t.Parallel()
+	files := fstest.MapFS{
+		"core/0001_owners.up.sql": {Data: []byte("CREATE TABLE owners (id INTEGER PRIMARY KEY);")},
+		"core/reset.sql":          {Data: []byte("DELETE FROM owners;")},
+		"addons/0001_pets.up.sql": {Data: []byte("CREATE TABLE pets (id INTEGER PRIMARY KEY);")},
+	}
+	hash := func(files fstest.MapFS, dirs ...string) string {
+		t.Helper()
+		gm := golangmigrator.New(dirs[0],
+			golangmigrator.WithDirs(dirs[1:]...),
+			golangmigrator.WithFS(files),
+			golangmigrator.WithGlob("*.up.sql"),
+		)
+		hash, err := gm.Hash()
+		assert.NilError(t, err)
+		return hash
+	}
+	original := hash(files, "core", "addons")
+
+	// Every directory is hashed, in order.
+	assert.Assert(t, original != hash(files, "core"))
+	assert.Assert(t, original != hash(files, "addons", "core"))
+
+	// Files that don't match the glob aren't.
+	files["core/reset.sql"] = &fstest.MapFile{Data: []byte("DELETE FROM owners WHERE id > 1;")}
+	assert.Equal(t, original, hash(files, "core", "addons"))
+
+	files["addons/0001_pets.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE pets (id INTEGER PRIMARY KEY, name TEXT);")}
+	assert.Assert(t, original != hash(files, "core", "addons"))
+}
+
+func TestWithDirsSharedTable(t *testing.T) {
+	t.Parallel()
+	gm := golangmigrator.New("migrations", golangmigrator.WithDirs("a-b", "extra/a_b"))
+	err := gm.Migrate(context.Background(), nil, sqlitestdb.Config{Driver: drivers[0], Database: filepath.Join(t.TempDir(), "template.sqlite")})
+	assert.ErrorContains(t, err, `migration directories "a-b" and "extra/a_b" would share the table schema_migrations_a_b`)
+}
+
+func TestMigrateFails(t *testing.T) {
+	t.Parallel()
+	for _, driver := range drivers {
+		t.Run(driver, func(t *testing.T) {
+			t.Parallel()
+			path := filepath.Join(t.TempDir(), "template.sqlite")
+			config := sqlitestdb.Config{Driver: driver, Database: path}
+
+			gm := golangmigrator.New("testdata/broken", golangmigrator.WithFS(brokenFS))
+			err := gm.Migrate(context.Background(), nil, config)
+			assert.ErrorContains(t, err, "migration 0002_cats.up.sql failed, leaving the database dirty: ")
+
+			// Migrating the dirty database again fails, with or without the fix.
+			err = gm.Migrate(context.Background(), nil, config)
+			assert.ErrorContains(t, err, "database is dirty at migration 0002_cats.up.sql")
+
+			fixed := fstest.MapFS{
+				"testdata/broken/0001_init.up.sql": {Data: mustReadFile(t, brokenFS, "testdata/broken/0001_init.up.sql")},
+				"testdata/broken/0002_cats.up.sql": {Data: []byte("CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT NOT NULL);")},
+			}
+			err = golangmigrator.New("testdata/broken", golangmigrator.WithFS(fixed)).Migrate(context.Background(), nil, config)
+			assert.ErrorContains(t, err, "database is dirty at migration 0002_cats.up.sql")
+
+			// Unless the version is forced.
+			gm = golangmigrator.New("testdata/broken", golangmigrator.WithFS(fixed), golangmigrator.WithForceOnDirty())
+			assert.NilError(t, gm.Migrate(context.Background(), nil, config))
+
+			db, err := sql.Open(driver, path)
+			assert.NilError(t, err)
+			defer db.Close()
+
+			var (
+				version int
+				dirty   bool
+			)
+			err = db.QueryRow("SELECT version, dirty FROM schema_migrations").Scan(&version, &dirty)
+			assert.NilError(t, err)
+			assert.Equal(t, 2, version)
+			assert.Assert(t, !dirty)
+
+			_, err = db.Exec("INSERT INTO cats (name) VALUES ('daisy')")
+			assert.NilError(t, err)
+		})
+	}
+}
+
+func TestForceOnDirtyFirstMigration(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "template.sqlite")
+	config := sqlitestdb.Config{Driver: drivers[0], Database: path}
+
+	broken := fstest.MapFS{
+		"migrations/0001_init.up.sql": {Data: []byte("CREATE TABLE users (id INTEGER PRIMARY KEY,);")},
+	}
+	err := golangmigrator.New("migrations", golangmigrator.WithFS(broken)).Migrate(context.Background(), nil, config)
+	assert.ErrorContains(t, err, "migration 0001_init.up.sql failed, leaving the database dirty: ")
+
+	gm := golangmigrator.New("migrations", golangmigrator.WithForceOnDirty())
+	assert.NilError(t, gm.Migrate(context.Background(), nil, config))
+}
+
+func TestProgress(t *testing.T) {
+	t.Parallel()
+	for _, driver := range drivers {
+		t.Run(driver, func(t *testing.T) {
+			t.Parallel()
+			// The default pattern hashes the helper scripts too, so that the
+			// template isn't shared with the other tests, and is rebuilt.
+			gm := golangmigrator.New("testdata/core",
+				golangmigrator.WithDirs("testdata/addons"),
+				golangmigrator.WithFS(dirsFS),
+			)
+
+			var events []sqlitestdb.Event
+			_, err := sqlitestdb.Prepare(context.Background(), sqlitestdb.Config{Driver: driver}, gm,
+				sqlitestdb.WithForceRebuild(),
+				sqlitestdb.WithProgress(func(e sqlitestdb.Event) { events = append(events, e) }),
+			)
+			assert.NilError(t, err)
+
+			var applied []string
+			for _, e := range events[1 : len(events)-1] {
+				assert.Equal(t, sqlitestdb.MigrationApplied, e.Kind)
+				applied = append(applied, fmt.Sprintf("%d/%d %s", e.Applied, e.Total, e.Migration))
+			}
+			assert.DeepEqual(t, applied, []string{
+				"1/1 testdata/core/0001_owners.up.sql",
+				"1/2 testdata/addons/0001_pets.up.sql",
+				"2/2 testdata/addons/0002_owner_email.up.sql",
+			})
+			assert.Equal(t, sqlitestdb.BuildStarted, events[0].Kind)
+			assert.Equal(t, sqlitestdb.BuildFinished, events[len(events)-1].Kind)
+			assert.NilError(t, events[len(events)-1].Err)
+		})
+	}
+}
+
+func TestNewAtVersion(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	for _, driver := range drivers {
+		t.Run(driver, func(t *testing.T) {
+			t.Parallel()
+			gm := golangmigrator.New("testdata/reversible", golangmigrator.WithFS(reversibleFS))
+			db := sqlitestdb.NewAtVersion(t, sqlitestdb.Config{Driver: driver}, gm, 1)
+
+			var version int
+			assert.NilError(t, db.QueryRowContext(ctx, "SELECT version FROM schema_migrations").Scan(&version))
+			assert.Equal(t, 1, version)
+			_, err := db.ExecContext(ctx, "INSERT INTO owners (id, name) VALUES (1, 'terin')")
+			assert.NilError(t, err)
+			_, err = db.ExecContext(ctx, "SELECT * FROM pets")
+			assert.ErrorContains(t, err, "no such table: pets")
+
+			// The owners survive the later migrations.
+			assert.NilError(t, gm.Migrate(ctx, db, *sqlitestdb.ConfigFor(db)))
+			_, err = db.ExecContext(ctx, "INSERT INTO pets (owner_id, name) VALUES (1, 'daisy')")
+			assert.NilError(t, err)
+		})
+	}
+}
+
+func TestHashAt(t *testing.T) {
+	t.Parallel()
+	files := fstest.MapFS{
+		"migrations/0001_owners.up.sql": {Data: []byte("CREATE TABLE owners (id INTEGER PRIMARY KEY);")},
+		"migrations/0002_pets.up.sql":   {Data: []byte("CREATE TABLE pets (id INTEGER PRIMARY KEY);")},
+	}
+	before, err := golangmigrator.New("migrations", golangmigrator.WithFS(files)).HashAt(1)
+	assert.NilError(t, err)
+
+	// Adding or changing later migrations doesn't change the hash at a version.
+	files["migrations/0002_pets.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE pets (id INTEGER PRIMARY KEY, name TEXT);")}
+	files["migrations/0003_cats.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE cats (id INTEGER PRIMARY KEY);")}
+	after, err := golangmigrator.New("migrations", golangmigrator.WithFS(files)).HashAt(1)
+	assert.NilError(t, err)
+	assert.Equal(t, before, after)
+
+	latest, err := golangmigrator.New("migrations", golangmigrator.WithFS(files)).HashAt(2)
+	assert.NilError(t, err)
+	assert.Assert(t, latest != before)
+
+	_, err = golangmigrator.New("migrations", golangmigrator.WithFS(files), golangmigrator.WithDirs("addons")).HashAt(1)
+	assert.ErrorContains(t, err, "isn't supported with WithDirs")
+
+	config := sqlitestdb.Config{Driver: drivers[0], Database: filepath.Join(t.TempDir(), "template.sqlite")}
+	err = golangmigrator.New("migrations", golangmigrator.WithFS(files)).MigrateTo(context.Background(), nil, config, 7)
+	assert.ErrorContains(t, err, `no up migration with version 7 in "migrations"`)
+}
+
+func TestReversible(t *testing.T) {
+	t.Parallel()
+	for _, driver := range drivers {
+		t.Run(driver, func(t *testing.T) {
+			t.Parallel()
+			gm := golangmigrator.New("testdata/reversible", golangmigrator.WithFS(reversibleFS))
+			migtest.CheckReversible(t, sqlitestdb.Config{Driver: driver}, gm)
+		})
+	}
+}
+
+func TestUnsupportedDriver(t *testing.T) {
+	t.Parallel()
+	gm := golangmigrator.New("migrations")
+	err := gm.Migrate(context.Background(), nil, sqlitestdb.Config{Driver: "postgres", Database: "test.sqlite"})
+	assert.ErrorContains(t, err, `golang-migrate has no database driver for "postgres"`)
+}
+
+func TestSourceConformance(t *testing.T) {
+	t.Parallel()
+	commontest.Conformance{
+		Files: fstest.MapFS{
+			"db/migrations/0001_init.up.sql":   {Data: []byte("CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);")},
+			"db/migrations/0001_init.down.sql": {Data: []byte("DROP TABLE users;")},
+			"db/migrations/0002_cats.up.sql":   {Data: []byte("CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT);")},
+		},
+		Dir:    "db/migrations",
+		Config: sqlitestdb.Config{Driver: drivers[0]},
+		New: func(source common.Source) sqlitestdb.Migrator {
+			return golangmigrator.New(source.Dir, golangmigrator.WithFS(source.FS))
+		},
+		Check: func(t *testing.T, db *sql.DB) {
+			var version int
+			err := db.QueryRow("SELECT version FROM schema_migrations").Scan(&version)
+			assert.NilError(t, err)
+			assert.Equal(t, 2, version)
+
+			var numCats int
+			err = db.QueryRow("SELECT count(*) FROM cats").Scan(&numCats)
+			assert.NilError(t, err)
+			assert.Equal(t, 0, numCats)
+		},
+	}.Run(t)
+}
+
+func TestSequenceWithFixtures(t *testing.T) {
+	t.Parallel()
+	fixtures := sqlitestdb.MigrateFunc("cats-fixtures-v1", func(ctx context.Context, db *sql.DB) error {
+		_, err := db.ExecContext(ctx, "INSERT INTO cats (name) VALUES ('daisy'), ('sunny')")
+		return err
+	})
 	gm := golangmigrator.New("migrations", golangmigrator.WithFS(exampleFS))
-	db := sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, gm)
-	testDB(t, db)
+	m := sqlitestdb.Sequence(gm, fixtures)
+	db := sqlitestdb.New(t, sqlitestdb.Config{Driver: drivers[0]}, m)
+
+	var numCats int
+	err := db.QueryRow("SELECT count(*) FROM cats").Scan(&numCats)
+	assert.NilError(t, err)
+	assert.Equal(t, 2, numCats)
+
+	// Changing the migrations changes the hash of the sequence.
+	hash, err := m.Hash()
+	assert.NilError(t, err)
+	changed := fstest.MapFS{
+		"migrations/0001_init.up.sql": {Data: []byte("CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT);")},
+	}
+	changedHash, err := sqlitestdb.Sequence(golangmigrator.New("migrations", golangmigrator.WithFS(changed)), fixtures).Hash()
+	assert.NilError(t, err)
+	assert.Assert(t, hash != changedHash)
 }
 
 func testDB(t *testing.T, db *sql.DB) {
@@ -61,3 +440,10 @@ func testDB(t *testing.T, db *sql.DB) {
 	assert.NilError(t, err)
 	assert.Equal(t, 0, numBlogPosts)
 }
+
+func mustReadFile(t *testing.T, fsys fs.FS, name string) []byte {
+	t.Helper()
+	contents, err := fs.ReadFile(fsys, name)
+	assert.NilError(t, err)
+	return contents
+}
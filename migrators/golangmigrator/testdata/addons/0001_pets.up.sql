@@ -0,0 +1,5 @@
+CREATE TABLE pets (
+  id INTEGER PRIMARY KEY,
+  owner_id INTEGER NOT NULL REFERENCES owners (id),
+  name TEXT NOT NULL
+);
@@ -0,0 +1 @@
+ALTER TABLE owners ADD COLUMN email TEXT;
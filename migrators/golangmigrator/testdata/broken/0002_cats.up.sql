@@ -0,0 +1,4 @@
+CREATE TABLE cats (
+  id INTEGER PRIMARY KEY,
+  name TEXT NOT NULL,
+);
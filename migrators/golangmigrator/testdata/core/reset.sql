@@ -0,0 +1,2 @@
+-- Not a migration: clears the tables between manual runs.
+DELETE FROM owners;
@@ -0,0 +1,4 @@
+CREATE TABLE owners (
+  id INTEGER PRIMARY KEY,
+  name TEXT NOT NULL
+);
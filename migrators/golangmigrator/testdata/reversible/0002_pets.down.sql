@@ -0,0 +1,2 @@
+DROP INDEX pets_owner_id;
+DROP TABLE pets;
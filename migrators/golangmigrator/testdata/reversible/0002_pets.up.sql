@@ -0,0 +1,6 @@
+CREATE TABLE pets (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  owner_id INTEGER NOT NULL REFERENCES owners (id),
+  name TEXT NOT NULL
+);
+CREATE INDEX pets_owner_id ON pets (owner_id);
@@ -0,0 +1,23 @@
+module github.com/terinjokes/sqlitestdb/migrators/goosemigrator
+
+go 1.23.0
+
+require (
+	braces.dev/errtrace v0.3.0
+	github.com/mattn/go-sqlite3 v1.14.24
+	github.com/pressly/goose/v3 v3.24.2
+	github.com/terinjokes/sqlitestdb v0.1.0
+	gotest.tools/v3 v3.5.1
+)
+
+require (
+	github.com/google/go-cmp v0.6.0 // indirect
+	github.com/mfridman/interpolate v0.0.2 // indirect
+	github.com/sethvargo/go-retry v0.3.0 // indirect
+	go.uber.org/multierr v1.11.0 // indirect
+	golang.org/x/mod v0.22.0 // indirect
+	golang.org/x/sync v0.12.0 // indirect
+	golang.org/x/sys v0.31.0 // indirect
+)
+
+replace github.com/terinjokes/sqlitestdb => ../..
@@ -0,0 +1,270 @@
+// Copyright 2024 Terin Stock.
+// Copyright 2023 Peter Downs.
+// SPDX-License-Identifier: MIT
+
+package goosemigrator
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"math"
+	"slices"
+
+	"braces.dev/errtrace"
+	"github.com/pressly/goose/v3"
+	"github.com/pressly/goose/v3/database"
+	"github.com/terinjokes/sqlitestdb"
+	"github.com/terinjokes/sqlitestdb/migrators/common"
+	"github.com/terinjokes/sqlitestdb/migrators/hash"
+)
+
+// DefaultTableName is the name of the table goose records the applied
+// migrations in, unless set with [WithTableName].
+const DefaultTableName = "goose_db_version"
+
+// Option provides a way to configure the GooseMigrator struct and its behavior.
+//
+// goose documentation: https://github.com/pressly/goose
+type Option func(*GooseMigrator)
+
+// WithFS specifies a [fs.FS] from which to read the migration files.
+// If not specified as an option to [New], the migrator will read from
+// the real filesystem.
+func WithFS(dir fs.FS) Option {
+	return func(gm *GooseMigrator) {
+		gm.FS = dir
+	}
+}
+
+// WithTableName specifies the name of the table goose records the applied
+// migrations in. If not specified as an option to [New], [DefaultTableName]
+// is used.
+func WithTableName(name string) Option {
+	return func(gm *GooseMigrator) {
+		gm.TableName = name
+	}
+}
+
+// WithGoMigrationsHash enables Go migrations: those registered globally with
+// goose, such as with [goose.AddMigrationContext], and those passed to
+// [WithGoMigrations]. As the code of Go migrations can't be hashed, hash is a
+// fingerprint of them chosen by the caller, such as a version number, that is
+// included in [GooseMigrator.Hash].
+//
+// The fingerprint must be changed whenever a Go migration is added or changed.
+// Otherwise, the template created with the previous Go migrations is reused,
+// and the tests silently run against a stale schema.
+func WithGoMigrationsHash(hash string) Option {
+	return func(gm *GooseMigrator) {
+		gm.GoMigrationsHash = hash
+	}
+}
+
+// WithGoMigrations specifies Go migrations, such as those created with
+// [goose.NewGoMigration], to run along with the migration files and the Go
+// migrations registered globally. They are only run with [WithGoMigrationsHash].
+//
+// A [goose.Provider] can't be passed instead, as it is bound to a database, and
+// a new one is created for each template.
+func WithGoMigrations(migrations ...*goose.Migration) Option {
+	return func(gm *GooseMigrator) {
+		gm.GoMigrations = append(gm.GoMigrations, migrations...)
+	}
+}
+
+// GooseMigrator is a [sqlitestdb.Migrator] that uses goose to perform migrations.
+//
+// Because [Hash] requires calculating a unique hash based on the contents of
+// the migrations, this implementation only supports reading SQL migration files
+// from disk or an embedded filesystem. Go migrations are only supported with a
+// fingerprint set by [WithGoMigrationsHash].
+type GooseMigrator struct {
+	MigrationsDir    string
+	FS               fs.FS
+	TableName        string
+	GoMigrationsHash string
+	GoMigrations     []*goose.Migration
+}
+
+// New returns a [GooseMigrator], which implements sqlitestdb.Migrator
+// using goose to perform up migrations.
+func New(migrationsDir string, opts ...Option) *GooseMigrator {
+	gm := &GooseMigrator{MigrationsDir: migrationsDir, TableName: DefaultTableName}
+	for _, opt := range opts {
+		opt(gm)
+	}
+
+	return gm
+}
+
+func (gm *GooseMigrator) Hash() (string, error) {
+	if len(gm.GoMigrations) > 0 && gm.GoMigrationsHash == "" {
+		return "", errtrace.New("Go migrations require a fingerprint set with WithGoMigrationsHash")
+	}
+
+	migrations, err := gm.source().Hash("*.sql")
+	if err != nil {
+		return "", errtrace.Wrap(err)
+	}
+
+	h := hash.NewRecursiveHash(
+		hash.Field("TableName", gm.TableName),
+		hash.Field("Migrations", migrations),
+	)
+	if gm.GoMigrationsHash != "" {
+		h.AddField("GoMigrations", gm.GoMigrationsHash)
+	}
+	return h.String(), nil
+}
+
+// Migrate runs goose's UpByOne until the template database is migrated, using
+// the sqlite3 dialect, and reports each migration with
+// [sqlitestdb.ReportProgress]. Unless enabled with [WithGoMigrationsHash], only
+// the migration files are used, not the Go migrations registered globally with
+// goose.
+func (gm *GooseMigrator) Migrate(ctx context.Context, db *sql.DB, _ sqlitestdb.Config) error {
+	provider, err := gm.provider(db)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	return errtrace.Wrap(up(ctx, provider, math.MaxInt64))
+}
+
+// HashAt returns a hash of the migration files up to, and including, version,
+// and of the other files matching "*.sql", along with the table name, and the
+// fingerprint of the Go migrations, if any, which covers all of them.
+func (gm *GooseMigrator) HashAt(version int64) (string, error) {
+	if len(gm.GoMigrations) > 0 && gm.GoMigrationsHash == "" {
+		return "", errtrace.New("Go migrations require a fingerprint set with WithGoMigrationsHash")
+	}
+
+	src := gm.source()
+	names, err := src.List("*.sql")
+	if err != nil {
+		return "", errtrace.Wrap(err)
+	}
+	var kept []string
+	for _, name := range names {
+		if v, err := goose.NumericComponent(name); err == nil && v > version {
+			continue
+		}
+		kept = append(kept, name)
+	}
+
+	migrations, err := src.HashFiles(kept...)
+	if err != nil {
+		return "", errtrace.Wrap(err)
+	}
+
+	h := hash.NewRecursiveHash(
+		hash.Field("Version", version),
+		hash.Field("TableName", gm.TableName),
+		hash.Field("Migrations", migrations),
+	)
+	if gm.GoMigrationsHash != "" {
+		h.AddField("GoMigrations", gm.GoMigrationsHash)
+	}
+	return h.String(), nil
+}
+
+// MigrateTo migrates the template database up to, and including, version, like
+// [GooseMigrator.Migrate], so that templates can be created at that version with
+// [sqlitestdb.NewAtVersion].
+func (gm *GooseMigrator) MigrateTo(ctx context.Context, db *sql.DB, _ sqlitestdb.Config, version int64) error {
+	provider, err := gm.provider(db)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	if !slices.ContainsFunc(provider.ListSources(), func(s *goose.Source) bool { return s.Version == version }) {
+		return errtrace.Errorf("no migration with version %d in %q", version, gm.MigrationsDir)
+	}
+	return errtrace.Wrap(up(ctx, provider, version))
+}
+
+// up runs goose's UpByOne for each of the pending migrations up to, and
+// including, version to, and reports each of them.
+func up(ctx context.Context, provider *goose.Provider, to int64) error {
+	statuses, err := provider.Status(ctx)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+	var total int
+	for _, status := range statuses {
+		if status.State == goose.StatePending && status.Source.Version <= to {
+			total++
+		}
+	}
+
+	for applied := 1; applied <= total; applied++ {
+		res, err := provider.UpByOne(ctx)
+		if err != nil {
+			return errtrace.Wrap(err)
+		}
+
+		name := res.Source.Path
+		if name == "" {
+			name = fmt.Sprintf("version %d", res.Source.Version)
+		}
+		sqlitestdb.ReportProgress(ctx, sqlitestdb.Event{
+			Kind:      sqlitestdb.MigrationApplied,
+			Migration: name,
+			Applied:   applied,
+			Total:     total,
+		})
+	}
+
+	return nil
+}
+
+// Down runs goose's DownTo to revert all of the migrations of the database. It
+// is used to check that the down migrations revert the up migrations, see
+// [migtest.CheckReversible].
+//
+// [migtest.CheckReversible]: https://pkg.go.dev/github.com/terinjokes/sqlitestdb/migrators/migtest#CheckReversible
+func (gm *GooseMigrator) Down(ctx context.Context, db *sql.DB, _ sqlitestdb.Config) error {
+	provider, err := gm.provider(db)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	_, err = provider.DownTo(ctx, 0)
+	return errtrace.Wrap(err)
+}
+
+// VersionTables returns the name of the table goose records the applied
+// migrations in.
+func (gm *GooseMigrator) VersionTables() []string {
+	return []string{gm.TableName}
+}
+
+// provider returns a goose provider for the migrations, and the database.
+func (gm *GooseMigrator) provider(db *sql.DB) (*goose.Provider, error) {
+	sub, err := gm.source().Sub()
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+
+	store, err := database.NewStore(database.DialectSQLite3, gm.TableName)
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+
+	opts := []goose.ProviderOption{goose.WithStore(store)}
+	if gm.GoMigrationsHash == "" {
+		opts = append(opts, goose.WithDisableGlobalRegistry(true))
+	} else if len(gm.GoMigrations) > 0 {
+		opts = append(opts, goose.WithGoMigrations(gm.GoMigrations...))
+	}
+
+	return errtrace.Wrap2(goose.NewProvider("", db, sub, opts...))
+}
+
+// source returns the [common.Source] the migrations are read from, so that
+// embedded and on-disk migrations are handled identically.
+func (gm *GooseMigrator) source() common.Source {
+	return common.NewSource(gm.FS, gm.MigrationsDir)
+}
@@ -0,0 +1,229 @@
+// Copyright 2024 Terin Stock.
+// Copyright 2023 Peter Downs.
+// SPDX-License-Identifier: MIT
+
+package goosemigrator_test
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"testing"
+	"testing/fstest"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pressly/goose/v3"
+	"github.com/terinjokes/sqlitestdb"
+	"github.com/terinjokes/sqlitestdb/migrators/common"
+	"github.com/terinjokes/sqlitestdb/migrators/common/commontest"
+	"github.com/terinjokes/sqlitestdb/migrators/goosemigrator"
+	"github.com/terinjokes/sqlitestdb/migrators/migtest"
+	"gotest.tools/v3/assert"
+)
+
+//go:embed migrations/*.sql
+var exampleFS embed.FS
+
+func TestMigrateFromDisk(t *testing.T) {
+	t.Parallel()
+	gm := goosemigrator.New("migrations")
+	db := sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, gm)
+	testDB(t, db, goosemigrator.DefaultTableName)
+}
+
+func TestMigrateFromEmbeddedFS(t *testing.T) {
+	t.Parallel()
+	gm := goosemigrator.New("migrations", goosemigrator.WithFS(exampleFS))
+	db := sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, gm)
+	testDB(t, db, goosemigrator.DefaultTableName)
+}
+
+func TestMigrateWithTableName(t *testing.T) {
+	t.Parallel()
+	gm := goosemigrator.New("migrations", goosemigrator.WithFS(exampleFS), goosemigrator.WithTableName("schema_versions"))
+	db := sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, gm)
+	testDB(t, db, "schema_versions")
+
+	defaultHash, err := goosemigrator.New("migrations", goosemigrator.WithFS(exampleFS)).Hash()
+	assert.NilError(t, err)
+	hash, err := gm.Hash()
+	assert.NilError(t, err)
+	assert.Assert(t, hash != defaultHash)
+}
+
+func init() {
+	goose.AddNamedMigrationContext("00002_seed_cats.go", seedCats, nil)
+}
+
+// seedCats is a Go migration, registered globally.
+func seedCats(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, "INSERT INTO cats (name) VALUES ('daisy'), ('sunny')")
+	return err
+}
+
+func TestMigrateWithGoMigrations(t *testing.T) {
+	t.Parallel()
+	files := fstest.MapFS{
+		"migrations/00001_cats.sql": {Data: []byte("-- +goose Up\nCREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT);\n")},
+	}
+	gm := goosemigrator.New("migrations", goosemigrator.WithFS(files), goosemigrator.WithGoMigrationsHash("seed-v1"))
+	db := sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, gm)
+
+	var version int
+	err := db.QueryRow("SELECT MAX(version_id) FROM goose_db_version WHERE is_applied").Scan(&version)
+	assert.NilError(t, err)
+	assert.Equal(t, 2, version)
+
+	var numCats int
+	err = db.QueryRow("SELECT count(*) FROM cats").Scan(&numCats)
+	assert.NilError(t, err)
+	assert.Equal(t, 2, numCats)
+
+	// Bumping the fingerprint creates a new template.
+	hash, err := gm.Hash()
+	assert.NilError(t, err)
+	bumped, err := goosemigrator.New("migrations", goosemigrator.WithFS(files), goosemigrator.WithGoMigrationsHash("seed-v2")).Hash()
+	assert.NilError(t, err)
+	assert.Assert(t, hash != bumped)
+
+	// Without the fingerprint, the Go migrations can't be hashed.
+	seed := goose.NewGoMigration(3, &goose.GoFunc{RunTx: seedCats}, nil)
+	_, err = goosemigrator.New("migrations", goosemigrator.WithFS(files), goosemigrator.WithGoMigrations(seed)).Hash()
+	assert.ErrorContains(t, err, "WithGoMigrationsHash")
+}
+
+func TestProgress(t *testing.T) {
+	t.Parallel()
+	files := fstest.MapFS{
+		"migrations/00001_cats.sql": {Data: []byte("-- +goose Up\nCREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT);\n")},
+	}
+	// The Go migration seeding the cats is registered globally.
+	gm := goosemigrator.New("migrations", goosemigrator.WithFS(files), goosemigrator.WithGoMigrationsHash("progress"))
+
+	var events []sqlitestdb.Event
+	_, err := sqlitestdb.Prepare(context.Background(), sqlitestdb.Config{Driver: "sqlite3"}, gm,
+		sqlitestdb.WithForceRebuild(),
+		sqlitestdb.WithProgress(func(e sqlitestdb.Event) { events = append(events, e) }),
+	)
+	assert.NilError(t, err)
+
+	var kinds []sqlitestdb.EventKind
+	var applied []string
+	for _, e := range events {
+		kinds = append(kinds, e.Kind)
+		if e.Kind == sqlitestdb.MigrationApplied {
+			applied = append(applied, fmt.Sprintf("%d/%d %s", e.Applied, e.Total, e.Migration))
+		}
+	}
+	assert.DeepEqual(t, kinds, []sqlitestdb.EventKind{
+		sqlitestdb.BuildStarted,
+		sqlitestdb.MigrationApplied,
+		sqlitestdb.MigrationApplied,
+		sqlitestdb.BuildFinished,
+	})
+	assert.DeepEqual(t, applied, []string{"1/2 00001_cats.sql", "2/2 00002_seed_cats.go"})
+}
+
+func TestReversible(t *testing.T) {
+	t.Parallel()
+	gm := goosemigrator.New("migrations")
+	migtest.CheckReversible(t, sqlitestdb.Config{Driver: "sqlite3"}, gm)
+}
+
+func TestNewAtVersion(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	gm := goosemigrator.New("migrations", goosemigrator.WithFS(exampleFS))
+	db := sqlitestdb.NewAtVersion(t, sqlitestdb.Config{Driver: "sqlite3"}, gm, 1)
+
+	var version int
+	assert.NilError(t, db.QueryRowContext(ctx, "SELECT MAX(version_id) FROM goose_db_version WHERE is_applied").Scan(&version))
+	assert.Equal(t, 1, version)
+	_, err := db.ExecContext(ctx, "INSERT INTO users (id, name) VALUES (1, 'terin')")
+	assert.NilError(t, err)
+	_, err = db.ExecContext(ctx, "SELECT * FROM cats")
+	assert.ErrorContains(t, err, "no such table: cats")
+
+	// The users survive the later migrations.
+	assert.NilError(t, gm.Migrate(ctx, db, *sqlitestdb.ConfigFor(db)))
+	var numUsers int
+	assert.NilError(t, db.QueryRowContext(ctx, "SELECT count(*) FROM users").Scan(&numUsers))
+	assert.Equal(t, 1, numUsers)
+	_, err = db.ExecContext(ctx, "INSERT INTO cats (name) VALUES ('daisy')")
+	assert.NilError(t, err)
+
+	_, err = sqlitestdb.Prepare(ctx, sqlitestdb.Config{Driver: "sqlite3"}, sqlitestdb.AtVersion(gm, 3))
+	assert.ErrorContains(t, err, "no migration with version 3")
+}
+
+func TestHashAt(t *testing.T) {
+	t.Parallel()
+	files := fstest.MapFS{
+		"migrations/00001_users.sql": {Data: []byte("-- +goose Up\nCREATE TABLE users (id INTEGER PRIMARY KEY);\n")},
+		"migrations/00002_cats.sql":  {Data: []byte("-- +goose Up\nCREATE TABLE cats (id INTEGER PRIMARY KEY);\n")},
+	}
+	before, err := goosemigrator.New("migrations", goosemigrator.WithFS(files)).HashAt(1)
+	assert.NilError(t, err)
+
+	// Adding or changing later migrations doesn't change the hash at a version.
+	files["migrations/00002_cats.sql"] = &fstest.MapFile{Data: []byte("-- +goose Up\nCREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT);\n")}
+	files["migrations/00003_dogs.sql"] = &fstest.MapFile{Data: []byte("-- +goose Up\nCREATE TABLE dogs (id INTEGER PRIMARY KEY);\n")}
+	after, err := goosemigrator.New("migrations", goosemigrator.WithFS(files)).HashAt(1)
+	assert.NilError(t, err)
+	assert.Equal(t, before, after)
+
+	atHead, err := goosemigrator.New("migrations", goosemigrator.WithFS(files)).HashAt(3)
+	assert.NilError(t, err)
+	assert.Assert(t, atHead != after)
+}
+
+func TestSourceConformance(t *testing.T) {
+	t.Parallel()
+	commontest.Conformance{
+		Files: fstest.MapFS{
+			"db/migrations/00001_init.sql": {Data: []byte("-- +goose Up\nCREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);\n-- +goose Down\nDROP TABLE users;\n")},
+			"db/migrations/00002_cats.sql": {Data: []byte("-- +goose Up\nCREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT);\n")},
+		},
+		Dir:    "db/migrations",
+		Config: sqlitestdb.Config{Driver: "sqlite3"},
+		New: func(source common.Source) sqlitestdb.Migrator {
+			return goosemigrator.New(source.Dir, goosemigrator.WithFS(source.FS))
+		},
+		Check: func(t *testing.T, db *sql.DB) {
+			var version int
+			err := db.QueryRow("SELECT MAX(version_id) FROM goose_db_version").Scan(&version)
+			assert.NilError(t, err)
+			assert.Equal(t, 2, version)
+
+			var numCats int
+			err = db.QueryRow("SELECT count(*) FROM cats").Scan(&numCats)
+			assert.NilError(t, err)
+			assert.Equal(t, 0, numCats)
+		},
+	}.Run(t)
+}
+
+func testDB(t *testing.T, db *sql.DB, tableName string) {
+	ctx := context.Background()
+
+	var version int
+	err := db.QueryRowContext(ctx, "SELECT MAX(version_id) FROM "+tableName+" WHERE is_applied").Scan(&version)
+	assert.NilError(t, err)
+	assert.Equal(t, 2, version)
+
+	var numUsers int
+	err = db.QueryRowContext(ctx, "SELECT count(*) FROM users").Scan(&numUsers)
+	assert.NilError(t, err)
+	assert.Equal(t, 0, numUsers)
+
+	var numCats int
+	err = db.QueryRowContext(ctx, "SELECT count(*) FROM cats").Scan(&numCats)
+	assert.NilError(t, err)
+	assert.Equal(t, 0, numCats)
+
+	var numBlogPosts int
+	err = db.QueryRowContext(ctx, "SELECT count(*) FROM blog_posts").Scan(&numBlogPosts)
+	assert.NilError(t, err)
+	assert.Equal(t, 0, numBlogPosts)
+}
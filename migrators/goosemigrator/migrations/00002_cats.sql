@@ -0,0 +1,8 @@
+-- +goose Up
+CREATE TABLE cats (
+       id INTEGER PRIMARY KEY AUTOINCREMENT,
+       name TEXT
+);
+
+-- +goose Down
+DROP TABLE cats;
@@ -0,0 +1,141 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package gormmigrator
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"braces.dev/errtrace"
+	"github.com/terinjokes/sqlitestdb"
+	"github.com/terinjokes/sqlitestdb/migrators/hash"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Option provides a way to configure the GormMigrator struct and its behavior.
+// Options are passed to [New] along with the models.
+//
+// GORM documentation: https://gorm.io/docs/migration.html
+type Option func(*GormMigrator)
+
+// WithHash specifies a hash identifying the models, such as a version number,
+// that is included in [GormMigrator.Hash]. As the definitions of the models
+// can't be hashed, it must be changed whenever a model is added or changed.
+// Otherwise, the template created with the previous models is reused, and the
+// tests silently run against a stale schema.
+func WithHash(hash string) Option {
+	return func(gm *GormMigrator) {
+		gm.ModelsHash = hash
+	}
+}
+
+// WithReflectedHash derives the hash from the models, using reflection: the
+// names, types, and tags of their fields, including those of embedded and
+// related structs. Changes that aren't visible in the fields, such as a
+// TableName method, or the naming strategy, aren't detected.
+func WithReflectedHash() Option {
+	return func(gm *GormMigrator) {
+		gm.ReflectedHash = true
+	}
+}
+
+// GormMigrator is a [sqlitestdb.Migrator] that uses GORM's AutoMigrate to
+// create the tables of the models.
+//
+// Because the models are Go code, [GormMigrator.Hash] requires a hash set with
+// [WithHash], or derived from the models with [WithReflectedHash].
+type GormMigrator struct {
+	Models        []any
+	ModelsHash    string
+	ReflectedHash bool
+}
+
+// New returns a [GormMigrator], which implements sqlitestdb.Migrator using
+// GORM's AutoMigrate for the models. Options, such as [WithHash], are passed
+// along with the models.
+func New(models ...any) *GormMigrator {
+	gm := &GormMigrator{}
+	for _, model := range models {
+		if opt, ok := model.(Option); ok {
+			opt(gm)
+			continue
+		}
+		gm.Models = append(gm.Models, model)
+	}
+
+	return gm
+}
+
+func (gm *GormMigrator) Hash() (string, error) {
+	if gm.ModelsHash == "" && !gm.ReflectedHash {
+		return "", errtrace.New("GORM models require a hash set with WithHash, or WithReflectedHash")
+	}
+
+	h := hash.NewRecursiveHash(hash.Field("Hash", gm.ModelsHash))
+	if gm.ReflectedHash {
+		var b strings.Builder
+		seen := make(map[reflect.Type]bool)
+		for _, model := range gm.Models {
+			describe(&b, reflect.TypeOf(model), seen)
+		}
+		h.AddField("Models", b.String())
+	}
+
+	return h.String(), nil
+}
+
+// Migrate opens a gorm.DB over the template database, and runs AutoMigrate for
+// the models.
+func (gm *GormMigrator) Migrate(ctx context.Context, db *sql.DB, _ sqlitestdb.Config) error {
+	gdb, err := gorm.Open(sqlite.New(sqlite.Config{Conn: db}), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	return errtrace.Wrap(gdb.WithContext(ctx).AutoMigrate(gm.Models...))
+}
+
+// describe writes the names, types, and tags of the fields of the struct type
+// t, and of the structs it refers to, to b. Each struct is only described once.
+// Structs without exported fields, such as time.Time, are stored as columns by
+// GORM, and aren't described.
+func describe(b *strings.Builder, t reflect.Type, seen map[reflect.Type]bool) {
+	for t != nil && (t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map) {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct || seen[t] || !hasExportedFields(t) {
+		return
+	}
+	seen[t] = true
+
+	fmt.Fprintf(b, "%s.%s{", t.PkgPath(), t.Name())
+	for i := range t.NumField() {
+		f := t.Field(i)
+		fmt.Fprintf(b, "%s %s %q;", f.Name, f.Type, f.Tag)
+	}
+	b.WriteString("}\n")
+
+	for i := range t.NumField() {
+		if f := t.Field(i); f.IsExported() {
+			describe(b, f.Type, seen)
+		}
+	}
+}
+
+// hasExportedFields reports whether the struct type t has exported fields.
+func hasExportedFields(t reflect.Type) bool {
+	for i := range t.NumField() {
+		if t.Field(i).IsExported() {
+			return true
+		}
+	}
+	return false
+}
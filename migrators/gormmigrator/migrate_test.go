@@ -0,0 +1,99 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package gormmigrator_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/terinjokes/sqlitestdb"
+	"github.com/terinjokes/sqlitestdb/migrators/gormmigrator"
+	"gorm.io/gorm"
+	"gotest.tools/v3/assert"
+)
+
+type User struct {
+	gorm.Model
+	Name  string `gorm:"not null"`
+	Email string `gorm:"uniqueIndex"`
+	Cats  []Cat  `gorm:"foreignKey:OwnerID"`
+}
+
+type Cat struct {
+	ID      uint `gorm:"primaryKey"`
+	Name    string
+	Born    time.Time
+	OwnerID uint
+}
+
+func TestMigrate(t *testing.T) {
+	t.Parallel()
+	gm := gormmigrator.New(&User{}, &Cat{}, gormmigrator.WithHash("models-v1"))
+	db := sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, gm)
+
+	assert.DeepEqual(t, columns(t, db, "users"), []string{"id", "created_at", "updated_at", "deleted_at", "name", "email"})
+	assert.DeepEqual(t, columns(t, db, "cats"), []string{"id", "name", "born", "owner_id"})
+
+	_, err := db.Exec("INSERT INTO users (name, email) VALUES ('terin', 'terin@example.com')")
+	assert.NilError(t, err)
+	_, err = db.Exec("INSERT INTO users (name, email) VALUES ('other', 'terin@example.com')")
+	assert.ErrorContains(t, err, "UNIQUE constraint failed: users.email")
+}
+
+func TestMigrateWithReflectedHash(t *testing.T) {
+	t.Parallel()
+	gm := gormmigrator.New(&User{}, &Cat{}, gormmigrator.WithReflectedHash())
+	db := sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, gm)
+	assert.DeepEqual(t, columns(t, db, "cats"), []string{"id", "name", "born", "owner_id"})
+
+	hash, err := gm.Hash()
+	assert.NilError(t, err)
+
+	// Models with the same fields have the same hash.
+	same, err := gormmigrator.New(User{}, []Cat{}, gormmigrator.WithReflectedHash()).Hash()
+	assert.NilError(t, err)
+	assert.Equal(t, hash, same)
+
+	// Changing a field, including of a related model, changes the hash.
+	type Cat struct {
+		ID      uint `gorm:"primaryKey"`
+		Name    string
+		Born    time.Time `gorm:"not null"`
+		OwnerID uint
+	}
+	changed, err := gormmigrator.New(&User{}, &Cat{}, gormmigrator.WithReflectedHash()).Hash()
+	assert.NilError(t, err)
+	assert.Assert(t, hash != changed)
+
+	// Combined with a hash, both are included.
+	combined, err := gormmigrator.New(&User{}, &Cat{}, gormmigrator.WithReflectedHash(), gormmigrator.WithHash("v2")).Hash()
+	assert.NilError(t, err)
+	assert.Assert(t, changed != combined)
+}
+
+func TestHashRequired(t *testing.T) {
+	t.Parallel()
+	_, err := gormmigrator.New(&User{}).Hash()
+	assert.ErrorContains(t, err, "WithHash")
+}
+
+func columns(t *testing.T, db *sql.DB, table string) []string {
+	t.Helper()
+
+	rows, err := db.QueryContext(context.Background(), "SELECT name FROM pragma_table_info(?) ORDER BY cid", table)
+	assert.NilError(t, err)
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		assert.NilError(t, rows.Scan(&name))
+		names = append(names, name)
+	}
+	assert.NilError(t, rows.Err())
+	return names
+}
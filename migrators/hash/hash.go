@@ -0,0 +1,172 @@
+// Copyright 2024 Terin Stock.
+// Copyright 2023 Peter Downs.
+// SPDX-License-Identifier: MIT
+
+// Package hash computes the hashes identifying the templates created by
+// migrators, from their settings and the contents of their migration files.
+//
+// The hashes are the same as those computed by the hashing helpers of pgtestdb,
+// which this package replaces, so switching to it doesn't create new templates.
+package hash
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	hashlib "hash"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"braces.dev/errtrace"
+)
+
+// RecursiveHash is a hash that is updated with the hash of each value added to
+// it, so that adding "ab" is different from adding "a" and "b". It is safe to
+// use in file names, as [RecursiveHash.String] only contains hexadecimal
+// digits.
+type RecursiveHash struct {
+	h hashlib.Hash
+}
+
+// NewRecursiveHash returns a new [RecursiveHash], to which the fields are added.
+func NewRecursiveHash(fields ...HashField) RecursiveHash {
+	h := RecursiveHash{h: md5.New()}
+	h.AddFields(fields...)
+	return h
+}
+
+// Add updates the hash with the hash of contents.
+func (h RecursiveHash) Add(contents []byte) {
+	// Writing to a hash never fails.
+	fmt.Fprintf(h.h, "%x=%x\n", h.h.Sum(nil), md5.Sum(contents))
+}
+
+// AddField updates the hash with the hash of a field, formatted as key=value
+// with the %v verb.
+func (h RecursiveHash) AddField(key string, value any) {
+	h.Add([]byte(fmt.Sprintf("%s=%v", key, value)))
+}
+
+// AddFields updates the hash with the hash of each field, in order.
+func (h RecursiveHash) AddFields(fields ...HashField) {
+	for _, field := range fields {
+		h.AddField(field.Key, field.Value)
+	}
+}
+
+// String returns the hexadecimal encoding of the hash.
+func (h RecursiveHash) String() string {
+	return hex.EncodeToString(h.h.Sum(nil))
+}
+
+// HashField is a setting of a migrator that changes the schema it creates,
+// created with [Field].
+type HashField struct {
+	Key   string
+	Value any
+}
+
+// Field returns a [HashField] for a setting of a migrator. Settings that change
+// the schema, such as the name of a migrations table, should be hashed along
+// with the migration files, so that changing them creates a new template.
+func Field(key string, value any) HashField {
+	return HashField{Key: key, Value: value}
+}
+
+// HashFile returns a hash of the contents of the file at path of fsys, or of the
+// real filesystem if fsys is nil.
+func HashFile(fsys fs.FS, path string) (string, error) {
+	return errtrace.Wrap2(HashFiles(fsys, path))
+}
+
+// HashFiles returns a hash of the contents of the files at paths of fsys, or of
+// the real filesystem if fsys is nil, in the order of paths.
+func HashFiles(fsys fs.FS, paths ...string) (string, error) {
+	h := NewRecursiveHash()
+	for _, p := range paths {
+		contents, err := readFile(fsys, p)
+		if err != nil {
+			return "", errtrace.Wrap(err)
+		}
+		h.Add(contents)
+	}
+
+	return h.String(), nil
+}
+
+// HashFS returns a hash of the contents of the files at the root of fsys
+// matching pattern, in the syntax of [path.Match], in lexical order.
+func HashFS(fsys fs.FS, pattern string) (string, error) {
+	h := NewRecursiveHash()
+	if err := addFS(h, fsys, pattern); err != nil {
+		return "", errtrace.Wrap(err)
+	}
+
+	return h.String(), nil
+}
+
+// HashDirs returns a hash of the contents of the files matching pattern, in the
+// syntax of [path.Match], of each of the directories of fsys, or of the real
+// filesystem if fsys is nil. The directories are hashed in order, and their
+// files in lexical order.
+//
+// The directories use forward slashes, whatever the operating system, so that
+// the hash is the same on every one of them.
+func HashDirs(fsys fs.FS, pattern string, dirs ...string) (string, error) {
+	h := NewRecursiveHash()
+	for _, dir := range dirs {
+		sub, err := subDir(fsys, dir)
+		if err != nil {
+			return "", errtrace.Wrap(err)
+		}
+		if err := addFS(h, sub, pattern); err != nil {
+			return "", errtrace.Wrap(err)
+		}
+	}
+
+	return h.String(), nil
+}
+
+// addFS adds the contents of the files at the root of fsys matching pattern to
+// h, in lexical order.
+func addFS(h RecursiveHash, fsys fs.FS, pattern string) error {
+	names, err := fs.Glob(fsys, pattern)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+	// fs.Glob returns the names in the order of the directory entries, which
+	// is only sorted by the filesystems of the standard library.
+	sort.Strings(names)
+
+	for _, name := range names {
+		contents, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return errtrace.Wrap(err)
+		}
+		h.Add(contents)
+	}
+
+	return nil
+}
+
+// subDir returns the directory dir of fsys, or of the real filesystem if fsys
+// is nil.
+func subDir(fsys fs.FS, dir string) (fs.FS, error) {
+	dir = path.Clean(filepath.ToSlash(dir))
+	if fsys == nil {
+		return os.DirFS(filepath.FromSlash(dir)), nil
+	}
+
+	return errtrace.Wrap2(fs.Sub(fsys, dir))
+}
+
+func readFile(fsys fs.FS, name string) ([]byte, error) {
+	if fsys == nil {
+		return errtrace.Wrap2(os.ReadFile(filepath.FromSlash(name)))
+	}
+
+	return errtrace.Wrap2(fs.ReadFile(fsys, path.Clean(filepath.ToSlash(name))))
+}
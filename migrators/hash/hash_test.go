@@ -0,0 +1,108 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package hash_test
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/terinjokes/sqlitestdb/migrators/hash"
+	"gotest.tools/v3/assert"
+)
+
+// The digests are pinned, as changing them creates new templates for every
+// user. They are the same as those of the hashing helpers of pgtestdb.
+const (
+	dirsDigest   = "d9908bd4f8290d10237be37a92b371f4"
+	upDigest     = "484cca99d3e0d62d9794a3a117faf20c"
+	fileDigest   = "c34aee0cbc7e6aa608085de1066c487c"
+	fieldsDigest = "51f95a849b098c7825425c21ccde1917"
+	emptyDigest  = "d41d8cd98f00b204e9800998ecf8427e"
+)
+
+// fixtures is the directory of the fixture tree.
+const fixtures = "testdata"
+
+func TestRecursiveHash(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, hash.NewRecursiveHash().String(), emptyDigest)
+
+	h := hash.NewRecursiveHash(hash.Field("TableName", "goose_db_version"))
+	h.Add([]byte("x"))
+	assert.Equal(t, h.String(), fieldsDigest)
+
+	// Values are hashed separately, not concatenated.
+	ab := hash.NewRecursiveHash()
+	ab.Add([]byte("ab"))
+	a := hash.NewRecursiveHash()
+	a.Add([]byte("a"))
+	a.Add([]byte("b"))
+	assert.Assert(t, ab.String() != a.String())
+}
+
+func TestHashDirs(t *testing.T) {
+	t.Parallel()
+	fsys := os.DirFS(fixtures)
+
+	got, err := hash.HashDirs(fsys, "*.sql", "migrations", "seeds")
+	assert.NilError(t, err)
+	assert.Equal(t, got, dirsDigest)
+
+	// From the real filesystem.
+	got, err = hash.HashDirs(nil, "*.sql", filepath.Join(fixtures, "migrations"), fixtures+"/seeds/")
+	assert.NilError(t, err)
+	assert.Equal(t, got, dirsDigest)
+
+	// Whatever order the filesystem lists the files in.
+	got, err = hash.HashDirs(reversedFS{fsys}, "*.sql", "./migrations", "seeds")
+	assert.NilError(t, err)
+	assert.Equal(t, got, dirsDigest)
+
+	// But not whatever the order of the directories.
+	got, err = hash.HashDirs(fsys, "*.sql", "seeds", "migrations")
+	assert.NilError(t, err)
+	assert.Assert(t, got != dirsDigest)
+
+	got, err = hash.HashDirs(fsys, "*.up.sql", "migrations")
+	assert.NilError(t, err)
+	assert.Equal(t, got, upDigest)
+}
+
+func TestHashFS(t *testing.T) {
+	t.Parallel()
+	sub, err := fs.Sub(os.DirFS(fixtures), "migrations")
+	assert.NilError(t, err)
+
+	got, err := hash.HashFS(reversedFS{sub}, "*.up.sql")
+	assert.NilError(t, err)
+	assert.Equal(t, got, upDigest)
+}
+
+func TestHashFile(t *testing.T) {
+	t.Parallel()
+	got, err := hash.HashFile(os.DirFS(fixtures), "seeds/users.sql")
+	assert.NilError(t, err)
+	assert.Equal(t, got, fileDigest)
+
+	got, err = hash.HashFile(nil, filepath.Join(fixtures, "seeds", "users.sql"))
+	assert.NilError(t, err)
+	assert.Equal(t, got, fileDigest)
+
+	_, err = hash.HashFile(nil, filepath.Join(fixtures, "missing.sql"))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+// reversedFS lists the entries of its directories in reverse lexical order.
+type reversedFS struct {
+	fs.FS
+}
+
+func (r reversedFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := fs.ReadDir(r.FS, name)
+	slices.Reverse(entries)
+	return entries, err
+}
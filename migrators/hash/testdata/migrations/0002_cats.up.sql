@@ -0,0 +1 @@
+CREATE TABLE cats (id INTEGER PRIMARY KEY);
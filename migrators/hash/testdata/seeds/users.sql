@@ -0,0 +1 @@
+INSERT INTO users (id) VALUES (1);
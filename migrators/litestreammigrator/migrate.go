@@ -0,0 +1,203 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package litestreammigrator
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"braces.dev/errtrace"
+	"github.com/terinjokes/sqlitestdb"
+	"github.com/terinjokes/sqlitestdb/migrators/hash"
+)
+
+// DefaultCommand is the litestream binary run by default, looked up in the
+// PATH.
+const DefaultCommand = "litestream"
+
+// Option provides a way to configure the LitestreamMigrator struct and its
+// behavior.
+//
+// Litestream documentation: https://litestream.io/reference/restore/
+type Option func(*LitestreamMigrator)
+
+// WithCommand specifies the path of the litestream binary. If not specified as
+// an option to [New], [DefaultCommand] is looked up in the PATH.
+func WithCommand(command string) Option {
+	return func(lm *LitestreamMigrator) {
+		lm.Command = command
+	}
+}
+
+// LitestreamMigrator is a [sqlitestdb.Migrator] that creates the template by
+// restoring a replica of a database made with Litestream, such as a replica of
+// a production database on S3, with "litestream restore".
+//
+// The replica is read with the litestream binary, which takes the credentials
+// from the environment, such as LITESTREAM_ACCESS_KEY_ID and
+// LITESTREAM_SECRET_ACCESS_KEY, or the variables of the AWS SDK.
+//
+// Restoring a replica is slow, but a template is only restored once for each
+// snapshot: [LitestreamMigrator.Hash] identifies the latest snapshot of the
+// replica, so that the template is restored again after a new snapshot is
+// taken.
+type LitestreamMigrator struct {
+	ReplicaURL string
+	Command    string
+
+	mu     sync.Mutex
+	latest *snapshot
+}
+
+// New returns a [LitestreamMigrator], which implements sqlitestdb.Migrator by
+// restoring the replica at replicaURL, such as "s3://bucket/db".
+func New(replicaURL string, opts ...Option) *LitestreamMigrator {
+	lm := &LitestreamMigrator{ReplicaURL: replicaURL, Command: DefaultCommand}
+	for _, opt := range opts {
+		opt(lm)
+	}
+
+	return lm
+}
+
+// Hash returns a hash of the replica URL, and of the generation and index of
+// the latest snapshot of the replica. The snapshots are only listed by the first
+// call, so a snapshot taken while the tests run isn't restored until the next
+// run.
+func (lm *LitestreamMigrator) Hash() (string, error) {
+	s, err := lm.snapshot(context.Background())
+	if err != nil {
+		return "", errtrace.Wrap(err)
+	}
+
+	h := hash.NewRecursiveHash(
+		hash.Field("ReplicaURL", lm.ReplicaURL),
+		hash.Field("Generation", s.generation),
+		hash.Field("Index", s.index),
+	)
+	return h.String(), nil
+}
+
+// Migrate restores the generation of the latest snapshot of the replica to a
+// temporary file, and clones it into the template database with "VACUUM INTO".
+// If the restore fails, such as when the replica can't be reached, the partial
+// file is removed.
+func (lm *LitestreamMigrator) Migrate(ctx context.Context, _ *sql.DB, templateConfig sqlitestdb.Config) error {
+	s, err := lm.snapshot(ctx)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	dir, err := os.MkdirTemp("", "sqlitestdb_litestream_")
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := templateConfig
+	src.Database = filepath.Join(dir, "restore.sqlite")
+	if _, err := lm.run(ctx, "restore", "-o", src.Database, "-generation", s.generation, lm.ReplicaURL); err != nil {
+		return errtrace.Errorf("could not restore %s: %w", lm.ReplicaURL, err)
+	}
+
+	db, err := src.Connect()
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+	defer db.Close()
+
+	if _, err := db.ExecContext(ctx, "VACUUM INTO ?", templateConfig.URI()); err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	return errtrace.Wrap(db.Close())
+}
+
+// snapshot is a snapshot of the replica, as listed by "litestream snapshots".
+type snapshot struct {
+	generation string
+	index      string
+	created    time.Time
+}
+
+// snapshot returns the latest snapshot of the replica, listing the snapshots on
+// the first call.
+func (lm *LitestreamMigrator) snapshot(ctx context.Context) (*snapshot, error) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	if lm.latest != nil {
+		return lm.latest, nil
+	}
+
+	out, err := lm.run(ctx, "snapshots", lm.ReplicaURL)
+	if err != nil {
+		return nil, errtrace.Errorf("could not list the snapshots of %s: %w", lm.ReplicaURL, err)
+	}
+
+	latest, err := parseSnapshots(out)
+	if err != nil {
+		return nil, errtrace.Errorf("could not list the snapshots of %s: %w", lm.ReplicaURL, err)
+	}
+
+	lm.latest = latest
+	return latest, nil
+}
+
+// parseSnapshots returns the latest of the snapshots in the output of
+// "litestream snapshots", a table of their replica, generation, index, size,
+// and creation time.
+func parseSnapshots(out []byte) (*snapshot, error) {
+	var latest *snapshot
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if line == 1 || len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 5 {
+			return nil, errtrace.Errorf("unexpected line %q", scanner.Text())
+		}
+
+		created, err := time.Parse(time.RFC3339, fields[4])
+		if err != nil {
+			return nil, errtrace.Errorf("unexpected line %q: %w", scanner.Text(), err)
+		}
+		if latest == nil || created.After(latest.created) {
+			latest = &snapshot{generation: fields[1], index: fields[2], created: created}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+
+	if latest == nil {
+		return nil, errtrace.New("the replica has no snapshots")
+	}
+	return latest, nil
+}
+
+// run runs the litestream subcommand with args, and returns its output. If it
+// fails, the error includes what it wrote to stderr.
+func (lm *LitestreamMigrator) run(ctx context.Context, args ...string) ([]byte, error) {
+	out, err := exec.CommandContext(ctx, lm.Command, args...).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(bytes.TrimSpace(exitErr.Stderr)) > 0 {
+			return nil, errtrace.Errorf("litestream %s: %w: %s", args[0], err, bytes.TrimSpace(exitErr.Stderr))
+		}
+		return nil, errtrace.Errorf("litestream %s: %w", args[0], err)
+	}
+
+	return out, nil
+}
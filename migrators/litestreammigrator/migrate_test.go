@@ -0,0 +1,149 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+//go:build unix
+
+package litestreammigrator_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/terinjokes/sqlitestdb"
+	"github.com/terinjokes/sqlitestdb/migrators/litestreammigrator"
+	"gotest.tools/v3/assert"
+)
+
+// fakeLitestream is a stand-in for the litestream binary, which lists the
+// snapshots in the file "snapshots" next to it, and restores the database
+// "replica.sqlite" next to it, unless the file "unreachable" exists. It records
+// the arguments of the restore, and the file it was restoring to.
+const fakeLitestream = `#!/bin/sh
+dir=$(dirname "$0")
+case "$1" in
+snapshots)
+	if [ -e "$dir/unreachable" ]; then
+		echo "dial tcp: connection refused" >&2
+		exit 1
+	fi
+	cat "$dir/snapshots"
+	;;
+restore)
+	echo "$@" > "$dir/args"
+	echo "$3" > "$dir/restored"
+	if [ -e "$dir/unreachable" ]; then
+		echo "partial" > "$3"
+		echo "cannot fetch wal segment: dial tcp: connection refused" >&2
+		exit 1
+	fi
+	cp "$dir/replica.sqlite" "$3"
+	;;
+esac
+`
+
+const snapshots = `replica  generation        index  size  created
+s3       0123456789abcdef  0      8192  2024-01-01T00:00:00Z
+s3       fedcba9876543210  4      8192  2024-02-01T00:00:00Z
+s3       0123456789abcdef  2      8192  2024-01-15T00:00:00Z
+`
+
+// newReplica writes the fake litestream binary, and a replica with a cats
+// table, to a directory, and returns the path of the binary.
+func newReplica(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	command := filepath.Join(dir, "litestream")
+	assert.NilError(t, os.WriteFile(command, []byte(fakeLitestream), 0o755))
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "snapshots"), []byte(snapshots), 0o600))
+
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "replica.sqlite"))
+	assert.NilError(t, err)
+	defer db.Close()
+	_, err = db.Exec("CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT); INSERT INTO cats (name) VALUES ('daisy'), ('sunny');")
+	assert.NilError(t, err)
+
+	return command
+}
+
+func TestMigrate(t *testing.T) {
+	t.Parallel()
+	command := newReplica(t)
+	lm := litestreammigrator.New("s3://bucket/db", litestreammigrator.WithCommand(command))
+	// The template of a previous run would be reused otherwise.
+	db := sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, lm, sqlitestdb.WithForceRebuild())
+
+	var numCats int
+	assert.NilError(t, db.QueryRowContext(context.Background(), "SELECT count(*) FROM cats").Scan(&numCats))
+	assert.Equal(t, 2, numCats)
+
+	// The generation of the latest snapshot is restored.
+	args, err := os.ReadFile(filepath.Join(filepath.Dir(command), "args"))
+	assert.NilError(t, err)
+	assert.Assert(t, strings.HasSuffix(strings.TrimSpace(string(args)), "-generation fedcba9876543210 s3://bucket/db"), string(args))
+}
+
+func TestHash(t *testing.T) {
+	t.Parallel()
+	command := newReplica(t)
+	lm := litestreammigrator.New("s3://bucket/db", litestreammigrator.WithCommand(command))
+	before, err := lm.Hash()
+	assert.NilError(t, err)
+
+	// A new snapshot changes the hash, but only once the snapshots are listed
+	// again.
+	newer := snapshots + "s3       fedcba9876543210  9      8192  2024-03-01T00:00:00Z\n"
+	assert.NilError(t, os.WriteFile(filepath.Join(filepath.Dir(command), "snapshots"), []byte(newer), 0o600))
+	cached, err := lm.Hash()
+	assert.NilError(t, err)
+	assert.Equal(t, before, cached)
+
+	after, err := litestreammigrator.New("s3://bucket/db", litestreammigrator.WithCommand(command)).Hash()
+	assert.NilError(t, err)
+	assert.Assert(t, before != after)
+
+	other, err := litestreammigrator.New("s3://bucket/other", litestreammigrator.WithCommand(command)).Hash()
+	assert.NilError(t, err)
+	assert.Assert(t, other != after)
+}
+
+func TestNoSnapshots(t *testing.T) {
+	t.Parallel()
+	command := newReplica(t)
+	assert.NilError(t, os.WriteFile(filepath.Join(filepath.Dir(command), "snapshots"), []byte("replica  generation  index  size  created\n"), 0o600))
+
+	_, err := litestreammigrator.New("s3://bucket/db", litestreammigrator.WithCommand(command)).Hash()
+	assert.ErrorContains(t, err, "could not list the snapshots of s3://bucket/db: the replica has no snapshots")
+}
+
+func TestUnreachable(t *testing.T) {
+	t.Parallel()
+	command := newReplica(t)
+	dir := filepath.Dir(command)
+
+	// The snapshots can't be listed.
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "unreachable"), nil, 0o600))
+	_, err := litestreammigrator.New("s3://bucket/unreachable", litestreammigrator.WithCommand(command)).Hash()
+	assert.ErrorContains(t, err, "could not list the snapshots of s3://bucket/unreachable: litestream snapshots: exit status 1: dial tcp: connection refused")
+
+	// The snapshots were listed, but the restore fails part way. The replica
+	// URL is unique to this test, so that no template exists yet.
+	assert.NilError(t, os.Remove(filepath.Join(dir, "unreachable")))
+	lm := litestreammigrator.New("s3://bucket/unreachable", litestreammigrator.WithCommand(command))
+	_, err = lm.Hash()
+	assert.NilError(t, err)
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "unreachable"), nil, 0o600))
+
+	_, err = sqlitestdb.Prepare(context.Background(), sqlitestdb.Config{Driver: "sqlite3"}, lm)
+	assert.ErrorContains(t, err, "could not restore s3://bucket/unreachable: litestream restore: exit status 1: cannot fetch wal segment: dial tcp: connection refused")
+
+	// The partial file was removed.
+	restored, err := os.ReadFile(filepath.Join(dir, "restored"))
+	assert.NilError(t, err)
+	_, err = os.Stat(strings.TrimSpace(string(restored)))
+	assert.Assert(t, os.IsNotExist(err), err)
+}
@@ -0,0 +1,109 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+// Package migtest contains test helpers checking the migrations of migration
+// frameworks, such as that their down migrations revert their up migrations.
+package migtest
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"braces.dev/errtrace"
+	"github.com/terinjokes/sqlitestdb"
+	"github.com/terinjokes/sqlitestdb/internal/diff"
+)
+
+// Reversible is a [sqlitestdb.Migrator] of a migration framework that supports
+// down migrations, such as the golang-migrate and goose migrators.
+type Reversible interface {
+	sqlitestdb.Migrator
+
+	// Down runs all of the down migrations, reverting the migrations applied
+	// by Migrate.
+	Down(ctx context.Context, db *sql.DB, config sqlitestdb.Config) error
+
+	// VersionTables returns the names of the tables in which the framework
+	// records the applied migrations, which remain after running the down
+	// migrations.
+	VersionTables() []string
+}
+
+// CheckReversible checks that the down migrations of the migrator revert its up
+// migrations, against a scratch database: it runs the up migrations, the down
+// migrations, and the up migrations again. It fails the test with
+// [testing.TB.Fatalf] if any of them fails, if the schema isn't empty after
+// running the down migrations, apart from the version tables, or if it differs
+// after running the up migrations again. Differences of the schema are
+// reported as a diff.
+//
+// The scratch database is created in a temporary directory of the test, with
+// the driver of config, and removed once the test finishes.
+func CheckReversible(t testing.TB, config sqlitestdb.Config, migrator Reversible) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	config.Database = filepath.Join(t.TempDir(), "reversible.sqlite")
+	ignored := migrator.VersionTables()
+
+	empty, err := dump(ctx, config, ignored)
+	if err != nil {
+		t.Fatalf("could not dump schema: %+v", err)
+	}
+
+	if err := run(ctx, config, migrator.Migrate); err != nil {
+		t.Fatalf("could not run up migrations: %+v", err)
+	}
+	up, err := dump(ctx, config, ignored)
+	if err != nil {
+		t.Fatalf("could not dump schema: %+v", err)
+	}
+
+	if err := run(ctx, config, migrator.Down); err != nil {
+		t.Fatalf("could not run down migrations: %+v", err)
+	}
+	down, err := dump(ctx, config, ignored)
+	if err != nil {
+		t.Fatalf("could not dump schema: %+v", err)
+	}
+	if down != empty {
+		t.Fatalf("down migrations of %T left objects in the schema:\n%s", migrator, diff.Lines(empty, down))
+	}
+
+	if err := run(ctx, config, migrator.Migrate); err != nil {
+		t.Fatalf("could not run up migrations again: %+v", err)
+	}
+	again, err := dump(ctx, config, ignored)
+	if err != nil {
+		t.Fatalf("could not dump schema: %+v", err)
+	}
+	if again != up {
+		t.Fatalf("running the up migrations of %T again created another schema:\n%s", migrator, diff.Lines(up, again))
+	}
+}
+
+// run runs the migrations with fn, on a connection of its own.
+func run(ctx context.Context, config sqlitestdb.Config, fn func(context.Context, *sql.DB, sqlitestdb.Config) error) error {
+	db, err := config.Connect()
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+	defer db.Close()
+
+	return errtrace.Wrap(fn(ctx, db, config))
+}
+
+// dump returns the schema of the database, with [sqlitestdb.DumpSchema], except
+// for the ignored tables and their indexes and triggers.
+func dump(ctx context.Context, config sqlitestdb.Config, ignored []string) (string, error) {
+	db, err := config.Connect()
+	if err != nil {
+		return "", errtrace.Wrap(err)
+	}
+	defer db.Close()
+
+	return errtrace.Wrap2(sqlitestdb.DumpSchema(ctx, db, ignored...))
+}
@@ -0,0 +1,169 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package migtest_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"runtime"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/terinjokes/sqlitestdb"
+	"github.com/terinjokes/sqlitestdb/migrators/migtest"
+	"gotest.tools/v3/assert"
+	_ "modernc.org/sqlite"
+)
+
+func TestCheckReversible(t *testing.T) {
+	t.Parallel()
+	for _, driver := range []string{"sqlite3", "sqlite"} {
+		t.Run(driver, func(t *testing.T) {
+			t.Parallel()
+			migtest.CheckReversible(t, sqlitestdb.Config{Driver: driver}, &sqlMigrator{
+				up: []string{
+					"CREATE TABLE IF NOT EXISTS versions (version INTEGER)",
+					"CREATE TABLE cats (id INTEGER PRIMARY KEY AUTOINCREMENT,\n\tname TEXT)",
+					"CREATE INDEX cats_name ON cats (name)",
+					"INSERT INTO versions VALUES (1)",
+				},
+				down: []string{
+					"DROP TABLE cats",
+					"DELETE FROM versions",
+				},
+			})
+		})
+	}
+}
+
+func TestCheckReversibleFails(t *testing.T) {
+	t.Parallel()
+
+	ftb := runFake(t, func(tb testing.TB) {
+		migtest.CheckReversible(tb, sqlitestdb.Config{Driver: "sqlite3"}, &sqlMigrator{
+			up: []string{
+				"CREATE TABLE cats (id INTEGER PRIMARY KEY)",
+				"CREATE INDEX cats_id ON cats (id)",
+				"CREATE TABLE dogs (id INTEGER PRIMARY KEY)",
+			},
+			down: []string{
+				"DROP TABLE dogs",
+				"DROP INDEX cats_id",
+			},
+		})
+	})
+	assert.Equal(t, ftb.fatal, "down migrations of *migtest_test.sqlMigrator left objects in the schema:\n"+
+		"  PRAGMA application_id = 0;\n"+
+		"  PRAGMA user_version = 0;\n"+
+		"+ CREATE TABLE cats (id INTEGER PRIMARY KEY);\n")
+
+	ftb = runFake(t, func(tb testing.TB) {
+		migtest.CheckReversible(tb, sqlitestdb.Config{Driver: "sqlite3"}, &versionedMigrator{sqlMigrator{
+			up: []string{
+				"CREATE TABLE cats (id INTEGER PRIMARY KEY)",
+				"CREATE TABLE dogs (id INTEGER PRIMARY KEY)",
+			},
+			down: []string{
+				"DROP TABLE dogs",
+				"DROP TABLE cats",
+			},
+		}})
+	})
+	assert.Equal(t, ftb.fatal, "running the up migrations of *migtest_test.versionedMigrator again created another schema:\n"+
+		"  PRAGMA application_id = 0;\n"+
+		"  PRAGMA user_version = 0;\n"+
+		"- CREATE TABLE cats (id INTEGER PRIMARY KEY);\n"+
+		"- CREATE TABLE dogs (id INTEGER PRIMARY KEY);\n")
+}
+
+// sqlMigrator runs the up and down statements.
+type sqlMigrator struct {
+	up, down []string
+}
+
+func (m *sqlMigrator) Hash() (string, error) {
+	return fmt.Sprint(m.up), nil
+}
+
+func (m *sqlMigrator) Migrate(ctx context.Context, db *sql.DB, _ sqlitestdb.Config) error {
+	return exec(ctx, db, m.up)
+}
+
+func (m *sqlMigrator) Down(ctx context.Context, db *sql.DB, _ sqlitestdb.Config) error {
+	return exec(ctx, db, m.down)
+}
+
+func (m *sqlMigrator) VersionTables() []string {
+	return []string{"versions"}
+}
+
+// versionedMigrator is a sqlMigrator that records that the up migrations were
+// applied, but doesn't forget it when running the down migrations, so they
+// aren't applied again.
+type versionedMigrator struct {
+	sqlMigrator
+}
+
+func (m *versionedMigrator) Migrate(ctx context.Context, db *sql.DB, config sqlitestdb.Config) error {
+	if _, err := db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS versions (version INTEGER)"); err != nil {
+		return err
+	}
+	var applied int
+	if err := db.QueryRowContext(ctx, "SELECT count(*) FROM versions").Scan(&applied); err != nil || applied > 0 {
+		return err
+	}
+	if err := m.sqlMigrator.Migrate(ctx, db, config); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, "INSERT INTO versions VALUES (1)")
+	return err
+}
+
+func exec(ctx context.Context, db *sql.DB, statements []string) error {
+	for _, stmt := range statements {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fakeTB is a [testing.TB] that records the first fatal error instead of
+// failing the test.
+type fakeTB struct {
+	testing.TB
+	dir   string
+	fatal string
+}
+
+// runFake runs fn with a fakeTB in its own goroutine, as [testing.TB.Fatalf]
+// exits the calling goroutine.
+func runFake(t *testing.T, fn func(testing.TB)) *fakeTB {
+	t.Helper()
+	ftb := &fakeTB{dir: t.TempDir()}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn(ftb)
+	}()
+	<-done
+	return ftb
+}
+
+func (f *fakeTB) Helper() {}
+
+func (f *fakeTB) TempDir() string {
+	dir, err := os.MkdirTemp(f.dir, "")
+	if err != nil {
+		f.Fatalf("could not create temporary directory: %+v", err)
+	}
+	return dir
+}
+
+func (f *fakeTB) Fatalf(format string, args ...any) {
+	f.fatal = fmt.Sprintf(format, args...)
+	runtime.Goexit()
+}
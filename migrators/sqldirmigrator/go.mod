@@ -0,0 +1,18 @@
+module github.com/terinjokes/sqlitestdb/migrators/sqldirmigrator
+
+go 1.22.0
+
+require (
+	braces.dev/errtrace v0.3.0
+	github.com/mattn/go-sqlite3 v1.14.24
+	github.com/terinjokes/sqlitestdb v0.1.0
+	gotest.tools/v3 v3.5.1
+)
+
+require (
+	github.com/google/go-cmp v0.6.0 // indirect
+	golang.org/x/mod v0.22.0 // indirect
+	golang.org/x/sys v0.27.0 // indirect
+)
+
+replace github.com/terinjokes/sqlitestdb => ../..
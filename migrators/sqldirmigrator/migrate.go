@@ -0,0 +1,139 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package sqldirmigrator
+
+import (
+	"context"
+	"database/sql"
+	"io/fs"
+
+	"braces.dev/errtrace"
+	"github.com/terinjokes/sqlitestdb"
+	"github.com/terinjokes/sqlitestdb/migrators/common"
+	"github.com/terinjokes/sqlitestdb/migrators/hash"
+)
+
+// DefaultGlob is the pattern the migration files are matched with, unless set
+// with [WithGlob].
+const DefaultGlob = "*.sql"
+
+// Option provides a way to configure the SQLDirMigrator struct and its behavior.
+type Option func(*SQLDirMigrator)
+
+// WithFS specifies a [fs.FS] from which to read the migration files.
+// If not specified as an option to [New], the migrator will read from
+// the real filesystem.
+func WithFS(dir fs.FS) Option {
+	return func(sm *SQLDirMigrator) {
+		sm.FS = dir
+	}
+}
+
+// WithGlob specifies the pattern, in the syntax of [path.Match], that the
+// migration files are matched with. If not specified as an option to [New],
+// [DefaultGlob] is used.
+func WithGlob(glob string) Option {
+	return func(sm *SQLDirMigrator) {
+		sm.Glob = glob
+	}
+}
+
+// SQLDirMigrator is a [sqlitestdb.Migrator] that executes the SQL files of a
+// directory in lexical order, without a migration framework. Each file is
+// executed in its own transaction, so files must not contain BEGIN or COMMIT
+// statements. The executed files aren't recorded in the template database.
+//
+// The files are split into statements, which are executed one by one, as
+// libsql only runs the first statement of [sql.DB.ExecContext].
+//
+// Because [Hash] requires calculating a unique hash based on the contents of
+// the migrations, this implementation only supports reading migration files
+// from disk or an embedded filesystem.
+type SQLDirMigrator struct {
+	MigrationsDir string
+	FS            fs.FS
+	Glob          string
+}
+
+// New returns a [SQLDirMigrator], which implements sqlitestdb.Migrator by
+// executing the SQL files in migrationsDir.
+func New(migrationsDir string, opts ...Option) *SQLDirMigrator {
+	sm := &SQLDirMigrator{MigrationsDir: migrationsDir, Glob: DefaultGlob}
+	for _, opt := range opts {
+		opt(sm)
+	}
+
+	return sm
+}
+
+// Hash returns a hash of the names and contents of the migration files, in
+// lexical order, so that renaming a file, which may change the order they are
+// executed in, creates a new template.
+func (sm *SQLDirMigrator) Hash() (string, error) {
+	source := sm.source()
+	names, err := source.List(sm.Glob)
+	if err != nil {
+		return "", errtrace.Wrap(err)
+	}
+
+	h := hash.NewRecursiveHash()
+	for _, name := range names {
+		contents, err := source.ReadFile(name)
+		if err != nil {
+			return "", errtrace.Wrap(err)
+		}
+		h.Add([]byte(name))
+		h.Add(contents)
+	}
+
+	return h.String(), nil
+}
+
+// Migrate executes the migration files in lexical order, each in a transaction.
+func (sm *SQLDirMigrator) Migrate(ctx context.Context, db *sql.DB, _ sqlitestdb.Config) error {
+	source := sm.source()
+	names, err := source.List(sm.Glob)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	for _, name := range names {
+		contents, err := source.ReadFile(name)
+		if err != nil {
+			return errtrace.Wrap(err)
+		}
+
+		if err := apply(ctx, db, name, string(contents)); err != nil {
+			return errtrace.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+// apply executes the statements of a migration file in a transaction, with
+// [sqlitestdb.ExecSplit]. Errors name the file, and the position, line and text
+// of the statement that failed.
+func apply(ctx context.Context, db *sql.DB, name, contents string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return errtrace.Errorf("migration %s: %w", name, err)
+	}
+	defer tx.Rollback()
+
+	if err := sqlitestdb.ExecSplit(ctx, tx, contents); err != nil {
+		return errtrace.Errorf("migration %s: %w", name, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errtrace.Errorf("migration %s: %w", name, err)
+	}
+	return nil
+}
+
+// source returns the [common.Source] the migrations are read from, so that
+// embedded and on-disk migrations are handled identically.
+func (sm *SQLDirMigrator) source() common.Source {
+	return common.NewSource(sm.FS, sm.MigrationsDir)
+}
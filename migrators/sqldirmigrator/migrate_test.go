@@ -0,0 +1,131 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package sqldirmigrator_test
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/terinjokes/sqlitestdb"
+	"github.com/terinjokes/sqlitestdb/migrators/common"
+	"github.com/terinjokes/sqlitestdb/migrators/common/commontest"
+	"github.com/terinjokes/sqlitestdb/migrators/sqldirmigrator"
+	"gotest.tools/v3/assert"
+)
+
+//go:embed migrations/*.sql
+var exampleFS embed.FS
+
+func TestMigrateFromDisk(t *testing.T) {
+	t.Parallel()
+	sm := sqldirmigrator.New("migrations")
+	db := sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, sm)
+	testDB(t, db)
+}
+
+func TestMigrateFromEmbeddedFS(t *testing.T) {
+	t.Parallel()
+	sm := sqldirmigrator.New("migrations", sqldirmigrator.WithFS(exampleFS))
+	db := sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, sm)
+	testDB(t, db)
+}
+
+func TestWithGlob(t *testing.T) {
+	t.Parallel()
+	files := fstest.MapFS{
+		"schema/0001_cats.up.sql":   {Data: []byte("CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT);")},
+		"schema/0001_cats.down.sql": {Data: []byte("DROP TABLE cats;")},
+	}
+	sm := sqldirmigrator.New("schema", sqldirmigrator.WithFS(files), sqldirmigrator.WithGlob("*.up.sql"))
+	db := sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, sm)
+
+	var numCats int
+	err := db.QueryRow("SELECT count(*) FROM cats").Scan(&numCats)
+	assert.NilError(t, err)
+	assert.Equal(t, 0, numCats)
+}
+
+func TestHashIncludesNames(t *testing.T) {
+	t.Parallel()
+	contents := []byte("CREATE TABLE cats (id INTEGER PRIMARY KEY);")
+
+	hash, err := sqldirmigrator.New("migrations", sqldirmigrator.WithFS(fstest.MapFS{
+		"migrations/0001_cats.sql": {Data: contents},
+	})).Hash()
+	assert.NilError(t, err)
+	renamed, err := sqldirmigrator.New("migrations", sqldirmigrator.WithFS(fstest.MapFS{
+		"migrations/0002_cats.sql": {Data: contents},
+	})).Hash()
+	assert.NilError(t, err)
+	assert.Assert(t, hash != renamed)
+}
+
+func TestMigrateError(t *testing.T) {
+	t.Parallel()
+	files := fstest.MapFS{
+		"migrations/0001_cats.sql": {Data: []byte("CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT);\n")},
+		"migrations/0002_seed.sql": {Data: []byte("-- Seed the cats.\n" +
+			"INSERT INTO cats (name) VALUES ('daisy');\n" +
+			"INSERT INTO dogs (name)\n  VALUES ('rex');\n")},
+	}
+	sm := sqldirmigrator.New("migrations", sqldirmigrator.WithFS(files))
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.sqlite"))
+	assert.NilError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	err = sm.Migrate(context.Background(), db, sqlitestdb.Config{Driver: "sqlite3"})
+	assert.Error(t, err, "migration 0002_seed.sql: statement 2, line 3: INSERT INTO dogs (name) VALUES ('rex'): no such table: dogs")
+
+	// The first file was committed, and the second rolled back.
+	var numCats int
+	err = db.QueryRow("SELECT count(*) FROM cats").Scan(&numCats)
+	assert.NilError(t, err)
+	assert.Equal(t, 0, numCats)
+}
+
+func TestSourceConformance(t *testing.T) {
+	t.Parallel()
+	commontest.Conformance{
+		Files: fstest.MapFS{
+			"db/migrations/0001_init.sql": {Data: []byte("CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);\n")},
+			"db/migrations/0002_cats.sql": {Data: []byte("CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT);\n")},
+		},
+		Dir:    "db/migrations",
+		Config: sqlitestdb.Config{Driver: "sqlite3"},
+		New: func(source common.Source) sqlitestdb.Migrator {
+			return sqldirmigrator.New(source.Dir, sqldirmigrator.WithFS(source.FS))
+		},
+		Check: func(t *testing.T, db *sql.DB) {
+			var numCats int
+			err := db.QueryRow("SELECT count(*) FROM cats").Scan(&numCats)
+			assert.NilError(t, err)
+			assert.Equal(t, 0, numCats)
+		},
+	}.Run(t)
+}
+
+func testDB(t *testing.T, db *sql.DB) {
+	ctx := context.Background()
+
+	var numUsers int
+	err := db.QueryRowContext(ctx, "SELECT count(*) FROM users").Scan(&numUsers)
+	assert.NilError(t, err)
+	assert.Equal(t, 0, numUsers)
+
+	var numCats int
+	err = db.QueryRowContext(ctx, "SELECT count(*) FROM cats").Scan(&numCats)
+	assert.NilError(t, err)
+	assert.Equal(t, 0, numCats)
+
+	var numBlogPosts int
+	err = db.QueryRowContext(ctx, "SELECT count(*) FROM blog_posts").Scan(&numBlogPosts)
+	assert.NilError(t, err)
+	assert.Equal(t, 0, numBlogPosts)
+}
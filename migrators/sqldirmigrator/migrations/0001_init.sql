@@ -0,0 +1,15 @@
+-- Create the users and blog_posts tables.
+CREATE TABLE users (
+  id integer NOT NULL,
+  name text NULL,
+  PRIMARY KEY (id)
+);
+
+CREATE TABLE blog_posts (
+  id integer NOT NULL,
+  title text NULL,
+  body text NULL,
+  author_id integer NULL,
+  PRIMARY KEY (id),
+  CONSTRAINT author_fk FOREIGN KEY (author_id) REFERENCES users (id)
+);
@@ -0,0 +1,7 @@
+-- Create the cats table.
+CREATE TABLE cats (
+  id integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+  name text NULL
+);
+
+CREATE INDEX cats_name ON cats (name);
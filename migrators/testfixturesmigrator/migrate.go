@@ -0,0 +1,228 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package testfixturesmigrator
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"braces.dev/errtrace"
+	"github.com/go-testfixtures/testfixtures/v3"
+	"github.com/terinjokes/sqlitestdb"
+	"github.com/terinjokes/sqlitestdb/migrators/common"
+	"github.com/terinjokes/sqlitestdb/migrators/hash"
+)
+
+// DefaultGlob is the pattern the fixture files are matched with, unless set
+// with [WithGlob]. It matches both the ".yml" and ".yaml" extensions.
+const DefaultGlob = "*.y*ml"
+
+// Option provides a way to configure the TestFixturesMigrator struct and its
+// behavior.
+//
+// testfixtures documentation: https://github.com/go-testfixtures/testfixtures
+type Option func(*TestFixturesMigrator)
+
+// WithFS specifies a [fs.FS] from which to read the fixture files.
+// If not specified as an option to [New], the migrator will read from
+// the real filesystem.
+func WithFS(dir fs.FS) Option {
+	return func(tm *TestFixturesMigrator) {
+		tm.FS = dir
+	}
+}
+
+// WithGlob specifies the pattern, in the syntax of [path.Match], that the
+// fixture files are matched with. If not specified as an option to [New],
+// [DefaultGlob] is used.
+func WithGlob(glob string) Option {
+	return func(tm *TestFixturesMigrator) {
+		tm.Glob = glob
+	}
+}
+
+// WithDeferredForeignKeys disables the enforcement of foreign keys while the
+// fixtures are loaded, so that they can be loaded in any order, and checks
+// them all once the fixtures are loaded instead.
+//
+// It has no effect inside a transaction, such as with
+// [sqlitestdb.WithTxMigrations], as SQLite ignores changes to the foreign_keys
+// PRAGMA in transactions.
+func WithDeferredForeignKeys() Option {
+	return func(tm *TestFixturesMigrator) {
+		tm.DeferForeignKeys = true
+	}
+}
+
+// TestFixturesMigrator loads a directory of YAML fixture files, one for each
+// table and named after it, with go-testfixtures. It is both a
+// [sqlitestdb.Migrator], to load the fixtures once into the template, such as
+// after the schema with [sqlitestdb.Sequence], and a [sqlitestdb.Seeder], to
+// load them into each instance instead.
+//
+// Like testfixtures, loading the fixtures deletes the existing rows of their
+// tables.
+//
+// Because [Hash] requires calculating a unique hash based on the contents of
+// the fixtures, this implementation only supports reading fixture files from
+// disk or an embedded filesystem.
+type TestFixturesMigrator struct {
+	FixturesDir      string
+	FS               fs.FS
+	Glob             string
+	DeferForeignKeys bool
+}
+
+// New returns a [TestFixturesMigrator], which implements sqlitestdb.Migrator
+// and sqlitestdb.Seeder by loading the fixtures in fixturesDir.
+func New(fixturesDir string, opts ...Option) *TestFixturesMigrator {
+	tm := &TestFixturesMigrator{FixturesDir: fixturesDir, Glob: DefaultGlob}
+	for _, opt := range opts {
+		opt(tm)
+	}
+
+	return tm
+}
+
+// Hash returns a hash of the names and contents of the fixture files, as the
+// names of the files are those of the tables they are loaded into.
+func (tm *TestFixturesMigrator) Hash() (string, error) {
+	source := tm.source()
+	names, err := source.List(tm.Glob)
+	if err != nil {
+		return "", errtrace.Wrap(err)
+	}
+
+	h := hash.NewRecursiveHash(hash.Field("DeferForeignKeys", tm.DeferForeignKeys))
+	for _, name := range names {
+		contents, err := source.ReadFile(name)
+		if err != nil {
+			return "", errtrace.Wrap(err)
+		}
+		h.Add([]byte(name))
+		h.Add(contents)
+	}
+
+	return h.String(), nil
+}
+
+// Migrate loads the fixtures into the template database.
+func (tm *TestFixturesMigrator) Migrate(ctx context.Context, db *sql.DB, _ sqlitestdb.Config) error {
+	return errtrace.Wrap(tm.Seed(ctx, db))
+}
+
+// Seed loads the fixtures into an instance database.
+func (tm *TestFixturesMigrator) Seed(ctx context.Context, db *sql.DB) (err error) {
+	source := tm.source()
+	sub, err := source.Sub()
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+	names, err := source.List(tm.Glob)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+	if len(names) == 0 {
+		return errtrace.Errorf("no fixtures matching %q in %q", tm.Glob, tm.FixturesDir)
+	}
+
+	if tm.DeferForeignKeys {
+		// The PRAGMA only applies to the connection it is run on, so the
+		// fixtures are loaded on a single connection.
+		db.SetMaxOpenConns(1)
+		defer db.SetMaxOpenConns(0)
+
+		restore, err := disableForeignKeys(ctx, db)
+		if err != nil {
+			return errtrace.Wrap(err)
+		}
+		defer func() {
+			if rErr := restore(); rErr != nil && err == nil {
+				err = errtrace.Wrap(rErr)
+			}
+		}()
+	}
+
+	fixtures, err := testfixtures.New(
+		testfixtures.Database(db),
+		testfixtures.Dialect("sqlite"),
+		testfixtures.FS(sub),
+		testfixtures.Files(names...),
+		// The template and instance databases are always throwaway copies, and
+		// their names don't need to contain "test".
+		testfixtures.DangerousSkipTestDatabaseCheck(),
+	)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	if err := fixtures.Load(); err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	if tm.DeferForeignKeys {
+		return errtrace.Wrap(checkForeignKeys(ctx, db))
+	}
+	return nil
+}
+
+// disableForeignKeys disables the enforcement of foreign keys, and returns a
+// function enabling it again, if it was enabled.
+func disableForeignKeys(ctx context.Context, db *sql.DB) (restore func() error, err error) {
+	var enabled bool
+	if err := db.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&enabled); err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+	if !enabled {
+		return func() error { return nil }, nil
+	}
+
+	if _, err := db.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+	return func() error {
+		_, err := db.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+		return errtrace.Wrap(err)
+	}, nil
+}
+
+// checkForeignKeys reports the rows of the fixtures that violate a foreign key
+// constraint.
+func checkForeignKeys(ctx context.Context, db *sql.DB) error {
+	rows, err := db.QueryContext(ctx, "PRAGMA foreign_key_check")
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+	defer rows.Close()
+
+	var violations []string
+	for rows.Next() {
+		var (
+			table, parent string
+			rowid         sql.NullInt64
+			fkid          int
+		)
+		if err := rows.Scan(&table, &rowid, &parent, &fkid); err != nil {
+			return errtrace.Wrap(err)
+		}
+		violations = append(violations, fmt.Sprintf("row %d of %s references a missing row of %s", rowid.Int64, table, parent))
+	}
+	if err := rows.Err(); err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	if len(violations) > 0 {
+		return errtrace.Errorf("fixtures violate foreign keys: %s", strings.Join(violations, "; "))
+	}
+	return nil
+}
+
+// source returns the [common.Source] the fixtures are read from, so that
+// embedded and on-disk fixtures are handled identically.
+func (tm *TestFixturesMigrator) source() common.Source {
+	return common.NewSource(tm.FS, tm.FixturesDir)
+}
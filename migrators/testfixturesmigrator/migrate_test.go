@@ -0,0 +1,113 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package testfixturesmigrator_test
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"testing"
+	"testing/fstest"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/terinjokes/sqlitestdb"
+	"github.com/terinjokes/sqlitestdb/migrators/testfixturesmigrator"
+	"gotest.tools/v3/assert"
+)
+
+//go:embed fixtures/*.yml
+var exampleFS embed.FS
+
+// schema creates the tables the fixtures are loaded into.
+var schema = sqlitestdb.MigrateFunc("owners-cats-v1", func(ctx context.Context, db *sql.DB) error {
+	for _, stmt := range []string{
+		"CREATE TABLE owners (id INTEGER PRIMARY KEY, name TEXT NOT NULL)",
+		"CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT NOT NULL, owner_id INTEGER NOT NULL REFERENCES owners (id))",
+	} {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+})
+
+func TestMigrateFromDisk(t *testing.T) {
+	t.Parallel()
+	tm := testfixturesmigrator.New("fixtures")
+	db := sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, sqlitestdb.Sequence(schema, tm))
+	testDB(t, db)
+}
+
+func TestMigrateFromEmbeddedFS(t *testing.T) {
+	t.Parallel()
+	tm := testfixturesmigrator.New("fixtures", testfixturesmigrator.WithFS(exampleFS))
+	db := sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, sqlitestdb.Sequence(schema, tm))
+	testDB(t, db)
+}
+
+func TestSeed(t *testing.T) {
+	t.Parallel()
+	tm := testfixturesmigrator.New("fixtures", testfixturesmigrator.WithFS(exampleFS))
+	db := sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, schema, sqlitestdb.WithSeed(tm))
+	testDB(t, db)
+}
+
+func TestHashCoversFixtures(t *testing.T) {
+	t.Parallel()
+	files := fstest.MapFS{
+		"fixtures/owners.yml": {Data: []byte("- id: 1\n  name: terin\n")},
+	}
+	hash, err := testfixturesmigrator.New("fixtures", testfixturesmigrator.WithFS(files)).Hash()
+	assert.NilError(t, err)
+
+	files["fixtures/owners.yml"] = &fstest.MapFile{Data: []byte("- id: 1\n  name: peter\n")}
+	changed, err := testfixturesmigrator.New("fixtures", testfixturesmigrator.WithFS(files)).Hash()
+	assert.NilError(t, err)
+	assert.Assert(t, hash != changed)
+
+	// The names of the files are those of the tables.
+	renamed, err := testfixturesmigrator.New("fixtures", testfixturesmigrator.WithFS(fstest.MapFS{
+		"fixtures/people.yml": files["fixtures/owners.yml"],
+	})).Hash()
+	assert.NilError(t, err)
+	assert.Assert(t, changed != renamed)
+}
+
+func TestWithDeferredForeignKeys(t *testing.T) {
+	t.Parallel()
+	config := sqlitestdb.Config{Driver: "sqlite3"}
+
+	// cats.yml is loaded before owners.yml, which it references, and the
+	// foreign keys are checked once both are loaded.
+	tm := testfixturesmigrator.New("fixtures", testfixturesmigrator.WithFS(exampleFS), testfixturesmigrator.WithDeferredForeignKeys())
+	db := sqlitestdb.New(t, config, sqlitestdb.Sequence(schema, tm))
+	testDB(t, db)
+
+	files := fstest.MapFS{
+		"fixtures/owners.yml": {Data: []byte("- id: 1\n  name: terin\n")},
+		"fixtures/cats.yml":   {Data: []byte("- id: 1\n  name: daisy\n  owner_id: 2\n")},
+	}
+	tm = testfixturesmigrator.New("fixtures", testfixturesmigrator.WithFS(files), testfixturesmigrator.WithDeferredForeignKeys())
+	_, err := sqlitestdb.Prepare(context.Background(), config, sqlitestdb.Sequence(schema, tm))
+	assert.ErrorContains(t, err, "fixtures violate foreign keys: row 1 of cats references a missing row of owners")
+}
+
+func testDB(t *testing.T, db *sql.DB) {
+	ctx := context.Background()
+
+	var numOwners int
+	err := db.QueryRowContext(ctx, "SELECT count(*) FROM owners").Scan(&numOwners)
+	assert.NilError(t, err)
+	assert.Equal(t, 2, numOwners)
+
+	var numCats int
+	err = db.QueryRowContext(ctx, "SELECT count(*) FROM cats").Scan(&numCats)
+	assert.NilError(t, err)
+	assert.Equal(t, 3, numCats)
+
+	var numDaisy int
+	err = db.QueryRowContext(ctx, "SELECT count(*) FROM cats JOIN owners ON owners.id = cats.owner_id WHERE owners.name = 'terin'").Scan(&numDaisy)
+	assert.NilError(t, err)
+	assert.Equal(t, 2, numDaisy)
+}
@@ -0,0 +1,181 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package sqlitestdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+
+	"braces.dev/errtrace"
+)
+
+// Multi is a set of related instance databases created by [NewMulti], keyed by
+// their names.
+type Multi struct {
+	Configs map[string]*Config
+	DBs     map[string]*sql.DB
+}
+
+// Spec describes one of the databases created by [NewSet].
+type Spec struct {
+	Config   Config
+	Migrator Migrator
+}
+
+// NewMulti is like [New], but creates an instance database for each of the
+// named migrators, for applications that use several SQLite files together.
+// Each migrator has its own template, cached by its hash as with [New]. Seeders
+// given with [WithSeed] are not run.
+//
+// If this method succeeds and your test succeeds, all of the databases will be
+// removed as part of the test cleanup process.
+func NewMulti(t testing.TB, config Config, migrators map[string]Migrator, opts ...Option) *Multi {
+	t.Helper()
+	specs := make(map[string]Spec, len(migrators))
+	for name, migrator := range migrators {
+		specs[name] = Spec{Config: config, Migrator: migrator}
+	}
+
+	return newSet(t, specs, newOptions(opts))
+}
+
+// NewSet is like [NewMulti], but each of the named databases may use its own
+// configuration, and only the connections are returned.
+//
+// The names are included in the names of the instance database files. If any
+// of the databases can't be created, those already created are removed, and the
+// test will be immediately failed with [testing.TB.Fatalf].
+func NewSet(t testing.TB, specs map[string]Spec, opts ...Option) map[string]*sql.DB {
+	t.Helper()
+	return newSet(t, specs, newOptions(opts)).DBs
+}
+
+// newSet contains the implementation of [NewMulti] and [NewSet].
+func newSet(t testing.TB, specs map[string]Spec, o *options) *Multi {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	o.logf = t.Logf
+	m := &Multi{
+		Configs: make(map[string]*Config, len(specs)),
+		DBs:     make(map[string]*sql.DB, len(specs)),
+	}
+
+	// The databases created before a failure are still in the template state,
+	// so there is nothing to inspect in them.
+	discard := func() {
+		for name, db := range m.DBs {
+			closeDB(db)
+			discardInstance(m.Configs[name].Database)
+		}
+	}
+
+	var uris []string
+	for _, name := range sortedNames(specs) {
+		spec := specs[name]
+		instance, err := instantiate(ctx, t.Name()+"/"+name, spec.Config, spec.Migrator, o)
+		if err != nil {
+			discard()
+			t.Fatalf("could not create %q database: %+v", name, err)
+		}
+
+		db, err := instance.Connect()
+		if err != nil {
+			discardInstance(instance.Database)
+			discard()
+			t.Fatalf("could not connect to %q database: %+v", name, err)
+		}
+		limitPool(db)
+		trackDB(db, instance)
+
+		m.Configs[name] = instance
+		m.DBs[name] = db
+		uris = append(uris, name+"="+instance.URI())
+	}
+
+	t.Cleanup(func() {
+		var errs []error
+		for _, name := range sortedNames(m.DBs) {
+			if err := closeDB(m.DBs[name]); err != nil {
+				errs = append(errs, errtrace.Errorf("could not close %q database %q: %w", name, m.Configs[name].Database, err))
+			}
+		}
+		if err := errors.Join(errs...); err != nil {
+			t.Fatalf("%+v", err)
+		}
+
+		for _, config := range m.Configs {
+			removeInstance(t, config.Database)
+		}
+	})
+
+	logInstance(t, o, "sqlitestdb: "+strings.Join(uris, " "))
+
+	return m
+}
+
+// Attach returns a connection to the database named primary, with each of the
+// other databases attached to it under its name, so that they can be queried
+// together. As attached databases only exist on a single connection, a
+// [*sql.Conn] is returned, rather than a pool.
+//
+// The other databases are detached, and the connection is closed, as part of
+// the test cleanup process. If there is an error attaching the databases, the
+// test will be immediately failed with [testing.TB.Fatalf].
+func (m *Multi) Attach(t testing.TB, primary string) *sql.Conn {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, ok := m.DBs[primary]
+	if !ok {
+		t.Fatalf("no database named %q", primary)
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatalf("could not connect to %q database: %+v", primary, err)
+	}
+
+	var attached []string
+	t.Cleanup(func() {
+		// The connection goes back into the pool when closed, so the other
+		// databases have to be detached first. Errors are ignored, as the test
+		// may already have closed the connection.
+		for _, name := range attached {
+			conn.ExecContext(context.Background(), "DETACH DATABASE ?", name)
+		}
+		conn.Close()
+	})
+
+	for _, name := range sortedNames(m.Configs) {
+		if name == primary {
+			continue
+		}
+
+		if _, err := conn.ExecContext(ctx, "ATTACH DATABASE ? AS ?", m.Configs[name].URI(), name); err != nil {
+			t.Fatalf("could not attach %q database: %+v", name, err)
+		}
+		attached = append(attached, name)
+	}
+
+	return conn
+}
+
+// sortedNames returns the keys of m in order, so that databases are always
+// created, logged, and attached in the same order.
+func sortedNames[V any](m map[string]V) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
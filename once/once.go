@@ -15,6 +15,13 @@ type Map[K comparable, V any] interface {
 	// Get returns the initialization result associated with the key K.
 	// If K has not yet been initialized, the result will be (<nil>, <nil>).
 	Get(K) (*V, error)
+	// Forget removes the key K, so that the next call to Set initializes it
+	// again. Callers of Set already initializing K are unaffected, but their
+	// result is not stored.
+	Forget(K)
+	// Range calls f for each key K that was initialized without an error,
+	// until f returns false.
+	Range(f func(K, *V) bool)
 }
 
 // NewMap returns a [Map], a type-safe and concurrency-safe implementation of a
@@ -24,30 +31,58 @@ func NewMap[K comparable, V any]() Map[K, V] {
 }
 
 type entry[V any] struct {
+	once sync.Once
 	data *V
 	err  error
 }
 
 type smap[K comparable, V any] struct {
-	onces sync.Map // map[K]*sync.Once
-	data  sync.Map // map[K]safemapEntry
+	onces sync.Map // map[K]*entry[V]
+	data  sync.Map // map[K]*entry[V], once initialized
+
+	// mu orders storing the result of an entry with forgetting it, so that
+	// the result of a forgotten entry is never stored.
+	mu sync.Mutex
 }
 
 func (sm *smap[K, V]) Set(key K, f func() (*V, error)) (*V, error) {
-	onceRaw, _ := sm.onces.LoadOrStore(key, &sync.Once{})
-	once := onceRaw.(*sync.Once)
-	once.Do(func() {
-		res, err := f()
-		sm.data.Store(key, entry[V]{
-			data: res,
-			err:  err,
-		})
+	raw, _ := sm.onces.LoadOrStore(key, &entry[V]{})
+	e := raw.(*entry[V])
+	e.once.Do(func() {
+		e.data, e.err = f()
+
+		sm.mu.Lock()
+		defer sm.mu.Unlock()
+		if current, ok := sm.onces.Load(key); ok && current == e {
+			sm.data.Store(key, e)
+		}
 	})
-	return sm.Get(key)
+	return e.data, e.err
 }
 
 func (sm *smap[K, V]) Get(key K) (*V, error) {
-	rawState, _ := sm.data.Load(key)
-	state, _ := rawState.(entry[V])
+	rawState, ok := sm.data.Load(key)
+	if !ok {
+		return nil, nil
+	}
+	state := rawState.(*entry[V])
 	return state.data, state.err
 }
+
+func (sm *smap[K, V]) Forget(key K) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	sm.onces.Delete(key)
+	sm.data.Delete(key)
+}
+
+func (sm *smap[K, V]) Range(f func(K, *V) bool) {
+	sm.data.Range(func(key, rawState any) bool {
+		state := rawState.(*entry[V])
+		if state.err != nil {
+			return true
+		}
+		return f(key.(K), state.data)
+	})
+}
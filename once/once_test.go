@@ -0,0 +1,176 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package once_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/terinjokes/sqlitestdb/once"
+	"gotest.tools/v3/assert"
+)
+
+func value(v int) func() (*int, error) {
+	return func() (*int, error) {
+		return &v, nil
+	}
+}
+
+func TestSet(t *testing.T) {
+	t.Parallel()
+	m := once.NewMap[string, int]()
+
+	var wg sync.WaitGroup
+	var calls int
+	var mu sync.Mutex
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := m.Set("a", func() (*int, error) {
+				mu.Lock()
+				calls++
+				mu.Unlock()
+				return value(1)()
+			})
+			assert.NilError(t, err)
+			assert.Equal(t, 1, *v)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, calls)
+
+	v, err := m.Get("a")
+	assert.NilError(t, err)
+	assert.Equal(t, 1, *v)
+
+	v, err = m.Get("b")
+	assert.NilError(t, err)
+	assert.Assert(t, v == nil)
+}
+
+func TestSetError(t *testing.T) {
+	t.Parallel()
+	m := once.NewMap[string, int]()
+
+	failed := errors.New("failed")
+	_, err := m.Set("a", func() (*int, error) { return nil, failed })
+	assert.Assert(t, errors.Is(err, failed))
+
+	// The error is kept, and returned to later callers.
+	_, err = m.Set("a", value(1))
+	assert.Assert(t, errors.Is(err, failed))
+	_, err = m.Get("a")
+	assert.Assert(t, errors.Is(err, failed))
+}
+
+func TestForget(t *testing.T) {
+	t.Parallel()
+	m := once.NewMap[string, int]()
+
+	_, err := m.Set("a", value(1))
+	assert.NilError(t, err)
+	m.Forget("a")
+
+	v, err := m.Get("a")
+	assert.NilError(t, err)
+	assert.Assert(t, v == nil)
+
+	v, err = m.Set("a", value(2))
+	assert.NilError(t, err)
+	assert.Equal(t, 2, *v)
+
+	v, err = m.Get("a")
+	assert.NilError(t, err)
+	assert.Equal(t, 2, *v)
+}
+
+func TestForgetInFlight(t *testing.T) {
+	t.Parallel()
+	m := once.NewMap[string, int]()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan *int)
+	go func() {
+		v, err := m.Set("a", func() (*int, error) {
+			close(started)
+			<-release
+			return value(1)()
+		})
+		assert.NilError(t, err)
+		done <- v
+	}()
+
+	<-started
+	m.Forget("a")
+
+	// The key is initialized again, without waiting for the forgotten call.
+	v, err := m.Set("a", value(2))
+	assert.NilError(t, err)
+	assert.Equal(t, 2, *v)
+
+	// The forgotten call still returns its own result to its caller, but
+	// doesn't replace the current one.
+	close(release)
+	assert.Equal(t, 1, *<-done)
+
+	v, err = m.Get("a")
+	assert.NilError(t, err)
+	assert.Equal(t, 2, *v)
+
+	var values []int
+	m.Range(func(_ string, v *int) bool {
+		values = append(values, *v)
+		return true
+	})
+	assert.DeepEqual(t, values, []int{2})
+}
+
+func TestRange(t *testing.T) {
+	t.Parallel()
+	m := once.NewMap[string, int]()
+
+	_, err := m.Set("done", value(1))
+	assert.NilError(t, err)
+	_, err = m.Set("failed", func() (*int, error) { return nil, errors.New("failed") })
+	assert.ErrorContains(t, err, "failed")
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	pending := make(chan struct{})
+	go func() {
+		defer close(pending)
+		_, _ = m.Set("pending", func() (*int, error) {
+			close(started)
+			<-release
+			return value(3)()
+		})
+	}()
+	<-started
+
+	// Entries still being initialized, and those that failed, are skipped.
+	keys := func() []string {
+		var keys []string
+		m.Range(func(key string, _ *int) bool {
+			keys = append(keys, key)
+			return true
+		})
+		return keys
+	}
+	assert.DeepEqual(t, keys(), []string{"done"})
+
+	close(release)
+	<-pending
+	assert.Equal(t, 2, len(keys()))
+
+	// Returning false stops the iteration.
+	var calls int
+	m.Range(func(string, *int) bool {
+		calls++
+		return false
+	})
+	assert.Equal(t, 1, calls)
+}
@@ -0,0 +1,293 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package sqlitestdb
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"strconv"
+	"time"
+
+	"braces.dev/errtrace"
+)
+
+// Option provides a way to configure the behavior of [New], [Custom], and
+// [NewTx].
+//
+// Options that affect how a template is created only take effect for the first
+// caller to create that template in this program's execution.
+type Option func(*options)
+
+// defaultBusyTimeout is how long to wait for a template that another process
+// holds locked, unless changed by [WithBusyTimeout].
+const defaultBusyTimeout = time.Minute
+
+// defaultCloneAttempts and defaultCloneRetryDelay are how often, and how soon,
+// cloning a busy template is retried, unless changed by [WithCloneRetries].
+const (
+	defaultCloneAttempts   = 6
+	defaultCloneRetryDelay = 10 * time.Millisecond
+)
+
+type options struct {
+	busyTimeout      time.Duration
+	migrateTimeout   time.Duration
+	txMigrations     bool
+	foreignKeyCheck  bool
+	untrustedTempDir bool
+	templateCopy     bool
+	seeders          []Seeder
+	forceRebuild     bool
+	quietLogging     bool
+	poolSize         int
+	deterministic    bool
+	nameSeed         int64
+	cloneStrategy    CloneStrategy
+	cloneAttempts    int
+	cloneRetryDelay  time.Duration
+	progress         func(Event)
+
+	// instanceDir is the directory instances are created in, if not the
+	// directory of the template. It is set by [NewIn].
+	instanceDir string
+
+	// uncounted is set for lookups of the template that aren't made for a
+	// test, such as by [Prepare] or when rebuilding it, so that they aren't
+	// counted as cache hits, see [TemplateStats].
+	uncounted bool
+
+	// logf receives warnings, such as a template being rebuilt. It is set to
+	// the Logf of the test, if there is one that outlives the call.
+	logf func(format string, args ...any)
+}
+
+// warnf logs a warning, if there is a test to log it to.
+func (o *options) warnf(format string, args ...any) {
+	if o.logf != nil {
+		o.logf(format, args...)
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		busyTimeout:     defaultBusyTimeout,
+		cloneAttempts:   defaultCloneAttempts,
+		cloneRetryDelay: defaultCloneRetryDelay,
+	}
+	if force, err := strconv.ParseBool(os.Getenv("SQLITESTDB_FORCE_REBUILD")); err == nil {
+		o.forceRebuild = force
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+// WithBusyTimeout specifies how long to wait, with backoff, for a template that
+// another process holds locked, such as while checkpointing it, before giving
+// up. If not specified, a timeout of one minute is used.
+//
+// It doesn't bound waiting for another process that is migrating the same
+// template, which New waits for however long the migrations take, see
+// [WithMigrateTimeout].
+func WithBusyTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.busyTimeout = d
+	}
+}
+
+// WithMigrateTimeout specifies how long the migrator may take to migrate a
+// template. Once the timeout expires, the context passed to [Migrator.Migrate]
+// is canceled, the partially migrated template is removed, and the error names
+// the hash of the migrator that timed out. Migrators that ignore the context
+// can't be interrupted. If not specified, or zero, there is no timeout.
+//
+// The timeout is independent of the deadline of the test, as templates are
+// often created before any test-specific deadline applies.
+func WithMigrateTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.migrateTimeout = d
+	}
+}
+
+// WithTxMigrations runs the migrator inside a single "BEGIN IMMEDIATE"
+// transaction, so that a migration failing halfway through leaves the template
+// unmigrated, instead of partially migrated. The *sql.DB passed to
+// [Migrator.Migrate] is limited to the one connection holding the transaction,
+// so a migrator that holds on to a connection, such as with [sql.DB.Conn],
+// while running statements on the *sql.DB will block.
+//
+// SQLite doesn't nest transactions. If the migrator starts its own, this is
+// detected, and the migration is run again without the outer transaction.
+func WithTxMigrations() Option {
+	return func(o *options) {
+		o.txMigrations = true
+	}
+}
+
+// WithForeignKeyCheck runs "PRAGMA foreign_key_check" once the migrations of a
+// template have run, and fails creating the template if any row violates a
+// foreign key constraint. SQLite only enforces foreign keys on connections that
+// enable the "foreign_keys" pragma, so fixtures inserted by migrations may
+// otherwise be inconsistent. The check runs whether or not the migrator enabled
+// the pragma.
+func WithForeignKeyCheck() Option {
+	return func(o *options) {
+		o.foreignKeyCheck = true
+	}
+}
+
+// WithCloneRetries specifies how many times cloning the template is attempted
+// when SQLite reports the template or the instance database as busy or locked,
+// which may happen under heavy load when many tests, or test binaries, clone
+// the same template. The first retry waits for delay, doubling with each
+// attempt, and retries stop once the test's context is done. If not specified,
+// cloning is attempted 6 times, starting with a delay of 10ms.
+func WithCloneRetries(attempts int, delay time.Duration) Option {
+	return func(o *options) {
+		o.cloneAttempts = attempts
+		o.cloneRetryDelay = delay
+	}
+}
+
+// WithUntrustedTempDir creates the template and instance databases in a
+// private directory, instead of reusing templates from the shared temporary
+// directory.
+//
+// By default, a template found in [os.TempDir] with the expected name is
+// trusted and cloned for each test. On systems where the temporary directory
+// is world-writable, another user could plant a file there with a name
+// matching the hash of your migrations, and have your tests run against a
+// database of their choosing. With this option, the template is always
+// rebuilt, once per program execution, in a freshly created directory that is
+// only accessible by the current user. Templates are still cached in-process,
+// but never across runs. The directory is not removed when the program exits.
+func WithUntrustedTempDir() Option {
+	return func(o *options) {
+		o.untrustedTempDir = true
+	}
+}
+
+// WithTemplateCopy places a copy of the template next to the instance database
+// created by [NewIn], so that the state of the database before the test ran is
+// available for comparison. It has no effect on the other constructors.
+func WithTemplateCopy() Option {
+	return func(o *options) {
+		o.templateCopy = true
+	}
+}
+
+// CloneStrategy is how instance databases are cloned from their template, see
+// [WithCloneStrategy].
+type CloneStrategy string
+
+const (
+	// CloneVacuum clones the template with "VACUUM INTO", which rewrites
+	// every page of the template. The instance database is compacted, without
+	// the free pages of the template, but cloning takes time proportional to
+	// the size of the template. This is the default.
+	CloneVacuum CloneStrategy = "vacuum"
+
+	// CloneCopy copies the template file byte-for-byte, which is much faster
+	// for large templates, but preserves any free pages and fragmentation left
+	// behind by the migrations in every instance database.
+	CloneCopy CloneStrategy = "copy"
+
+	// CloneBackup clones the template with SQLite's Online Backup API, which
+	// copies its pages without rewriting them. It is supported by
+	// github.com/mattn/go-sqlite3 and modernc.org/sqlite, and falls back to
+	// [CloneVacuum] for other drivers.
+	CloneBackup CloneStrategy = "backup"
+
+	// CloneReflink clones the template file as a copy-on-write reflink, on
+	// file systems that support it, such as Btrfs, XFS, and APFS. Cloning is
+	// then nearly free, whatever the size of the template. It falls back to
+	// [CloneVacuum] on other file systems and platforms, or if the instance
+	// database is on another file system than the template.
+	CloneReflink CloneStrategy = "reflink"
+)
+
+// WithCloneStrategy specifies how instance databases are cloned from their
+// template. If not specified, [CloneVacuum] is used.
+func WithCloneStrategy(strategy CloneStrategy) Option {
+	return func(o *options) {
+		o.cloneStrategy = strategy
+	}
+}
+
+// WithSeed runs the seeder against each instance database after it has been
+// cloned from the template, before it is returned to the test. It may be given
+// several times, in which case the seeders are run in order. It has no effect
+// on [NewTx], [NewShared], or [NewMulti].
+func WithSeed(seeder Seeder) Option {
+	return func(o *options) {
+		o.seeders = append(o.seeders, seeder)
+	}
+}
+
+// seed runs the seeders against the instance database.
+func (o *options) seed(ctx context.Context, db *sql.DB) error {
+	for _, seeder := range o.seeders {
+		if err := seeder.Seed(ctx, db); err != nil {
+			return errtrace.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+// WithForceRebuild removes any existing template and runs the migrations again,
+// once per program execution. This is useful when iterating on a migrator
+// whose hash doesn't change with its behavior. Setting the environment
+// variable SQLITESTDB_FORCE_REBUILD=1 has the same effect for every template.
+//
+// Another process reusing the same template at the same time may fail.
+func WithForceRebuild() Option {
+	return func(o *options) {
+		o.forceRebuild = true
+	}
+}
+
+// WithQuietLogging stops [New] and the other constructors from logging the
+// SQLite URI of the instance database when the test succeeds. The URI is still
+// logged if the test fails.
+func WithQuietLogging() Option {
+	return func(o *options) {
+		o.quietLogging = true
+	}
+}
+
+// WithInstancePool keeps up to size instance databases of the template cloned
+// ahead of time by a background goroutine, so that [New] doesn't have to wait
+// for "VACUUM INTO". When the pool is empty, the template is cloned on demand as
+// usual. The pool of each template is sized by the first caller to use it. It
+// isn't used by [NewIn], with [WithDeterministicNames], or when
+// [Config.Database] is set.
+//
+// Call [ClosePools] from TestMain to remove the instance databases left in the
+// pools when the tests are done. See [TemplateStats] for how often the pool
+// was empty.
+func WithInstancePool(size int) Option {
+	return func(o *options) {
+		o.poolSize = size
+	}
+}
+
+// WithDeterministicNames derives the names of instance databases from the seed,
+// the template, and the name of the test, instead of a random id, so that
+// running the same test again creates its instance database at the same path.
+// A test creating several instances gets a different one for each, in order.
+//
+// An instance left behind by a previous run, for example because the test
+// failed, is replaced. As a consequence, programs running the same tests at
+// the same time, such as the same package tested with different build tags,
+// must use different seeds.
+func WithDeterministicNames(seed int64) Option {
+	return func(o *options) {
+		o.deterministic = true
+		o.nameSeed = seed
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package sqlitestdb
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// instancePool keeps instance databases of a single template cloned ahead of
+// time for [WithInstancePool]. A single goroutine fills the pool, so it never
+// blocks sending to ready.
+type instancePool struct {
+	hash  string
+	ready chan *Config
+	wake  chan struct{}
+
+	stop    sync.Once
+	stopped chan struct{}
+	done    chan struct{}
+}
+
+// pools holds the instance pools of each template, keyed the same way as the
+// templates map.
+var pools sync.Map // map[string]*instancePool
+
+// takePooled returns an instance database from the pool of the template, or nil
+// if pooling is disabled or the pool is empty, in which case the caller clones
+// the template itself. The instance is renamed to include the name of the test.
+func takePooled(name string, config Config, migrator Migrator, o *options) *Config {
+	if o.poolSize <= 0 || o.instanceDir != "" || o.deterministic || config.Database != "" {
+		return nil
+	}
+
+	mhash, err := TemplateHash(migrator)
+	if err != nil {
+		return nil
+	}
+
+	key := templateKey(config.templateDriver(), mhash, o)
+	counter := counterFor(key, mhash)
+	p := &instancePool{
+		hash:    mhash,
+		ready:   make(chan *Config, o.poolSize),
+		wake:    make(chan struct{}, 1),
+		stopped: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if existing, loaded := pools.LoadOrStore(key, p); loaded {
+		p = existing.(*instancePool)
+	} else {
+		go p.fill(config, migrator, *o)
+	}
+
+	var instance *Config
+	select {
+	case instance = <-p.ready:
+		counter.poolHits.Add(1)
+	default:
+		counter.poolMisses.Add(1)
+	}
+
+	// Wake the goroutine filling the pool, unless it already has been.
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+
+	if instance == nil {
+		return nil
+	}
+
+	id, err := uniqueID()
+	if err != nil {
+		return instance
+	}
+
+	renamed := *instance
+	renamed.Database = filepath.Join(filepath.Dir(instance.Database), instanceFileName(p.hash, name, id))
+	if err := os.Rename(instance.Database, renamed.Database); err != nil {
+		return instance
+	}
+	instances.Delete(instance.Database)
+	instances.Store(renamed.Database, instanceLive)
+
+	return &renamed
+}
+
+// fill clones instances until the pool is full, and then waits to be woken up
+// after an instance was taken. If cloning fails, it gives up, leaving the
+// callers to clone the template themselves and report the error.
+func (p *instancePool) fill(config Config, migrator Migrator, o options) {
+	defer close(p.done)
+
+	o.poolSize = 0
+	o.uncounted = true
+	// The pool outlives the test that started it.
+	o.logf = nil
+	for {
+		for len(p.ready) < cap(p.ready) {
+			instance, err := instantiate(context.Background(), "pool", config, migrator, &o)
+			if err != nil {
+				return
+			}
+
+			select {
+			case p.ready <- instance:
+			case <-p.stopped:
+				discardInstance(instance.Database)
+				return
+			}
+		}
+
+		select {
+		case <-p.wake:
+		case <-p.stopped:
+			return
+		}
+	}
+}
+
+// ClosePools stops refilling the pools of [WithInstancePool], and removes the
+// instance databases left in them. It is intended to be called from TestMain
+// after all tests have run, as pooled instances are otherwise left behind when
+// the program exits.
+//
+//	func TestMain(m *testing.M) {
+//		code := m.Run()
+//		sqlitestdb.ClosePools()
+//		os.Exit(code)
+//	}
+func ClosePools() {
+	pools.Range(func(_, value any) bool {
+		p := value.(*instancePool)
+		p.stop.Do(func() { close(p.stopped) })
+		<-p.done
+
+		for {
+			select {
+			case instance := <-p.ready:
+				discardInstance(instance.Database)
+			default:
+				return true
+			}
+		}
+	})
+}
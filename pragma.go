@@ -0,0 +1,110 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package sqlitestdb
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"braces.dev/errtrace"
+)
+
+// templatePragmas are the settings of a template that are stored in the
+// database file, and that not every clone strategy carries over to instances.
+type templatePragmas struct {
+	journalMode string
+	pageSize    int
+	autoVacuum  int
+}
+
+// readPragmas reads the settings of the template at config. The journal mode
+// is only reported by a connection that isn't immutable, so the template is
+// opened normally.
+func readPragmas(ctx context.Context, config Config) (templatePragmas, error) {
+	db, err := config.Connect()
+	if err != nil {
+		return templatePragmas{}, errtrace.Wrap(err)
+	}
+	defer db.Close()
+
+	var p templatePragmas
+	if err := db.QueryRowContext(ctx, "PRAGMA journal_mode").Scan(&p.journalMode); err != nil {
+		return templatePragmas{}, errtrace.Wrap(err)
+	}
+	if err := db.QueryRowContext(ctx, "PRAGMA page_size").Scan(&p.pageSize); err != nil {
+		return templatePragmas{}, errtrace.Wrap(err)
+	}
+	if err := db.QueryRowContext(ctx, "PRAGMA auto_vacuum").Scan(&p.autoVacuum); err != nil {
+		return templatePragmas{}, errtrace.Wrap(err)
+	}
+	p.journalMode = strings.ToLower(p.journalMode)
+
+	return p, errtrace.Wrap(db.Close())
+}
+
+// applyPragmas re-applies the settings of the template to a freshly cloned
+// instance. "VACUUM INTO" always creates the instance in rollback journal mode,
+// and WAL mode, the only journal mode stored in the database file, can only be
+// enabled by a new connection to the instance.
+//
+// The page size is set on the cloning connection by [vacuumInto], and the
+// auto-vacuum mode carried over, as by the other strategies, but both are
+// checked, and fixed with a VACUUM, should a driver's defaults have overridden
+// them.
+func applyPragmas(ctx context.Context, instance Config, p templatePragmas) error {
+	db, err := instance.Connect()
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+	defer db.Close()
+
+	// The pragmas only apply to the connection they are run on.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+	defer conn.Close()
+
+	// Both settings only change on a VACUUM, which isn't possible in WAL mode,
+	// so they are fixed first.
+	var pageSize, autoVacuum int
+	if err := conn.QueryRowContext(ctx, "PRAGMA page_size").Scan(&pageSize); err != nil {
+		return errtrace.Wrap(err)
+	}
+	if err := conn.QueryRowContext(ctx, "PRAGMA auto_vacuum").Scan(&autoVacuum); err != nil {
+		return errtrace.Wrap(err)
+	}
+	if pageSize != p.pageSize || autoVacuum != p.autoVacuum {
+		var mode string
+		if err := conn.QueryRowContext(ctx, "PRAGMA journal_mode=DELETE").Scan(&mode); err != nil {
+			return errtrace.Wrap(err)
+		}
+		if _, err := conn.ExecContext(ctx, "PRAGMA page_size="+strconv.Itoa(p.pageSize)); err != nil {
+			return errtrace.Wrap(err)
+		}
+		if _, err := conn.ExecContext(ctx, "PRAGMA auto_vacuum="+strconv.Itoa(p.autoVacuum)); err != nil {
+			return errtrace.Wrap(err)
+		}
+		if _, err := conn.ExecContext(ctx, "VACUUM"); err != nil {
+			return errtrace.Wrap(err)
+		}
+	}
+
+	// See [migrateTemplate] for why this uses QueryRowContext.
+	if p.journalMode == "wal" {
+		var mode string
+		if err := conn.QueryRowContext(ctx, "PRAGMA journal_mode=WAL").Scan(&mode); err != nil {
+			return errtrace.Wrap(err)
+		}
+		if !strings.EqualFold(mode, "wal") {
+			return errtrace.Errorf("could not enable WAL mode on instance %q: journal mode is %q", instance.Database, mode)
+		}
+	}
+
+	if err := conn.Close(); err != nil {
+		return errtrace.Wrap(err)
+	}
+	return errtrace.Wrap(db.Close())
+}
@@ -0,0 +1,141 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package sqlitestdb
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// EventKind is the kind of an [Event].
+type EventKind int
+
+const (
+	// BuildStarted is reported before the migrator creates a template.
+	BuildStarted EventKind = iota + 1
+	// MigrationApplied is reported by migrators, with [ReportProgress], after
+	// applying each of their migrations.
+	MigrationApplied
+	// BuildFinished is reported once the template is created, or failed to
+	// be.
+	BuildFinished
+)
+
+// Event is the progress of the creation of a template, reported to the
+// function set with [WithProgress].
+type Event struct {
+	Kind EventKind
+
+	// Hash is the hash of the template, see [TemplateHash].
+	Hash string
+
+	// Migration names the migration applied, such as its file, for
+	// [MigrationApplied] events.
+	Migration string
+
+	// Applied is the number of migrations applied so far, and Total the number
+	// of migrations to apply, if the migrator knows it, for [MigrationApplied]
+	// events.
+	Applied, Total int
+
+	// Elapsed is the time since the creation of the template started.
+	Elapsed time.Duration
+
+	// Err is the error the creation of the template failed with, for
+	// [BuildFinished] events.
+	Err error
+}
+
+// String describes the event on a single line.
+func (e Event) String() string {
+	switch e.Kind {
+	case BuildStarted:
+		return fmt.Sprintf("building template %s", e.Hash)
+	case MigrationApplied:
+		count := fmt.Sprintf("%d", e.Applied)
+		if e.Total > 0 {
+			count += fmt.Sprintf("/%d", e.Total)
+		}
+		if e.Migration == "" {
+			return fmt.Sprintf("applied migration %s after %s", count, e.Elapsed.Round(time.Millisecond))
+		}
+		return fmt.Sprintf("applied migration %s after %s: %s", count, e.Elapsed.Round(time.Millisecond), e.Migration)
+	case BuildFinished:
+		if e.Err != nil {
+			return fmt.Sprintf("could not build template %s after %s: %v", e.Hash, e.Elapsed.Round(time.Millisecond), e.Err)
+		}
+		return fmt.Sprintf("built template %s in %s", e.Hash, e.Elapsed.Round(time.Millisecond))
+	default:
+		return fmt.Sprintf("unknown event %d", e.Kind)
+	}
+}
+
+// WithProgress calls fn with the progress of the creation of the template,
+// such as to show that a slow migrator isn't stuck. fn is called from the
+// goroutine creating the template, and isn't called at all if the template
+// already exists. By default, the progress isn't reported. See [LogProgress].
+func WithProgress(fn func(Event)) Option {
+	return func(o *options) {
+		o.progress = fn
+	}
+}
+
+// LogProgress returns a function for [WithProgress] logging the events with
+// [testing.TB.Logf], when the tests are run with the -v flag of "go test".
+func LogProgress(t testing.TB) func(Event) {
+	return func(e Event) {
+		if testing.Verbose() {
+			t.Logf("sqlitestdb: %s", e)
+		}
+	}
+}
+
+// ReportProgress reports the event to the function set with [WithProgress], if
+// ctx is the context of the creation of a template. Migrators applying several
+// migrations call it with a [MigrationApplied] event after each of them. The
+// Hash and Elapsed fields of the event are set by sqlitestdb.
+func ReportProgress(ctx context.Context, event Event) {
+	if p, ok := ctx.Value(progressKey{}).(*progress); ok {
+		p.report(event)
+	}
+}
+
+// progressKey is the key of the [progress] of the creation of a template in
+// the context passed to its migrator.
+type progressKey struct{}
+
+// progress reports the events of the creation of a template.
+type progress struct {
+	fn    func(Event)
+	hash  string
+	start time.Time
+}
+
+// startProgress reports the start of the creation of the template with hash
+// mhash, and returns a context passing the progress on to the migrator. It
+// returns nil if the progress isn't reported.
+func startProgress(ctx context.Context, mhash string, o *options) (context.Context, *progress) {
+	if o.progress == nil {
+		return ctx, nil
+	}
+
+	p := &progress{fn: o.progress, hash: mhash, start: time.Now()}
+	p.report(Event{Kind: BuildStarted})
+	return context.WithValue(ctx, progressKey{}, p), p
+}
+
+// finish reports the end of the creation of the template, with its error.
+func (p *progress) finish(err error) {
+	if p != nil {
+		p.report(Event{Kind: BuildFinished, Err: err})
+	}
+}
+
+func (p *progress) report(event Event) {
+	event.Hash = p.hash
+	event.Elapsed = time.Since(p.start)
+	p.fn(event)
+}
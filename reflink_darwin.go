@@ -0,0 +1,27 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+//go:build darwin
+
+package sqlitestdb
+
+import (
+	"errors"
+
+	"braces.dev/errtrace"
+	"golang.org/x/sys/unix"
+)
+
+// reflink creates dst as a copy-on-write clone of src with clonefile, supported
+// by APFS. It reports false, without creating dst, if the file system doesn't
+// support it, or src and dst are on different file systems.
+func reflink(dst, src string) (bool, error) {
+	if err := unix.Clonefile(src, dst, unix.CLONE_NOFOLLOW); err != nil {
+		if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EXDEV) {
+			return false, nil
+		}
+		return false, errtrace.Wrap(err)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,43 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+//go:build linux
+
+package sqlitestdb
+
+import (
+	"errors"
+	"os"
+
+	"braces.dev/errtrace"
+	"golang.org/x/sys/unix"
+)
+
+// reflink creates dst as a copy-on-write clone of src with the FICLONE ioctl,
+// supported by file systems such as Btrfs and XFS. It reports false, without
+// creating dst, if the file system doesn't support it, or src and dst are on
+// different file systems.
+func reflink(dst, src string) (bool, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return false, errtrace.Wrap(err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return false, errtrace.Wrap(err)
+	}
+
+	if err := unix.IoctlFileClone(int(out.Fd()), int(in.Fd())); err != nil {
+		out.Close()
+		os.Remove(dst)
+		if errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.EXDEV) ||
+			errors.Is(err, unix.EINVAL) || errors.Is(err, unix.ENOTTY) || errors.Is(err, unix.ENOSYS) {
+			return false, nil
+		}
+		return false, errtrace.Wrap(err)
+	}
+
+	return true, errtrace.Wrap(out.Close())
+}
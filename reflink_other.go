@@ -0,0 +1,11 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+//go:build !(linux || darwin)
+
+package sqlitestdb
+
+// reflink is not supported on this platform.
+func reflink(dst, src string) (bool, error) {
+	return false, nil
+}
@@ -0,0 +1,145 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package sqlitestdb
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"braces.dev/errtrace"
+)
+
+// Resettable is an instance database that can be restored to the state of its
+// template with [Resettable.Reset]. This allows table-driven tests to reuse one
+// instance across subtests, instead of calling [New] for each one.
+//
+// Reset clones the template again with "VACUUM INTO" over the instance path,
+// so the restored state is exact: the schema, including triggers and views, the
+// rows, and PRAGMA user_version all match the template. The trade-off is that
+// the cost of Reset grows with the size of the template, rather than with the
+// changes made by the test, and that all connections to the instance have to
+// be closed, so the [*sql.DB] returned by [Resettable.DB] before a Reset can no
+// longer be used.
+type Resettable struct {
+	config   Config
+	migrator Migrator
+	opts     *options
+
+	instance *Config
+	db       *sql.DB
+}
+
+// NewResettable is like [New], but returns a [Resettable] instance database.
+//
+// If this method succeeds and your test succeeds, the database will be removed
+// as part of the test cleanup process.
+func NewResettable(t testing.TB, config Config, migrator Migrator, opts ...Option) *Resettable {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r := &Resettable{
+		config:   config,
+		migrator: migrator,
+		opts:     newOptions(opts),
+	}
+	r.opts.logf = t.Logf
+
+	instance, err := instantiate(ctx, t.Name(), config, migrator, r.opts)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	r.instance = instance
+
+	logInstance(t, r.opts, "sqlitestdb: "+instance.URI())
+
+	r.db, err = instance.Connect()
+	if err != nil {
+		instances.Store(instance.Database, instanceKept)
+		t.Fatalf("could not connect to instance database: %+v", err)
+	}
+	limitPool(r.db)
+	trackDB(r.db, instance)
+
+	t.Cleanup(func() {
+		if err := closeDB(r.db); err != nil {
+			t.Fatalf("could not close instance database %q: %+v", r.instance.Database, err)
+		}
+
+		removeInstance(t, r.instance.Database)
+	})
+
+	if err := r.opts.seed(ctx, r.db); err != nil {
+		t.Fatalf("seed failed for instance database %q: %+v", r.instance.Database, err)
+	}
+
+	return r
+}
+
+// DB returns the connection to the instance database. It must be called again
+// after each call to [Resettable.Reset].
+func (r *Resettable) DB() *sql.DB {
+	return r.db
+}
+
+// Config returns the configuration details of the instance database, which
+// remain the same across calls to [Resettable.Reset].
+func (r *Resettable) Config() *Config {
+	return r.instance
+}
+
+// Reset closes all connections to the instance database and restores it to the
+// state of the template, running any seeders given with [WithSeed] again. If
+// there is an error resetting the database, the test will be immediately failed
+// with [testing.TB.Fatalf].
+func (r *Resettable) Reset(t testing.TB) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := r.reset(ctx); err != nil {
+		t.Fatalf("could not reset instance database %q: %+v", r.instance.Database, err)
+	}
+}
+
+func (r *Resettable) reset(ctx context.Context) error {
+	if err := closeDB(r.db); err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	// A leftover sidecar file would be applied to the new clone, so they have
+	// to be removed along with the database itself.
+	if err := removeDatabase(r.instance.Database); err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	tpl, err := loadTemplate(ctx, r.config, r.migrator, r.opts)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	tplDB, err := tpl.connect()
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+	defer tplDB.Close()
+
+	if err := cloneInto(ctx, tplDB, *tpl, *r.instance, r.opts); err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	r.db, err = r.instance.Connect()
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+	limitPool(r.db)
+	trackDB(r.db, r.instance)
+
+	if err := r.opts.seed(ctx, r.db); err != nil {
+		return errtrace.Errorf("seed failed: %w", err)
+	}
+
+	return nil
+}
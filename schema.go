@@ -0,0 +1,158 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package sqlitestdb
+
+import (
+	"context"
+	"crypto/md5"
+	"database/sql"
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"strings"
+
+	"braces.dev/errtrace"
+	"github.com/terinjokes/sqlitestdb/migrators/common"
+)
+
+// SchemaFile returns a [Migrator] that creates the template by executing a
+// single SQL file, such as a schema.sql maintained declaratively, or the output
+// of the sqlite3 shell's ".schema" or ".dump" commands. The file is read from
+// fsys, or from the real filesystem if fsys is nil.
+//
+// The hash of the migrator is the hash of the file's contents. The statements
+// of the file are executed one by one, as libsql only runs the first statement
+// of [sql.DB.ExecContext]. The BEGIN and COMMIT statements ".dump" wraps its
+// output in are skipped, and the PRAGMAs it starts with are executed first.
+//
+// The rows ".dump" inserts into sqlite_sequence, recording the last rowid of
+// AUTOINCREMENT tables, replace those created while inserting the rows of the
+// tables, so that each table keeps a single row in sqlite_sequence.
+func SchemaFile(fsys fs.FS, path string) Migrator {
+	return &schemaMigrator{fsys: fsys, path: path}
+}
+
+type schemaMigrator struct {
+	fsys fs.FS
+	path string
+}
+
+func (m *schemaMigrator) Hash() (string, error) {
+	contents, err := m.read()
+	if err != nil {
+		return "", errtrace.Wrap(err)
+	}
+
+	sum := md5.Sum(contents)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Migrate executes the statements of the file, like [ExecSplit]. Errors name
+// the file, and the position, line and text of the statement that failed.
+//
+// The statements are run on a single connection, so that PRAGMAs apply to all
+// of them. Those after the leading PRAGMAs are run inside a savepoint, instead
+// of a transaction, so that they can also be run with [WithTxMigrations].
+func (m *schemaMigrator) Migrate(ctx context.Context, db *sql.DB, _ Config) (err error) {
+	contents, err := m.read()
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+	defer conn.Close()
+
+	var savepoint, sequenceReset bool
+	defer func() {
+		if err != nil && savepoint {
+			rbCtx := withoutInterrupt(ctx)
+			_, _ = conn.ExecContext(rbCtx, "ROLLBACK TO sqlitestdb_schema")
+			_, _ = conn.ExecContext(rbCtx, "RELEASE sqlitestdb_schema")
+		}
+	}()
+
+	for i, stmt := range common.SplitStatements(string(contents)) {
+		if isTransactionStatement(stmt.SQL) {
+			continue
+		}
+
+		if !savepoint && !isPragma(stmt.SQL) {
+			if _, err := conn.ExecContext(ctx, "SAVEPOINT sqlitestdb_schema"); err != nil {
+				return errtrace.Wrap(err)
+			}
+			savepoint = true
+		}
+
+		// Recent versions of the sqlite3 shell no longer delete the rows of
+		// sqlite_sequence before inserting their own.
+		if !sequenceReset && isSequenceInsert(stmt.SQL) {
+			if _, err := conn.ExecContext(ctx, "DELETE FROM sqlite_sequence"); err != nil {
+				return errtrace.Errorf("%s, line %d: %w", m.path, stmt.Line, err)
+			}
+			sequenceReset = true
+		}
+
+		if err := execStatement(ctx, conn, i, stmt); err != nil {
+			return errtrace.Errorf("%s: %w", m.path, err)
+		}
+	}
+
+	if !savepoint {
+		return nil
+	}
+	_, err = conn.ExecContext(ctx, "RELEASE sqlitestdb_schema")
+	return errtrace.Wrap(err)
+}
+
+func (m *schemaMigrator) read() ([]byte, error) {
+	if m.fsys == nil {
+		return errtrace.Wrap2(os.ReadFile(m.path))
+	}
+	return errtrace.Wrap2(fs.ReadFile(m.fsys, m.path))
+}
+
+// isPragma reports whether a statement is a PRAGMA, some of which, such as
+// "PRAGMA journal_mode=WAL", can't be run inside a transaction.
+func isPragma(stmt string) bool {
+	words := strings.Fields(stmt)
+	return len(words) > 0 && strings.EqualFold(words[0], "PRAGMA")
+}
+
+// isSequenceInsert reports whether a statement inserts rows into the
+// sqlite_sequence table, such as those written by the ".dump" command of the
+// sqlite3 shell.
+func isSequenceInsert(stmt string) bool {
+	words := strings.Fields(strings.ToUpper(stmt))
+	if len(words) < 3 || words[0] != "INSERT" || words[1] != "INTO" {
+		return false
+	}
+
+	table, _, _ := strings.Cut(words[2], "(")
+	return strings.Trim(table, "\"`[]") == "SQLITE_SEQUENCE"
+}
+
+// isTransactionStatement reports whether a statement begins or ends a
+// transaction, such as the "BEGIN TRANSACTION" and "COMMIT" statements written
+// by the ".dump" command of the sqlite3 shell.
+func isTransactionStatement(stmt string) bool {
+	words := strings.Fields(strings.ToUpper(stmt))
+	switch {
+	case len(words) == 0:
+		return false
+	case words[0] == "BEGIN":
+		words = words[1:]
+		if len(words) > 0 && (words[0] == "DEFERRED" || words[0] == "IMMEDIATE" || words[0] == "EXCLUSIVE") {
+			words = words[1:]
+		}
+	case words[0] == "COMMIT" || words[0] == "END":
+		words = words[1:]
+	default:
+		return false
+	}
+
+	return len(words) == 0 || len(words) == 1 && words[0] == "TRANSACTION"
+}
@@ -0,0 +1,26 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package sqlitestdb
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Seeder inserts per-test data into an instance database, see [WithSeed].
+//
+// Unlike migrations, seeds are not part of the template, so changing them
+// doesn't invalidate the cached template. As they run for every instance, they
+// should be fast.
+type Seeder interface {
+	Seed(context.Context, *sql.DB) error
+}
+
+// SeedFunc is an adapter to allow the use of ordinary functions as a [Seeder].
+type SeedFunc func(context.Context, *sql.DB) error
+
+// Seed calls f(ctx, db).
+func (f SeedFunc) Seed(ctx context.Context, db *sql.DB) error {
+	return f(ctx, db)
+}
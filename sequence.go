@@ -0,0 +1,67 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package sqlitestdb
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+
+	"braces.dev/errtrace"
+	"github.com/terinjokes/sqlitestdb/migrators/hash"
+)
+
+// Sequence returns a [Migrator] that runs migrators one after the other against
+// the same template, such as a schema migrator, followed by a fixtures loader,
+// and an ANALYZE step.
+//
+// The hash of the sequence combines the template hashes of the migrators in
+// order, see [TemplateHash], so changing, adding, removing, or reordering any of
+// them creates a new template.
+// Migrating stops at the first migrator that fails, and the error reports its
+// position in the sequence.
+func Sequence(migrators ...Migrator) Migrator {
+	return sequenceMigrator(migrators)
+}
+
+type sequenceMigrator []Migrator
+
+func (s sequenceMigrator) Hash() (string, error) {
+	h := hash.NewRecursiveHash()
+	for i, m := range s {
+		mhash, err := TemplateHash(m)
+		if err != nil {
+			return "", errtrace.Errorf("migrator %d (%T) of sequence: %w", i, m, err)
+		}
+		h.AddField(strconv.Itoa(i), mhash)
+	}
+
+	return h.String(), nil
+}
+
+func (s sequenceMigrator) Migrate(ctx context.Context, db *sql.DB, config Config) error {
+	for i, m := range s {
+		if err := m.Migrate(ctx, db, config); err != nil {
+			return errtrace.Errorf("migrator %d (%T) of sequence: %w", i, m, err)
+		}
+	}
+
+	return nil
+}
+
+// Verify runs the Verify method of each of the migrators that is a [Verifier],
+// in order.
+func (s sequenceMigrator) Verify(ctx context.Context, db *sql.DB) error {
+	for i, m := range s {
+		verifier, ok := m.(Verifier)
+		if !ok {
+			continue
+		}
+		if err := verifier.Verify(ctx, db); err != nil {
+			return errtrace.Errorf("migrator %d (%T) of sequence: %w", i, m, err)
+		}
+	}
+
+	return nil
+}
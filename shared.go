@@ -0,0 +1,108 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package sqlitestdb
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"testing"
+
+	"braces.dev/errtrace"
+	"github.com/terinjokes/sqlitestdb/once"
+)
+
+// NewShared is like [New], but for tests that only read the data created by the
+// migrations. Instead of cloning a database for every test, a single instance
+// database is cloned per template, and shared by all tests using it at the same
+// time. Each test gets its own connection, opened with "mode=ro&immutable=1", so
+// that any attempt to write fails.
+//
+// The shared instance is removed once the last test using it has finished,
+// whether or not the tests succeeded. Seeders given with [WithSeed] are not run.
+func NewShared(t testing.TB, config Config, migrator Migrator, opts ...Option) *sql.DB {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	o := newOptions(opts)
+	mhash, err := TemplateHash(migrator)
+	if err != nil {
+		t.Fatalf("could not hash migrator: %+v", err)
+	}
+
+	shared, _ := sharedInstances.Set(templateKey(config.templateDriver(), mhash, o), func() (*sharedInstance, error) {
+		return &sharedInstance{}, nil
+	})
+
+	instance, err := shared.acquire(ctx, config, migrator, o)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	uri := instance.URI() + "?mode=ro&immutable=1"
+	db, err := instance.open(uri)
+	if err != nil {
+		shared.release()
+		t.Fatalf("could not connect to instance database: %+v", err)
+	}
+	limitPool(db)
+	trackDB(db, instance)
+
+	logInstance(t, o, "sqlitestdb: "+uri+" (shared, read-only)")
+
+	t.Cleanup(func() {
+		defer shared.release()
+
+		if err := closeDB(db); err != nil {
+			t.Fatalf("could not close instance database %q: %+v", instance.Database, err)
+		}
+	})
+
+	return db
+}
+
+// sharedInstance is the instance database shared by all [NewShared] callers of
+// a single template, counting the tests currently using it.
+type sharedInstance struct {
+	mu     sync.Mutex
+	config *Config
+	refs   int
+}
+
+var sharedInstances = once.NewMap[string, sharedInstance]()
+
+// acquire clones the shared instance from the template if no test is using it,
+// and records another user.
+func (si *sharedInstance) acquire(ctx context.Context, config Config, migrator Migrator, o *options) (*Config, error) {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+
+	if si.config == nil {
+		instance, err := instantiate(ctx, "shared", config, migrator, o)
+		if err != nil {
+			return nil, errtrace.Wrap(err)
+		}
+		instances.Store(instance.Database, instanceShared)
+		si.config = instance
+	}
+
+	si.refs++
+	return si.config, nil
+}
+
+// release records that a test finished using the shared instance, and removes
+// it once no test is using it anymore.
+func (si *sharedInstance) release() {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+
+	si.refs--
+	if si.refs > 0 {
+		return
+	}
+
+	discardInstance(si.config.Database)
+	si.config = nil
+}
@@ -1,14 +1,33 @@
 package sqlitestdb
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/binary"
 	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
-	"github.com/peterldowns/pgtestdb/migrators/common"
+	"github.com/mattn/go-sqlite3"
+	"github.com/terinjokes/sqlitestdb/migrators/hash"
 	"gotest.tools/v3/assert"
+	_ "modernc.org/sqlite"
 )
 
 func TestRemovingTemplateDatabaseOnError(t *testing.T) {
@@ -22,12 +41,12 @@ func TestRemovingTemplateDatabaseOnError(t *testing.T) {
 		},
 	}
 
-	errh, err := errm.Hash()
+	errh, err := TemplateHash(errm)
 	assert.NilError(t, err)
 
-	dbconf := Config{Driver: "sqlite3", Database: "/tmp/sqlitestdb_tpl_" + errh + ".sqlite"}
+	dbconf := Config{Driver: "sqlite3", Database: filepath.Join(os.TempDir(), templateFileName(errh, "sqlite3", testEngine(t, "sqlite3")))}
 
-	errdb, err := getOrCreateTemplate(ctx, dbconf, errm)
+	errdb, err := getOrCreateTemplate(ctx, dbconf, errm, newOptions(nil))
 	assert.Assert(t, err != nil)
 	assert.Assert(t, errdb == nil)
 
@@ -35,16 +54,886 @@ func TestRemovingTemplateDatabaseOnError(t *testing.T) {
 	assert.Assert(t, errors.Is(err, os.ErrNotExist))
 }
 
+func TestUntrustedTempDirIgnoresPlantedTemplate(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := &sqlMigrator{
+		migrations: []string{
+			"CREATE TABLE planted (id INTEGER PRIMARY KEY)",
+		},
+	}
+	mhash, err := TemplateHash(m)
+	assert.NilError(t, err)
+
+	// Plant a file owned by another user where a shared template with the
+	// same hash would be.
+	planted := filepath.Join(os.TempDir(), templateFileName(mhash, "sqlite3", testEngine(t, "sqlite3")))
+	assert.NilError(t, os.WriteFile(planted, []byte("not a database"), 0o666))
+	defer os.Remove(planted)
+	if err := os.Chown(planted, 65534, 65534); err != nil {
+		t.Logf("could not change ownership of planted template: %v", err)
+	}
+
+	tpl, err := getOrCreateTemplate(ctx, Config{Driver: "sqlite3"}, m, newOptions([]Option{WithUntrustedTempDir()}))
+	assert.NilError(t, err)
+	assert.Assert(t, tpl.config.Database != planted)
+
+	info, err := os.Stat(tpl.dir)
+	assert.NilError(t, err)
+	assert.Equal(t, os.FileMode(0o700), info.Mode().Perm())
+
+	db := New(t, Config{Driver: "sqlite3"}, m, WithUntrustedTempDir())
+	var count int
+	assert.NilError(t, db.QueryRowContext(ctx, "SELECT COUNT(*) FROM planted").Scan(&count))
+	assert.Equal(t, 0, count)
+}
+
+func TestForceRebuild(t *testing.T) {
+	t.Parallel()
+
+	for _, force := range []bool{false, true} {
+		t.Run(fmt.Sprintf("force=%t", force), func(t *testing.T) {
+			t.Parallel()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if !force && newOptions(nil).forceRebuild {
+				t.Skip("SQLITESTDB_FORCE_REBUILD is set")
+			}
+
+			// A migrator whose hash doesn't change with its behavior, and a
+			// template left behind by a previous run, with another schema.
+			m := &staticHashMigrator{
+				sqlMigrator: sqlMigrator{migrations: []string{"CREATE TABLE fresh (id INTEGER PRIMARY KEY)"}},
+				hash:        fmt.Sprintf("static_force_%t", force),
+			}
+			mhash, err := TemplateHash(m)
+			assert.NilError(t, err)
+			path := filepath.Join(os.TempDir(), templateFileName(mhash, "sqlite3", testEngine(t, "sqlite3")))
+			assert.NilError(t, removeDatabase(path))
+			stale, err := sql.Open("sqlite3", "file:"+path)
+			assert.NilError(t, err)
+			_, err = stale.ExecContext(ctx, "CREATE TABLE stale (id INTEGER PRIMARY KEY)")
+			assert.NilError(t, err)
+			assert.NilError(t, markTemplate(ctx, stale, "sqlite3", mhash))
+			assert.NilError(t, stale.Close())
+
+			var opts []Option
+			if force {
+				opts = append(opts, WithForceRebuild())
+			}
+			db := New(t, Config{Driver: "sqlite3"}, m, opts...)
+
+			var table string
+			err = db.QueryRowContext(ctx, "SELECT name FROM sqlite_master WHERE type = 'table'").Scan(&table)
+			assert.NilError(t, err)
+			if force {
+				assert.Equal(t, "fresh", table)
+			} else {
+				assert.Equal(t, "stale", table)
+			}
+		})
+	}
+}
+
+// testEngine returns the SQLite engine used by the driver, which is part of the
+// names of its templates.
+func testEngine(t *testing.T, driver string) *sqliteEngine {
+	t.Helper()
+	engine, err := engineFor(context.Background(), Config{Driver: driver})
+	assert.NilError(t, err)
+	return engine
+}
+
+// staticHashMigrator is a sqlMigrator with a fixed hash.
+type staticHashMigrator struct {
+	sqlMigrator
+	hash string
+}
+
+func (m *staticHashMigrator) Hash() (string, error) {
+	return m.hash, nil
+}
+
+func TestInvalidTemplateIsRebuilt(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]func(ctx context.Context, t *testing.T, path string){
+		"empty": func(_ context.Context, t *testing.T, path string) {
+			assert.NilError(t, os.WriteFile(path, nil, 0o666))
+		},
+		"truncated": func(ctx context.Context, t *testing.T, path string) {
+			db, err := sql.Open("sqlite3", "file:"+path)
+			assert.NilError(t, err)
+			_, err = db.ExecContext(ctx, `
+				CREATE TABLE stale (id INTEGER PRIMARY KEY, name TEXT);
+				WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 1000)
+				INSERT INTO stale (name) SELECT printf('cat %d', i) FROM n;
+			`)
+			assert.NilError(t, err)
+			assert.NilError(t, markTemplate(ctx, db, "sqlite3", "unused"))
+			assert.NilError(t, db.Close())
+
+			info, err := os.Stat(path)
+			assert.NilError(t, err)
+			assert.NilError(t, os.Truncate(path, info.Size()/2))
+		},
+		"unmarked": func(ctx context.Context, t *testing.T, path string) {
+			db, err := sql.Open("sqlite3", "file:"+path)
+			assert.NilError(t, err)
+			_, err = db.ExecContext(ctx, "CREATE TABLE stale (id INTEGER PRIMARY KEY)")
+			assert.NilError(t, err)
+			assert.NilError(t, db.Close())
+		},
+		"wrong hash": func(ctx context.Context, t *testing.T, path string) {
+			db, err := sql.Open("sqlite3", "file:"+path)
+			assert.NilError(t, err)
+			_, err = db.ExecContext(ctx, "CREATE TABLE stale (id INTEGER PRIMARY KEY)")
+			assert.NilError(t, err)
+			assert.NilError(t, markTemplate(ctx, db, "sqlite3", "another migrator"))
+			assert.NilError(t, db.Close())
+		},
+	}
+
+	for name, plant := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			id, err := uniqueID()
+			assert.NilError(t, err)
+			m := &staticHashMigrator{
+				sqlMigrator: sqlMigrator{migrations: []string{"CREATE TABLE fresh (id INTEGER PRIMARY KEY)"}},
+				hash:        "invalid_" + strings.ReplaceAll(name, " ", "_") + "_" + id,
+			}
+			mhash, err := TemplateHash(m)
+			assert.NilError(t, err)
+			path := filepath.Join(os.TempDir(), templateFileName(mhash, "sqlite3", testEngine(t, "sqlite3")))
+			assert.NilError(t, removeDatabase(path))
+			plant(ctx, t, path)
+
+			db := New(t, Config{Driver: "sqlite3"}, m)
+
+			var table string
+			err = db.QueryRowContext(ctx, "SELECT name FROM sqlite_master WHERE type = 'table'").Scan(&table)
+			assert.NilError(t, err)
+			assert.Equal(t, "fresh", table)
+			for _, stats := range Stats() {
+				if stats.Hash == m.hash {
+					assert.Equal(t, TemplateCreated, stats.Source)
+				}
+			}
+		})
+	}
+}
+
+func TestCorruptCachedTemplateIsRebuilt(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	id, err := uniqueID()
+	assert.NilError(t, err)
+	m := &staticHashMigrator{
+		sqlMigrator: sqlMigrator{migrations: []string{"CREATE TABLE fresh (id INTEGER PRIMARY KEY)"}},
+		hash:        "corrupt_" + id,
+	}
+	New(t, Config{Driver: "sqlite3"}, m)
+
+	// The template was validated and cached by the first test, before it was
+	// corrupted.
+	mhash, err := TemplateHash(m)
+	assert.NilError(t, err)
+	path := filepath.Join(os.TempDir(), templateFileName(mhash, "sqlite3", testEngine(t, "sqlite3")))
+	assert.NilError(t, os.WriteFile(path, bytes.Repeat([]byte("not a database "), 512), 0o666))
+
+	var warnings []string
+	o := newOptions(nil)
+	o.logf = func(format string, args ...any) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}
+	instance, err := instantiate(ctx, t.Name(), Config{Driver: "sqlite3"}, m, o)
+	assert.NilError(t, err)
+	t.Cleanup(func() { discardInstance(instance.Database) })
+
+	assert.Equal(t, 1, len(warnings))
+	assert.Assert(t, strings.Contains(warnings[0], "rebuilding invalid template"), warnings[0])
+
+	db, err := instance.Connect()
+	assert.NilError(t, err)
+	defer db.Close()
+
+	var table string
+	err = db.QueryRowContext(ctx, "SELECT name FROM sqlite_master WHERE type = 'table'").Scan(&table)
+	assert.NilError(t, err)
+	assert.Equal(t, "fresh", table)
+}
+
+func TestTemplateCreatedAtomically(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := &flakyMigrator{sqlMigrator: sqlMigrator{migrations: []string{
+		"CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT)",
+		"INSERT INTO cats (name) VALUES ('daisy'), ('sunny')",
+	}}}
+	mhash, err := TemplateHash(m)
+	assert.NilError(t, err)
+
+	config := Config{Driver: "sqlite3", Database: filepath.Join(t.TempDir(), templateFileName(mhash, "sqlite3", testEngine(t, "sqlite3")))}
+	tmp := config.Database + tempTemplateSuffix + strconv.Itoa(os.Getpid())
+
+	// A temporary file left behind by a crashed process is ignored.
+	crashed := config.Database + tempTemplateSuffix + "0"
+	assert.NilError(t, os.WriteFile(crashed, nil, 0o666))
+
+	_, err = awaitTemplate(ctx, config, mhash, m, &templateCounter{}, newOptions(nil))
+	assert.ErrorContains(t, err, "migration failed")
+	for _, path := range []string{config.Database, tmp, tmp + "-journal", tmp + "-wal"} {
+		_, err = os.Stat(path)
+		assert.Assert(t, errors.Is(err, fs.ErrNotExist), "%q was not removed", path)
+	}
+
+	created, err := awaitTemplate(ctx, config, mhash, m, &templateCounter{}, newOptions(nil))
+	assert.NilError(t, err)
+	assert.Assert(t, created)
+	assert.NilError(t, checkTemplate(ctx, config, mhash))
+	_, err = os.Stat(tmp)
+	assert.Assert(t, errors.Is(err, fs.ErrNotExist))
+}
+
+func TestLockTemplate(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "template.sqlite")
+	unlock, err := lockTemplate(ctx, path)
+	assert.NilError(t, err)
+
+	if runtime.GOOS != "plan9" && runtime.GOOS != "solaris" && runtime.GOOS != "illumos" {
+		timeoutCtx, timeoutCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		_, err = lockTemplate(timeoutCtx, path)
+		timeoutCancel()
+		assert.Assert(t, errors.Is(err, context.DeadlineExceeded), "got %v", err)
+
+		// A held lock file is not removed.
+		assert.NilError(t, removeLockFile(path))
+		_, err = os.Stat(path + ".lock")
+		assert.NilError(t, err)
+
+		// Waiting for the lock takes it once it is released.
+		locked := make(chan error, 1)
+		go func() {
+			unlock, err := lockTemplate(ctx, path)
+			if err == nil {
+				unlock()
+			}
+			locked <- err
+		}()
+		time.Sleep(50 * time.Millisecond)
+		unlock()
+		assert.NilError(t, <-locked)
+	} else {
+		unlock()
+	}
+
+	unlock, err = lockTemplate(ctx, path)
+	assert.NilError(t, err)
+	unlock()
+
+	assert.NilError(t, removeLockFile(path))
+	_, err = os.Stat(path + ".lock")
+	assert.Assert(t, errors.Is(err, os.ErrNotExist), "got %v", err)
+	assert.NilError(t, removeLockFile(path))
+}
+
+// TestAwaitLockedTemplate checks that a template being created by another
+// process is waited for beyond the busy timeout.
+func TestAwaitLockedTemplate(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if runtime.GOOS == "plan9" || runtime.GOOS == "solaris" || runtime.GOOS == "illumos" {
+		t.Skip("file locking is not supported")
+	}
+
+	id, err := uniqueID()
+	assert.NilError(t, err)
+	m := &sqlMigrator{migrations: []string{"CREATE TABLE locked_" + id + " (id INTEGER PRIMARY KEY)"}}
+	mhash, err := TemplateHash(m)
+	assert.NilError(t, err)
+
+	config := Config{Driver: "sqlite3", Database: filepath.Join(t.TempDir(), "template.sqlite")}
+	unlock, err := lockTemplate(ctx, config.Database)
+	assert.NilError(t, err)
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		unlock()
+	}()
+
+	created, err := awaitTemplate(ctx, config, mhash, m, &templateCounter{}, newOptions([]Option{WithBusyTimeout(10 * time.Millisecond)}))
+	assert.NilError(t, err)
+	assert.Assert(t, created)
+}
+
+func TestWALTemplate(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	id, err := uniqueID()
+	assert.NilError(t, err)
+	m := &sqlMigrator{migrations: []string{
+		"-- " + id,
+		"PRAGMA journal_mode=WAL",
+		"CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT)",
+		"INSERT INTO cats (name) VALUES ('daisy'), ('sunny')",
+	}}
+	mhash, err := TemplateHash(m)
+	assert.NilError(t, err)
+
+	db := New(t, Config{Driver: "sqlite3"}, m)
+	var count int
+	assert.NilError(t, db.QueryRowContext(ctx, "SELECT COUNT(*) FROM cats").Scan(&count))
+	assert.Equal(t, 2, count)
+
+	// The template is complete without its write-ahead log.
+	path := filepath.Join(os.TempDir(), templateFileName(mhash, "sqlite3", testEngine(t, "sqlite3")))
+	for _, suffix := range []string{"-wal", "-shm"} {
+		_, err := os.Stat(path + suffix)
+		assert.Assert(t, errors.Is(err, fs.ErrNotExist), "%q was left behind", path+suffix)
+	}
+}
+
+func TestLeftoverWALIsCheckpointed(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	id, err := uniqueID()
+	assert.NilError(t, err)
+	m := &staticHashMigrator{
+		sqlMigrator: sqlMigrator{migrations: []string{"CREATE TABLE fresh (id INTEGER PRIMARY KEY)"}},
+		hash:        "leftover_wal_" + id,
+	}
+
+	// Keep a connection to the source database open while copying it, so that
+	// the rows and the marker are only in its write-ahead log.
+	src := filepath.Join(t.TempDir(), "src.sqlite")
+	srcDB, err := sql.Open("sqlite3", "file:"+src)
+	assert.NilError(t, err)
+	defer srcDB.Close()
+	srcDB.SetMaxOpenConns(1)
+	_, err = srcDB.ExecContext(ctx, `
+		PRAGMA journal_mode=WAL;
+		CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT);
+		INSERT INTO cats (name) VALUES ('daisy'), ('sunny');
+	`)
+	assert.NilError(t, err)
+	mhash, err := TemplateHash(m)
+	assert.NilError(t, err)
+	assert.NilError(t, markTemplate(ctx, srcDB, "sqlite3", mhash))
+
+	path := filepath.Join(os.TempDir(), templateFileName(mhash, "sqlite3", testEngine(t, "sqlite3")))
+	assert.NilError(t, copyFile(path, src))
+	assert.NilError(t, copyFile(path+"-wal", src+"-wal"))
+
+	db := New(t, Config{Driver: "sqlite3"}, m)
+	var count int
+	assert.NilError(t, db.QueryRowContext(ctx, "SELECT COUNT(*) FROM cats").Scan(&count))
+	assert.Equal(t, 2, count)
+}
+
+func TestBackupInto(t *testing.T) {
+	t.Parallel()
+
+	for _, driver := range []string{"sqlite3", "sqlite"} {
+		t.Run(driver, func(t *testing.T) {
+			t.Parallel()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			dir := t.TempDir()
+			src := Config{Driver: driver, Database: filepath.Join(dir, "src.sqlite")}
+			srcDB, err := src.Connect()
+			assert.NilError(t, err)
+			defer srcDB.Close()
+			_, err = srcDB.ExecContext(ctx, "CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT)")
+			assert.NilError(t, err)
+			_, err = srcDB.ExecContext(ctx, "INSERT INTO cats (name) VALUES ('daisy'), ('sunny')")
+			assert.NilError(t, err)
+
+			dst := Config{Driver: driver, Database: filepath.Join(dir, "dst.sqlite")}
+			ok, err := backupInto(ctx, srcDB, dst)
+			assert.NilError(t, err)
+			assert.Assert(t, ok, "driver %q should support the backup API", driver)
+
+			dstDB, err := dst.Connect()
+			assert.NilError(t, err)
+			defer dstDB.Close()
+			var count int
+			assert.NilError(t, dstDB.QueryRowContext(ctx, "SELECT COUNT(*) FROM cats").Scan(&count))
+			assert.Equal(t, 2, count)
+		})
+	}
+}
+
+func TestReflink(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.sqlite")
+	assert.NilError(t, os.WriteFile(src, []byte("SQLite format 3\x00 and some pages"), 0o600))
+
+	dst := filepath.Join(dir, "dst.sqlite")
+	ok, err := reflink(dst, src)
+	assert.NilError(t, err)
+	if !ok {
+		_, err := os.Stat(dst)
+		assert.Assert(t, errors.Is(err, fs.ErrNotExist), "%q was left behind", dst)
+		t.Skip("reflinks aren't supported by the file system of", dir)
+	}
+
+	data, err := os.ReadFile(dst)
+	assert.NilError(t, err)
+	assert.Equal(t, "SQLite format 3\x00 and some pages", string(data))
+}
+
+func TestCloneRetriesBusy(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Use the modernc driver, which doesn't set a default busy_timeout that
+	// would wait for the lock instead of reporting it.
+	dir := t.TempDir()
+	src := Config{Driver: "sqlite", Database: filepath.Join(dir, "src.sqlite")}
+	srcDB, err := src.Connect()
+	assert.NilError(t, err)
+	defer srcDB.Close()
+	_, err = srcDB.ExecContext(ctx, "CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT)")
+	assert.NilError(t, err)
+
+	// An exclusive transaction keeps other connections from reading.
+	lockDB, err := src.Connect()
+	assert.NilError(t, err)
+	defer lockDB.Close()
+	lock, err := lockDB.Conn(ctx)
+	assert.NilError(t, err)
+	_, err = lock.ExecContext(ctx, "BEGIN EXCLUSIVE")
+	assert.NilError(t, err)
+
+	err = vacuumInto(ctx, srcDB, templateState{}, Config{Driver: "sqlite", Database: filepath.Join(dir, "once.sqlite")}, newOptions([]Option{WithCloneRetries(1, time.Millisecond)}))
+	assert.ErrorContains(t, err, "gave up after 1 attempts")
+	assert.Assert(t, isBusy(err))
+
+	// Release the lock while many clones are being retried.
+	time.AfterFunc(50*time.Millisecond, func() {
+		lock.ExecContext(ctx, "ROLLBACK")
+		lock.Close()
+	})
+
+	var wg sync.WaitGroup
+	errs := make([]error, 20)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			instance := Config{Driver: "sqlite", Database: filepath.Join(dir, fmt.Sprintf("instance_%d.sqlite", i))}
+			errs[i] = vacuumInto(ctx, srcDB, templateState{}, instance, newOptions(nil))
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		assert.NilError(t, err)
+	}
+}
+
+// TestInstanceNameCollision replaces the random source of the package, and so
+// can't run in parallel with other tests.
+func TestInstanceNameCollision(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := &sqlMigrator{migrations: []string{"CREATE TABLE cats (id INTEGER PRIMARY KEY)"}}
+	tpl, err := loadTemplate(ctx, Config{Driver: "sqlite3"}, m, newOptions(nil))
+	assert.NilError(t, err)
+	tplDB, err := tpl.connect()
+	assert.NilError(t, err)
+	defer tplDB.Close()
+
+	// A file left behind by an earlier process with the same process ID uses
+	// the first name generated.
+	dir := t.TempDir()
+	nameAt := func(n uint64, random string) string {
+		return filepath.Join(dir, instanceFileName(tpl.hash, t.Name(), fmt.Sprintf("%d_%d_%s", os.Getpid(), n, random)))
+	}
+	next := idCounter.Load() + 1
+	taken := nameAt(next, "0000000000000000")
+	assert.NilError(t, os.WriteFile(taken, []byte("left behind"), 0o600))
+
+	reader := rand.Reader
+	defer func() { rand.Reader = reader }()
+
+	rand.Reader = io.MultiReader(bytes.NewReader(make([]byte, 8)), bytes.NewReader([]byte{1, 2, 3, 4, 5, 6, 7, 8}))
+	instance, err := createInstance(ctx, tplDB, *tpl, dir, t.Name(), newOptions(nil))
+	assert.NilError(t, err)
+	assert.Equal(t, nameAt(next+1, "0102030405060708"), instance.Database)
+	discardInstance(instance.Database)
+
+	data, err := os.ReadFile(taken)
+	assert.NilError(t, err)
+	assert.Equal(t, "left behind", string(data))
+
+	next = idCounter.Load() + 1
+	for n := next; n < next+maxNameAttempts; n++ {
+		assert.NilError(t, os.WriteFile(nameAt(n, "0000000000000000"), nil, 0o600))
+	}
+	rand.Reader = bytes.NewReader(make([]byte, 8*maxNameAttempts))
+	_, err = createInstance(ctx, tplDB, *tpl, dir, t.Name(), newOptions(nil))
+	assert.ErrorContains(t, err, "could not find an unused name")
+}
+
+func TestTemplateFileNameIncludesEngine(t *testing.T) {
+	t.Parallel()
+
+	mattn := testEngine(t, "sqlite3")
+	modernc := testEngine(t, "sqlite")
+	assert.Assert(t, regexp.MustCompile(`^3\.\d+\.\d+-[0-9a-f]{8}$`).MatchString(mattn.tag()), mattn.tag())
+	assert.Assert(t, templateFileName("abc", "sqlite3", mattn) != templateFileName("abc", "sqlite", modernc))
+
+	upgraded := *mattn
+	upgraded.version = "3.99.0"
+	assert.Assert(t, templateFileName("abc", "sqlite3", mattn) != templateFileName("abc", "sqlite3", &upgraded))
+
+	rebuilt := *mattn
+	rebuilt.options = "00000000"
+	assert.Assert(t, templateFileName("abc", "sqlite3", mattn) != templateFileName("abc", "sqlite3", &rebuilt))
+	assert.Assert(t, isTemplateName(templateFileName("abc", "sqlite3", mattn)))
+}
+
+// oldSQLiteConns counts the connections opened with the "sqlitestdb_old"
+// driver, registered by TestSQLiteVersionTooOld, which pretends to use an
+// ancient version of SQLite.
+var (
+	registerOldSQLite sync.Once
+	oldSQLiteConns    atomic.Int32
+)
+
+func TestSQLiteVersionTooOld(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	registerOldSQLite.Do(func() {
+		sql.Register("sqlitestdb_old", &sqlite3.SQLiteDriver{
+			ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+				oldSQLiteConns.Add(1)
+				return conn.RegisterFunc("sqlite_version", func() string { return "3.7.17" }, true)
+			},
+		})
+	})
+
+	m := &flakyMigrator{sqlMigrator: sqlMigrator{migrations: []string{"CREATE TABLE cats (id INTEGER PRIMARY KEY)"}}}
+	mhash, err := TemplateHash(m)
+	assert.NilError(t, err)
+
+	for i := 0; i < 2; i++ {
+		_, err = getOrCreateTemplate(ctx, Config{Driver: "sqlitestdb_old"}, m, newOptions(nil))
+		assert.ErrorContains(t, err, "SQLite version too old (found v3.7.17")
+	}
+	assert.Assert(t, errors.Is(err, ErrSQLiteTooOld))
+	var versionErr *SQLiteVersionError
+	assert.Assert(t, errors.As(err, &versionErr))
+	assert.Equal(t, "v3.7.17", versionErr.Found)
+	assert.Equal(t, minVersion, versionErr.Minimum)
+	assert.Equal(t, int32(0), m.calls.Load())
+	assert.Equal(t, int32(1), oldSQLiteConns.Load())
+
+	matches, err := filepath.Glob(filepath.Join(os.TempDir(), "sqlitestdb_tpl_"+mhash+"_sqlitestdb_old*"))
+	assert.NilError(t, err)
+	assert.Assert(t, len(matches) == 0, "template was created: %v", matches)
+}
+
+func TestErrorClasses(t *testing.T) {
+	t.Parallel()
+
+	t.Run("template", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		id, err := uniqueID()
+		assert.NilError(t, err)
+		m := &flakyMigrator{sqlMigrator: sqlMigrator{migrations: []string{"CREATE TABLE error_classes_" + id + " (id INTEGER PRIMARY KEY)"}}}
+		_, err = instantiate(ctx, t.Name(), Config{Driver: "sqlite3"}, m, newOptions(nil))
+		assert.Assert(t, errors.Is(err, ErrTemplateCreate), "%v", err)
+
+		var tplErr *TemplateError
+		assert.Assert(t, errors.As(err, &tplErr))
+		assert.Assert(t, strings.HasPrefix(filepath.Base(tplErr.Path), "sqlitestdb_tpl_"), tplErr.Path)
+		assert.ErrorContains(t, tplErr.Err, "interrupted")
+		assert.Assert(t, !errors.Is(err, ErrClone))
+	})
+
+	t.Run("clone", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		_, err := instantiate(ctx, t.Name(), Config{Driver: "sqlite3"}, NoopMigrator{}, newOptions([]Option{WithCloneStrategy("teleport")}))
+		assert.Assert(t, errors.Is(err, ErrClone), "%v", err)
+		assert.Assert(t, !errors.Is(err, ErrTemplateCreate))
+	})
+
+	t.Run("driver", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		_, err := instantiate(ctx, t.Name(), Config{Driver: "sqlitestdb_missing"}, NoopMigrator{}, newOptions(nil))
+		assert.Assert(t, errors.Is(err, ErrDriverOpen), "%v", err)
+	})
+}
+
+// flakyMigrator is a sqlMigrator that fails after running its first migration
+// the first time it is called, as if the process had been interrupted.
+type flakyMigrator struct {
+	sqlMigrator
+	calls atomic.Int32
+}
+
+func (m *flakyMigrator) Migrate(ctx context.Context, db *sql.DB, config Config) error {
+	if m.calls.Add(1) == 1 {
+		if _, err := db.ExecContext(ctx, m.migrations[0]); err != nil {
+			return err
+		}
+		return errors.New("interrupted")
+	}
+	return m.sqlMigrator.Migrate(ctx, db, config)
+}
+
+// brittleMigrator is a sqlMigrator that refuses to migrate again once it
+// succeeded, until refuse is cleared.
+type brittleMigrator struct {
+	sqlMigrator
+	refuse atomic.Bool
+}
+
+func (m *brittleMigrator) Migrate(ctx context.Context, db *sql.DB, config Config) error {
+	if m.refuse.Load() {
+		return errors.New("rebuild refused")
+	}
+	if err := m.sqlMigrator.Migrate(ctx, db, config); err != nil {
+		return err
+	}
+	m.refuse.Store(true)
+	return nil
+}
+
+func TestVerifier(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	id, err := uniqueID()
+	assert.NilError(t, err)
+	m := &verifyingMigrator{sqlMigrator: sqlMigrator{migrations: []string{
+		"CREATE TABLE verified_" + id + " (id INTEGER PRIMARY KEY)",
+	}}}
+	config := Config{Driver: "sqlite3"}
+
+	var warnings []string
+	o := newOptions(nil)
+	o.logf = func(format string, args ...any) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}
+	reuse := func() *templateState {
+		t.Helper()
+		// Forgetting the template makes it look like it was created by a
+		// previous run.
+		mhash, err := TemplateHash(m)
+		assert.NilError(t, err)
+		templates.Forget(templateKey(config.Driver, mhash, o))
+
+		tpl, err := getOrCreateTemplate(ctx, config, m, o)
+		assert.NilError(t, err)
+		return tpl
+	}
+
+	// A template that was just created isn't verified.
+	tpl := reuse()
+	assert.Assert(t, tpl.created)
+	assert.Equal(t, int32(1), m.migrateCalls.Load())
+	assert.Equal(t, int32(0), m.verifyCalls.Load())
+
+	// A template that passes verification is reused.
+	tpl = reuse()
+	assert.Assert(t, !tpl.created)
+	assert.Equal(t, int32(1), m.migrateCalls.Load())
+	assert.Equal(t, int32(1), m.verifyCalls.Load())
+	assert.Equal(t, 0, len(warnings))
+
+	// A template that fails verification is created again, once.
+	m.fail.Store(true)
+	tpl = reuse()
+	assert.Assert(t, tpl.created)
+	assert.Equal(t, int32(2), m.migrateCalls.Load())
+	assert.Equal(t, int32(2), m.verifyCalls.Load())
+	assert.Equal(t, 1, len(warnings))
+	assert.Assert(t, strings.Contains(warnings[0], "failed verification: outdated fixtures"), warnings[0])
+}
+
+// verifyingMigrator is a sqlMigrator that verifies the templates it reuses.
+type verifyingMigrator struct {
+	sqlMigrator
+	fail         atomic.Bool
+	migrateCalls atomic.Int32
+	verifyCalls  atomic.Int32
+}
+
+func (m *verifyingMigrator) Migrate(ctx context.Context, db *sql.DB, config Config) error {
+	m.migrateCalls.Add(1)
+	return m.sqlMigrator.Migrate(ctx, db, config)
+}
+
+func (m *verifyingMigrator) Verify(ctx context.Context, db *sql.DB) error {
+	m.verifyCalls.Add(1)
+	if m.fail.Load() {
+		return errors.New("outdated fixtures")
+	}
+
+	var count int
+	return db.QueryRowContext(ctx, "SELECT count(*) FROM sqlite_master").Scan(&count)
+}
+
+func TestTxMigrations(t *testing.T) {
+	t.Parallel()
+
+	tables := func(ctx context.Context, t *testing.T, path string) []string {
+		db, err := sql.Open("sqlite3", "file:"+path)
+		assert.NilError(t, err)
+		defer db.Close()
+
+		rows, err := db.QueryContext(ctx, "SELECT name FROM sqlite_master WHERE type = 'table' ORDER BY name")
+		assert.NilError(t, err)
+		defer rows.Close()
+
+		var names []string
+		for rows.Next() {
+			var name string
+			assert.NilError(t, rows.Scan(&name))
+			names = append(names, name)
+		}
+		assert.NilError(t, rows.Err())
+		return names
+	}
+
+	failing := &sqlMigrator{migrations: []string{
+		"CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT)",
+		"SELECT x FROM nothing",
+	}}
+
+	t.Run("failing", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		path := filepath.Join(t.TempDir(), "template.sqlite")
+		err := migrateTemplate(ctx, Config{Driver: "sqlite3", Database: path}, "failing", failing, newOptions([]Option{WithTxMigrations()}))
+		assert.ErrorContains(t, err, "syntax error")
+		assert.Equal(t, 0, len(tables(ctx, t, path)))
+	})
+
+	t.Run("failing without transaction", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		path := filepath.Join(t.TempDir(), "template.sqlite")
+		err := migrateTemplate(ctx, Config{Driver: "sqlite3", Database: path}, "failing", failing, newOptions(nil))
+		assert.ErrorContains(t, err, "syntax error")
+		assert.DeepEqual(t, []string{"cats"}, tables(ctx, t, path))
+	})
+
+	t.Run("own transaction", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var warnings []string
+		o := newOptions([]Option{WithTxMigrations()})
+		o.logf = func(format string, args ...any) {
+			warnings = append(warnings, fmt.Sprintf(format, args...))
+		}
+
+		path := filepath.Join(t.TempDir(), "template.sqlite")
+		err := migrateTemplate(ctx, Config{Driver: "sqlite3", Database: path}, "tx", &txMigrator{}, o)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, []string{"cats", markerTable}, tables(ctx, t, path))
+		assert.Equal(t, 1, len(warnings))
+	})
+
+	t.Run("failing template is removed", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := getOrCreateTemplate(context.Background(), Config{Driver: "sqlite3"}, failing, newOptions([]Option{WithTxMigrations()}))
+		assert.ErrorContains(t, err, "syntax error")
+
+		mhash, err := TemplateHash(failing)
+		assert.NilError(t, err)
+		matches, err := filepath.Glob(filepath.Join(os.TempDir(), "sqlitestdb_tpl_"+mhash+"_sqlite3_*.sqlite*"))
+		assert.NilError(t, err)
+		for _, match := range matches {
+			assert.Assert(t, strings.HasSuffix(match, ".lock"), "%s was not removed", match)
+		}
+	})
+
+	db := New(t, Config{Driver: "sqlite3"}, &sqlMigrator{migrations: []string{
+		"CREATE TABLE tx_cats (id INTEGER PRIMARY KEY, name TEXT)",
+		"INSERT INTO tx_cats (name) VALUES ('daisy'), ('sunny')",
+	}}, WithTxMigrations())
+	var count int
+	assert.NilError(t, db.QueryRow("SELECT COUNT(*) FROM tx_cats").Scan(&count))
+	assert.Equal(t, 2, count)
+}
+
+// txMigrator runs its migration in a transaction of its own.
+type txMigrator struct{}
+
+func (*txMigrator) Hash() (string, error) {
+	return "tx", nil
+}
+
+func (*txMigrator) Migrate(ctx context.Context, db *sql.DB, _ Config) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT)"); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 type sqlMigrator struct {
 	migrations []string
 }
 
 func (m *sqlMigrator) Hash() (string, error) {
-	hash := common.NewRecursiveHash()
+	h := hash.NewRecursiveHash()
 	for _, migration := range m.migrations {
-		hash.Add([]byte(migration))
+		h.Add([]byte(migration))
 	}
-	return hash.String(), nil
+	return h.String(), nil
 }
 
 func (m *sqlMigrator) Migrate(ctx context.Context, db *sql.DB, _ Config) error {
@@ -55,3 +944,585 @@ func (m *sqlMigrator) Migrate(ctx context.Context, db *sql.DB, _ Config) error {
 	}
 	return nil
 }
+
+func TestCleanTemplates(t *testing.T) {
+	t.Parallel()
+
+	old := time.Now().Add(-20 * 365 * 24 * time.Hour)
+	plant := func(name string, contents []byte) string {
+		path := filepath.Join(os.TempDir(), name)
+		assert.NilError(t, os.WriteFile(path, contents, 0o600))
+		assert.NilError(t, os.Chtimes(path, old, old))
+		t.Cleanup(func() { os.Remove(path) })
+		return path
+	}
+
+	id, err := uniqueID()
+	assert.NilError(t, err)
+
+	database := stampedHeader()
+	stale := plant("sqlitestdb_tpl_stale"+id+".sqlite", database)
+	staleWAL := plant("sqlitestdb_tpl_stale"+id+".sqlite-wal", nil)
+	notSQLite := plant("sqlitestdb_tpl_garbage"+id+".sqlite", []byte("not a database"))
+	unstamped := plant("sqlitestdb_tpl_unstamped"+id+".sqlite", unstampedHeader())
+	instance := plant("sqlitestdb_tpl_stale"+id+"_inst_"+id+".sqlite", database)
+	other := plant("other_"+id+".sqlite", database)
+	staleLock := plant("sqlitestdb_tpl_stale"+id+".sqlite.lock", nil)
+	orphanLock := plant("sqlitestdb_tpl_orphan"+id+".sqlite.lock", nil)
+	garbageLock := plant("sqlitestdb_tpl_garbage"+id+".sqlite.lock", nil)
+
+	// A template used by this program is never removed, however old.
+	m := &sqlMigrator{migrations: []string{"CREATE TABLE active_" + id + " (id INTEGER PRIMARY KEY)"}}
+	_ = New(t, Config{Driver: "sqlite3"}, m)
+	mhash, err := TemplateHash(m)
+	assert.NilError(t, err)
+	active := filepath.Join(os.TempDir(), templateFileName(mhash, "sqlite3", testEngine(t, "sqlite3")))
+	assert.NilError(t, os.Chtimes(active, old, old))
+
+	assert.NilError(t, CleanTemplates(10*365*24*time.Hour))
+
+	for _, path := range []string{stale, staleWAL, staleLock, orphanLock} {
+		_, err := os.Stat(path)
+		assert.Assert(t, errors.Is(err, os.ErrNotExist), "%s was not removed", path)
+	}
+
+	for _, path := range []string{notSQLite, unstamped, instance, other, active, garbageLock} {
+		_, err := os.Stat(path)
+		assert.NilError(t, err, "%s was removed", path)
+	}
+}
+
+func TestCleanInstances(t *testing.T) {
+	t.Parallel()
+
+	old := time.Now().Add(-20 * 365 * 24 * time.Hour)
+	plant := func(name string, contents []byte, mtime time.Time) string {
+		path := filepath.Join(os.TempDir(), name)
+		assert.NilError(t, os.WriteFile(path, contents, 0o600))
+		assert.NilError(t, os.Chtimes(path, mtime, mtime))
+		t.Cleanup(func() { os.Remove(path) })
+		return path
+	}
+
+	id, err := uniqueID()
+	assert.NilError(t, err)
+
+	database := stampedHeader()
+	stale := plant(instanceFileName("crashed"+id, "TestCrashed", id), database, old)
+	staleWAL := plant(instanceFileName("crashed"+id, "TestCrashed", id)+"-wal", nil, old)
+	recent := plant(instanceFileName("running"+id, "", id), database, time.Now())
+	notSQLite := plant(instanceFileName("garbage"+id, "", id), []byte("not a database"), old)
+	unstamped := plant(instanceFileName("unstamped"+id, "", id), unstampedHeader(), old)
+	template := plant("sqlitestdb_tpl_crashed"+id+".sqlite", database, old)
+	other := plant("other_"+id+"_inst_"+id+".sqlite", database, old)
+
+	// The instance databases of this program are never removed, however old.
+	db := New(t, Config{Driver: "sqlite3"}, NoopMigrator{})
+	var active string
+	assert.NilError(t, db.QueryRow("SELECT file FROM pragma_database_list WHERE name = 'main'").Scan(&active))
+	assert.NilError(t, os.Chtimes(active, old, old))
+
+	assert.NilError(t, CleanInstances(10*365*24*time.Hour))
+
+	for _, path := range []string{stale, staleWAL} {
+		_, err := os.Stat(path)
+		assert.Assert(t, errors.Is(err, os.ErrNotExist), "%s was not removed", path)
+	}
+
+	for _, path := range []string{recent, notSQLite, unstamped, template, other, active} {
+		_, err := os.Stat(path)
+		assert.NilError(t, err, "%s was removed", path)
+	}
+}
+
+// stampedHeader returns the header of a SQLite database created by sqlitestdb,
+// and unstampedHeader the header of any other SQLite database.
+func stampedHeader() []byte {
+	header := unstampedHeader()
+	binary.BigEndian.PutUint32(header[applicationIDOffset:], applicationID)
+	return header
+}
+
+func unstampedHeader() []byte {
+	return append(append([]byte{}, sqliteHeader...), make([]byte, 84)...)
+}
+
+func TestReadMeta(t *testing.T) {
+	t.Parallel()
+
+	id, err := uniqueID()
+	assert.NilError(t, err)
+	m := &sqlMigrator{migrations: []string{"CREATE TABLE meta_" + id + " (id INTEGER PRIMARY KEY)"}}
+	mhash, err := TemplateHash(m)
+	assert.NilError(t, err)
+
+	before := time.Now()
+	for _, strategy := range []CloneStrategy{CloneVacuum, CloneCopy, CloneBackup} {
+		db := New(t, Config{Driver: "sqlite3"}, m, WithCloneStrategy(strategy))
+		var path string
+		assert.NilError(t, db.QueryRow("SELECT file FROM pragma_database_list WHERE name = 'main'").Scan(&path))
+
+		meta, err := ReadMeta(path)
+		assert.NilError(t, err, "clone strategy %s", strategy)
+		assert.Equal(t, mhash, meta.Hash)
+		assert.Equal(t, "sqlite3", meta.Driver)
+		assert.Equal(t, "(devel)", meta.Version)
+		assert.Assert(t, !meta.CreatedAt.Before(before), meta.CreatedAt)
+	}
+
+	other := filepath.Join(t.TempDir(), "other.sqlite")
+	db, err := sql.Open("sqlite3", "file:"+other)
+	assert.NilError(t, err)
+	_, err = db.Exec("CREATE TABLE " + markerTable + " (hash TEXT)")
+	assert.NilError(t, err)
+	assert.NilError(t, db.Close())
+
+	_, err = ReadMeta(other)
+	assert.ErrorContains(t, err, "was not created by sqlitestdb")
+}
+
+func TestTemplateRemovedByReaper(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	id, err := uniqueID()
+	assert.NilError(t, err)
+
+	m := &sqlMigrator{migrations: []string{"CREATE TABLE reaped_" + id + " (id INTEGER PRIMARY KEY); INSERT INTO reaped_" + id + " VALUES (1)"}}
+	_ = New(t, Config{Driver: "sqlite3"}, m)
+
+	mhash, err := TemplateHash(m)
+	assert.NilError(t, err)
+	tpl, err := templates.Get(templateKey("sqlite3", mhash, newOptions(nil)))
+	assert.NilError(t, err)
+	assert.NilError(t, removeDatabase(tpl.config.Database))
+
+	db := New(t, Config{Driver: "sqlite3"}, m)
+	var count int
+	assert.NilError(t, db.QueryRowContext(ctx, "SELECT COUNT(*) FROM reaped_"+id).Scan(&count))
+	assert.Equal(t, 1, count)
+
+	_, err = os.Stat(tpl.config.Database)
+	assert.NilError(t, err)
+}
+
+func TestRemoveDatabase(t *testing.T) {
+	t.Parallel()
+
+	// Only the file system is used, so the databases are removed the same way
+	// whether or not the drivers are built with cgo.
+	path := filepath.Join(t.TempDir(), "db.sqlite")
+	for _, suffix := range []string{"", "-wal", "-journal"} {
+		assert.NilError(t, os.WriteFile(path+suffix, nil, 0o600))
+	}
+
+	assert.NilError(t, removeDatabase(path))
+	for _, suffix := range []string{"", "-wal", "-shm", "-journal"} {
+		_, err := os.Stat(path + suffix)
+		assert.Assert(t, errors.Is(err, fs.ErrNotExist), "%q was not removed: %v", path+suffix, err)
+	}
+
+	// Files that are already gone aren't an error.
+	assert.NilError(t, removeDatabase(path))
+}
+
+// ctxConn is a connection that records the context of its last query.
+type ctxConn struct {
+	driver.Conn
+	ctx context.Context
+}
+
+func (c *ctxConn) QueryContext(ctx context.Context, _ string, _ []driver.NamedValue) (driver.Rows, error) {
+	c.ctx = ctx
+	return emptyRows{}, nil
+}
+
+type emptyRows struct{}
+
+func (emptyRows) Columns() []string              { return []string{"x"} }
+func (emptyRows) Close() error                   { return nil }
+func (emptyRows) Next(dest []driver.Value) error { return io.EOF }
+
+func TestInterruptRowsReleaseContext(t *testing.T) {
+	t.Parallel()
+	connCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	drv := &ctxConn{}
+	conn := &interruptConn{Conn: drv, ctx: connCtx}
+	rows, err := conn.QueryContext(context.Background(), "SELECT 1", nil)
+	assert.NilError(t, err)
+	assert.NilError(t, drv.ctx.Err())
+
+	// The rows of the driver don't implement the optional interfaces.
+	assert.Equal(t, reflect.TypeFor[any](), rows.(driver.RowsColumnTypeScanType).ColumnTypeScanType(0))
+	assert.Equal(t, io.EOF, rows.(driver.RowsNextResultSet).NextResultSet())
+
+	assert.NilError(t, rows.Close())
+	assert.Equal(t, context.Canceled, drv.ctx.Err())
+	assert.NilError(t, connCtx.Err())
+}
+
+func TestFDError(t *testing.T) {
+	t.Parallel()
+
+	err := fdError(errors.New("too many open files"))
+	assert.ErrorContains(t, err, "ulimit -n")
+	assert.ErrorContains(t, err, "-parallel")
+
+	other := errors.New("no such table: cats")
+	assert.Equal(t, other, fdError(other))
+}
+
+func TestInstanceFileName(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "sqlitestdb_tpl_abc_inst_01234567.sqlite", instanceFileName("abc", "", "01234567"))
+	assert.Equal(t, "sqlitestdb_tpl_abc_inst_TestCats-daisy_and_sunny_01234567.sqlite", instanceFileName("abc", "TestCats/daisy and sunny", "01234567"))
+
+	long := "TestCats/" + strings.Repeat("very_long_subtest_name_", 10)
+	name1 := instanceFileName("abc", long+"1", "01234567")
+	name2 := instanceFileName("abc", long+"2", "01234567")
+	assert.Assert(t, name1 != name2)
+	assert.Assert(t, len(name1) < 128, name1)
+	assert.Assert(t, instanceName.MatchString(name1))
+	assert.Assert(t, !isTemplateName(name1))
+}
+
+func TestURIPath(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		path string
+		sep  rune
+		want string
+	}{
+		{`/tmp/sqlitestdb_tpl_x.sqlite`, '/', `/tmp/sqlitestdb_tpl_x.sqlite`},
+		{`relative/x.sqlite`, '/', `relative/x.sqlite`},
+		{`C:\Users\runner\AppData\Local\Temp\sqlitestdb_tpl_x.sqlite`, '\\', `///C:/Users/runner/AppData/Local/Temp/sqlitestdb_tpl_x.sqlite`},
+		{`d:\x.sqlite`, '\\', `///d:/x.sqlite`},
+		{`relative\x.sqlite`, '\\', `relative/x.sqlite`},
+		{`\\server\share\x.sqlite`, '\\', `////server/share/x.sqlite`},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.want, uriPath(c.path, c.sep), "path %q", c.path)
+	}
+}
+
+func TestHasScheme(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]bool{
+		"/tmp/x.sqlite":                        false,
+		"relative/x.sqlite":                    false,
+		`C:\Users\x.sqlite`:                    false,
+		"c:/x.sqlite":                          false,
+		"/tmp/odd:name.sqlite":                 false,
+		"file:/tmp/x.sqlite?mode=ro":           true,
+		"file:///C:/x.sqlite":                  true,
+		"libsql://db.turso.io?authToken=token": true,
+		"https://db.turso.io":                  true,
+		"sqlite+libsql://db":                   true,
+		"1db://x":                              false,
+	}
+	for database, want := range cases {
+		assert.Equal(t, want, hasScheme(database), "database %q", database)
+	}
+
+	// URLs are passed through unmodified.
+	config := Config{Driver: "libsql", Database: "libsql://db.turso.io?authToken=token"}
+	assert.Equal(t, config.URI(), "libsql://db.turso.io?authToken=token")
+}
+
+func TestDetectDriver(t *testing.T) {
+	t.Parallel()
+
+	driver, err := detectDriver([]string{"postgres", "sqlite"})
+	assert.NilError(t, err)
+	assert.Equal(t, "sqlite", driver)
+
+	driver, err = detectDriver([]string{"libsql"})
+	assert.NilError(t, err)
+	assert.Equal(t, "libsql", driver)
+
+	_, err = detectDriver([]string{"postgres"})
+	assert.ErrorContains(t, err, `no SQLite driver is registered; import one, such as _ "github.com/mattn/go-sqlite3" or _ "modernc.org/sqlite" (registered drivers: ["postgres"])`)
+
+	_, err = detectDriver([]string{"sqlite", "postgres", "sqlite3"})
+	assert.ErrorContains(t, err, `several SQLite drivers are registered, ["sqlite3" "sqlite"]; set Config.Driver to the one to use (registered drivers: ["sqlite" "postgres" "sqlite3"])`)
+
+	// An explicit driver is never detected.
+	driver, err = resolveDriver(Config{Driver: "sqlite3"})
+	assert.NilError(t, err)
+	assert.Equal(t, "sqlite3", driver)
+}
+
+func TestCheckDriver(t *testing.T) {
+	t.Parallel()
+
+	assert.NilError(t, checkDriver("sqlite", []string{"sqlite"}))
+
+	err := checkDriver("sqlite3", []string{"sqlite"})
+	assert.Error(t, err, `could not open database: driver "sqlite3" is not registered; did you forget to import _ "github.com/mattn/go-sqlite3"? registered drivers: ["sqlite"]`)
+
+	err = checkDriver("sqlite4", nil)
+	assert.Error(t, err, `could not open database: driver "sqlite4" is not registered, and isn't a known SQLite driver, such as "sqlite3" (github.com/mattn/go-sqlite3), "sqlite" (modernc.org/sqlite), "libsql" (github.com/tursodatabase/go-libsql); registered drivers: []`)
+	assert.Assert(t, errors.Is(err, ErrDriverOpen))
+}
+
+func TestTemplateDriver(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "sqlite3", Config{Driver: "sqlite3"}.templateDriver())
+
+	// Templates encrypted with different keys are kept apart.
+	keyed := Config{Driver: "sqlite3", Key: "correct horse"}.templateDriver()
+	assert.Assert(t, strings.HasPrefix(keyed, "sqlite3-key-"), keyed)
+	assert.Assert(t, !strings.Contains(keyed, "horse"), keyed)
+	assert.Assert(t, keyed != Config{Driver: "sqlite3", Key: "battery staple"}.templateDriver())
+}
+
+func TestKeyed(t *testing.T) {
+	t.Parallel()
+
+	config := Config{Driver: "sqlite3", Key: "correct horse&battery=staple"}
+	assert.Equal(t, config.keyed("file:/tmp/x.sqlite"), "file:/tmp/x.sqlite?key=correct%20horse%26battery%3Dstaple")
+	assert.Equal(t, config.keyed("file:/tmp/x.sqlite?mode=ro"), "file:/tmp/x.sqlite?mode=ro&key=correct%20horse%26battery%3Dstaple")
+	assert.Equal(t, config.keyed(":memory:"), ":memory:")
+	assert.Equal(t, Config{Driver: "sqlite3"}.keyed("file:/tmp/x.sqlite"), "file:/tmp/x.sqlite")
+
+	// The key isn't part of the URI, which is logged.
+	config.Database = "/tmp/x.sqlite"
+	assert.Equal(t, config.URI(), "file:/tmp/x.sqlite")
+}
+
+func TestHasCompileOption(t *testing.T) {
+	t.Parallel()
+
+	options := []string{"ENABLE_FTS5", "MAX_VARIABLE_NUMBER=250000", "THREADSAFE=1"}
+	for opt, want := range map[string]bool{
+		"ENABLE_FTS5":         true,
+		"SQLITE_ENABLE_FTS5":  true,
+		"enable_fts5":         true,
+		"ENABLE_FTS":          false,
+		"THREADSAFE":          true,
+		"THREADSAFE=1":        true,
+		"THREADSAFE=2":        false,
+		"MAX_VARIABLE_NUMBER": true,
+		"ENABLE_RTREE":        false,
+		"SQLITE_ENABLE_RTREE": false,
+	} {
+		assert.Equal(t, want, hasCompileOption(options, opt), "option %q", opt)
+	}
+}
+
+func TestUniqueID(t *testing.T) {
+	t.Parallel()
+
+	format := regexp.MustCompile(`^` + strconv.Itoa(os.Getpid()) + `_[0-9]+_[0-9a-f]{16}$`)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	ids := map[string]bool{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				id, err := uniqueID()
+				assert.Check(t, err)
+				assert.Check(t, format.MatchString(id), id)
+
+				mu.Lock()
+				assert.Check(t, !ids[id], "duplicate id %q", id)
+				ids[id] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	name := instanceFileName(strings.Repeat("f", 64), strings.Repeat("x", 200), "4194304_18446744073709551615_0123456789abcdef")
+	assert.Assert(t, len(name) < 255, name)
+	assert.Assert(t, instanceName.MatchString(name))
+}
+
+func TestInstanceFileNameIncludesTestName(t *testing.T) {
+	t.Parallel()
+
+	paths := map[string]bool{}
+	var mu sync.Mutex
+	for _, name := range []string{"same_prefix", "same_prefix"} {
+		t.Run(name, func(t *testing.T) {
+			db := New(t, Config{Driver: "sqlite3"}, NoopMigrator{})
+
+			var path string
+			err := db.QueryRow("SELECT file FROM pragma_database_list WHERE name = 'main'").Scan(&path)
+			assert.NilError(t, err)
+			assert.Assert(t, strings.Contains(filepath.Base(path), "_inst_"+sanitizeName(t.Name())+"_"), path)
+
+			mu.Lock()
+			defer mu.Unlock()
+			assert.Assert(t, !paths[path])
+			paths[path] = true
+		})
+	}
+}
+
+func TestVerifyShutdown(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string][]string{
+		"none":    nil,
+		"leak":    {"was not removed", "was not closed", "could not be created: "},
+		"rebuild": {"was rebuilt: could not clone template", "file is not a database"},
+		"retry":   {"could not be created, but was created when retried"},
+		"removed": {"failed after being created: ", "rebuild refused"},
+		"corrupt": {"was rebuilt: could not clone template", "failed after being created: ", "rebuild refused"},
+		"recover": {"failed after being created, but was rebuilt when retried: ", "rebuild refused"},
+	}
+	for mode, wants := range cases {
+		t.Run(mode, func(t *testing.T) {
+			t.Parallel()
+
+			// The state checked by VerifyShutdown is shared by the whole
+			// program, so it is checked in a separate process.
+			cmd := exec.Command(os.Args[0], "-test.run=^TestHelperVerifyShutdown$", "-test.count=1", "-test.v")
+			cmd.Env = append(os.Environ(), "SQLITESTDB_HELPER_VERIFY="+mode)
+			out, err := cmd.CombinedOutput()
+			assert.NilError(t, err, string(out))
+
+			if wants == nil {
+				assert.Assert(t, strings.Contains(string(out), "verify: <nil>"), string(out))
+				return
+			}
+
+			for _, want := range wants {
+				assert.Assert(t, strings.Contains(string(out), want), "%q not in output:\n%s", want, out)
+			}
+			if mode != "leak" && mode != "retry" {
+				// The template was created before anything failed.
+				assert.Assert(t, !strings.Contains(string(out), "could not be created"), string(out))
+			}
+		})
+	}
+}
+
+func TestHelperVerifyShutdown(t *testing.T) {
+	mode := os.Getenv("SQLITESTDB_HELPER_VERIFY")
+	if mode == "" {
+		t.Skip("helper process for TestVerifyShutdown")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	t.Run("passed", func(t *testing.T) {
+		_ = New(t, Config{Driver: "sqlite3"}, NoopMigrator{})
+		_ = Custom(t, Config{Driver: "sqlite3"}, NoopMigrator{})
+		_ = NewResettable(t, Config{Driver: "sqlite3"}, NoopMigrator{})
+		_ = NewTx(t, Config{Driver: "sqlite3"}, NoopMigrator{})
+	})
+
+	switch mode {
+	case "leak":
+		_, err := getOrCreateTemplate(ctx, Config{Driver: "sqlite3"}, &sqlMigrator{migrations: []string{"SELECT x FROM nothing"}}, newOptions(nil))
+		assert.Assert(t, err != nil)
+
+		// An instance that is never removed, with a connection that is never
+		// closed.
+		instance, err := instantiate(ctx, "leaked", Config{Driver: "sqlite3"}, NoopMigrator{}, newOptions(nil))
+		assert.NilError(t, err)
+		defer os.Remove(instance.Database)
+
+		db, err := instance.Connect()
+		assert.NilError(t, err)
+		defer db.Close()
+		trackDB(db, instance)
+	case "rebuild":
+		// A template corrupted after it was cached, which is rebuilt when it
+		// can't be cloned.
+		id, err := uniqueID()
+		assert.NilError(t, err)
+		m := &staticHashMigrator{
+			sqlMigrator: sqlMigrator{migrations: []string{"CREATE TABLE fresh (id INTEGER PRIMARY KEY)"}},
+			hash:        "verify_rebuild_" + id,
+		}
+		tpl, err := getOrCreateTemplate(ctx, Config{Driver: "sqlite3"}, m, newOptions(nil))
+		assert.NilError(t, err)
+		assert.NilError(t, os.WriteFile(tpl.config.Database, bytes.Repeat([]byte("not a database "), 512), 0o666))
+
+		t.Run("rebuilt", func(t *testing.T) {
+			_ = New(t, Config{Driver: "sqlite3"}, m)
+		})
+	case "retry":
+		// A failure that is forgotten, and retried successfully.
+		id, err := uniqueID()
+		assert.NilError(t, err)
+		m := &flakyMigrator{sqlMigrator: sqlMigrator{migrations: []string{"CREATE TABLE retried_" + id + " (id INTEGER PRIMARY KEY)"}}}
+		_, err = getOrCreateTemplate(ctx, Config{Driver: "sqlite3"}, m, newOptions(nil))
+		assert.Assert(t, err != nil)
+		mhash, err := TemplateHash(m)
+		assert.NilError(t, err)
+		templates.Forget(templateKey("sqlite3", mhash, newOptions(nil)))
+		_, err = getOrCreateTemplate(ctx, Config{Driver: "sqlite3"}, m, newOptions(nil))
+		assert.NilError(t, err)
+	case "removed", "corrupt", "recover":
+		// A template that is created, but can't be rebuilt after it was
+		// removed, or corrupted.
+		id, err := uniqueID()
+		assert.NilError(t, err)
+		m := &brittleMigrator{sqlMigrator: sqlMigrator{migrations: []string{"CREATE TABLE brittle_" + id + " (id INTEGER PRIMARY KEY)"}}}
+		tpl, err := getOrCreateTemplate(ctx, Config{Driver: "sqlite3"}, m, newOptions(nil))
+		assert.NilError(t, err)
+		if mode == "corrupt" {
+			assert.NilError(t, os.WriteFile(tpl.config.Database, bytes.Repeat([]byte("not a database "), 512), 0o666))
+		} else {
+			assert.NilError(t, removeDatabase(tpl.config.Database))
+		}
+
+		_, err = instantiate(ctx, "brittle", Config{Driver: "sqlite3"}, m, newOptions(nil))
+		assert.ErrorContains(t, err, "rebuild refused")
+
+		if mode == "recover" {
+			// Another caller that loaded the template before it was removed
+			// retries the rebuild, see [loadTemplate].
+			m.refuse.Store(false)
+			templates.Forget(templateKey("sqlite3", tpl.hash, newOptions(nil)))
+			instance, err := instantiate(ctx, "brittle", Config{Driver: "sqlite3"}, m, newOptions(nil))
+			assert.NilError(t, err)
+			assert.NilError(t, discardInstance(instance.Database))
+		}
+	}
+
+	fmt.Printf("verify: %v\n", VerifyShutdown())
+}
+
+func TestWithDeterministicNames(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// A seed that wasn't used by this program yet, so that the instances are
+	// counted from zero.
+	seed := time.Now().UnixNano()
+	mhash, err := TemplateHash(NoopMigrator{})
+	assert.NilError(t, err)
+	key := fmt.Sprintf("%d\x00%s\x00%s", seed, mhash, t.Name())
+	path := func(n int64) string {
+		return filepath.Join(os.TempDir(), instanceFileName(mhash, t.Name(), nthDeterministicID(key, n)))
+	}
+
+	// The instance left behind by a previous run is replaced.
+	assert.NilError(t, os.WriteFile(path(0), []byte("left behind"), 0o600))
+	defer os.Remove(path(0))
+
+	first := New(t, Config{Driver: "sqlite3"}, NoopMigrator{}, WithDeterministicNames(seed))
+	assert.Equal(t, path(0), ConfigFor(first).Database)
+	assert.NilError(t, first.PingContext(ctx))
+	_, err = first.ExecContext(ctx, "CREATE TABLE cats (id INTEGER PRIMARY KEY)")
+	assert.NilError(t, err)
+
+	second := New(t, Config{Driver: "sqlite3"}, NoopMigrator{}, WithDeterministicNames(seed))
+	assert.Equal(t, path(1), ConfigFor(second).Database)
+}
@@ -6,13 +6,23 @@ package sqlitestdb
 
 import (
 	"context"
+	"crypto/md5"
 	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"database/sql/driver"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"braces.dev/errtrace"
 	"github.com/terinjokes/sqlitestdb/once"
@@ -23,55 +33,192 @@ import (
 // and is the version where "VACUUM INTO" was added.
 const minVersion = "v3.27.0"
 
+// Migrator creates the schema of a template, identified by its hash.
+//
+// The connections of the database passed to Migrate are wrapped to interrupt
+// their statements once the migration is canceled, see [WithMigrateTimeout].
+// Migrators using [sql.Conn.Raw] get the connection of the driver with
+// [DriverConn].
 type Migrator interface {
 	Hash() (string, error)
 	Migrate(context.Context, *sql.DB, Config) error
 }
 
+// Verifier is implemented by migrators that check an existing template before
+// it is reused, for expectations that their hash can't capture, such as
+// migrations reading an external file. If Verify returns an error, the template
+// is removed, and created again. It isn't called for templates that were just
+// created.
+//
+// Verify must not modify the template.
+type Verifier interface {
+	Verify(context.Context, *sql.DB) error
+}
+
 // Config contains the details needed to handle a SQLite database.
 type Config struct {
-	Driver   string // The driver name used in sql.Open(). "sqlite3" (mattn/go-sqlite3), "sqlite" (modernc), or "libsql" (LibSQL)
-	Database string // The path to the database file.
+	Driver   string // The driver name used in sql.Open(). "sqlite3" (mattn/go-sqlite3), "sqlite" (modernc or glebarez/go-sqlite), or "libsql" (LibSQL). Optional if the program registers only one of them.
+	Database string // The path to the database file, or a URL, see [Config.URI]. Optional for instance databases, see [New].
+	// Connector optionally opens the connector of the database at a DSN,
+	// used instead of sql.Open(Driver, dsn), see [Config.Connect] and
+	// [DriverConnector]. The Driver still names the templates, and is
+	// recorded in their [Meta].
+	Connector driver.DriverContext
+	// Key optionally encrypts the templates and instance databases with
+	// SQLCipher, passed in the "key" parameter of the URI of every
+	// connection, but not included in [Config.URI]. The driver must be
+	// built with SQLCipher.
+	Key string
 }
 
 // URI returns a URI string needed to open the SQLite database.
 //
 //	"file:/path/to/database.sql?options=..."
 //
+// On Windows, the path is written with forward slashes, and paths starting
+// with a drive letter use the "file:///C:/path/to/database.sql" form.
+//
 // This should be a subset of the URIs defined by [SQLite URIs], but may contain
 // driver-specific options.
 //
+// If Database is already a URL, with a scheme such as "file:" or "libsql:", it
+// is returned unmodified. This allows connecting to a database with options of
+// the driver, such as the authToken of a remote libsql database, or to a copy of
+// an instance database opened with options, such as "file:/path?mode=ro".
+// Templates and instance databases are always local files, created at paths.
+//
 // [SQLite URIs]: https://www.sqlite.org/uri.html
 func (c Config) URI() string {
-	return fmt.Sprintf("file:%s", c.Database)
+	if hasScheme(c.Database) {
+		return c.Database
+	}
+	return "file:" + uriPath(c.Database, filepath.Separator)
+}
+
+// hasScheme reports whether database is already a URL, such as a "file:" URI
+// with driver-specific options, or the "libsql://" URL of a remote database,
+// rather than a path. Schemes are at least two characters long, so that the
+// drive letters of Windows paths aren't mistaken for them.
+func hasScheme(database string) bool {
+	scheme, _, ok := strings.Cut(database, ":")
+	if !ok || len(scheme) < 2 {
+		return false
+	}
+
+	for i, r := range scheme {
+		switch {
+		case 'a' <= r|0x20 && r|0x20 <= 'z':
+		case i > 0 && ('0' <= r && r <= '9' || r == '+' || r == '-' || r == '.'):
+		default:
+			return false
+		}
+	}
+	return true
 }
 
-// Connect calls [sql.Open] and connects to the database.
+// uriPath formats path for a "file:" URI, on a platform using sep to separate
+// path elements. SQLite would otherwise misread backslashes and the colon of a
+// drive letter, and take the server of a UNC path for the URI's authority.
+func uriPath(path string, sep rune) string {
+	if sep != '\\' {
+		return path
+	}
+
+	path = strings.ReplaceAll(path, `\`, "/")
+	switch {
+	case len(path) >= 2 && path[1] == ':' && 'a' <= path[0]|0x20 && path[0]|0x20 <= 'z':
+		return "///" + path
+	case strings.HasPrefix(path, "//"):
+		return "//" + path
+	}
+	return path
+}
+
+// Connect calls [sql.Open] and connects to the database. If the driver isn't
+// registered, the error suggests the import that registers it.
+//
+// If the Connector is set, the database is opened with [sql.OpenDB] and the
+// connector it returns for the URI instead, which allows setting up each
+// connection, such as registering functions and collations. sqlitestdb opens
+// the templates, the instance databases, and every other database of the
+// config the same way, with options appended to the URI as needed.
 func (c Config) Connect() (*sql.DB, error) {
-	db, err := sql.Open(c.Driver, c.URI())
-	if err != nil {
+	return errtrace.Wrap2(c.open(c.URI()))
+}
+
+// open opens the database at dsn, a URI of the database, with the Connector, or
+// the Driver, and the Key.
+func (c Config) open(dsn string) (*sql.DB, error) {
+	dsn = c.keyed(dsn)
+	if c.Connector != nil {
+		connector, err := c.Connector.OpenConnector(dsn)
+		if err != nil {
+			return nil, errtrace.Wrap(err)
+		}
+		return sql.OpenDB(connector), nil
+	}
+
+	if err := checkDriver(c.Driver, sql.Drivers()); err != nil {
 		return nil, errtrace.Wrap(err)
 	}
 
-	return db, nil
+	return errtrace.Wrap2(sql.Open(c.Driver, dsn))
+}
+
+// connector returns the connector of the database at dsn, like [Config.open].
+func (c Config) connector(dsn string) (driver.Connector, error) {
+	dsn = c.keyed(dsn)
+	dc := c.Connector
+	if dc == nil {
+		if err := checkDriver(c.Driver, sql.Drivers()); err != nil {
+			return nil, errtrace.Wrap(err)
+		}
+
+		// The driver is only available from a database opened with it.
+		db, err := sql.Open(c.Driver, dsn)
+		if err != nil {
+			return nil, errtrace.Wrap(err)
+		}
+		drv := db.Driver()
+		if err := db.Close(); err != nil {
+			return nil, errtrace.Wrap(err)
+		}
+		dc = DriverConnector(drv)
+	}
+
+	return errtrace.Wrap2(dc.OpenConnector(dsn))
 }
 
 // New creates a fresh SQLite database and connects. This database is created by
 // cloning a database migrated by the provided migrator. It is safe to call
-// concurrently, but running the same migrations across multiple packages at the
-// same time may race. If there is an error creating the database, the test will
-// be immediately failed with [testing.TB.Fatalf].
+// concurrently, and when another process is migrating the same template at the
+// same time, New waits for it to finish, however long it takes. If there is an
+// error creating the database, the test will be immediately failed with
+// [testing.TB.Fatalf].
+//
+// The [Config.Database] field may be left blank, in which case a path in the
+// temporary directory is generated for the new database. Otherwise, the new
+// database is created at that path, which must not exist yet, and can't be a
+// URL. The template is always kept in the temporary directory.
 //
-// The [Config.Database] field may be left blank, as a new database will be created.
+// The configuration of the new database, including its path, is available from
+// [ConfigFor].
 //
 // If this methods succeeds, it will call [testing.TB.Log] with the SQLite URI of
 // the test database, so that you may open the database manually and see what failed.
+// This can be silenced with [WithQuietLogging], or redirected with [SetLogger],
+// in which case the URI is only logged if the test fails.
 //
 // If this method succeeds and your test succeeds, the database will be removed
 // as part of the test cleanup process.
-func New(t testing.TB, config Config, migrator Migrator) *sql.DB {
+//
+// The returned database keeps at most one idle connection, which is closed
+// after a second, so that many instances alive at the same time don't exhaust
+// the file descriptors of the test process. Use [sql.DB.SetMaxIdleConns] and
+// [sql.DB.SetConnMaxIdleTime] to change this.
+func New(t testing.TB, config Config, migrator Migrator, opts ...Option) *sql.DB {
 	t.Helper()
-	_, db := create(t, config, migrator)
+	_, db := create(t, config, migrator, newOptions(opts))
 	return db
 }
 
@@ -79,124 +226,749 @@ func New(t testing.TB, config Config, migrator Migrator) *sql.DB {
 // any connections and returns the configuration details od the test database,
 // so that you can connect to it explicitly, potentnially via a different SQL
 // interface.
-func Custom(t testing.TB, config Config, migrator Migrator) *Config {
+func Custom(t testing.TB, config Config, migrator Migrator, opts ...Option) *Config {
 	t.Helper()
-	c, db := create(t, config, migrator)
-	if err := db.Close(); err != nil {
-		t.Fatalf("could not close test database %q: %+v", config.Database, err)
+	c, db := create(t, config, migrator, newOptions(opts))
+	if err := closeDB(db); err != nil {
+		t.Fatalf("could not close test database %q: %+v", c.Database, err)
 	}
 
 	return c
 }
 
-// create contains the implementation of [New] and [Custom], and is responsible
-// for actually creating the instance database to be used by a testcase.
-func create(t testing.TB, config Config, migrator Migrator) (*Config, *sql.DB) {
+// NewPath is like [Custom], but only returns the path of the instance database,
+// for SQLite libraries that don't implement "database/sql", such as
+// zombiezen.com/go/sqlite and crawshaw.io/sqlite, with which the test opens it.
+//
+// sqlitestdb still migrates and clones the template with the "database/sql"
+// driver of config, which must be registered, but the test doesn't need to use
+// it. Migrators using the other library can ignore the [sql.DB] passed to
+// Migrate, and open the path of the template from its [Config] instead, before
+// anything is written to it with the [sql.DB].
+//
+// The connections the test opens must be closed before its cleanup removes the
+// database, such as by closing them in a cleanup function registered after
+// calling NewPath, which then runs first.
+func NewPath(t testing.TB, config Config, migrator Migrator, opts ...Option) string {
+	t.Helper()
+	return Custom(t, config, migrator, opts...).Database
+}
+
+// Prepare creates the template for the migrator, or reuses an existing one,
+// without creating an instance database. It is intended to be called from
+// TestMain before [testing.M.Run], so that the time spent migrating is not
+// attributed to whichever test happens to run first, and the tests then clone
+// the already cached template.
+//
+// The options must match those later given to [New], as templates created with
+// [WithUntrustedTempDir] are kept apart. The returned configuration describes
+// the template itself, which must not be modified.
+func Prepare(ctx context.Context, config Config, migrator Migrator, opts ...Option) (*Config, error) {
+	o := newOptions(opts)
+	o.uncounted = true
+	tpl, err := loadTemplate(ctx, config, migrator, o)
+	if err != nil {
+		return nil, errtrace.Wrap(fdError(err))
+	}
+
+	tplConfig := tpl.config
+	return &tplConfig, nil
+}
+
+// NewIn is like [New], but creates the instance database in dir, which is
+// created if it doesn't exist yet. This allows collecting the complete state of
+// a failed test, for example as an artifact of a CI job. With the
+// [WithTemplateCopy] option, a copy of the template is placed in dir as well.
+//
+// The name of each instance database is unique, so a test may call NewIn
+// several times with the same dir. As with New, the databases are removed as
+// part of the test cleanup process if your test succeeds.
+func NewIn(t testing.TB, dir string, config Config, migrator Migrator, opts ...Option) *sql.DB {
+	t.Helper()
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	tpl, err := getOrCreateTemplate(ctx, config, migrator)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("could not create directory %q: %+v", dir, err)
+	}
+
+	o := newOptions(opts)
+	o.instanceDir = dir
+	_, db := create(t, config, migrator, o)
+
+	if o.templateCopy {
+		copyTemplate(t, ctx, dir, config, migrator, o)
+	}
+
+	return db
+}
+
+// copyTemplate clones the template into dir for [WithTemplateCopy], unless a
+// copy already exists there.
+func copyTemplate(t testing.TB, ctx context.Context, dir string, config Config, migrator Migrator, o *options) {
+	lookup := *o
+	lookup.uncounted = true
+	tpl, err := getOrCreateTemplate(ctx, config, migrator, &lookup)
 	if err != nil {
 		t.Fatalf("could not create template database: %+v", err)
 	}
 
-	tplDB, err := tpl.config.Connect()
-	if err != nil {
-		t.Fatalf("could not open template datbase: %+v", err)
+	tplCopy := tpl.config
+	tplCopy.Database = filepath.Join(dir, filepath.Base(tpl.config.Database))
+	if _, err := os.Stat(tplCopy.Database); err == nil {
+		return
 	}
 
-	var version string
-	if err := tplDB.QueryRowContext(ctx, "SELECT sqlite_version()").Scan(&version); err != nil {
-		t.Fatalf("could not determine SQLite version: %+v", err)
+	tplDB, err := tpl.connect()
+	if err != nil {
+		t.Fatalf("could not open template database: %+v", err)
 	}
+	defer tplDB.Close()
 
-	if semver.Compare("v"+version, minVersion) < 0 {
-		t.Fatalf("SQLite version too old (found v%s, minimium required %s)", version, minVersion)
+	if err := cloneInto(ctx, tplDB, *tpl, tplCopy, o); err != nil {
+		t.Fatalf("could not copy template database: %+v", err)
 	}
 
-	instance, err := createInstance(ctx, tplDB, *tpl)
+	t.Cleanup(func() {
+		if t.Failed() {
+			return
+		}
+
+		removeDatabase(tplCopy.Database)
+	})
+}
+
+// create contains the implementation of [New] and [Custom], and is responsible
+// for actually creating the instance database to be used by a testcase.
+func create(t testing.TB, config Config, migrator Migrator, o *options) (*Config, *sql.DB) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// A missing driver is reported up front, rather than as a failure to
+	// create the template.
+	driver, err := resolveDriver(config)
 	if err != nil {
-		t.Fatalf("could not create instance: %+v", err)
+		t.Fatalf("%+v", err)
 	}
+	config.Driver = driver
 
-	t.Logf("sqlitestdb: %s", instance.URI())
-
-	if err := tplDB.Close(); err != nil {
-		t.Fatalf("could not close template DB: %+v", err)
+	o.logf = t.Logf
+	instance, err := instantiate(ctx, t.Name(), config, migrator, o)
+	if err != nil {
+		t.Fatalf("%+v", err)
 	}
 
+	logInstance(t, o, "sqlitestdb: "+instance.URI())
+
 	db, err := instance.Connect()
 	if err != nil {
-		t.Fatalf("could not connect to instance database: %+v", err)
+		instances.Store(instance.Database, instanceKept)
+		t.Fatalf("could not connect to instance database: %+v", errtrace.Errorf("%w: %w", ErrDriverOpen, err))
 	}
+	limitPool(db)
+	trackDB(db, instance)
 
 	t.Cleanup(func() {
-		if err := db.Close(); err != nil {
-			t.Fatalf("could not close instance database %q: %+v", instance.Database, err)
-		}
-
-		if t.Failed() {
-			return
+		// Custom closes db before returning, and only the test's own
+		// connections, closed by now, remain.
+		if _, open := openDBs.Load(db); open {
+			if err := closeDB(db); err != nil {
+				t.Fatalf("could not close instance database %q: %+v", instance.Database, err)
+			}
 		}
 
-		os.Remove(instance.Database)
+		removeInstance(t, instance.Database)
 	})
 
+	if err := o.seed(ctx, db); err != nil {
+		t.Fatalf("seed failed for instance database %q: %+v", instance.Database, err)
+	}
+
 	return instance, db
 }
 
+// instantiate gets-or-creates the template for the config and migrator, and
+// clones it into a new instance database. Unlike [create], it does not register
+// any cleanup, which is left to the caller.
+func instantiate(ctx context.Context, name string, config Config, migrator Migrator, o *options) (_ *Config, err error) {
+	defer func() { err = fdError(err) }()
+
+	if instance := takePooled(name, config, migrator, o); instance != nil {
+		return instance, nil
+	}
+
+	tpl, err := loadTemplate(ctx, config, migrator, o)
+	if err != nil {
+		return nil, errtrace.Errorf("could not create template database: %w", err)
+	}
+
+	instance, err := cloneTemplate(ctx, name, config, *tpl, o)
+	if err == nil {
+		return instance, nil
+	}
+
+	// The template may have been corrupted after it was validated, such as by a
+	// crashed process or a full disk. If so, it is rebuilt, and the clone is
+	// attempted once more. If another caller already rebuilt it, the clone is
+	// only attempted again. The invalid template is removed, with a warning,
+	// once it is loaded again.
+	key := templateKey(tpl.config.templateDriver(), tpl.hash, o)
+	if cached, _ := templates.Get(key); cached == tpl {
+		checkErr := checkTemplate(ctx, tpl.config, tpl.hash)
+		if checkErr == nil || isBusy(checkErr) || ctx.Err() != nil {
+			return nil, errtrace.Wrap(err)
+		}
+		counterFor(key, tpl.hash).rebuilt(errtrace.Errorf("could not clone template %q: %w", tpl.config.Database, errors.Join(err, checkErr)))
+		templates.Forget(key)
+	}
+
+	rebuild := *o
+	rebuild.uncounted = true
+	if tpl, err = loadTemplate(ctx, config, migrator, &rebuild); err != nil {
+		return nil, errtrace.Errorf("could not rebuild template database: %w", err)
+	}
+
+	return errtrace.Wrap2(cloneTemplate(ctx, name, config, *tpl, o))
+}
+
+// cloneTemplate clones the template into a new instance database.
+func cloneTemplate(ctx context.Context, name string, config Config, tpl templateState, o *options) (*Config, error) {
+	tplDB, err := tpl.connect()
+	if err != nil {
+		return nil, errtrace.Errorf("could not open template datbase: %w", err)
+	}
+	defer tplDB.Close()
+
+	dir := tpl.dir
+	if o.instanceDir != "" {
+		dir = o.instanceDir
+	}
+
+	var instance *Config
+	switch {
+	case hasScheme(config.Database):
+		err = errtrace.Errorf("instance databases are local files, but the database is the URL %q, not a path", config.Database)
+	case config.Database != "":
+		instance, err = createInstanceAt(ctx, tplDB, tpl, config.Database, o)
+	case o.deterministic:
+		path := filepath.Join(dir, instanceFileName(tpl.hash, name, deterministicID(o.nameSeed, tpl.hash, name)))
+		// The instance of a previous run of the same test may have been left
+		// behind, if it failed.
+		if err = removeDatabase(path); err == nil {
+			instance, err = createInstanceAt(ctx, tplDB, tpl, path, o)
+		}
+	default:
+		instance, err = createInstance(ctx, tplDB, tpl, dir, name, o)
+	}
+	if err != nil {
+		return nil, errtrace.Errorf("could not create instance: %w", err)
+	}
+
+	if err := tplDB.Close(); err != nil {
+		discardInstance(instance.Database)
+		return nil, errtrace.Errorf("could not close template DB: %w", err)
+	}
+
+	return instance, nil
+}
+
 // templateState keeps the state of a single template, so that each program only
 // attempts to create and migrate the template at most once.
 type templateState struct {
-	config Config
-	hash   string
+	config  Config
+	hash    string
+	dir     string
+	pragmas templatePragmas
+	// created is true if the template was migrated by this program, instead
+	// of reused from a previous run or another process.
+	created bool
 }
 
 var templates = once.NewMap[string, templateState]()
 
+// connect opens the template for cloning. Templates are never written to once
+// they have been created and validated, so the connection is opened with
+// "mode=ro&immutable=1", which doesn't take any locks, and never contends with
+// other processes cloning the same template.
+func (tpl templateState) connect() (*sql.DB, error) {
+	db, err := tpl.config.open(tpl.config.URI() + "?mode=ro&immutable=1")
+	if err != nil {
+		return nil, errtrace.Errorf("%w: %w", ErrDriverOpen, err)
+	}
+	return db, nil
+}
+
+// privateTempDir creates a directory only accessible by the current user, at
+// most once per program execution, for templates created with
+// [WithUntrustedTempDir].
+var privateTempDir = sync.OnceValues(func() (string, error) {
+	return errtrace.Wrap2(os.MkdirTemp("", "sqlitestdb_"))
+})
+
+// sqliteEngine describes the SQLite library used by a driver.
+type sqliteEngine struct {
+	version        string   // The result of sqlite_version().
+	options        string   // A digest of the results of PRAGMA compile_options.
+	compileOptions []string // The sorted results of PRAGMA compile_options.
+}
+
+// tag identifies the engine in the file names of templates, so that templates
+// created by another version, or build, of SQLite are not reused.
+func (e *sqliteEngine) tag() string {
+	return e.version + "-" + e.options
+}
+
+// engines caches the SQLite library used by each driver.
+var engines = once.NewMap[string, sqliteEngine]()
+
+// engineFor describes the SQLite library used by the driver of config, querying
+// it at most once per program execution, from an in-memory database. It fails
+// if the version is older than minVersion, before any template is created with
+// the driver.
+func engineFor(ctx context.Context, config Config) (*sqliteEngine, error) {
+	return errtrace.Wrap2(engines.Set(config.Driver, func() (*sqliteEngine, error) {
+		db, err := config.open(":memory:")
+		if err != nil {
+			return nil, errtrace.Errorf("%w: %w", ErrDriverOpen, err)
+		}
+		defer db.Close()
+
+		var engine sqliteEngine
+		if err := db.QueryRowContext(ctx, "SELECT sqlite_version()").Scan(&engine.version); err != nil {
+			return nil, errtrace.Errorf("could not determine SQLite version: %w", err)
+		}
+
+		if semver.Compare("v"+engine.version, minVersion) < 0 {
+			return nil, errtrace.Wrap(&SQLiteVersionError{Found: "v" + engine.version, Minimum: minVersion})
+		}
+
+		engine.compileOptions, err = readCompileOptions(ctx, db)
+		if err != nil {
+			return nil, errtrace.Errorf("could not determine SQLite compile options: %w", err)
+		}
+
+		sum := sha256.Sum256([]byte(strings.Join(engine.compileOptions, "\n")))
+		engine.options = hex.EncodeToString(sum[:4])
+
+		return &engine, nil
+	}))
+}
+
+// templateKey returns the key of a template in the templates map. Templates
+// are specific to the driver that created them, as drivers differ in their
+// defaults, and templates created in a private directory must not be shared
+// with those created in the shared temporary directory.
+func templateKey(driver, mhash string, o *options) string {
+	key := driver + ":" + mhash
+	if o.untrustedTempDir {
+		return "untrusted:" + key
+	}
+
+	return key
+}
+
+// templateFileName returns the file name of the template with the hash, created
+// by the driver using the SQLite engine. Upgrading the driver, or switching to
+// another one, changes the name, so that a fresh template is created instead of
+// reusing one that was created by another engine, whose behavior may differ.
+func templateFileName(mhash, driver string, engine *sqliteEngine) string {
+	return "sqlitestdb_tpl_" + mhash + "_" + sanitizeName(driver) + "_" + engine.tag() + ".sqlite"
+}
+
+// loadTemplate is like getOrCreateTemplate, but also handles a template that
+// was removed after it was cached. Temporary directory reapers, such as
+// systemd-tmpfiles, remove files that haven't been modified in a while, even
+// while a long-running test binary is still using them. The template is touched
+// each time it is used, so that it remains fresh, and if it is already gone, it
+// is rebuilt once.
+func loadTemplate(ctx context.Context, config Config, migrator Migrator, o *options) (*templateState, error) {
+	tpl, err := getOrCreateTemplate(ctx, config, migrator, o)
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+
+	now := time.Now()
+	if err := os.Chtimes(tpl.config.Database, now, now); !errors.Is(err, fs.ErrNotExist) {
+		return tpl, nil
+	}
+
+	templates.Forget(templateKey(tpl.config.templateDriver(), tpl.hash, o))
+	rebuild := *o
+	rebuild.uncounted = true
+	return errtrace.Wrap2(getOrCreateTemplate(ctx, config, migrator, &rebuild))
+}
+
 // getOrCreateTemplate will get-or-create a template, synchronizing calls using the
 // templates map, so that each template is get-or-created at most once.
 //
 // If there was an error during template creation an error will be returned by
 // the inner function, which will cause the error to be returned to all callers
 // during this program's execution.
-func getOrCreateTemplate(ctx context.Context, config Config, migrator Migrator) (*templateState, error) {
-	mhash, err := migrator.Hash()
+func getOrCreateTemplate(ctx context.Context, config Config, migrator Migrator, o *options) (*templateState, error) {
+	driver, err := resolveDriver(config)
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+	config.Driver = driver
+
+	engine, err := engineFor(ctx, config)
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+
+	mhash, err := TemplateHash(migrator)
 	if err != nil {
 		return nil, err
 	}
 
-	return errtrace.Wrap2(templates.Set(mhash, func() (*templateState, error) {
+	key := templateKey(config.templateDriver(), mhash, o)
+	counter := counterFor(key, mhash)
+
+	// The lookup is a cache hit unless it is the one creating the template.
+	hit := true
+	state, err := templates.Set(key, func() (*templateState, error) {
+		hit = false
+		if config.Key != "" {
+			if err := checkCipher(ctx, config); err != nil {
+				counter.fail(err)
+				return nil, errtrace.Wrap(err)
+			}
+		}
+
 		tpl := templateState{}
+		tpl.dir = os.TempDir()
+		if o.untrustedTempDir {
+			dir, err := privateTempDir()
+			if err != nil {
+				return nil, errtrace.Wrap(err)
+			}
+			tpl.dir = dir
+		}
+
 		tpl.config = config
-		tpl.config.Database = filepath.Join(os.TempDir(), "sqlitestdb_tpl_"+mhash+".sqlite")
+		tpl.config.Database = filepath.Join(tpl.dir, templateFileName(mhash, config.templateDriver(), engine))
 		tpl.hash = mhash
 
-		if _, err := os.Stat(tpl.config.Database); err == nil {
-			return &tpl, nil
+		activeTemplates.Store(tpl.config.Database, struct{}{})
+		sweep()
+
+		// As the templates map guarantees this function runs at most once per
+		// template, only the first caller in this program removes the template.
+		if o.forceRebuild {
+			if err := removeDatabase(tpl.config.Database); err != nil {
+				return nil, errtrace.Wrap(err)
+			}
 		}
 
-		if err := ensureTemplate(ctx, tpl.config, migrator); err != nil {
-			os.Remove(tpl.config.Database)
+		created, err := awaitTemplate(ctx, tpl.config, mhash, migrator, counter, o)
+		if err != nil {
+			err = &TemplateError{Path: tpl.config.Database, Err: err}
+			counter.fail(err)
 			return nil, errtrace.Wrap(err)
 		}
 
+		// Like the first clone, see [vacuumInto], reading the settings of a
+		// newly created template may find it still locked.
+		err = retryBusy(ctx, o, func() error {
+			var err error
+			tpl.pragmas, err = readPragmas(ctx, tpl.config)
+			return errtrace.Wrap(err)
+		})
+		if err != nil {
+			err = errtrace.Errorf("could not read settings of template %q: %w", tpl.config.Database, err)
+			counter.fail(err)
+			return nil, err
+		}
+
+		counter.record(tpl.config.Database, created)
+		tpl.created = created
 		return &tpl, nil
-	}))
+	})
+	if err == nil && hit && !o.uncounted {
+		counter.cacheHits.Add(1)
+	}
+
+	return state, errtrace.Wrap(err)
+}
+
+// awaitTemplate reuses an existing template database, or creates it using the
+// migrator. Creating a template is guarded by an inter-process lock, see
+// [lockTemplate]. If another process is creating the same template at the same
+// time, awaitTemplate waits for the other process to finish, until ctx is done,
+// before reusing its template. Reading a template another process still holds
+// locked, which fails with SQLITE_BUSY, is retried with backoff for up to the
+// busy timeout. It reports whether the template was created by this call.
+//
+// An existing template that fails [checkTemplate], because it is corrupt or
+// wasn't created for the migrator hash, or that the migrator rejects, see
+// [Verifier], is removed and created again. The rebuild is recorded in counter,
+// for [VerifyShutdown].
+func awaitTemplate(ctx context.Context, config Config, mhash string, migrator Migrator, counter *templateCounter, o *options) (created bool, err error) {
+	deadline := time.Now().Add(o.busyTimeout)
+	backoff := 10 * time.Millisecond
+
+	for {
+		created = false
+		if _, statErr := os.Stat(config.Database); statErr == nil {
+			err = checkTemplate(ctx, config, mhash)
+			if err == nil {
+				err = verifyTemplate(ctx, config, migrator)
+			}
+			if err != nil && !isBusy(err) && ctx.Err() == nil {
+				o.warnf("sqlitestdb: rebuilding invalid template %q: %v", config.Database, err)
+				counter.rebuilt(errtrace.Errorf("invalid template %q: %w", config.Database, err))
+				if err := removeDatabase(config.Database); err != nil {
+					return false, errtrace.Errorf("could not remove invalid template %q: %w", config.Database, err)
+				}
+				continue
+			}
+		} else {
+			// The lock is held for as long as another process takes to create
+			// the template, however long its migrations run.
+			unlock, lockErr := lockTemplate(ctx, config.Database)
+			if lockErr != nil {
+				return false, errtrace.Errorf("could not lock template %q: %w", config.Database, lockErr)
+			}
+
+			// Another process may have finished creating the template
+			// before the lock was taken.
+			if _, statErr := os.Stat(config.Database); statErr == nil {
+				unlock()
+				continue
+			}
+
+			var release func()
+			release, err = acquireBuild(ctx)
+			if err == nil {
+				created, err = ensureTemplate(ctx, config, mhash, migrator, o)
+				release()
+			}
+			unlock()
+		}
+
+		if !isBusy(err) {
+			return created, errtrace.Wrap(err)
+		}
+
+		if time.Now().Add(backoff).After(deadline) {
+			return false, errtrace.Errorf("timed out after %s waiting for template %q, locked by another process: %w", o.busyTimeout, config.Database, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return false, errtrace.Wrap(ctx.Err())
+		case <-time.After(backoff):
+		}
+
+		backoff = min(2*backoff, time.Second)
+	}
 }
 
-// ensureTemplate creates a template database using the config and migrator. If there
-// was an error during creation it will be returned.
-func ensureTemplate(ctx context.Context, config Config, migrator Migrator) error {
+// checkTemplate verifies an existing template database can be read, which fails
+// with SQLITE_BUSY while another process holds the exclusive lock taken by
+// [ensureTemplate]. It also verifies the template passes "PRAGMA quick_check",
+// and that it is stamped with the application ID of sqlitestdb, and its marker
+// table records the migrator hash, as files left behind by crashed processes or
+// other tools may be anything.
+//
+// A write-ahead log left next to the template, such as by a reader that
+// crashed, is checkpointed into it, as the template is cloned with
+// "immutable=1", which ignores the log.
+//
+// Reusing a template refreshes its modification time, so that [CleanTemplates]
+// doesn't consider templates that are still in use to be stale.
+func checkTemplate(ctx context.Context, config Config, mhash string) error {
+	// Reading a template in WAL mode creates the log, so check beforehand.
+	_, walErr := os.Stat(config.Database + "-wal")
+
 	db, err := config.Connect()
 	if err != nil {
 		return errtrace.Wrap(err)
 	}
 	defer db.Close()
 
+	var count int
+	if err := db.QueryRowContext(ctx, "SELECT count(*) FROM sqlite_master").Scan(&count); err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	if walErr == nil {
+		if err := checkpoint(ctx, db); err != nil {
+			return errtrace.Wrap(err)
+		}
+	}
+
+	var check string
+	if err := db.QueryRowContext(ctx, "PRAGMA quick_check").Scan(&check); err != nil {
+		return errtrace.Wrap(err)
+	}
+	if check != "ok" {
+		return errtrace.Errorf("template %q failed quick_check: %s", config.Database, check)
+	}
+
+	var id int
+	if err := db.QueryRowContext(ctx, "PRAGMA application_id").Scan(&id); err != nil {
+		return errtrace.Wrap(err)
+	}
+	if id != applicationID {
+		return errtrace.Errorf("template %q has application ID %d, not the one of sqlitestdb", config.Database, id)
+	}
+
+	var hash string
+	if err := db.QueryRowContext(ctx, "SELECT hash FROM "+markerTable).Scan(&hash); err != nil {
+		return errtrace.Errorf("template %q has no marker: %w", config.Database, err)
+	}
+	if hash != mhash {
+		return errtrace.Errorf("template %q was created for hash %q, not %q", config.Database, hash, mhash)
+	}
+
+	now := time.Now()
+	os.Chtimes(config.Database, now, now)
+	return nil
+}
+
+// verifyTemplate runs the Verify method of the migrator against an existing
+// template database, if the migrator is a [Verifier].
+func verifyTemplate(ctx context.Context, config Config, migrator Migrator) error {
+	verifier, ok := migrator.(Verifier)
+	if !ok {
+		return nil
+	}
+
+	db, err := config.Connect()
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+	defer db.Close()
+
+	if err := verifier.Verify(ctx, db); err != nil {
+		return errtrace.Errorf("template %q failed verification: %w", config.Database, err)
+	}
+	return nil
+}
+
+// closeDB closes a database handed out to a test. Tests often close the handle
+// themselves, so an error reporting the database as already closed is treated
+// as success.
+func closeDB(db *sql.DB) error {
+	openDBs.Delete(db)
+	if err := db.Close(); err != nil && !isClosed(err) {
+		return errtrace.Wrap(err)
+	}
+
+	return nil
+}
+
+// isClosed reports whether err was caused by using a connection or database
+// that was already closed. [sql.DB.Close] is idempotent, but the drivers
+// report closing their connections or statements more than once differently.
+func isClosed(err error) bool {
+	if errors.Is(err, sql.ErrConnDone) || errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+
+	msg := err.Error()
+	return strings.Contains(msg, "database is closed") ||
+		strings.Contains(msg, "already closed")
+}
+
+// isBusy reports whether err was caused by SQLITE_BUSY or SQLITE_LOCKED. As
+// sqlitestdb doesn't depend on any specific driver, this matches the error
+// messages used by SQLite itself, which all the drivers pass through.
+func isBusy(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	msg := err.Error()
+	return strings.Contains(msg, "database is locked") ||
+		strings.Contains(msg, "database table is locked") ||
+		strings.Contains(msg, "SQLITE_BUSY")
+}
+
+// markerTable is the table added to each template after its migrations, that
+// records the migrator hash, so that [checkTemplate] can tell a complete template
+// apart from any other file at its path, and the rest of its [Meta]. As
+// instances are clones of the template, the table is also present in every
+// instance database.
+const markerTable = "sqlitestdb_meta"
+
+// tempTemplateSuffix is appended to the path of a template, followed by the
+// process ID, while the template is being migrated by [ensureTemplate].
+const tempTemplateSuffix = ".tmp."
+
+// ensureTemplate creates a template database using the config and migrator. The
+// migrations are run on a temporary file, which is only renamed to the path of
+// the template once it has been migrated and marked as complete, so that a
+// process killed part way through never leaves a half-migrated template
+// behind. If another process finished creating the template first, the
+// temporary file is discarded, and ensureTemplate reports the template as not
+// created. If there was an error during creation it will be returned.
+func ensureTemplate(ctx context.Context, config Config, mhash string, migrator Migrator, o *options) (created bool, err error) {
+	tmp := config
+	tmp.Database = config.Database + tempTemplateSuffix + strconv.Itoa(os.Getpid())
+
+	// A previous process with the same ID may have left its file behind.
+	if err := removeDatabase(tmp.Database); err != nil {
+		return false, errtrace.Wrap(err)
+	}
+	defer func() {
+		if created {
+			return
+		}
+		if err := removeDatabase(tmp.Database); err != nil {
+			o.warnf("sqlitestdb: could not remove failed template %q: %v", tmp.Database, err)
+		}
+	}()
+
+	ctx, p := startProgress(ctx, mhash, o)
+	err = migrateTemplate(ctx, tmp, mhash, migrator, o)
+	p.finish(err)
+	if err != nil {
+		return false, errtrace.Wrap(err)
+	}
+
+	// The write-ahead log was checkpointed into the database, so that the
+	// template is complete without its sidecar files, which aren't renamed.
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(tmp.Database + suffix); err != nil && !os.IsNotExist(err) {
+			return false, errtrace.Wrap(err)
+		}
+	}
+
+	if _, err := os.Lstat(config.Database); err == nil {
+		return false, nil
+	}
+
+	if err := os.Rename(tmp.Database, config.Database); err != nil {
+		return false, errtrace.Wrap(err)
+	}
+
+	return true, nil
+}
+
+// migrateTemplate runs the migrator on a new database, marks it as a complete
+// template, and checkpoints its write-ahead log, if any.
+func migrateTemplate(ctx context.Context, config Config, mhash string, migrator Migrator, o *options) error {
+	migrateCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if o.migrateTimeout > 0 {
+		migrateCtx, cancel = context.WithTimeout(migrateCtx, o.migrateTimeout)
+		defer cancel()
+	}
+
+	// Migrators may run statements without a context, so the statements are
+	// interrupted once migrateCtx is done, see [openInterruptible].
+	db, err := openInterruptible(migrateCtx, config)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+	defer db.Close()
+
 	// As SQLite doesn't have advisory locks, the best we can do is enable
 	// exclusive [locking-mode], which will prevent reads and writes from other
-	// processes.
+	// processes. The lock is held only by this connection, and released once
+	// it is closed at the end of the migration.
 	//
 	// Note that taking the exclusive lock requires a write, so this still allows
 	// migrations which exec another program to succeed.
@@ -214,50 +986,382 @@ func ensureTemplate(ctx context.Context, config Config, migrator Migrator) error
 		return errtrace.Wrap(err)
 	}
 
-	if err := migrator.Migrate(ctx, db, config); err != nil {
+	migrate := migrator.Migrate
+	if o.txMigrations {
+		migrate = func(ctx context.Context, db *sql.DB, config Config) error {
+			return errtrace.Wrap(migrateInTx(ctx, db, config, migrator, o))
+		}
+	}
+
+	if err := migrate(migrateCtx, db, config); err != nil {
+		if errors.Is(migrateCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+			return errtrace.Errorf("migration of template with hash %q timed out after %s: %w", mhash, o.migrateTimeout, err)
+		}
+		return errtrace.Errorf("migration failed: %w", err)
+	}
+
+	if o.foreignKeyCheck {
+		if err := checkForeignKeys(ctx, db); err != nil {
+			return errtrace.Wrap(err)
+		}
+	}
+
+	if err := markTemplate(ctx, db, config.Driver, mhash); err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	if err := checkpoint(ctx, db); err != nil {
 		return errtrace.Wrap(err)
 	}
 
+	return errtrace.Wrap(db.Close())
+}
+
+// checkpoint copies all the pages in the write-ahead log of a database in WAL
+// mode into the database, and truncates the log, so that the database file is
+// complete on its own. It does nothing for databases in other journal modes.
+// If another connection prevents the checkpoint, it fails with an error that
+// [isBusy] recognizes.
+func checkpoint(ctx context.Context, db *sql.DB) error {
+	var busy, log, checkpointed int
+	row := db.QueryRowContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)")
+	if err := row.Scan(&busy, &log, &checkpointed); err != nil {
+		return errtrace.Wrap(err)
+	}
+	if busy != 0 {
+		return errtrace.New("could not checkpoint write-ahead log: database is locked")
+	}
+
 	return nil
 }
 
-// createInstance creates a new test database by cloning a template.
-func createInstance(ctx context.Context, baseDB *sql.DB, template templateState) (*Config, error) {
-	baseConn, err := baseDB.Conn(ctx)
+// checkForeignKeys fails if any row of the database violates a foreign key
+// constraint, listing every violation. "PRAGMA foreign_key_check" checks the
+// constraints even if they aren't enforced, as the "foreign_keys" pragma is off
+// by default, and only applies to the connection it was set on.
+func checkForeignKeys(ctx context.Context, db *sql.DB) error {
+	rows, err := db.QueryContext(ctx, "PRAGMA foreign_key_check")
 	if err != nil {
+		return errtrace.Wrap(err)
+	}
+	defer rows.Close()
+
+	var violations []string
+	for rows.Next() {
+		var table, parent string
+		var rowid sql.NullInt64
+		var fkid int
+		if err := rows.Scan(&table, &rowid, &parent, &fkid); err != nil {
+			return errtrace.Wrap(err)
+		}
+
+		row := "without rowid"
+		if rowid.Valid {
+			row = "rowid " + strconv.FormatInt(rowid.Int64, 10)
+		}
+		violations = append(violations, fmt.Sprintf("%s (%s) references a missing row of %s", table, row, parent))
+	}
+	if err := rows.Err(); err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	if len(violations) > 0 {
+		return errtrace.Errorf("foreign key check failed for %d rows:\n\t%s", len(violations), strings.Join(violations, "\n\t"))
+	}
+	return nil
+}
+
+// maxNameAttempts is how many random names createInstance tries before giving
+// up, if files left behind by earlier runs already use them.
+const maxNameAttempts = 5
+
+// createInstance creates a new test database in dir by cloning a template. The
+// name of the test is included in the name of the file, to make it easier to
+// find the database of a failed test.
+//
+// Instance databases of failed tests, and of crashed processes, outlive the run
+// that created them, so the randomly generated name may already be in use. The
+// existing file may belong to a test that is still running in another process,
+// so it is never removed, and another name is generated instead.
+func createInstance(ctx context.Context, baseDB *sql.DB, template templateState, dir, name string, o *options) (*Config, error) {
+	for attempt := 0; attempt < maxNameAttempts; attempt++ {
+		id, err := uniqueID()
+		if err != nil {
+			return nil, errtrace.Wrap(err)
+		}
+
+		instance, err := createInstanceAt(ctx, baseDB, template, filepath.Join(dir, instanceFileName(template.hash, name, id)), o)
+		if !errors.Is(err, errInstanceExists) {
+			return instance, errtrace.Wrap(err)
+		}
+	}
+
+	return nil, errtrace.Errorf("could not find an unused name for the instance database in %q after %d attempts, consider removing old instance databases", dir, maxNameAttempts)
+}
+
+// errInstanceExists is returned by createInstanceAt if a file already exists at
+// the path of the instance database.
+var errInstanceExists = errors.New("instance database already exists")
+
+// createInstanceAt creates a new test database at path by cloning a template.
+// Unlike "VACUUM INTO", which accepts an existing empty file, it fails if
+// anything exists at path.
+func createInstanceAt(ctx context.Context, baseDB *sql.DB, template templateState, path string, o *options) (*Config, error) {
+	if _, err := os.Lstat(path); err == nil {
+		return nil, errtrace.Errorf("%w: %q", errInstanceExists, path)
+	} else if !errors.Is(err, fs.ErrNotExist) {
 		return nil, errtrace.Wrap(err)
 	}
-	defer baseConn.Close()
 
-	id, err := randomID()
+	baseConn, err := baseDB.Conn(ctx)
 	if err != nil {
 		return nil, errtrace.Wrap(err)
 	}
+	defer baseConn.Close()
 
 	testConfig := template.config
-	testConfig.Database = filepath.Join(os.TempDir(), "sqlitestdb_tpl_"+template.hash+"_inst_"+id+".sqlite")
+	testConfig.Database = path
+
+	if err := cloneInto(ctx, baseDB, template, testConfig, o); err != nil {
+		// The path didn't exist before, so whatever was written is removed,
+		// for the clone to be attempted again.
+		_ = removeDatabase(path)
+		return nil, errtrace.Errorf("%w: %w", ErrClone, err)
+	}
+	instances.Store(testConfig.Database, instanceLive)
 
+	return &testConfig, nil
+}
+
+// cloneInto clones the template database opened as baseDB into the instance
+// database, which must not exist yet, using the strategy set by
+// [WithCloneStrategy], and applies the settings of the template to it.
+func cloneInto(ctx context.Context, baseDB *sql.DB, template templateState, instance Config, o *options) error {
+	if err := cloneWith(ctx, baseDB, template, instance, o); err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	if err := applyPragmas(ctx, instance, template.pragmas); err != nil {
+		removeDatabase(instance.Database)
+		return errtrace.Errorf("could not apply the settings of the template: %w", err)
+	}
+
+	return nil
+}
+
+// cloneWith clones the template with the strategy set by [WithCloneStrategy].
+func cloneWith(ctx context.Context, baseDB *sql.DB, template templateState, instance Config, o *options) error {
+	switch o.cloneStrategy {
+	case "", CloneVacuum:
+	case CloneCopy:
+		// Templates are checkpointed once created, but another process
+		// checking a template in WAL mode creates its write-ahead log while it
+		// reads, so the file is only copied, or reflinked, without one.
+		if _, err := os.Stat(template.config.Database + "-wal"); errors.Is(err, fs.ErrNotExist) {
+			return errtrace.Wrap(copyFile(instance.Database, template.config.Database))
+		}
+	case CloneReflink:
+		if _, err := os.Stat(template.config.Database + "-wal"); errors.Is(err, fs.ErrNotExist) {
+			ok, err := reflink(instance.Database, template.config.Database)
+			if ok || err != nil {
+				return errtrace.Wrap(err)
+			}
+		}
+	case CloneBackup:
+		ok, err := backupInto(ctx, baseDB, instance)
+		if err != nil {
+			removeDatabase(instance.Database)
+		}
+		if ok || err != nil {
+			return errtrace.Wrap(err)
+		}
+	default:
+		return errtrace.Errorf("unknown clone strategy %q", o.cloneStrategy)
+	}
+
+	return errtrace.Wrap(vacuumInto(ctx, baseDB, template, instance, o))
+}
+
+// vacuumInto clones the template database opened as baseDB into the instance
+// database with "VACUUM INTO".
+func vacuumInto(ctx context.Context, baseDB *sql.DB, template templateState, instance Config, o *options) error {
 	// Since we can be reasonably sure the template database is free of any transactions
 	// at this point, we can use the "VACUUM INTO" statement to create a new database.
-	// This allows us to avoid the Online Backup API, which would require separate
-	// implementations for github.com/mattn/go-sqlite3 and modernc.org/sqlite, as the
-	// backup API requires acquiring the raw driver connection.
-	if _, err := baseDB.ExecContext(ctx, "VACUUM INTO ?", testConfig.URI()); err != nil {
-		return nil, errtrace.Wrap(err)
+	// Unlike the Online Backup API, used by [CloneBackup], this works the same
+	// for every driver, without acquiring the raw driver connection.
+	//
+	// Some drivers, such as libsql, release the exclusive lock taken by
+	// [ensureTemplate] only shortly after the connection is closed, so the first
+	// clone of a newly created template may find it busy, and is retried.
+	//
+	// The page size of the instance is the one set on the connection running
+	// the VACUUM, if any, so it is set to the template's on the same connection.
+	//
+	// With SQLCipher, the database written by "VACUUM INTO" is encrypted with
+	// the key of the template. The instance is then opened with the key to
+	// apply the settings of the template, which fails if it wasn't.
+	return errtrace.Wrap(retryBusy(ctx, o, func() error {
+		err := vacuumOnce(ctx, baseDB, template, instance)
+		if err != nil {
+			removeDatabase(instance.Database)
+		}
+		return errtrace.Wrap(err)
+	}))
+}
+
+// vacuumOnce runs "VACUUM INTO" on a single connection to the template.
+func vacuumOnce(ctx context.Context, baseDB *sql.DB, template templateState, instance Config) error {
+	conn, err := baseDB.Conn(ctx)
+	if err != nil {
+		return errtrace.Wrap(err)
 	}
+	defer conn.Close()
 
-	return &testConfig, nil
+	if template.pragmas.pageSize != 0 {
+		if _, err := conn.ExecContext(ctx, "PRAGMA page_size="+strconv.Itoa(template.pragmas.pageSize)); err != nil {
+			return errtrace.Wrap(err)
+		}
+	}
+
+	_, err = conn.ExecContext(ctx, "VACUUM INTO ?", instance.URI())
+	return errtrace.Wrap(err)
+}
+
+// retryBusy calls fn until it succeeds, or fails with an error other than
+// SQLITE_BUSY or SQLITE_LOCKED, waiting with exponential backoff between
+// attempts. It gives up after the number of attempts set by [WithCloneRetries],
+// or once ctx is done.
+func retryBusy(ctx context.Context, o *options, fn func() error) error {
+	backoff := o.cloneRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if !isBusy(err) {
+			return err
+		}
+		if attempt >= o.cloneAttempts {
+			return errtrace.Errorf("gave up after %d attempts: %w", attempt, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return errtrace.Errorf("gave up after %d attempts: %w", attempt, errors.Join(err, ctx.Err()))
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// removeDatabase removes the database file at path, along with any of its
+// write-ahead log, shared-memory, and rollback journal sidecar files. Files
+// that don't exist are ignored. All the files are attempted, even if removing
+// one of them failed.
+func removeDatabase(path string) error {
+	var errs []error
+	for _, suffix := range []string{"", "-wal", "-shm", "-journal"} {
+		if err := removeFile(path + suffix); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errtrace.Wrap(errors.Join(errs...))
+}
+
+// removeAttempts is how many times removeFile tries to remove a file.
+const removeAttempts = 5
+
+// removeFile removes the file at path, unless it doesn't exist. On Windows,
+// files can't be removed while they are open, and some drivers finalize their
+// connections shortly after they are closed, so the removal is retried a few
+// times, with backoff.
+func removeFile(path string) error {
+	delay := 10 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		err := os.Remove(path)
+		if err == nil || os.IsNotExist(err) {
+			return nil
+		}
+		if attempt == removeAttempts {
+			return errtrace.Wrap(err)
+		}
+
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// maxNameLength caps the length of the test name included in the names of
+// instance databases, keeping them below the file name limit of common
+// filesystems.
+const maxNameLength = 64
+
+// instanceFileName returns the file name of an instance database, including the
+// sanitized test name, if any, and the unique id.
+func instanceFileName(hash, name, id string) string {
+	if name = sanitizeName(name); name != "" {
+		id = name + "_" + id
+	}
+
+	return "sqlitestdb_tpl_" + hash + "_inst_" + id + ".sqlite"
+}
+
+// sanitizeName replaces the characters of a test name that are unsafe in file
+// names. Names longer than maxNameLength are truncated, and suffixed with a hash
+// of the full name, so that long subtest names sharing a prefix remain
+// distinguishable.
+func sanitizeName(name string) string {
+	sanitized := []byte(name)
+	for i, c := range sanitized {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		case c == '/':
+			sanitized[i] = '-'
+		default:
+			sanitized[i] = '_'
+		}
+	}
+
+	if len(sanitized) <= maxNameLength {
+		return string(sanitized)
+	}
+
+	sum := md5.Sum([]byte(name))
+	return string(sanitized[:maxNameLength-9]) + "-" + hex.EncodeToString(sum[:4])
 }
 
-// randomID is a helper for coming up with the names of the instance databases.
-// It uses 32 random bits in the name, which means collisions are unlikely.
-func randomID() (string, error) {
-	bytes := make([]byte, 4)
+// idCounter numbers the ids generated by uniqueID in this process.
+var idCounter atomic.Uint64
+
+// uniqueID is a helper for coming up with the names of the instance databases.
+// It combines the process ID and a per-process counter, so that names are
+// unique across the processes running at the same time, with 64 random bits,
+// so that names are also unlikely to collide with files left behind by earlier
+// processes that had the same process ID.
+func uniqueID() (string, error) {
+	bytes := make([]byte, 8)
 	_, err := rand.Read(bytes)
 	if err != nil {
 		return "", errtrace.Wrap(err)
 	}
-	return hex.EncodeToString(bytes), nil
+	return fmt.Sprintf("%d_%d_%s", os.Getpid(), idCounter.Add(1), hex.EncodeToString(bytes)), nil
+}
+
+// deterministicCalls counts the instances created for each test by
+// [WithDeterministicNames], keyed by seed, template hash, and test name.
+var deterministicCalls sync.Map // map[string]*atomic.Int64
+
+// deterministicID returns the id of the next instance of the test, which is the
+// same in every run of the program.
+func deterministicID(seed int64, hash, name string) string {
+	key := fmt.Sprintf("%d\x00%s\x00%s", seed, hash, name)
+	calls, _ := deterministicCalls.LoadOrStore(key, &atomic.Int64{})
+	n := calls.(*atomic.Int64).Add(1) - 1
+
+	return nthDeterministicID(key, n)
+}
+
+func nthDeterministicID(key string, n int64) string {
+	sum := md5.Sum([]byte(fmt.Sprintf("%s\x00%d", key, n)))
+	return hex.EncodeToString(sum[:4])
 }
 
 // NoopMigrator fulfills the [Migrator] interface, but it does absolutely
@@ -5,17 +5,34 @@
 package sqlitestdb_test
 
 import (
+	"bufio"
 	"context"
+	"crypto/rand"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/jmoiron/sqlx"
-	_ "github.com/mattn/go-sqlite3"
-	"github.com/peterldowns/pgtestdb/migrators/common"
+	"github.com/mattn/go-sqlite3"
 	"github.com/terinjokes/sqlitestdb"
+	"github.com/terinjokes/sqlitestdb/migrators/hash"
 	"gotest.tools/v3/assert"
-	_ "modernc.org/sqlite"
+	"modernc.org/sqlite"
 )
 
 func New(t *testing.T) *sql.DB {
@@ -74,6 +91,59 @@ func TestCustom(t *testing.T) {
 	assert.DeepEqual(t, cat, Cat{ID: 1, Name: "daisy"})
 }
 
+func TestCustomModernC(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var path string
+	t.Run("custom", func(t *testing.T) {
+		config := sqlitestdb.Custom(t, sqlitestdb.Config{Driver: "sqlite"}, defaultMigrator())
+		path = config.Database
+
+		db, err := config.Connect()
+		assert.NilError(t, err)
+		defer db.Close()
+
+		var count int
+		assert.NilError(t, db.QueryRowContext(ctx, "SELECT COUNT(*) FROM cats").Scan(&count))
+		assert.Equal(t, 2, count)
+	})
+
+	_, err := os.Stat(path)
+	assert.Assert(t, errors.Is(err, os.ErrNotExist), "instance database %q was not removed: %v", path, err)
+}
+
+func TestSidecarFilesAreRemoved(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var path string
+	var extra *sql.DB
+	t.Run("wal", func(t *testing.T) {
+		db := New(t)
+		path = sqlitestdb.ConfigFor(db).Database
+
+		var mode string
+		assert.NilError(t, db.QueryRowContext(ctx, "PRAGMA journal_mode=WAL").Scan(&mode))
+		assert.Equal(t, "wal", mode)
+		_, err := db.ExecContext(ctx, "INSERT INTO cats (name) VALUES ('mittens')")
+		assert.NilError(t, err)
+
+		// Another connection, still open when the test ends, keeps the
+		// write-ahead log from being removed when the instance is closed.
+		extra, err = sql.Open("sqlite3", "file:"+path)
+		assert.NilError(t, err)
+		assert.NilError(t, extra.PingContext(ctx))
+	})
+	assert.NilError(t, extra.Close())
+
+	matches, err := filepath.Glob(path + "*")
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(matches), "files were left behind: %v", matches)
+}
+
 func TestParallel1(t *testing.T) {
 	t.Parallel()
 
@@ -161,6 +231,69 @@ func TestDifferentHashesAlwaysResultInDifferentDatabases(t *testing.T) {
 	}
 }
 
+func TestSameHashDifferentMigratorTypes(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	nonce := make([]byte, 8)
+	_, err := rand.Read(nonce)
+	assert.NilError(t, err)
+	plain := &sqlMigrator{migrations: []string{"CREATE TABLE cats (id INTEGER PRIMARY KEY) -- " + hex.EncodeToString(nonce)}}
+	versioned := &versionedMigrator{*plain}
+
+	// The migrators return the same hash, but create different schemas.
+	plainHash, err := plain.Hash()
+	assert.NilError(t, err)
+	versionedHash, err := versioned.Hash()
+	assert.NilError(t, err)
+	assert.Equal(t, plainHash, versionedHash)
+
+	plainDB := sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, plain)
+	versionedDB := sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, versioned)
+
+	var count int
+	assert.NilError(t, versionedDB.QueryRowContext(ctx, "SELECT count(*) FROM versions").Scan(&count))
+	assert.Equal(t, 1, count)
+	err = plainDB.QueryRowContext(ctx, "SELECT count(*) FROM versions").Scan(&count)
+	assert.ErrorContains(t, err, "no such table: versions")
+
+	plainTemplate, err := sqlitestdb.TemplateHash(plain)
+	assert.NilError(t, err)
+	versionedTemplate, err := sqlitestdb.TemplateHash(versioned)
+	assert.NilError(t, err)
+	assert.Assert(t, plainTemplate != versionedTemplate)
+
+	paths := map[string]bool{}
+	for _, stats := range sqlitestdb.Stats() {
+		if stats.Hash == plainTemplate || stats.Hash == versionedTemplate {
+			paths[stats.Path] = true
+		}
+	}
+	assert.Equal(t, 2, len(paths))
+}
+
+func TestTemplateHashNamedMigrator(t *testing.T) {
+	t.Parallel()
+	m := defaultMigrator()
+
+	// Migrators with the same name and hash share their templates, whatever
+	// their types.
+	named, err := sqlitestdb.TemplateHash(namedMigrator{m, "cats"})
+	assert.NilError(t, err)
+	same, err := sqlitestdb.TemplateHash(&namedMigrator{m, "cats"})
+	assert.NilError(t, err)
+	assert.Equal(t, named, same)
+
+	other, err := sqlitestdb.TemplateHash(namedMigrator{m, "dogs"})
+	assert.NilError(t, err)
+	assert.Assert(t, named != other)
+
+	unnamed, err := sqlitestdb.TemplateHash(m)
+	assert.NilError(t, err)
+	assert.Assert(t, named != unnamed)
+}
+
 func TestWithMattnAndModernC(t *testing.T) {
 	t.Parallel()
 	mattnConfig := sqlitestdb.Config{Driver: "sqlite3"}
@@ -181,36 +314,2382 @@ func TestWithMattnAndModernC(t *testing.T) {
 	}
 }
 
-func defaultMigrator() sqlitestdb.Migrator {
-	return &sqlMigrator{
-		migrations: []string{`
-			-- the "migration"
-			CREATE TABLE cats (
-				id INTEGER PRIMARY KEY,
-				name TEXT
-			);
-			INSERT INTO cats (name) VALUES ('daisy'), ('sunny');
-        `},
+func TestTemplatesAreKeyedByDriver(t *testing.T) {
+	t.Parallel()
+
+	nonce := make([]byte, 8)
+	_, err := rand.Read(nonce)
+	assert.NilError(t, err)
+
+	m := &sqlMigrator{migrations: []string{"-- " + hex.EncodeToString(nonce)}}
+
+	var mu sync.Mutex
+	driverTypes := map[string]string{}
+	t.Run("group", func(t *testing.T) {
+		sqlitestdb.ForEachDriver(t, m, func(t *testing.T, db *sql.DB, conf sqlitestdb.Config) {
+			t.Parallel()
+			assert.Equal(t, t.Name(), "TestTemplatesAreKeyedByDriver/group/"+conf.Driver)
+			mu.Lock()
+			defer mu.Unlock()
+			driverTypes[conf.Driver] = fmt.Sprintf("%T", db.Driver())
+		})
+	})
+	assert.Equal(t, 2, len(driverTypes))
+	assert.Assert(t, driverTypes["sqlite3"] != driverTypes["sqlite"])
+
+	hash, err := sqlitestdb.TemplateHash(m)
+	assert.NilError(t, err)
+
+	paths := map[string]bool{}
+	for _, stats := range sqlitestdb.Stats() {
+		if stats.Hash == hash {
+			assert.Equal(t, sqlitestdb.TemplateCreated, stats.Source)
+			paths[stats.Path] = true
+		}
 	}
+	assert.Equal(t, 2, len(paths))
 }
 
-type sqlMigrator struct {
-	migrations []string
+func TestForEachConfig(t *testing.T) {
+	t.Parallel()
+
+	var drivers []string
+	t.Run("group", func(t *testing.T) {
+		configs := []sqlitestdb.Config{{Driver: "libsql"}, {Driver: "sqlite3"}, {Driver: "sqlite"}}
+		sqlitestdb.ForEachConfig(t, configs, defaultMigrator(), func(t *testing.T, db *sql.DB, conf sqlitestdb.Config) {
+			drivers = append(drivers, conf.Driver)
+			assert.Assert(t, conf.Database != "")
+
+			var count int
+			assert.NilError(t, db.QueryRow("SELECT COUNT(*) FROM cats").Scan(&count))
+			assert.Equal(t, 2, count)
+		})
+	})
+
+	// libsql isn't registered in this program, and is skipped.
+	assert.DeepEqual(t, []string{"sqlite3", "sqlite"}, drivers)
 }
 
-func (m *sqlMigrator) Hash() (string, error) {
-	hash := common.NewRecursiveHash()
-	for _, migration := range m.migrations {
-		hash.Add([]byte(migration))
+func TestNewTx(t *testing.T) {
+	t.Parallel()
+
+	for i := 0; i < 5; i++ {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			t.Parallel()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			tx := sqlitestdb.NewTx(t, sqlitestdb.Config{Driver: "sqlite3"}, defaultMigrator())
+
+			_, err := tx.ExecContext(ctx, "INSERT INTO cats (name) VALUES (?)", fmt.Sprintf("kitten %d", i))
+			assert.NilError(t, err)
+
+			var count int
+			err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM cats").Scan(&count)
+			assert.NilError(t, err)
+			assert.Equal(t, 3, count)
+		})
 	}
-	return hash.String(), nil
 }
 
-func (m *sqlMigrator) Migrate(ctx context.Context, db *sql.DB, _ sqlitestdb.Config) error {
-	for _, migration := range m.migrations {
-		if _, err := db.ExecContext(ctx, migration); err != nil {
-			return err
+func TestNewTxCommitFails(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dbconf := sqlitestdb.Config{Driver: "sqlite3"}
+
+	ftb := runFake(t, func(tb testing.TB) {
+		tx := sqlitestdb.NewTx(tb, dbconf, defaultMigrator())
+		_, err := tx.ExecContext(ctx, "INSERT INTO cats (name) VALUES ('mittens')")
+		assert.NilError(t, err)
+		assert.NilError(t, tx.Commit())
+	})
+	assert.ErrorContains(t, ftb.err(), "transaction was committed")
+
+	// The committed row must not leak into the next test.
+	tx := sqlitestdb.NewTx(t, dbconf, defaultMigrator())
+	var count int
+	err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM cats").Scan(&count)
+	assert.NilError(t, err)
+	assert.Equal(t, 2, count)
+}
+
+func TestConcurrentProcessesShareTemplate(t *testing.T) {
+	t.Parallel()
+	if os.Getenv("SQLITESTDB_FORCE_REBUILD") != "" {
+		t.Skip("SQLITESTDB_FORCE_REBUILD is set")
+	}
+
+	nonce := make([]byte, 8)
+	_, err := rand.Read(nonce)
+	assert.NilError(t, err)
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHelperProcess$", "-test.count=1")
+	cmd.Env = append(os.Environ(), "SQLITESTDB_HELPER_NONCE="+hex.EncodeToString(nonce))
+	stdout, err := cmd.StdoutPipe()
+	assert.NilError(t, err)
+	assert.NilError(t, cmd.Start())
+
+	// Wait for the helper process to be in the middle of migrating.
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() && scanner.Text() != "migrating" {
+	}
+	go io.Copy(io.Discard, stdout)
+
+	// The helper process holds the lock of the template, so this waits for it
+	// to finish, and reuses its template instead of migrating again. Use the
+	// modernc driver, which unlike go-sqlite3 doesn't set a default
+	// busy_timeout that would mask the template being locked.
+	m := newSlowMigrator(hex.EncodeToString(nonce), nil)
+	db := sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite"}, m)
+	assert.NilError(t, cmd.Wait())
+
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM cats").Scan(&count)
+	assert.NilError(t, err)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, int32(0), m.calls.Load())
+}
+
+// TestHelperProcess is run as a subprocess by TestConcurrentProcessesShareTemplate.
+func TestHelperProcess(t *testing.T) {
+	nonce := os.Getenv("SQLITESTDB_HELPER_NONCE")
+	if nonce == "" {
+		t.Skip("only run as a helper process")
+	}
+
+	m := newSlowMigrator(nonce, os.Stdout)
+	_ = sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite"}, m)
+	assert.Equal(t, int32(1), m.calls.Load())
+}
+
+func TestConcurrentProcessesClone(t *testing.T) {
+	t.Parallel()
+
+	nonce := make([]byte, 8)
+	_, err := rand.Read(nonce)
+	assert.NilError(t, err)
+	m := &sqlMigrator{migrations: []string{
+		"-- " + hex.EncodeToString(nonce),
+		"CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT)",
+	}}
+
+	// Create the template first, so that the processes only clone it.
+	_ = sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite"}, m)
+
+	var cmds []*exec.Cmd
+	for i := 0; i < 3; i++ {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestHelperClone$", "-test.count=1")
+		cmd.Env = append(os.Environ(), "SQLITESTDB_HELPER_CLONE="+hex.EncodeToString(nonce))
+		assert.NilError(t, cmd.Start())
+		cmds = append(cmds, cmd)
+	}
+	for _, cmd := range cmds {
+		assert.NilError(t, cmd.Wait())
+	}
+}
+
+// TestHelperClone is run as a subprocess by TestConcurrentProcessesClone, and
+// clones the template many times in parallel. It uses the modernc driver,
+// which doesn't set a default busy_timeout that would mask locking.
+func TestHelperClone(t *testing.T) {
+	nonce := os.Getenv("SQLITESTDB_HELPER_CLONE")
+	if nonce == "" {
+		t.Skip("only run as a helper process")
+	}
+
+	m := &sqlMigrator{migrations: []string{
+		"-- " + nonce,
+		"CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT)",
+	}}
+	for i := 0; i < 20; i++ {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			t.Parallel()
+			_ = sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite"}, m)
+		})
+	}
+}
+
+func TestCleanupTemplates(t *testing.T) {
+	t.Parallel()
+
+	nonce := make([]byte, 8)
+	_, err := rand.Read(nonce)
+	assert.NilError(t, err)
+	reused := &sqlMigrator{migrations: []string{"-- reused " + hex.EncodeToString(nonce)}}
+	created := &sqlMigrator{migrations: []string{"-- created " + hex.EncodeToString(nonce)}}
+
+	// Create one of the templates first, so that the process only reuses it.
+	_ = sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite"}, reused)
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHelperCleanup$", "-test.count=1")
+	cmd.Env = append(os.Environ(), "SQLITESTDB_HELPER_CLEANUP="+hex.EncodeToString(nonce))
+	out, err := cmd.CombinedOutput()
+	assert.NilError(t, err, "%s", out)
+
+	templates := func(m sqlitestdb.Migrator) []string {
+		hash, err := sqlitestdb.TemplateHash(m)
+		assert.NilError(t, err)
+		paths, err := filepath.Glob(filepath.Join(os.TempDir(), "sqlitestdb_tpl_"+hash+"_sqlite_*.sqlite"))
+		assert.NilError(t, err)
+		return paths
+	}
+	assert.Equal(t, 0, len(templates(created)))
+	assert.Equal(t, 1, len(templates(reused)))
+}
+
+// TestHelperCleanup is run as a subprocess by TestCleanupTemplates, and creates
+// one template and reuses another before removing them.
+func TestHelperCleanup(t *testing.T) {
+	nonce := os.Getenv("SQLITESTDB_HELPER_CLEANUP")
+	if nonce == "" {
+		t.Skip("only run as a helper process")
+	}
+
+	_ = sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite"}, &sqlMigrator{migrations: []string{"-- reused " + nonce}})
+	_ = sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite"}, &sqlMigrator{migrations: []string{"-- created " + nonce}})
+
+	assert.NilError(t, sqlitestdb.CleanupTemplates(false))
+}
+
+func TestResettable(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := &sqlMigrator{
+		migrations: []string{
+			"CREATE TABLE cats (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT)",
+			"CREATE TABLE log (name TEXT)",
+			"CREATE VIEW cat_names AS SELECT name FROM cats",
+			"CREATE TRIGGER cats_log AFTER INSERT ON cats BEGIN INSERT INTO log (name) VALUES (NEW.name); END",
+			"INSERT INTO cats (name) VALUES ('daisy'), ('sunny')",
+			"PRAGMA user_version = 7",
+		},
+	}
+	r := sqlitestdb.NewResettable(t, sqlitestdb.Config{Driver: "sqlite3"}, m)
+
+	for i := 0; i < 3; i++ {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			r.Reset(t)
+			db := r.DB()
+
+			var names []string
+			rows, err := db.QueryContext(ctx, "SELECT name FROM cat_names ORDER BY name ASC")
+			assert.NilError(t, err)
+			for rows.Next() {
+				var name string
+				assert.NilError(t, rows.Scan(&name))
+				names = append(names, name)
+			}
+			assert.NilError(t, rows.Close())
+			assert.DeepEqual(t, names, []string{"daisy", "sunny"})
+
+			var userVersion int
+			assert.NilError(t, db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&userVersion))
+			assert.Equal(t, 7, userVersion)
+
+			var id int
+			err = db.QueryRowContext(ctx, "INSERT INTO cats (name) VALUES ('mittens') RETURNING id").Scan(&id)
+			assert.NilError(t, err)
+			assert.Equal(t, 3, id)
+
+			var logged int
+			assert.NilError(t, db.QueryRowContext(ctx, "SELECT COUNT(*) FROM log").Scan(&logged))
+			assert.Equal(t, 3, logged)
+
+			_, err = db.ExecContext(ctx, "DROP VIEW cat_names; DROP TRIGGER cats_log; PRAGMA user_version = 8")
+			assert.NilError(t, err)
+		})
+	}
+}
+
+func BenchmarkNew(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		_ = sqlitestdb.New(b, sqlitestdb.Config{Driver: "sqlite3"}, defaultMigrator())
+	}
+}
+
+func BenchmarkResettable(b *testing.B) {
+	r := sqlitestdb.NewResettable(b, sqlitestdb.Config{Driver: "sqlite3"}, defaultMigrator())
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		r.Reset(b)
+	}
+}
+
+func BenchmarkNewForBench(b *testing.B) {
+	bench := sqlitestdb.NewForBench(b, sqlitestdb.Config{Driver: "sqlite3"}, defaultMigrator())
+
+	for i := 0; i < b.N; i++ {
+		db := bench.New()
+		db.Close()
+	}
+}
+
+// BenchmarkCloneStrategy compares the clone strategies on a template of about
+// 20MB, where the cost of cloning dominates.
+func BenchmarkCloneStrategy(b *testing.B) {
+	m := &sqlMigrator{migrations: []string{
+		"CREATE TABLE blobs (id INTEGER PRIMARY KEY, data BLOB)",
+		"WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 20000) INSERT INTO blobs (data) SELECT randomblob(1000) FROM n",
+	}}
+
+	for _, strategy := range []sqlitestdb.CloneStrategy{sqlitestdb.CloneVacuum, sqlitestdb.CloneCopy, sqlitestdb.CloneBackup, sqlitestdb.CloneReflink} {
+		b.Run(string(strategy), func(b *testing.B) {
+			bench := sqlitestdb.NewForBench(b, sqlitestdb.Config{Driver: "sqlite3"}, m, sqlitestdb.WithCloneStrategy(strategy))
+			for i := 0; i < b.N; i++ {
+				_ = bench.CloneInstance()
+			}
+		})
+	}
+}
+
+// BenchmarkQueryWithoutClone measures only the query, excluding the time spent
+// cloning the template.
+func BenchmarkQueryWithoutClone(b *testing.B) {
+	bench := sqlitestdb.NewForBench(b, sqlitestdb.Config{Driver: "sqlite3"}, defaultMigrator())
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		instance := bench.CloneInstance()
+		db, err := instance.Connect()
+		assert.NilError(b, err)
+		b.StartTimer()
+
+		var count int
+		assert.NilError(b, db.QueryRow("SELECT COUNT(*) FROM cats").Scan(&count))
+		db.Close()
+	}
+}
+
+func TestTemplateFromFile(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Keep a connection to the source database open, so that the most
+	// recent writes stay in its write-ahead log.
+	path := filepath.Join(t.TempDir(), "snapshot.sqlite")
+	src, err := sql.Open("sqlite3", "file:"+path)
+	assert.NilError(t, err)
+	defer src.Close()
+	src.SetMaxOpenConns(1)
+
+	_, err = src.ExecContext(ctx, "PRAGMA journal_mode=WAL")
+	assert.NilError(t, err)
+	_, err = src.ExecContext(ctx, `
+		CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT);
+		INSERT INTO cats (name) VALUES ('daisy'), ('sunny');
+	`)
+	assert.NilError(t, err)
+	_, err = os.Stat(path + "-wal")
+	assert.NilError(t, err)
+
+	m := sqlitestdb.TemplateFromFile(path)
+	hash1, err := m.Hash()
+	assert.NilError(t, err)
+
+	db := sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, m)
+	var count int
+	assert.NilError(t, db.QueryRowContext(ctx, "SELECT COUNT(*) FROM cats").Scan(&count))
+	assert.Equal(t, 2, count)
+
+	_, err = src.ExecContext(ctx, "INSERT INTO cats (name) VALUES ('mittens')")
+	assert.NilError(t, err)
+
+	hash2, err := m.Hash()
+	assert.NilError(t, err)
+	assert.Assert(t, hash1 != hash2)
+
+	db = sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, m)
+	assert.NilError(t, db.QueryRowContext(ctx, "SELECT COUNT(*) FROM cats").Scan(&count))
+	assert.Equal(t, 3, count)
+}
+
+func TestNewIn(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join(t.TempDir(), "artifacts", "TestNewIn")
+	dbconf := sqlitestdb.Config{Driver: "sqlite3"}
+
+	ftb := runFake(t, func(tb testing.TB) {
+		db1 := sqlitestdb.NewIn(tb, dir, dbconf, defaultMigrator(), sqlitestdb.WithTemplateCopy())
+		db2 := sqlitestdb.NewIn(tb, dir, dbconf, defaultMigrator())
+
+		for _, db := range []*sql.DB{db1, db2} {
+			var count int
+			assert.NilError(t, db.QueryRow("SELECT COUNT(*) FROM cats").Scan(&count))
+			assert.Equal(t, 2, count)
+		}
+
+		entries, err := os.ReadDir(dir)
+		assert.NilError(t, err)
+		assert.Equal(t, 3, len(entries))
+	})
+	assert.NilError(t, ftb.err())
+
+	// As the test succeeded, all of the files have been removed.
+	entries, err := os.ReadDir(dir)
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(entries))
+}
+
+func TestWithCloneStrategy(t *testing.T) {
+	t.Parallel()
+
+	strategies := []sqlitestdb.CloneStrategy{sqlitestdb.CloneVacuum, sqlitestdb.CloneCopy, sqlitestdb.CloneBackup, sqlitestdb.CloneReflink}
+	for _, driver := range []string{"sqlite3", "sqlite"} {
+		for _, strategy := range strategies {
+			t.Run(driver+"/"+string(strategy), func(t *testing.T) {
+				t.Parallel()
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+
+				db := sqlitestdb.New(t, sqlitestdb.Config{Driver: driver}, defaultMigrator(), sqlitestdb.WithCloneStrategy(strategy))
+
+				var count int
+				assert.NilError(t, db.QueryRowContext(ctx, "SELECT COUNT(*) FROM cats").Scan(&count))
+				assert.Equal(t, 2, count)
+			})
 		}
 	}
-	return nil
+
+	ftb := runFake(t, func(tb testing.TB) {
+		_ = sqlitestdb.New(tb, sqlitestdb.Config{Driver: "sqlite3"}, defaultMigrator(), sqlitestdb.WithCloneStrategy("teleport"))
+	})
+	assert.ErrorContains(t, ftb.err(), `unknown clone strategy "teleport"`)
+}
+
+// registerFunctions registers the "uuid" function and the "bylength" collation
+// used by [functionsMigrator] with a ConnectHook of the mattn driver, registered
+// as "sqlite3_functions", and on every connection of the modernc driver.
+var registerFunctions = sync.OnceFunc(func() {
+	uuid := func() string {
+		b := make([]byte, 16)
+		_, _ = rand.Read(b)
+		return hex.EncodeToString(b)
+	}
+	byLength := func(a, b string) int {
+		if len(a) != len(b) {
+			return len(a) - len(b)
+		}
+		return strings.Compare(a, b)
+	}
+
+	sql.Register("sqlite3_functions", &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			if err := conn.RegisterFunc("uuid", uuid, false); err != nil {
+				return err
+			}
+			return conn.RegisterCollation("bylength", byLength)
+		},
+	})
+
+	sqlite.MustRegisterScalarFunction("uuid", 0, func(*sqlite.FunctionContext, []driver.Value) (driver.Value, error) {
+		return uuid(), nil
+	})
+	sqlite.MustRegisterCollationUtf8("bylength", byLength)
+})
+
+func functionsMigrator() sqlitestdb.Migrator {
+	return &sqlMigrator{migrations: []string{`
+		CREATE TABLE cats (id TEXT PRIMARY KEY DEFAULT (uuid()), name TEXT);
+		CREATE INDEX cats_name ON cats (name COLLATE bylength);
+		INSERT INTO cats (name) VALUES ('bandit'), ('sunny');
+	`}}
+}
+
+func TestCustomFunctions(t *testing.T) {
+	t.Parallel()
+	registerFunctions()
+
+	strategies := []sqlitestdb.CloneStrategy{sqlitestdb.CloneVacuum, sqlitestdb.CloneCopy, sqlitestdb.CloneBackup}
+	for _, driver := range []string{"sqlite3_functions", "sqlite"} {
+		for _, strategy := range strategies {
+			t.Run(driver+"/"+string(strategy), func(t *testing.T) {
+				t.Parallel()
+
+				db := sqlitestdb.New(t, sqlitestdb.Config{Driver: driver}, functionsMigrator(), sqlitestdb.WithCloneStrategy(strategy))
+				_, err := db.Exec("INSERT INTO cats (name) VALUES ('bo')")
+				assert.NilError(t, err)
+
+				var names []string
+				rows, err := db.Query("SELECT name FROM cats ORDER BY name COLLATE bylength")
+				assert.NilError(t, err)
+				defer rows.Close()
+				for rows.Next() {
+					var name string
+					assert.NilError(t, rows.Scan(&name))
+					names = append(names, name)
+				}
+				assert.NilError(t, rows.Err())
+				assert.DeepEqual(t, names, []string{"bo", "sunny", "bandit"})
+
+				// Checking the index needs the collation.
+				var check string
+				assert.NilError(t, db.QueryRow("PRAGMA integrity_check").Scan(&check))
+				assert.Equal(t, "ok", check)
+
+				var ids int
+				assert.NilError(t, db.QueryRow("SELECT COUNT(DISTINCT id) FROM cats WHERE length(id) = 32").Scan(&ids))
+				assert.Equal(t, 3, ids)
+			})
+		}
+	}
+}
+
+func TestInstancesKeepTemplatePragmas(t *testing.T) {
+	t.Parallel()
+
+	m := &sqlMigrator{migrations: []string{
+		"PRAGMA page_size=8192",
+		"PRAGMA auto_vacuum=FULL",
+		"PRAGMA journal_mode=WAL",
+		"CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT)",
+	}}
+
+	strategies := []sqlitestdb.CloneStrategy{sqlitestdb.CloneVacuum, sqlitestdb.CloneCopy, sqlitestdb.CloneBackup}
+	for _, driver := range []string{"sqlite3", "sqlite"} {
+		for _, strategy := range strategies {
+			t.Run(driver+"/"+string(strategy), func(t *testing.T) {
+				t.Parallel()
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+
+				db := sqlitestdb.New(t, sqlitestdb.Config{Driver: driver}, m, sqlitestdb.WithCloneStrategy(strategy))
+
+				var journalMode string
+				var pageSize, autoVacuum int
+				assert.NilError(t, db.QueryRowContext(ctx, "PRAGMA journal_mode").Scan(&journalMode))
+				assert.NilError(t, db.QueryRowContext(ctx, "PRAGMA page_size").Scan(&pageSize))
+				assert.NilError(t, db.QueryRowContext(ctx, "PRAGMA auto_vacuum").Scan(&autoVacuum))
+				assert.Equal(t, "wal", journalMode)
+				assert.Equal(t, 8192, pageSize)
+				assert.Equal(t, 1, autoVacuum)
+			})
+		}
+	}
+}
+
+func TestWithSeed(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dbconf := sqlitestdb.Config{Driver: "sqlite3"}
+	m := defaultMigrator()
+	hash, err := m.Hash()
+	assert.NilError(t, err)
+
+	seeder := sqlitestdb.SeedFunc(func(ctx context.Context, db *sql.DB) error {
+		_, err := db.ExecContext(ctx, "INSERT INTO cats (name) VALUES ('mittens')")
+		return err
+	})
+	seeded := sqlitestdb.New(t, dbconf, m, sqlitestdb.WithSeed(seeder), sqlitestdb.WithSeed(seeder))
+
+	var count int
+	assert.NilError(t, seeded.QueryRowContext(ctx, "SELECT COUNT(*) FROM cats").Scan(&count))
+	assert.Equal(t, 4, count)
+
+	// The seeds are not part of the template.
+	unseeded := sqlitestdb.New(t, dbconf, m)
+	assert.NilError(t, unseeded.QueryRowContext(ctx, "SELECT COUNT(*) FROM cats").Scan(&count))
+	assert.Equal(t, 2, count)
+
+	rehash, err := m.Hash()
+	assert.NilError(t, err)
+	assert.Equal(t, hash, rehash)
+}
+
+func TestWithSeedFails(t *testing.T) {
+	t.Parallel()
+
+	seeder := sqlitestdb.SeedFunc(func(ctx context.Context, db *sql.DB) error {
+		_, err := db.ExecContext(ctx, "INSERT INTO dogs (name) VALUES ('rex')")
+		return err
+	})
+
+	ftb := runFake(t, func(tb testing.TB) {
+		_ = sqlitestdb.New(tb, sqlitestdb.Config{Driver: "sqlite3"}, defaultMigrator(), sqlitestdb.WithSeed(seeder))
+	})
+	assert.ErrorContains(t, ftb.err(), "seed failed")
+	assert.ErrorContains(t, ftb.err(), "no such table: dogs")
+}
+
+func TestCloseInTest(t *testing.T) {
+	t.Parallel()
+
+	for _, driver := range []string{"sqlite3", "sqlite"} {
+		t.Run(driver, func(t *testing.T) {
+			t.Parallel()
+
+			var path string
+			ftb := runFake(t, func(tb testing.TB) {
+				db := sqlitestdb.New(tb, sqlitestdb.Config{Driver: driver}, defaultMigrator())
+				defer db.Close()
+
+				path = instancePath(t, tb)
+				var count int
+				assert.NilError(t, db.QueryRow("SELECT COUNT(*) FROM cats").Scan(&count))
+			})
+			assert.NilError(t, ftb.err())
+
+			_, err := os.Stat(path)
+			assert.Assert(t, os.IsNotExist(err), "instance database %q was not removed", path)
+		})
+	}
+}
+
+func TestStats(t *testing.T) {
+	t.Parallel()
+
+	nonce := make([]byte, 8)
+	_, err := rand.Read(nonce)
+	assert.NilError(t, err)
+
+	m := &sqlMigrator{migrations: []string{"-- " + hex.EncodeToString(nonce)}}
+	hash, err := sqlitestdb.TemplateHash(m)
+	assert.NilError(t, err)
+
+	_ = sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, m)
+	_ = sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, m)
+
+	var found *sqlitestdb.TemplateStats
+	for _, stats := range sqlitestdb.Stats() {
+		if stats.Hash == hash {
+			found = &stats
+		}
+	}
+	assert.Assert(t, found != nil)
+	assert.Equal(t, sqlitestdb.TemplateCreated, found.Source)
+	assert.Equal(t, int64(1), found.CacheHits)
+
+	var summary strings.Builder
+	assert.NilError(t, sqlitestdb.WriteStats(&summary))
+	assert.Assert(t, regexp.MustCompile(hash+`\s+created\s+1\s`).MatchString(summary.String()), summary.String())
+}
+
+// TestStatsPrepared checks that only the lookups made for tests count as cache
+// hits, and not those of Prepare.
+func TestStatsPrepared(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	nonce := make([]byte, 8)
+	_, err := rand.Read(nonce)
+	assert.NilError(t, err)
+
+	m := &sqlMigrator{migrations: []string{"-- prepared " + hex.EncodeToString(nonce)}}
+	hash, err := sqlitestdb.TemplateHash(m)
+	assert.NilError(t, err)
+
+	for i := 0; i < 2; i++ {
+		_, err := sqlitestdb.Prepare(ctx, sqlitestdb.Config{Driver: "sqlite3"}, m)
+		assert.NilError(t, err)
+	}
+	_ = sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, m)
+
+	var found *sqlitestdb.TemplateStats
+	for _, stats := range sqlitestdb.Stats() {
+		if stats.Hash == hash {
+			found = &stats
+		}
+	}
+	assert.Assert(t, found != nil)
+	assert.Equal(t, int64(1), found.CacheHits)
+}
+
+func TestAssertStableHash(t *testing.T) {
+	t.Parallel()
+
+	sqlitestdb.AssertStableHash(t, defaultMigrator())
+
+	ftb := runFake(t, func(tb testing.TB) {
+		sqlitestdb.AssertStableHash(tb, &unstableMigrator{})
+	})
+	assert.ErrorContains(t, ftb.err(), "returned different hashes")
+}
+
+func TestCheckIdempotent(t *testing.T) {
+	t.Parallel()
+
+	for _, driver := range []string{"sqlite3", "sqlite"} {
+		t.Run(driver, func(t *testing.T) {
+			t.Parallel()
+			config := sqlitestdb.Config{Driver: driver}
+
+			repeatable := &sqlMigrator{migrations: []string{
+				"CREATE TABLE IF NOT EXISTS cats (id INTEGER PRIMARY KEY, name TEXT)",
+				"INSERT OR IGNORE INTO cats (id, name) VALUES (1, 'daisy')",
+			}}
+			sqlitestdb.CheckIdempotent(t, config, repeatable)
+
+			// The scratch database isn't a template.
+			mhash, err := sqlitestdb.TemplateHash(repeatable)
+			assert.NilError(t, err)
+			for _, stats := range sqlitestdb.Stats() {
+				assert.Assert(t, stats.Hash != mhash)
+			}
+
+			// Migrators tracking the applied versions skip them.
+			versioned := sqlitestdb.MigrateFunc("versioned", func(ctx context.Context, db *sql.DB) error {
+				var version int
+				if err := db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil || version > 0 {
+					return err
+				}
+				_, err := db.ExecContext(ctx, "CREATE TABLE cats (id INTEGER PRIMARY KEY); INSERT INTO cats DEFAULT VALUES; PRAGMA user_version = 1")
+				return err
+			})
+			sqlitestdb.CheckIdempotent(t, config, versioned)
+
+			ftb := runFake(t, func(tb testing.TB) {
+				sqlitestdb.CheckIdempotent(tb, config, &sqlMigrator{migrations: []string{
+					"CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT)",
+				}})
+			})
+			assert.ErrorContains(t, ftb.err(), "is not idempotent, migrating a second time failed")
+			assert.ErrorContains(t, ftb.err(), "table cats already exists")
+
+			ftb = runFake(t, func(tb testing.TB) {
+				sqlitestdb.CheckIdempotent(tb, config, &sqlMigrator{migrations: []string{
+					"CREATE TABLE IF NOT EXISTS cats (id INTEGER PRIMARY KEY, name TEXT)",
+					"INSERT INTO cats (name) VALUES ('daisy')",
+				}})
+			})
+			assert.ErrorContains(t, ftb.err(), "is not idempotent, migrating a second time changed the database:\n- rows of cats: 1\n+ rows of cats: 2")
+		})
+	}
+}
+
+func TestWithProgress(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	nonce := make([]byte, 8)
+	_, _ = rand.Read(nonce)
+	m := sqlitestdb.MigrateFunc("progress-"+hex.EncodeToString(nonce), func(ctx context.Context, db *sql.DB) error {
+		for i, table := range []string{"cats", "dogs"} {
+			if _, err := db.ExecContext(ctx, "CREATE TABLE "+table+" (id INTEGER PRIMARY KEY)"); err != nil {
+				return err
+			}
+			sqlitestdb.ReportProgress(ctx, sqlitestdb.Event{Kind: sqlitestdb.MigrationApplied, Migration: table, Applied: i + 1, Total: 2})
+		}
+		return nil
+	})
+	mhash, err := sqlitestdb.TemplateHash(m)
+	assert.NilError(t, err)
+
+	var events []sqlitestdb.Event
+	progress := sqlitestdb.WithProgress(func(e sqlitestdb.Event) { events = append(events, e) })
+	_, err = sqlitestdb.Prepare(ctx, sqlitestdb.Config{Driver: "sqlite3"}, m, progress)
+	assert.NilError(t, err)
+
+	var descriptions []string
+	for _, e := range events {
+		assert.Equal(t, mhash, e.Hash)
+		descriptions = append(descriptions, regexp.MustCompile(`[0-9.]+[mµn]?s\b`).ReplaceAllString(e.String(), "X"))
+	}
+	assert.DeepEqual(t, descriptions, []string{
+		"building template " + mhash,
+		"applied migration 1/2 after X: cats",
+		"applied migration 2/2 after X: dogs",
+		"built template " + mhash + " in X",
+	})
+
+	// The progress of existing templates isn't reported.
+	events = nil
+	_, err = sqlitestdb.Prepare(ctx, sqlitestdb.Config{Driver: "sqlite3"}, m, progress)
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(events))
+
+	// Nor is the progress of migrators outside of templates.
+	sqlitestdb.ReportProgress(ctx, sqlitestdb.Event{Kind: sqlitestdb.MigrationApplied, Applied: 1})
+	assert.Equal(t, 0, len(events))
+
+	failing := &sqlMigrator{migrations: []string{"CREATE TABLE -- " + hex.EncodeToString(nonce)}}
+	_, err = sqlitestdb.Prepare(ctx, sqlitestdb.Config{Driver: "sqlite3"}, failing, progress)
+	assert.Assert(t, err != nil)
+	assert.Equal(t, sqlitestdb.BuildFinished, events[len(events)-1].Kind)
+	assert.ErrorContains(t, events[len(events)-1].Err, "incomplete input")
+	assert.Assert(t, strings.HasPrefix(events[len(events)-1].String(), "could not build template "))
+}
+
+func TestNewAtVersion(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	nonce := make([]byte, 8)
+	_, _ = rand.Read(nonce)
+	vm := &steppedMigrator{migrations: []string{
+		"CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT) -- " + hex.EncodeToString(nonce),
+		"ALTER TABLE cats ADD COLUMN age INTEGER NOT NULL DEFAULT 1",
+	}}
+
+	// The templates of each version, and of the latest one, are kept apart,
+	// although the hash at the latest version is the hash of the migrator.
+	hashes := make(map[string]bool)
+	for _, m := range []sqlitestdb.Migrator{vm, sqlitestdb.AtVersion(vm, 1), sqlitestdb.AtVersion(vm, 2)} {
+		mhash, err := sqlitestdb.TemplateHash(m)
+		assert.NilError(t, err)
+		hashes[mhash] = true
+	}
+	assert.Equal(t, 3, len(hashes))
+
+	for _, driver := range []string{"sqlite3", "sqlite"} {
+		t.Run(driver, func(t *testing.T) {
+			t.Parallel()
+			db := sqlitestdb.NewAtVersion(t, sqlitestdb.Config{Driver: driver}, vm, 1)
+
+			_, err := db.ExecContext(ctx, "INSERT INTO cats (name) VALUES ('daisy')")
+			assert.NilError(t, err)
+			_, err = db.ExecContext(ctx, "SELECT age FROM cats")
+			assert.ErrorContains(t, err, "no such column: age")
+
+			// The data of version 1 survives the later migrations.
+			assert.NilError(t, vm.Migrate(ctx, db, *sqlitestdb.ConfigFor(db)))
+			var (
+				name string
+				age  int
+			)
+			assert.NilError(t, db.QueryRowContext(ctx, "SELECT name, age FROM cats").Scan(&name, &age))
+			assert.Equal(t, "daisy", name)
+			assert.Equal(t, 1, age)
+
+			// The template at version 1 wasn't changed.
+			again := sqlitestdb.NewAtVersion(t, sqlitestdb.Config{Driver: driver}, vm, 1)
+			var version int
+			assert.NilError(t, again.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version))
+			assert.Equal(t, 1, version)
+
+			head := sqlitestdb.New(t, sqlitestdb.Config{Driver: driver}, vm)
+			assert.NilError(t, head.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version))
+			assert.Equal(t, 2, version)
+		})
+	}
+}
+
+func TestWithQuietLogging(t *testing.T) {
+	t.Parallel()
+
+	ftb := runFake(t, func(tb testing.TB) {
+		_ = sqlitestdb.New(tb, sqlitestdb.Config{Driver: "sqlite3"}, defaultMigrator(), sqlitestdb.WithQuietLogging())
+	})
+	assert.NilError(t, ftb.err())
+	assert.Equal(t, 0, len(ftb.logs))
+
+	ftb = runFake(t, func(tb testing.TB) {
+		_ = sqlitestdb.New(tb, sqlitestdb.Config{Driver: "sqlite3"}, defaultMigrator(), sqlitestdb.WithQuietLogging())
+		tb.Fatalf("test failed")
+	})
+	path := instancePath(t, ftb)
+	defer os.Remove(path)
+
+	_, err := os.Stat(path)
+	assert.NilError(t, err)
+}
+
+func TestNewAtDatabasePath(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "pinned.sqlite")
+	config := sqlitestdb.Config{Driver: "sqlite3", Database: path}
+
+	ftb := runFake(t, func(tb testing.TB) {
+		db := sqlitestdb.New(tb, config, defaultMigrator())
+
+		var file string
+		assert.NilError(t, db.QueryRowContext(ctx, "SELECT file FROM pragma_database_list WHERE name = 'main'").Scan(&file))
+		assert.Equal(t, path, file)
+
+		// Another instance can't be created at the same path.
+		ftb := runFake(t, func(tb testing.TB) {
+			_ = sqlitestdb.New(tb, config, defaultMigrator())
+		})
+		assert.ErrorContains(t, ftb.err(), "already exists")
+	})
+	assert.NilError(t, ftb.err())
+
+	_, err := os.Stat(path)
+	assert.Assert(t, os.IsNotExist(err), "instance database %q was not removed", path)
+}
+
+func TestNewAtDatabaseURL(t *testing.T) {
+	t.Parallel()
+
+	// Instance databases are local files, so they can't be created at a URL.
+	ftb := runFake(t, func(tb testing.TB) {
+		_ = sqlitestdb.New(tb, sqlitestdb.Config{Driver: "sqlite3", Database: "libsql://db.turso.io"}, defaultMigrator())
+	})
+	assert.ErrorContains(t, ftb.err(), `instance databases are local files, but the database is the URL "libsql://db.turso.io", not a path`)
+
+	// A "file:" URI with options is passed to the driver as is.
+	config := sqlitestdb.Custom(t, sqlitestdb.Config{Driver: "sqlite3"}, defaultMigrator())
+	readOnly := sqlitestdb.Config{Driver: "sqlite3", Database: config.URI() + "?mode=ro"}
+	assert.Equal(t, readOnly.URI(), config.URI()+"?mode=ro")
+	db, err := readOnly.Connect()
+	assert.NilError(t, err)
+	defer db.Close()
+
+	var count int
+	assert.NilError(t, db.QueryRow("SELECT COUNT(*) FROM cats").Scan(&count))
+	assert.Equal(t, 2, count)
+	_, err = db.Exec("INSERT INTO cats (name) VALUES ('bandit')")
+	assert.ErrorContains(t, err, "readonly")
+}
+
+func TestEmptyDriverIsAmbiguous(t *testing.T) {
+	t.Parallel()
+
+	// Both mattn/go-sqlite3 and modernc.org/sqlite are registered by the tests.
+	ftb := runFake(t, func(tb testing.TB) {
+		_ = sqlitestdb.New(tb, sqlitestdb.Config{}, defaultMigrator())
+	})
+	assert.ErrorContains(t, ftb.err(), `several SQLite drivers are registered, ["sqlite3" "sqlite"]; set Config.Driver to the one to use`)
+}
+
+func TestDriverNotRegistered(t *testing.T) {
+	t.Parallel()
+
+	// The tests don't import go-libsql.
+	ftb := runFake(t, func(tb testing.TB) {
+		_ = sqlitestdb.New(tb, sqlitestdb.Config{Driver: "libsql"}, defaultMigrator())
+	})
+	assert.ErrorContains(t, ftb.err(), `driver "libsql" is not registered; did you forget to import _ "github.com/tursodatabase/go-libsql"? registered drivers: [`)
+	assert.Assert(t, !strings.Contains(ftb.err().Error(), "template"), ftb.err())
+
+	_, err := sqlitestdb.Config{Driver: "libsql", Database: filepath.Join(t.TempDir(), "db.sqlite")}.Connect()
+	assert.ErrorContains(t, err, `driver "libsql" is not registered; did you forget to import _ "github.com/tursodatabase/go-libsql"?`)
+}
+
+func TestConfigConnector(t *testing.T) {
+	t.Parallel()
+
+	// The function is only registered on the connections of the connector.
+	config := sqlitestdb.Config{
+		Driver: "sqlite3",
+		Connector: sqlitestdb.DriverConnector(&sqlite3.SQLiteDriver{
+			ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+				return conn.RegisterFunc("shout", strings.ToUpper, true)
+			},
+		}),
+	}
+	m := &sqlMigrator{migrations: []string{`
+		CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT);
+		INSERT INTO cats (name) VALUES (shout('daisy'));
+	`}}
+
+	// The template of a previous run would be reused otherwise.
+	db := sqlitestdb.New(t, config, m, sqlitestdb.WithForceRebuild())
+	var name string
+	assert.NilError(t, db.QueryRow("SELECT shout(name || ' and sunny') FROM cats").Scan(&name))
+	assert.Equal(t, "DAISY AND SUNNY", name)
+
+	instance := sqlitestdb.Custom(t, config, m)
+	assert.Assert(t, instance.Connector != nil)
+	custom, err := instance.Connect()
+	assert.NilError(t, err)
+	defer custom.Close()
+	assert.NilError(t, custom.QueryRow("SELECT shout('bandit')").Scan(&name))
+	assert.Equal(t, "BANDIT", name)
+
+	// The function doesn't exist without the connector.
+	plain, err := sqlitestdb.Config{Driver: "sqlite3", Database: instance.Database}.Connect()
+	assert.NilError(t, err)
+	defer plain.Close()
+	_, err = plain.Exec("SELECT shout('bandit')")
+	assert.ErrorContains(t, err, "no such function: shout")
+}
+
+func TestKeyWithoutSQLCipher(t *testing.T) {
+	t.Parallel()
+
+	var version string
+	db, err := sql.Open("sqlite3", ":memory:")
+	assert.NilError(t, err)
+	defer db.Close()
+	if err := db.QueryRow("PRAGMA cipher_version").Scan(&version); err == nil {
+		t.Skipf("mattn/go-sqlite3 is built with SQLCipher %s", version)
+	}
+
+	// Other builds of SQLite ignore "PRAGMA key", and create databases that
+	// aren't encrypted.
+	ftb := runFake(t, func(tb testing.TB) {
+		_ = sqlitestdb.New(tb, sqlitestdb.Config{Driver: "sqlite3", Key: "correct horse battery staple"}, defaultMigrator())
+	})
+	assert.ErrorContains(t, ftb.err(), `Config.Key is set, but the driver "sqlite3" wasn't built with SQLCipher`)
+}
+
+func TestCompileOptions(t *testing.T) {
+	t.Parallel()
+
+	for _, driver := range []string{"sqlite3", "sqlite"} {
+		t.Run(driver, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			db := sqlitestdb.New(t, sqlitestdb.Config{Driver: driver}, defaultMigrator())
+
+			// The options of the driver are cached, and match those of any
+			// other database it opens.
+			options, err := sqlitestdb.CompileOptions(ctx, db)
+			assert.NilError(t, err)
+			other, err := sql.Open(driver, ":memory:")
+			assert.NilError(t, err)
+			defer other.Close()
+			uncached, err := sqlitestdb.CompileOptions(ctx, other)
+			assert.NilError(t, err)
+			assert.DeepEqual(t, options, uncached)
+
+			has, err := sqlitestdb.HasCompileOption(ctx, db, "THREADSAFE")
+			assert.NilError(t, err)
+			assert.Assert(t, has, "compile options: %v", options)
+			has, err = sqlitestdb.HasCompileOption(ctx, db, "SQLITE_ENABLE_TELEPATHY")
+			assert.NilError(t, err)
+			assert.Assert(t, !has)
+
+			// The options agree with the virtual tables the driver can create.
+			for opt, module := range map[string]string{"ENABLE_FTS5": "fts5(body)", "ENABLE_RTREE": "rtree(id, minX, maxX)"} {
+				has, err := sqlitestdb.HasCompileOption(ctx, db, opt)
+				assert.NilError(t, err)
+				_, err = db.ExecContext(ctx, "CREATE VIRTUAL TABLE "+strings.ToLower(opt)+" USING "+module)
+				assert.Equal(t, has, err == nil, "%s: %v", opt, err)
+			}
+
+			ftb := runFake(t, func(tb testing.TB) {
+				sqlitestdb.SkipIfMissing(tb, db, "THREADSAFE")
+				sqlitestdb.SkipIfMissing(tb, db, "ENABLE_TELEPATHY")
+			})
+			assert.NilError(t, ftb.err())
+			assert.Equal(t, len(ftb.skips), 1)
+			assert.Assert(t, strings.HasPrefix(ftb.skips[0], `SQLite of the driver "`+driver+`" was not compiled with ENABLE_TELEPATHY (compile options: `), ftb.skips[0])
+		})
+	}
+}
+
+func TestConfigFor(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, defaultMigrator())
+	var file string
+	assert.NilError(t, db.QueryRowContext(ctx, "SELECT file FROM pragma_database_list WHERE name = 'main'").Scan(&file))
+
+	// The configuration is still available after the test closed the database,
+	// for example to let another process open it.
+	assert.NilError(t, db.Close())
+	config := sqlitestdb.ConfigFor(db)
+	assert.Assert(t, config != nil)
+	assert.Equal(t, file, config.Database)
+	assert.Equal(t, "sqlite3", config.Driver)
+
+	other, err := sql.Open("sqlite3", ":memory:")
+	assert.NilError(t, err)
+	defer other.Close()
+	assert.Assert(t, sqlitestdb.ConfigFor(other) == nil)
+}
+
+func FuzzNewForFuzz(f *testing.F) {
+	fz := sqlitestdb.NewForFuzz(f, sqlitestdb.Config{Driver: "sqlite3"}, defaultMigrator())
+
+	f.Add("mittens")
+	f.Add("")
+	f.Add("Robert'); DROP TABLE cats;--")
+	f.Fuzz(func(t *testing.T, name string) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		tx := fz.Reset(t)
+
+		// The rows inserted for previous inputs were rolled back.
+		_, err := tx.ExecContext(ctx, "INSERT INTO cats (name) VALUES (?)", name)
+		assert.NilError(t, err)
+
+		var count int
+		assert.NilError(t, tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM cats").Scan(&count))
+		assert.Equal(t, 3, count)
+
+		var found string
+		assert.NilError(t, tx.QueryRowContext(ctx, "SELECT name FROM cats WHERE id = last_insert_rowid()").Scan(&found))
+		assert.Equal(t, name, found)
+	})
+}
+
+func TestPrepare(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	nonce := make([]byte, 8)
+	_, err := rand.Read(nonce)
+	assert.NilError(t, err)
+
+	m := &countingMigrator{sqlMigrator: sqlMigrator{migrations: []string{"-- " + hex.EncodeToString(nonce), "CREATE TABLE cats (id INTEGER PRIMARY KEY)"}}}
+	tpl, err := sqlitestdb.Prepare(ctx, sqlitestdb.Config{Driver: "sqlite3"}, m)
+	assert.NilError(t, err)
+
+	info, err := os.Stat(tpl.Database)
+	assert.NilError(t, err)
+	assert.Assert(t, info.Size() > 0)
+
+	_ = sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, m)
+	assert.Equal(t, int64(1), m.calls.Load())
+}
+
+// countingMigrator is a sqlMigrator that counts how many times it migrated.
+type countingMigrator struct {
+	sqlMigrator
+	calls atomic.Int64
+}
+
+func (m *countingMigrator) Migrate(ctx context.Context, db *sql.DB, config sqlitestdb.Config) error {
+	m.calls.Add(1)
+	return m.sqlMigrator.Migrate(ctx, db, config)
+}
+
+func TestWithMigrateTimeout(t *testing.T) {
+	t.Parallel()
+
+	nonce := make([]byte, 8)
+	_, err := rand.Read(nonce)
+	assert.NilError(t, err)
+	m := newSlowMigrator(hex.EncodeToString(nonce), nil)
+	hash, err := sqlitestdb.TemplateHash(m)
+	assert.NilError(t, err)
+
+	ftb := runFake(t, func(tb testing.TB) {
+		_ = sqlitestdb.New(tb, sqlitestdb.Config{Driver: "sqlite3"}, m, sqlitestdb.WithMigrateTimeout(50*time.Millisecond))
+	})
+	assert.ErrorContains(t, ftb.err(), fmt.Sprintf("migration of template with hash %q timed out after 50ms", hash))
+
+	paths, err := filepath.Glob(filepath.Join(os.TempDir(), "sqlitestdb_tpl_"+hash+"_*.sqlite*"))
+	assert.NilError(t, err)
+	for _, path := range paths {
+		assert.Assert(t, strings.HasSuffix(path, ".lock"), "%s was not removed", path)
+	}
+}
+
+func TestMigrationsAreInterrupted(t *testing.T) {
+	t.Parallel()
+
+	for _, driver := range []string{"sqlite3", "sqlite"} {
+		t.Run(driver, func(t *testing.T) {
+			t.Parallel()
+
+			nonce := make([]byte, 8)
+			_, err := rand.Read(nonce)
+			assert.NilError(t, err)
+			m := &runawayMigrator{nonce: hex.EncodeToString(nonce)}
+			hash, err := sqlitestdb.TemplateHash(m)
+			assert.NilError(t, err)
+
+			start := time.Now()
+			ftb := runFake(t, func(tb testing.TB) {
+				_ = sqlitestdb.New(tb, sqlitestdb.Config{Driver: driver}, m, sqlitestdb.WithMigrateTimeout(100*time.Millisecond))
+			})
+			assert.ErrorContains(t, ftb.err(), fmt.Sprintf("migration of template with hash %q timed out after 100ms", hash))
+			assert.Assert(t, time.Since(start) < 10*time.Second, "migration was not interrupted")
+
+			paths, err := filepath.Glob(filepath.Join(os.TempDir(), "sqlitestdb_tpl_"+hash+"_*.sqlite*"))
+			assert.NilError(t, err)
+			for _, path := range paths {
+				assert.Assert(t, strings.HasSuffix(path, ".lock"), "%s was not removed", path)
+			}
+		})
+	}
+}
+
+func TestDriverConn(t *testing.T) {
+	t.Parallel()
+
+	nonce := make([]byte, 8)
+	_, err := rand.Read(nonce)
+	assert.NilError(t, err)
+
+	m := sqlitestdb.MigrateFunc("driver_conn_"+hex.EncodeToString(nonce), func(ctx context.Context, db *sql.DB) error {
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		return conn.Raw(func(raw any) error {
+			if _, ok := raw.(*sqlite3.SQLiteConn); ok {
+				return errors.New("the connection passed to Raw isn't wrapped")
+			}
+			if _, ok := sqlitestdb.DriverConn(raw).(*sqlite3.SQLiteConn); !ok {
+				return fmt.Errorf("DriverConn returned %T", sqlitestdb.DriverConn(raw))
+			}
+			return nil
+		})
+	})
+	_ = sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, m)
+}
+
+func TestWithForeignKeyCheck(t *testing.T) {
+	t.Parallel()
+
+	schema := []string{
+		"CREATE TABLE owners (id INTEGER PRIMARY KEY, name TEXT)",
+		"CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT, owner_id INTEGER REFERENCES owners (id))",
+		"INSERT INTO owners (id, name) VALUES (1, 'peter')",
+	}
+
+	t.Run("consistent", func(t *testing.T) {
+		t.Parallel()
+
+		m := &sqlMigrator{migrations: append(schema, "INSERT INTO cats (name, owner_id) VALUES ('daisy', 1), ('sunny', NULL)")}
+		db := sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, m, sqlitestdb.WithForeignKeyCheck())
+
+		var count int
+		assert.NilError(t, db.QueryRow("SELECT COUNT(*) FROM cats").Scan(&count))
+		assert.Equal(t, 2, count)
+	})
+
+	t.Run("violated", func(t *testing.T) {
+		t.Parallel()
+
+		m := &sqlMigrator{migrations: append(schema, "INSERT INTO cats (id, name, owner_id) VALUES (1, 'daisy', 1), (2, 'sunny', 2)")}
+		ftb := runFake(t, func(tb testing.TB) {
+			_ = sqlitestdb.New(tb, sqlitestdb.Config{Driver: "sqlite3"}, m, sqlitestdb.WithForeignKeyCheck())
+		})
+		assert.ErrorContains(t, ftb.err(), "foreign key check failed for 1 rows")
+		assert.ErrorContains(t, ftb.err(), "cats (rowid 2) references a missing row of owners")
+	})
+}
+
+func TestNewShared(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	nonce := make([]byte, 8)
+	_, err := rand.Read(nonce)
+	assert.NilError(t, err)
+	m := &sqlMigrator{migrations: []string{
+		"-- " + hex.EncodeToString(nonce),
+		"CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT); INSERT INTO cats (name) VALUES ('daisy'), ('sunny')",
+	}}
+
+	// The instance is held by another user for the duration of the subtests,
+	// so that they share it even if they don't run at the same time.
+	var mu sync.Mutex
+	paths := map[string]bool{}
+	ftb := runFake(t, func(tb testing.TB) {
+		_ = sqlitestdb.NewShared(tb, sqlitestdb.Config{Driver: "sqlite3"}, m)
+
+		t.Run("group", func(t *testing.T) {
+			for i := 0; i < 5; i++ {
+				t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+					t.Parallel()
+
+					db := sqlitestdb.NewShared(t, sqlitestdb.Config{Driver: "sqlite3"}, m)
+
+					var path string
+					assert.NilError(t, db.QueryRowContext(ctx, "SELECT file FROM pragma_database_list WHERE name = 'main'").Scan(&path))
+					mu.Lock()
+					paths[path] = true
+					mu.Unlock()
+
+					var count int
+					assert.NilError(t, db.QueryRowContext(ctx, "SELECT COUNT(*) FROM cats").Scan(&count))
+					assert.Equal(t, 2, count)
+
+					_, err := db.ExecContext(ctx, "INSERT INTO cats (name) VALUES ('mittens')")
+					assert.ErrorContains(t, err, "readonly")
+				})
+			}
+		})
+	})
+	assert.NilError(t, ftb.err())
+
+	// The shared instance was removed once the last user finished.
+	assert.Equal(t, 1, len(paths))
+	for path := range paths {
+		_, err := os.Stat(path)
+		assert.Assert(t, os.IsNotExist(err), "shared instance %q was not removed", path)
+	}
+}
+
+func TestWithInstancePool(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	nonce := make([]byte, 8)
+	_, err := rand.Read(nonce)
+	assert.NilError(t, err)
+	m := &sqlMigrator{migrations: []string{
+		"-- " + hex.EncodeToString(nonce),
+		"CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT); INSERT INTO cats (name) VALUES ('daisy'), ('sunny')",
+	}}
+	hash, err := sqlitestdb.TemplateHash(m)
+	assert.NilError(t, err)
+
+	poolStats := func() sqlitestdb.TemplateStats {
+		for _, stats := range sqlitestdb.Stats() {
+			if stats.Hash == hash {
+				return stats
+			}
+		}
+		return sqlitestdb.TemplateStats{}
+	}
+
+	// The pool starts empty, and is filled in the background.
+	for i := 0; poolStats().PoolHits == 0; i++ {
+		assert.Assert(t, i < 100, "no instance was taken from the pool")
+		if i > 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		t.Run(fmt.Sprintf("attempt_%d", i), func(t *testing.T) {
+			db := sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, m, sqlitestdb.WithInstancePool(2))
+
+			var count int
+			assert.NilError(t, db.QueryRowContext(ctx, "SELECT COUNT(*) FROM cats").Scan(&count))
+			assert.Equal(t, 2, count)
+
+			var path string
+			assert.NilError(t, db.QueryRowContext(ctx, "SELECT file FROM pragma_database_list WHERE name = 'main'").Scan(&path))
+			assert.Assert(t, strings.Contains(filepath.Base(path), "_inst_TestWithInstancePool-attempt_"), path)
+		})
+	}
+	assert.Assert(t, poolStats().PoolMisses > 0)
+
+	sqlitestdb.ClosePools()
+	pooled, err := filepath.Glob(filepath.Join(os.TempDir(), "sqlitestdb_tpl_"+hash+"_inst_pool_*.sqlite"))
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(pooled), pooled)
+}
+
+func TestNewMulti(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	migrators := map[string]sqlitestdb.Migrator{
+		"app": defaultMigrator(),
+		"analytics": &sqlMigrator{migrations: []string{`
+			CREATE TABLE visits (cat_id INTEGER, visited_at TEXT);
+			INSERT INTO visits VALUES (1, '2024-01-01'), (1, '2024-01-02'), (2, '2024-01-01');
+		`}},
+	}
+
+	var paths []string
+	ftb := runFake(t, func(tb testing.TB) {
+		multi := sqlitestdb.NewMulti(tb, sqlitestdb.Config{Driver: "sqlite3"}, migrators)
+		for _, config := range multi.Configs {
+			paths = append(paths, config.Database)
+		}
+
+		conn := multi.Attach(tb, "app")
+		var visits int
+		err := conn.QueryRowContext(ctx, `
+			SELECT COUNT(*) FROM cats
+			JOIN analytics.visits ON visits.cat_id = cats.id
+			WHERE cats.name = 'daisy'
+		`).Scan(&visits)
+		assert.NilError(t, err)
+		assert.Equal(t, 2, visits)
+
+		// The other databases are still usable on their own.
+		err = multi.DBs["analytics"].QueryRowContext(ctx, "SELECT COUNT(*) FROM visits").Scan(&visits)
+		assert.NilError(t, err)
+		assert.Equal(t, 3, visits)
+	})
+	assert.NilError(t, ftb.err())
+	assert.Equal(t, 1, len(ftb.logs))
+	assert.Assert(t, strings.Contains(ftb.logs[0], "analytics=file:"), ftb.logs[0])
+	assert.Assert(t, strings.Contains(ftb.logs[0], "app=file:"), ftb.logs[0])
+
+	assert.Equal(t, 2, len(paths))
+	for _, path := range paths {
+		_, err := os.Stat(path)
+		assert.Assert(t, os.IsNotExist(err), "instance database %q was not removed", path)
+	}
+}
+
+func TestNewSet(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	specs := map[string]sqlitestdb.Spec{}
+	for _, name := range []string{"config", "data", "cache"} {
+		specs[name] = sqlitestdb.Spec{
+			Config:   sqlitestdb.Config{Driver: "sqlite3"},
+			Migrator: &sqlMigrator{migrations: []string{"CREATE TABLE " + name + " (id INTEGER PRIMARY KEY)"}},
+		}
+	}
+	specs["cache"] = sqlitestdb.Spec{Config: sqlitestdb.Config{Driver: "sqlite"}, Migrator: specs["cache"].Migrator}
+
+	dbs := sqlitestdb.NewSet(t, specs)
+	assert.Equal(t, 3, len(dbs))
+	for name, db := range dbs {
+		var count int
+		assert.NilError(t, db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+name).Scan(&count))
+
+		var path string
+		assert.NilError(t, db.QueryRowContext(ctx, "SELECT file FROM pragma_database_list WHERE name = 'main'").Scan(&path))
+		assert.Assert(t, strings.Contains(filepath.Base(path), "_inst_TestNewSet-"+name+"_"), path)
+	}
+}
+
+func TestNewSetFails(t *testing.T) {
+	t.Parallel()
+
+	ftb := runFake(t, func(tb testing.TB) {
+		sqlitestdb.NewSet(tb, map[string]sqlitestdb.Spec{
+			"a": {Config: sqlitestdb.Config{Driver: "sqlite3"}, Migrator: defaultMigrator()},
+			"b": {Config: sqlitestdb.Config{Driver: "sqlite3"}, Migrator: &sqlMigrator{migrations: []string{"SELECT x FROM nothing"}}},
+		})
+	})
+	assert.ErrorContains(t, ftb.err(), `could not create "b" database`)
+
+	// The database created before the failure was removed.
+	matches, err := filepath.Glob(filepath.Join(os.TempDir(), "sqlitestdb_tpl_*_inst_TestNewSetFails-a_*.sqlite"))
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(matches), matches)
+}
+
+func TestHandlerClosesServerFirst(t *testing.T) {
+	t.Parallel()
+
+	started := make(chan struct{})
+	done := make(chan struct{})
+	var status atomic.Int64
+	ftb := runFake(t, func(tb testing.TB) {
+		srv := sqlitestdb.Handler(tb, sqlitestdb.Config{Driver: "sqlite3"}, defaultMigrator(), func(db *sql.DB) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				close(started)
+				time.Sleep(100 * time.Millisecond)
+
+				var count int
+				if err := db.QueryRow("SELECT COUNT(*) FROM cats").Scan(&count); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+				}
+			})
+		})
+
+		go func() {
+			defer close(done)
+			resp, err := srv.Client().Get(srv.URL)
+			if err == nil {
+				status.Store(int64(resp.StatusCode))
+				resp.Body.Close()
+			}
+		}()
+		<-started
+	})
+	assert.NilError(t, ftb.err())
+	<-done
+
+	// The request was still being handled when the cleanup started, and must
+	// have been able to use the database.
+	assert.Equal(t, int64(http.StatusOK), status.Load())
+}
+
+// TestSetLogger doesn't run in parallel, as the logger is shared by every test.
+func TestSetLogger(t *testing.T) {
+	var logged []string
+	sqlitestdb.SetLogger(func(_ testing.TB, msg string) {
+		logged = append(logged, msg)
+	})
+	defer sqlitestdb.SetLogger(nil)
+
+	ftb := runFake(t, func(tb testing.TB) {
+		_ = sqlitestdb.New(tb, sqlitestdb.Config{Driver: "sqlite3"}, defaultMigrator())
+	})
+	assert.NilError(t, ftb.err())
+	assert.Equal(t, 0, len(ftb.logs))
+	assert.Equal(t, 1, len(logged))
+	assert.Assert(t, strings.HasPrefix(logged[0], "sqlitestdb: file:"), logged[0])
+
+	ftb = runFake(t, func(tb testing.TB) {
+		_ = sqlitestdb.New(tb, sqlitestdb.Config{Driver: "sqlite3"}, defaultMigrator())
+		tb.Fatalf("test failed")
+	})
+	defer os.Remove(instancePath(t, ftb))
+	assert.Equal(t, 2, len(logged))
+}
+
+// TestMaxConcurrentTemplateBuilds doesn't run in parallel, as the bound is
+// shared by every test.
+func TestMaxConcurrentTemplateBuilds(t *testing.T) {
+	sqlitestdb.SetMaxConcurrentTemplateBuilds(2)
+	defer sqlitestdb.SetMaxConcurrentTemplateBuilds(0)
+
+	nonce := make([]byte, 8)
+	_, err := rand.Read(nonce)
+	assert.NilError(t, err)
+
+	var builds concurrentBuilds
+	var wg sync.WaitGroup
+	for i := range 6 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m := &concurrentMigrator{nonce: fmt.Sprintf("%x_%d", nonce, i), builds: &builds}
+			ftb := runFake(t, func(tb testing.TB) {
+				_ = sqlitestdb.New(tb, sqlitestdb.Config{Driver: "sqlite3"}, m)
+			})
+			assert.Check(t, ftb.err())
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(2), builds.max.Load())
+}
+
+func defaultMigrator() sqlitestdb.Migrator {
+	return &sqlMigrator{
+		migrations: []string{`
+			-- the "migration"
+			CREATE TABLE cats (
+				id INTEGER PRIMARY KEY,
+				name TEXT
+			);
+			INSERT INTO cats (name) VALUES ('daisy'), ('sunny');
+        `},
+	}
+}
+
+// versionedMigrator runs the migrations of a sqlMigrator, and has the same
+// hash, but also records them in a versions table.
+type versionedMigrator struct {
+	sqlMigrator
+}
+
+func (m *versionedMigrator) Migrate(ctx context.Context, db *sql.DB, config sqlitestdb.Config) error {
+	if err := m.sqlMigrator.Migrate(ctx, db, config); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, "CREATE TABLE versions (version INTEGER); INSERT INTO versions VALUES (1)")
+	return err
+}
+
+// namedMigrator is a migrator with a name.
+type namedMigrator struct {
+	sqlitestdb.Migrator
+	name string
+}
+
+func (m namedMigrator) Name() string {
+	return m.name
+}
+
+type sqlMigrator struct {
+	migrations []string
+}
+
+func (m *sqlMigrator) Hash() (string, error) {
+	h := hash.NewRecursiveHash()
+	for _, migration := range m.migrations {
+		h.Add([]byte(migration))
+	}
+	return h.String(), nil
+}
+
+func (m *sqlMigrator) Migrate(ctx context.Context, db *sql.DB, _ sqlitestdb.Config) error {
+	for _, migration := range m.migrations {
+		if _, err := db.ExecContext(ctx, migration); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// steppedMigrator is a [sqlitestdb.VersionedMigrator] whose version n is its
+// first n migrations, recorded as the user_version of the database.
+type steppedMigrator struct {
+	migrations []string
+}
+
+func (m *steppedMigrator) Hash() (string, error) {
+	return m.HashAt(int64(len(m.migrations)))
+}
+
+func (m *steppedMigrator) HashAt(version int64) (string, error) {
+	h := hash.NewRecursiveHash()
+	for _, migration := range m.migrations[:version] {
+		h.Add([]byte(migration))
+	}
+	return h.String(), nil
+}
+
+func (m *steppedMigrator) Migrate(ctx context.Context, db *sql.DB, config sqlitestdb.Config) error {
+	return m.MigrateTo(ctx, db, config, int64(len(m.migrations)))
+}
+
+func (m *steppedMigrator) MigrateTo(ctx context.Context, db *sql.DB, _ sqlitestdb.Config, version int64) error {
+	var current int64
+	if err := db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&current); err != nil {
+		return err
+	}
+	for ; current < version; current++ {
+		if _, err := db.ExecContext(ctx, m.migrations[current]); err != nil {
+			return err
+		}
+		if _, err := db.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", current+1)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unstableMigrator returns a different hash on every call.
+type unstableMigrator struct {
+	sqlitestdb.NoopMigrator
+	calls atomic.Int32
+}
+
+func (m *unstableMigrator) Hash() (string, error) {
+	return fmt.Sprintf("unstable_%d", m.calls.Add(1)), nil
+}
+
+// slowMigrator creates the cats table, and then holds the exclusive lock on the
+// template for a while before finishing the migration, announcing it has
+// started on the ready writer.
+type slowMigrator struct {
+	nonce string
+	ready io.Writer
+	calls atomic.Int32
+}
+
+func newSlowMigrator(nonce string, ready io.Writer) *slowMigrator {
+	return &slowMigrator{nonce: nonce, ready: ready}
+}
+
+func (m *slowMigrator) Hash() (string, error) {
+	h := hash.NewRecursiveHash()
+	h.Add([]byte("slow"))
+	h.Add([]byte(m.nonce))
+	return h.String(), nil
+}
+
+func (m *slowMigrator) Migrate(ctx context.Context, db *sql.DB, _ sqlitestdb.Config) error {
+	m.calls.Add(1)
+	if _, err := db.ExecContext(ctx, "CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT)"); err != nil {
+		return err
+	}
+
+	if m.ready != nil {
+		fmt.Fprintln(m.ready, "migrating")
+	}
+	time.Sleep(500 * time.Millisecond)
+
+	_, err := db.ExecContext(ctx, "INSERT INTO cats (name) VALUES ('daisy'), ('sunny')")
+	return err
+}
+
+// runawayMigrator runs a statement that doesn't finish for a very long time,
+// without a context, so only interrupting it can stop the migration.
+type runawayMigrator struct {
+	nonce string
+}
+
+func (m *runawayMigrator) Hash() (string, error) {
+	h := hash.NewRecursiveHash()
+	h.Add([]byte("runaway"))
+	h.Add([]byte(m.nonce))
+	return h.String(), nil
+}
+
+func (m *runawayMigrator) Migrate(_ context.Context, db *sql.DB, _ sqlitestdb.Config) error {
+	_, err := db.Exec("CREATE TABLE n AS WITH RECURSIVE c(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM c) SELECT i FROM c LIMIT 100000000000")
+	return err
+}
+
+// concurrentBuilds counts the migrations of concurrentMigrators running at the
+// same time, and the most that ever did.
+type concurrentBuilds struct {
+	running atomic.Int32
+	max     atomic.Int32
+}
+
+// concurrentMigrator takes a while to migrate, recording the concurrent
+// migrations in builds.
+type concurrentMigrator struct {
+	nonce  string
+	builds *concurrentBuilds
+}
+
+func (m *concurrentMigrator) Hash() (string, error) {
+	h := hash.NewRecursiveHash()
+	h.Add([]byte("concurrent"))
+	h.Add([]byte(m.nonce))
+	return h.String(), nil
+}
+
+func (m *concurrentMigrator) Migrate(ctx context.Context, db *sql.DB, _ sqlitestdb.Config) error {
+	running := m.builds.running.Add(1)
+	defer m.builds.running.Add(-1)
+	for {
+		highest := m.builds.max.Load()
+		if running <= highest || m.builds.max.CompareAndSwap(highest, running) {
+			break
+		}
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	_, err := db.ExecContext(ctx, "CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT)")
+	return err
+}
+
+// fakeTB is a [testing.TB] that records fatal errors instead of failing the
+// calling test, so that failure paths can be asserted on.
+type fakeTB struct {
+	testing.TB
+	name string
+	dir  string
+
+	mu       sync.Mutex
+	cleanups []func()
+	fatals   []string
+	skips    []string
+	logs     []string
+}
+
+// runFake calls fn with a fakeTB and then runs the registered cleanups, each
+// in its own goroutine, as [testing.TB.Fatalf] exits the calling goroutine.
+func runFake(t *testing.T, fn func(testing.TB)) *fakeTB {
+	t.Helper()
+	ftb := &fakeTB{name: t.Name(), dir: t.TempDir()}
+	ftb.run(func() { fn(ftb) })
+
+	for i := len(ftb.cleanups) - 1; i >= 0; i-- {
+		ftb.run(ftb.cleanups[i])
+	}
+
+	return ftb
+}
+
+// instancePath returns the path of the most recent instance database logged to
+// the fakeTB.
+func instancePath(t *testing.T, tb testing.TB) string {
+	t.Helper()
+	ftb := tb.(*fakeTB)
+	ftb.mu.Lock()
+	defer ftb.mu.Unlock()
+
+	for i := len(ftb.logs) - 1; i >= 0; i-- {
+		if path, ok := strings.CutPrefix(ftb.logs[i], "sqlitestdb: file:"); ok {
+			return path
+		}
+	}
+
+	t.Fatalf("no instance database was logged")
+	return ""
+}
+
+func (f *fakeTB) run(fn func()) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+	<-done
+}
+
+func (f *fakeTB) err() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.fatals) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%v", f.fatals)
+}
+
+func (f *fakeTB) Helper() {}
+
+func (f *fakeTB) TempDir() string {
+	dir, err := os.MkdirTemp(f.dir, "")
+	if err != nil {
+		f.Fatalf("could not create temporary directory: %+v", err)
+	}
+	return dir
+}
+
+func (f *fakeTB) Name() string { return f.name }
+
+func (f *fakeTB) Cleanup(fn func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cleanups = append(f.cleanups, fn)
+}
+
+func (f *fakeTB) Failed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.fatals) > 0
+}
+
+func (f *fakeTB) Logf(format string, args ...any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.logs = append(f.logs, fmt.Sprintf(format, args...))
+}
+
+func (f *fakeTB) Fatalf(format string, args ...any) {
+	f.mu.Lock()
+	f.fatals = append(f.fatals, fmt.Sprintf(format, args...))
+	f.mu.Unlock()
+	runtime.Goexit()
+}
+
+func (f *fakeTB) Skipf(format string, args ...any) {
+	f.mu.Lock()
+	f.skips = append(f.skips, fmt.Sprintf(format, args...))
+	f.mu.Unlock()
+	runtime.Goexit()
+}
+
+// schemaDump is the output of the ".dump" command of the sqlite3 shell.
+const schemaDump = `PRAGMA foreign_keys=OFF;
+BEGIN TRANSACTION;
+CREATE TABLE owners (id INTEGER PRIMARY KEY, name TEXT);
+CREATE TABLE cats (
+  id INTEGER PRIMARY KEY,
+  name TEXT, -- the cat's name; unique
+  owner_id INTEGER REFERENCES owners (id)
+);
+INSERT INTO owners VALUES(1,'o''brien; jr.');
+CREATE TABLE audit (message TEXT);
+CREATE TRIGGER cats_audit AFTER INSERT ON cats BEGIN
+  INSERT INTO audit VALUES ('adopted: ' || NEW.name);
+END;
+INSERT INTO cats VALUES(1,'daisy',1);
+COMMIT;
+`
+
+func TestSchemaFile(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	for _, driver := range []string{"sqlite3", "sqlite"} {
+		t.Run(driver, func(t *testing.T) {
+			t.Parallel()
+			m := sqlitestdb.SchemaFile(fstest.MapFS{"db/schema.sql": {Data: []byte(schemaDump)}}, "db/schema.sql")
+			db := sqlitestdb.New(t, sqlitestdb.Config{Driver: driver}, m)
+
+			var owner string
+			assert.NilError(t, db.QueryRowContext(ctx, "SELECT name FROM owners WHERE id = 1").Scan(&owner))
+			assert.Equal(t, "o'brien; jr.", owner)
+
+			var count int
+			assert.NilError(t, db.QueryRowContext(ctx, "SELECT COUNT(*) FROM audit").Scan(&count))
+			assert.Equal(t, 1, count)
+
+			_, err := db.ExecContext(ctx, "INSERT INTO cats (name) VALUES ('sunny')")
+			assert.NilError(t, err)
+			assert.NilError(t, db.QueryRowContext(ctx, "SELECT COUNT(*) FROM audit").Scan(&count))
+			assert.Equal(t, 2, count)
+		})
+	}
+}
+
+func TestSchemaFileDump(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	// cats.dump.sql is the output of the ".dump" command of the sqlite3 shell,
+	// for the cats table, with AUTOINCREMENT, after deleting one of the cats.
+	m := sqlitestdb.SchemaFile(os.DirFS("testdata"), "cats.dump.sql")
+	for _, driver := range []string{"sqlite3", "sqlite"} {
+		t.Run(driver, func(t *testing.T) {
+			t.Parallel()
+			db := sqlitestdb.New(t, sqlitestdb.Config{Driver: driver}, m)
+
+			var name string
+			assert.NilError(t, db.QueryRowContext(ctx, "SELECT name FROM cats WHERE id = 3").Scan(&name))
+			assert.Equal(t, "o'malley; the third", name)
+
+			// The sequence isn't duplicated, and new cats don't reuse the
+			// rowid of the deleted one.
+			var sequences int
+			assert.NilError(t, db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_sequence").Scan(&sequences))
+			assert.Equal(t, 1, sequences)
+
+			res, err := db.ExecContext(ctx, "INSERT INTO cats (name) VALUES ('sunny')")
+			assert.NilError(t, err)
+			id, err := res.LastInsertId()
+			assert.NilError(t, err)
+			assert.Equal(t, int64(4), id)
+		})
+	}
+
+	broken := "PRAGMA foreign_keys=OFF;\n" +
+		"BEGIN TRANSACTION;\n" +
+		"CREATE TABLE cats (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT);\n" +
+		"INSERT INTO cats VALUES(1,'daisy; the first');\n" +
+		"INSERT INTO cats VALUES(1,'sunny');\n" +
+		"COMMIT;\n"
+	_, err := sqlitestdb.Prepare(ctx, sqlitestdb.Config{Driver: "sqlite3"}, sqlitestdb.SchemaFile(fstest.MapFS{"cats.dump.sql": {Data: []byte(broken)}}, "cats.dump.sql"))
+	assert.ErrorContains(t, err, "cats.dump.sql: statement 5, line 5: INSERT INTO cats VALUES(1,'sunny'): UNIQUE constraint failed: cats.id")
+}
+
+func TestSchemaFileFromDisk(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "schema.sql")
+	assert.NilError(t, os.WriteFile(path, []byte(schemaDump), 0o644))
+
+	m := sqlitestdb.SchemaFile(nil, path)
+	hash1, err := m.Hash()
+	assert.NilError(t, err)
+	fsHash, err := sqlitestdb.SchemaFile(fstest.MapFS{"schema.sql": {Data: []byte(schemaDump)}}, "schema.sql").Hash()
+	assert.NilError(t, err)
+	assert.Equal(t, hash1, fsHash)
+
+	db := sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, m)
+	var count int
+	assert.NilError(t, db.QueryRow("SELECT COUNT(*) FROM cats").Scan(&count))
+	assert.Equal(t, 1, count)
+
+	assert.NilError(t, os.WriteFile(path, []byte(schemaDump+"CREATE TABLE dogs (id INTEGER PRIMARY KEY);\n"), 0o644))
+	hash2, err := m.Hash()
+	assert.NilError(t, err)
+	assert.Assert(t, hash1 != hash2)
+}
+
+func TestSchemaFileWithTxMigrations(t *testing.T) {
+	t.Parallel()
+	m := sqlitestdb.SchemaFile(fstest.MapFS{"schema.sql": {Data: []byte(schemaDump)}}, "schema.sql")
+	db := sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, m, sqlitestdb.WithTxMigrations())
+
+	var count int
+	assert.NilError(t, db.QueryRow("SELECT COUNT(*) FROM cats").Scan(&count))
+	assert.Equal(t, 1, count)
+}
+
+func TestSchemaFileFails(t *testing.T) {
+	t.Parallel()
+
+	schema := "PRAGMA journal_mode=WAL;\n" +
+		"CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT);\n" +
+		"\n" +
+		"INSERT INTO dogs (name)\n" +
+		"  VALUES ('rex');\n"
+	m := sqlitestdb.SchemaFile(fstest.MapFS{"schema.sql": {Data: []byte(schema)}}, "schema.sql")
+
+	_, err := sqlitestdb.Prepare(context.Background(), sqlitestdb.Config{Driver: "sqlite3"}, m)
+	assert.ErrorContains(t, err, "schema.sql: statement 3, line 4: INSERT INTO dogs (name) VALUES ('rex'): no such table: dogs")
+}
+
+func TestDumpSchema(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	for _, driver := range []string{"sqlite3", "sqlite"} {
+		t.Run(driver, func(t *testing.T) {
+			t.Parallel()
+			db := sqlitestdb.New(t, sqlitestdb.Config{Driver: driver}, defaultMigrator())
+
+			dump, err := sqlitestdb.DumpSchema(ctx, db)
+			assert.NilError(t, err)
+			assert.Equal(t, dump, "PRAGMA application_id = 0;\n"+
+				"PRAGMA user_version = 0;\n"+
+				"CREATE TABLE cats ( id INTEGER PRIMARY KEY, name TEXT );\n")
+		})
+	}
+}
+
+func TestDumpSchemaExclude(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	m := &sqlMigrator{
+		migrations: []string{
+			"CREATE TABLE versions (version INTEGER PRIMARY KEY)",
+			"CREATE UNIQUE INDEX versions_desc ON versions (version DESC)",
+			"CREATE TABLE cats (\n\tid INTEGER PRIMARY KEY AUTOINCREMENT,\n\tname TEXT DEFAULT 'two  spaces'\n)",
+			"CREATE VIEW cat_names AS SELECT name FROM cats",
+			"CREATE INDEX cats_name ON cats (name)",
+			"CREATE TRIGGER versions_log AFTER INSERT ON versions BEGIN DELETE FROM cats; END",
+			"PRAGMA user_version = 7",
+		},
+	}
+	db := sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, m)
+
+	dump, err := sqlitestdb.DumpSchema(ctx, db, "versions")
+	assert.NilError(t, err)
+	assert.Equal(t, dump, "PRAGMA application_id = 0;\n"+
+		"PRAGMA user_version = 7;\n"+
+		"CREATE INDEX cats_name ON cats (name);\n"+
+		"CREATE TABLE cats ( id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT DEFAULT 'two  spaces' );\n"+
+		"CREATE VIEW cat_names AS SELECT name FROM cats;\n")
+}
+
+func TestDump(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	m := &sqlMigrator{
+		migrations: []string{
+			"CREATE TABLE owners (id INTEGER PRIMARY KEY, name TEXT)",
+			"INSERT INTO owners (name) VALUES ('terin')",
+			"CREATE TABLE cats (id INTEGER PRIMARY KEY AUTOINCREMENT, owner_id INTEGER REFERENCES owners (id), name TEXT, photo BLOB, weight REAL, shout TEXT AS (upper(name)))",
+			"INSERT INTO cats (owner_id, name, photo, weight) VALUES (1, 'o''malley; the third', x'00ff', 4.5), (NULL, 'daisy', NULL, NULL)",
+			"DELETE FROM cats WHERE name = 'daisy'",
+			"CREATE TABLE tags (name TEXT PRIMARY KEY, n INTEGER) WITHOUT ROWID",
+			"INSERT INTO tags VALUES ('b', 2), ('a', 1)",
+			"CREATE INDEX cats_name ON cats (name)",
+			"CREATE VIEW cat_names AS SELECT name FROM cats",
+			"CREATE TRIGGER owners_gone AFTER DELETE ON owners BEGIN DELETE FROM cats WHERE owner_id = OLD.id; END",
+			"PRAGMA user_version = 3",
+		},
+	}
+
+	for _, driver := range []string{"sqlite3", "sqlite"} {
+		t.Run(driver, func(t *testing.T) {
+			t.Parallel()
+			db := sqlitestdb.New(t, sqlitestdb.Config{Driver: driver}, m)
+
+			var dump strings.Builder
+			assert.NilError(t, sqlitestdb.Dump(ctx, db, &dump))
+			assert.Equal(t, dump.String(), "PRAGMA foreign_keys=OFF;\n"+
+				"PRAGMA user_version=3;\n"+
+				"BEGIN TRANSACTION;\n"+
+				"CREATE TABLE owners (id INTEGER PRIMARY KEY, name TEXT);\n"+
+				"INSERT INTO \"owners\" VALUES(1,'terin');\n"+
+				"CREATE TABLE cats (id INTEGER PRIMARY KEY AUTOINCREMENT, owner_id INTEGER REFERENCES owners (id), name TEXT, photo BLOB, weight REAL, shout TEXT AS (upper(name)));\n"+
+				"INSERT INTO \"cats\" VALUES(1,1,'o''malley; the third',X'00FF',4.5);\n"+
+				"CREATE TABLE tags (name TEXT PRIMARY KEY, n INTEGER) WITHOUT ROWID;\n"+
+				"INSERT INTO \"tags\" VALUES('a',1);\n"+
+				"INSERT INTO \"tags\" VALUES('b',2);\n"+
+				"DELETE FROM sqlite_sequence;\n"+
+				"INSERT INTO sqlite_sequence VALUES('cats',2);\n"+
+				"CREATE VIEW cat_names AS SELECT name FROM cats;\n"+
+				"CREATE TRIGGER owners_gone AFTER DELETE ON owners BEGIN DELETE FROM cats WHERE owner_id = OLD.id; END;\n"+
+				"CREATE INDEX cats_name ON cats (name);\n"+
+				"COMMIT;\n")
+
+			// Executing the dump creates a copy of the database, including the
+			// last rowid of cats.
+			copied := sqlitestdb.New(t, sqlitestdb.Config{Driver: driver}, sqlitestdb.SchemaFile(fstest.MapFS{
+				"dump.sql": {Data: []byte(dump.String())},
+			}, "dump.sql"))
+			var again strings.Builder
+			assert.NilError(t, sqlitestdb.Dump(ctx, copied, &again))
+			assert.Equal(t, again.String(), dump.String())
+
+			var id int
+			assert.NilError(t, copied.QueryRowContext(ctx, "INSERT INTO cats (name) VALUES ('sunny') RETURNING id").Scan(&id))
+			assert.Equal(t, id, 3)
+		})
+	}
+}
+
+func TestDumpRoundTrip(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	m := &sqlMigrator{
+		migrations: []string{
+			"CREATE TABLE \"odd \"\"name\"\"\" (\"select\" TEXT, r REAL, b BLOB, i INTEGER, x)",
+			"INSERT INTO \"odd \"\"name\"\"\" VALUES ('line one\nline two', 0.1 + 0.2, zeroblob(3), -9223372036854775808, 1e999), ('', -0.0, x'', 0, 'it''s')",
+		},
+	}
+
+	for _, driver := range []string{"sqlite3", "sqlite"} {
+		t.Run(driver, func(t *testing.T) {
+			t.Parallel()
+			db := sqlitestdb.New(t, sqlitestdb.Config{Driver: driver}, m)
+			var dump strings.Builder
+			assert.NilError(t, sqlitestdb.Dump(ctx, db, &dump))
+
+			copied := sqlitestdb.New(t, sqlitestdb.Config{Driver: driver}, sqlitestdb.NoopMigrator{})
+			conn, err := copied.Conn(ctx)
+			assert.NilError(t, err)
+			defer conn.Close()
+			assert.NilError(t, sqlitestdb.ExecSplit(ctx, conn, dump.String()))
+
+			var again strings.Builder
+			assert.NilError(t, sqlitestdb.Dump(ctx, copied, &again))
+			assert.Equal(t, again.String(), dump.String())
+
+			// The values, and their types, are the same in the copy.
+			query := "SELECT \"select\", r, b, i, x, typeof(r), typeof(b), typeof(x) FROM \"odd \"\"name\"\"\" ORDER BY rowid"
+			assert.DeepEqual(t, queryRows(t, copied, query), queryRows(t, db, query))
+		})
+	}
+}
+
+func TestDumpVirtualTable(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	// The FTS5 extension is only built into modernc.org/sqlite by default.
+	config := sqlitestdb.Config{Driver: "sqlite"}
+	db := sqlitestdb.New(t, config, &sqlMigrator{
+		migrations: []string{
+			"CREATE VIRTUAL TABLE notes USING fts5(body)",
+			"INSERT INTO notes (body) VALUES ('feed the cats'), ('walk the dog')",
+		},
+	})
+
+	var dump strings.Builder
+	assert.NilError(t, sqlitestdb.Dump(ctx, db, &dump))
+	assert.Equal(t, dump.String(), "PRAGMA foreign_keys=OFF;\n"+
+		"BEGIN TRANSACTION;\n"+
+		"CREATE VIRTUAL TABLE notes USING fts5(body);\n"+
+		"INSERT INTO \"notes\" VALUES('feed the cats');\n"+
+		"INSERT INTO \"notes\" VALUES('walk the dog');\n"+
+		"COMMIT;\n")
+
+	copied := sqlitestdb.New(t, config, sqlitestdb.SchemaFile(fstest.MapFS{
+		"dump.sql": {Data: []byte(dump.String())},
+	}, "dump.sql"))
+	var body string
+	assert.NilError(t, copied.QueryRowContext(ctx, "SELECT body FROM notes WHERE notes MATCH 'cats'").Scan(&body))
+	assert.Equal(t, body, "feed the cats")
+}
+
+func queryRows(t *testing.T, db *sql.DB, query string) []string {
+	t.Helper()
+	rows, err := db.Query(query)
+	assert.NilError(t, err)
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	assert.NilError(t, err)
+	var out []string
+	for rows.Next() {
+		values := make([]any, len(columns))
+		dest := make([]any, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		assert.NilError(t, rows.Scan(dest...))
+		out = append(out, fmt.Sprintf("%#v", values))
+	}
+	assert.NilError(t, rows.Err())
+	return out
+}
+
+func TestExecSplit(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	script := "CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT);\n" +
+		"CREATE TABLE log (entry TEXT);\n" +
+		"CREATE TRIGGER cats_log AFTER INSERT ON cats BEGIN\n" +
+		"  INSERT INTO log VALUES ('added; ' || NEW.name);\n" +
+		"  INSERT INTO log VALUES (CASE WHEN NEW.name = 'END;' THEN 'odd' ELSE 'ok' END);\n" +
+		"END;\n" +
+		"-- seed the cats; twice\n" +
+		"INSERT INTO cats (name) VALUES ('daisy'), ('END;');\n"
+
+	for _, driver := range []string{"sqlite3", "sqlite"} {
+		t.Run(driver, func(t *testing.T) {
+			t.Parallel()
+			db := sqlitestdb.New(t, sqlitestdb.Config{Driver: driver}, sqlitestdb.MigrateFunc("exec-split", func(ctx context.Context, db *sql.DB) error {
+				return sqlitestdb.ExecSplit(ctx, db, script)
+			}))
+
+			var entries []string
+			rows, err := db.QueryContext(ctx, "SELECT entry FROM log ORDER BY rowid")
+			assert.NilError(t, err)
+			defer rows.Close()
+			for rows.Next() {
+				var entry string
+				assert.NilError(t, rows.Scan(&entry))
+				entries = append(entries, entry)
+			}
+			assert.NilError(t, rows.Err())
+			assert.DeepEqual(t, entries, []string{"added; daisy", "ok", "added; END;", "odd"})
+
+			err = sqlitestdb.ExecSplit(ctx, db, "INSERT INTO cats (name) VALUES ('sunny');\n\nINSERT INTO dogs (name)\n  VALUES ('rex');\n")
+			assert.ErrorContains(t, err, "statement 2, line 3: INSERT INTO dogs (name) VALUES ('rex'): ")
+			assert.ErrorContains(t, err, "no such table: dogs")
+		})
+	}
+}
+
+func TestAssertSchemaGolden(t *testing.T) {
+	t.Parallel()
+
+	for _, driver := range []string{"sqlite3", "sqlite"} {
+		t.Run(driver, func(t *testing.T) {
+			t.Parallel()
+			config := sqlitestdb.Config{Driver: driver}
+
+			sqlitestdb.AssertSchemaGolden(t, config, defaultMigrator(), "testdata/cats.schema.sql")
+
+			ftb := runFake(t, func(tb testing.TB) {
+				sqlitestdb.AssertSchemaGolden(tb, config, &sqlMigrator{migrations: []string{
+					"CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT, age INTEGER)",
+					"CREATE INDEX cats_name ON cats (name)",
+				}}, "testdata/cats.schema.sql")
+			})
+			assert.ErrorContains(t, ftb.err(), "schema differs from golden file testdata/cats.schema.sql")
+			assert.ErrorContains(t, ftb.err(), "--- testdata/cats.schema.sql\n"+
+				"+++ schema of github.com/terinjokes/sqlitestdb_test.sqlMigrator\n"+
+				"@@ -1,3 +1,4 @@\n"+
+				" PRAGMA application_id = 0;\n"+
+				" PRAGMA user_version = 0;\n"+
+				"-CREATE TABLE cats ( id INTEGER PRIMARY KEY, name TEXT );\n"+
+				"+CREATE INDEX cats_name ON cats (name);\n"+
+				"+CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT, age INTEGER);\n")
+
+			ftb = runFake(t, func(tb testing.TB) {
+				sqlitestdb.AssertSchemaGolden(tb, config, defaultMigrator(), "testdata/missing.schema.sql")
+			})
+			assert.ErrorContains(t, ftb.err(), "golden file testdata/missing.schema.sql doesn't exist")
+		})
+	}
+}
+
+func TestAssertSchemaGoldenUpdate(t *testing.T) {
+	golden := filepath.Join(t.TempDir(), "schema", "cats.sql")
+	assert.NilError(t, os.MkdirAll(filepath.Dir(golden), 0o755))
+	assert.NilError(t, os.WriteFile(golden, []byte("outdated\n"), 0o644))
+
+	t.Setenv("SQLITESTDB_UPDATE_GOLDEN", "1")
+	sqlitestdb.AssertSchemaGolden(t, sqlitestdb.Config{Driver: "sqlite3"}, defaultMigrator(), golden)
+
+	updated, err := os.ReadFile(golden)
+	assert.NilError(t, err)
+	want, err := os.ReadFile("testdata/cats.schema.sql")
+	assert.NilError(t, err)
+	assert.Equal(t, string(want), string(updated))
+
+	t.Setenv("SQLITESTDB_UPDATE_GOLDEN", "0")
+	sqlitestdb.AssertSchemaGolden(t, sqlitestdb.Config{Driver: "sqlite3"}, defaultMigrator(), golden)
+}
+
+func TestMigrateFunc(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	createTable := func(table string) func(context.Context, *sql.DB) error {
+		return func(ctx context.Context, db *sql.DB) error {
+			_, err := db.ExecContext(ctx, "CREATE TABLE "+table+" (id INTEGER PRIMARY KEY)")
+			return err
+		}
+	}
+	cats := sqlitestdb.MigrateFunc("migrate-func-cats", createTable("cats"))
+	dogs := sqlitestdb.MigrateFunc("migrate-func-dogs", createTable("dogs"))
+
+	catsTpl, err := sqlitestdb.Prepare(ctx, sqlitestdb.Config{Driver: "sqlite3"}, cats)
+	assert.NilError(t, err)
+	dogsTpl, err := sqlitestdb.Prepare(ctx, sqlitestdb.Config{Driver: "sqlite3"}, dogs)
+	assert.NilError(t, err)
+	assert.Assert(t, catsTpl.Database != dogsTpl.Database)
+
+	for table, m := range map[string]sqlitestdb.Migrator{"cats": cats, "dogs": dogs} {
+		db := sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, m)
+		var tables []string
+		rows, err := db.QueryContext(ctx, "SELECT name FROM sqlite_schema WHERE type = 'table' AND name IN ('cats', 'dogs')")
+		assert.NilError(t, err)
+		for rows.Next() {
+			var name string
+			assert.NilError(t, rows.Scan(&name))
+			tables = append(tables, name)
+		}
+		assert.NilError(t, rows.Err())
+		assert.DeepEqual(t, tables, []string{table})
+	}
+
+	// The hash is safe to use in file names, whatever the key contains.
+	hash, err := sqlitestdb.MigrateFunc("schema/v1: cats", createTable("cats")).Hash()
+	assert.NilError(t, err)
+	assert.Assert(t, regexp.MustCompile(`^[0-9a-f]+$`).MatchString(hash), hash)
+
+	_, err = sqlitestdb.Prepare(ctx, sqlitestdb.Config{Driver: "sqlite3"}, sqlitestdb.MigrateFunc("", createTable("cats")))
+	assert.ErrorContains(t, err, "MigrateFunc requires a non-empty hash")
+}
+
+// schemaVersion is a [fmt.Stringer] used as the key of a MigrateFuncKeyed.
+type schemaVersion struct{ major, minor int }
+
+func (v *schemaVersion) String() string {
+	return fmt.Sprintf("v%d.%d", v.major, v.minor)
+}
+
+func TestMigrateFuncKeyed(t *testing.T) {
+	t.Parallel()
+
+	ddl := []byte("CREATE TABLE cats (id INTEGER PRIMARY KEY)")
+	migrate := func(ctx context.Context, db *sql.DB) error {
+		_, err := db.ExecContext(ctx, string(ddl))
+		return err
+	}
+
+	version := &schemaVersion{1, 0}
+	m := sqlitestdb.MigrateFuncKeyed(migrate, ddl, version)
+	hash1, err := m.Hash()
+	assert.NilError(t, err)
+
+	same, err := sqlitestdb.MigrateFuncKeyed(migrate, ddl, &schemaVersion{1, 0}).Hash()
+	assert.NilError(t, err)
+	assert.Equal(t, hash1, same)
+
+	// Stringers are formatted each time the migrator is hashed.
+	version.minor++
+	hash2, err := m.Hash()
+	assert.NilError(t, err)
+	assert.Assert(t, hash1 != hash2)
+
+	other, err := sqlitestdb.MigrateFuncKeyed(migrate, []byte("CREATE TABLE dogs (id INTEGER PRIMARY KEY)"), version).Hash()
+	assert.NilError(t, err)
+	assert.Assert(t, hash2 != other)
+
+	db := sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, m)
+	var count int
+	assert.NilError(t, db.QueryRow("SELECT COUNT(*) FROM cats").Scan(&count))
+	assert.Equal(t, 0, count)
+
+	_, err = sqlitestdb.MigrateFuncKeyed(migrate).Hash()
+	assert.ErrorContains(t, err, "MigrateFuncKeyed requires at least one key")
+}
+
+func TestSequence(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	schema := &sqlMigrator{migrations: []string{"CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT)"}}
+	fixtures := &sqlMigrator{migrations: []string{"INSERT INTO cats (name) VALUES ('daisy'), ('sunny')"}}
+	analyze := &sqlMigrator{migrations: []string{"ANALYZE"}}
+
+	m := sqlitestdb.Sequence(schema, fixtures, analyze)
+	db := sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite3"}, m)
+
+	var count int
+	assert.NilError(t, db.QueryRowContext(ctx, "SELECT COUNT(*) FROM cats").Scan(&count))
+	assert.Equal(t, 2, count)
+	assert.NilError(t, db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_stat1").Scan(&count))
+	assert.Assert(t, count > 0)
+
+	hash, err := m.Hash()
+	assert.NilError(t, err)
+
+	// Changing, reordering, or removing any of the migrators changes the hash.
+	changed := &sqlMigrator{migrations: []string{"INSERT INTO cats (name) VALUES ('mittens')"}}
+	for name, other := range map[string]sqlitestdb.Migrator{
+		"changed":   sqlitestdb.Sequence(schema, changed, analyze),
+		"reordered": sqlitestdb.Sequence(schema, analyze, fixtures),
+		"removed":   sqlitestdb.Sequence(schema, fixtures),
+	} {
+		otherHash, err := other.Hash()
+		assert.NilError(t, err)
+		assert.Assert(t, hash != otherHash, name)
+	}
+}
+
+func TestSequenceFails(t *testing.T) {
+	t.Parallel()
+
+	m := sqlitestdb.Sequence(
+		&sqlMigrator{migrations: []string{"CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT)"}},
+		&sqlMigrator{migrations: []string{"INSERT INTO dogs (name) VALUES ('rex')"}},
+		&sqlMigrator{migrations: []string{"ANALYZE"}},
+	)
+	_, err := sqlitestdb.Prepare(context.Background(), sqlitestdb.Config{Driver: "sqlite3"}, m)
+	assert.ErrorContains(t, err, "migrator 1 (*sqlitestdb_test.sqlMigrator) of sequence: no such table: dogs")
 }
@@ -0,0 +1,217 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package sqlitestdb
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"text/tabwriter"
+)
+
+// TemplateSource describes how a template was obtained the first time it was
+// needed by this program.
+type TemplateSource int
+
+const (
+	// TemplatePending means the template is still being created, or creating
+	// it failed.
+	TemplatePending TemplateSource = iota
+	// TemplateCreated means the migrations were run to create the template.
+	TemplateCreated
+	// TemplateReused means a template left by a previous run, or by another
+	// process, was reused from disk.
+	TemplateReused
+)
+
+func (s TemplateSource) String() string {
+	switch s {
+	case TemplateCreated:
+		return "created"
+	case TemplateReused:
+		return "reused from disk"
+	default:
+		return "pending"
+	}
+}
+
+// TemplateStats describes how a single template was used by this program.
+type TemplateStats struct {
+	Hash   string         // The hash of the template, see [TemplateHash].
+	Path   string         // The path of the template database.
+	Source TemplateSource // How the template was first obtained.
+	// CacheHits is the number of times a test reused the template from the
+	// in-process cache, after it was first obtained. Lookups that aren't made
+	// for a test, such as by [Prepare] or by a pool, are not counted.
+	CacheHits int64
+	// PoolHits and PoolMisses are the number of times an instance database
+	// was, or wasn't, available in the pool enabled by [WithInstancePool].
+	PoolHits   int64
+	PoolMisses int64
+}
+
+// Stats returns the statistics of every template used by this program so far,
+// ordered by hash. If templates are created much more often than expected, the
+// hash of a migrator is likely unstable, see [AssertStableHash].
+func Stats() []TemplateStats {
+	var stats []TemplateStats
+	templateCounters.Range(func(_, value any) bool {
+		stats = append(stats, value.(*templateCounter).stats())
+		return true
+	})
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Hash != stats[j].Hash {
+			return stats[i].Hash < stats[j].Hash
+		}
+		return stats[i].Path < stats[j].Path
+	})
+
+	return stats
+}
+
+// WriteStats writes a summary of [Stats] to w, intended to be called from
+// TestMain at the end of a run.
+//
+//	func TestMain(m *testing.M) {
+//		code := m.Run()
+//		sqlitestdb.WriteStats(os.Stderr)
+//		os.Exit(code)
+//	}
+func WriteStats(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "HASH\tSOURCE\tCACHE HITS\tPOOL HITS\tPOOL MISSES\tPATH")
+	for _, s := range Stats() {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%s\n", s.Hash, s.Source, s.CacheHits, s.PoolHits, s.PoolMisses, s.Path)
+	}
+
+	return tw.Flush()
+}
+
+// AssertStableHash calls the migrator's Hash method several times, and fails
+// the test with [testing.TB.Fatalf] if it ever returns an error or a different
+// hash. An unstable hash, for example one that depends on map iteration order,
+// causes the template to be rebuilt far more often than necessary.
+func AssertStableHash(t testing.TB, migrator Migrator) {
+	t.Helper()
+
+	const calls = 5
+	hashes := make([]string, calls)
+	for i := range hashes {
+		hash, err := migrator.Hash()
+		if err != nil {
+			t.Fatalf("could not hash migrator: %+v", err)
+		}
+		hashes[i] = hash
+	}
+
+	for _, hash := range hashes[1:] {
+		if hash != hashes[0] {
+			t.Fatalf("migrator %T returned different hashes across %d calls: %q", migrator, calls, hashes)
+		}
+	}
+}
+
+// templateCounters tracks the usage of each template, keyed the same way as the
+// templates map.
+var templateCounters sync.Map // map[string]*templateCounter
+
+// counterFor returns the counter of the template with the key and hash.
+func counterFor(key, mhash string) *templateCounter {
+	counter, _ := templateCounters.LoadOrStore(key, &templateCounter{hash: mhash})
+	return counter.(*templateCounter)
+}
+
+type templateCounter struct {
+	hash       string
+	cacheHits  atomic.Int64
+	poolHits   atomic.Int64
+	poolMisses atomic.Int64
+
+	mu         sync.Mutex
+	path       string
+	source     TemplateSource
+	err        error // the first failure to create the template
+	lateErr    error // the first failure to rebuild it, once created
+	recovered  bool  // the template was rebuilt after lateErr
+	rebuildErr error
+}
+
+// fail stores why the template could not be obtained, telling apart failures
+// before the template was first created, and those rebuilding it afterwards.
+// Only the first failure of each is kept.
+func (c *templateCounter) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.source == TemplatePending {
+		if c.err == nil {
+			c.err = err
+		}
+		return
+	}
+
+	if c.lateErr == nil {
+		c.lateErr = err
+	}
+	c.recovered = false
+}
+
+// failures returns the first failure to create the template, and whether it
+// was created afterwards, along with the first failure to rebuild it, and
+// whether it was rebuilt afterwards.
+func (c *templateCounter) failures() (err error, created bool, lateErr error, recovered bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.err, c.source != TemplatePending, c.lateErr, c.recovered
+}
+
+// rebuilt stores why the template was rebuilt, such as failing validation.
+// Only the first reason is kept.
+func (c *templateCounter) rebuilt(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.rebuildErr == nil {
+		c.rebuildErr = err
+	}
+}
+
+func (c *templateCounter) rebuildReason() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.rebuildErr
+}
+
+// record stores how the template was first obtained.
+func (c *templateCounter) record(path string, created bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.path = path
+	c.recovered = c.lateErr != nil
+	c.source = TemplateReused
+	if created {
+		c.source = TemplateCreated
+	}
+}
+
+func (c *templateCounter) stats() TemplateStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return TemplateStats{
+		Hash:       c.hash,
+		Path:       c.path,
+		Source:     c.source,
+		CacheHits:  c.cacheHits.Load(),
+		PoolHits:   c.poolHits.Load(),
+		PoolMisses: c.poolMisses.Load(),
+	}
+}
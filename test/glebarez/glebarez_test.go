@@ -0,0 +1,204 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+// github.com/glebarez/go-sqlite registers itself as "sqlite", like
+// modernc.org/sqlite, so it is being tested separately here, in its own module.
+
+package glebarez_test
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	_ "github.com/glebarez/go-sqlite"
+	"github.com/terinjokes/sqlitestdb"
+	"github.com/terinjokes/sqlitestdb/migrators/hash"
+	"gotest.tools/v3/assert"
+)
+
+func New(t *testing.T, opts ...sqlitestdb.Option) *sql.DB {
+	t.Helper()
+	dbconf := sqlitestdb.Config{
+		Driver: "sqlite",
+	}
+	m := defaultMigrator()
+	return sqlitestdb.New(t, dbconf, m, opts...)
+}
+
+func TestGlebarez(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := New(t)
+
+	var names []string
+	rows, err := db.QueryContext(ctx, "SELECT name FROM cats ORDER BY name ASC")
+	assert.NilError(t, err)
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		assert.NilError(t, rows.Scan(&name))
+		names = append(names, name)
+	}
+	assert.NilError(t, rows.Err())
+	assert.DeepEqual(t, names, []string{"daisy", "sunny"})
+
+	// The PRAGMAs set by the migrations are cloned.
+	var version int
+	assert.NilError(t, db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version))
+	assert.Equal(t, 3, version)
+}
+
+func TestGlebarezCloneStrategies(t *testing.T) {
+	t.Parallel()
+
+	// The driver doesn't expose the Online Backup API, so CloneBackup uses
+	// "VACUUM INTO".
+	for _, strategy := range []sqlitestdb.CloneStrategy{
+		sqlitestdb.CloneVacuum,
+		sqlitestdb.CloneCopy,
+		sqlitestdb.CloneBackup,
+		sqlitestdb.CloneReflink,
+	} {
+		t.Run(string(strategy), func(t *testing.T) {
+			t.Parallel()
+			db := New(t, sqlitestdb.WithCloneStrategy(strategy))
+
+			var count int
+			assert.NilError(t, db.QueryRow("SELECT COUNT(*) FROM cats").Scan(&count))
+			assert.Equal(t, 2, count)
+		})
+	}
+}
+
+func TestGlebarezWAL(t *testing.T) {
+	t.Parallel()
+
+	m := &sqlMigrator{migrations: []string{
+		"PRAGMA journal_mode=WAL",
+		"CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT)",
+		"INSERT INTO cats (name) VALUES ('daisy')",
+	}}
+	db := sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite"}, m)
+
+	var mode string
+	assert.NilError(t, db.QueryRow("PRAGMA journal_mode").Scan(&mode))
+	assert.Equal(t, "wal", mode)
+	var count int
+	assert.NilError(t, db.QueryRow("SELECT COUNT(*) FROM cats").Scan(&count))
+	assert.Equal(t, 1, count)
+}
+
+func TestGlebarezTxMigrations(t *testing.T) {
+	t.Parallel()
+
+	db := New(t, sqlitestdb.WithTxMigrations(), sqlitestdb.WithForeignKeyCheck())
+
+	var count int
+	assert.NilError(t, db.QueryRow("SELECT COUNT(*) FROM cats").Scan(&count))
+	assert.Equal(t, 2, count)
+}
+
+func TestGlebarezNewShared(t *testing.T) {
+	t.Parallel()
+
+	// Shared databases are opened with "mode=ro&immutable=1".
+	db := sqlitestdb.NewShared(t, sqlitestdb.Config{Driver: "sqlite"}, defaultMigrator())
+
+	var count int
+	assert.NilError(t, db.QueryRow("SELECT COUNT(*) FROM cats").Scan(&count))
+	assert.Equal(t, 2, count)
+	_, err := db.Exec("INSERT INTO cats (name) VALUES ('bandit')")
+	assert.ErrorContains(t, err, "readonly")
+}
+
+func TestGlebarezReadMeta(t *testing.T) {
+	t.Parallel()
+	db := New(t)
+
+	var path string
+	assert.NilError(t, db.QueryRow("SELECT file FROM pragma_database_list WHERE name = 'main'").Scan(&path))
+	meta, err := sqlitestdb.ReadMeta(path)
+	assert.NilError(t, err)
+	assert.Equal(t, "sqlite", meta.Driver)
+}
+
+func TestGlebarezDump(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := New(t)
+
+	var dump strings.Builder
+	assert.NilError(t, sqlitestdb.Dump(ctx, db, &dump))
+	assert.Equal(t, dump.String(), "PRAGMA foreign_keys=OFF;\n"+
+		"PRAGMA user_version=3;\n"+
+		"BEGIN TRANSACTION;\n"+
+		"CREATE TABLE cats (\n\t\t\t\tid INTEGER PRIMARY KEY,\n\t\t\t\tname TEXT\n\t\t\t);\n"+
+		"INSERT INTO \"cats\" VALUES(1,'daisy');\n"+
+		"INSERT INTO \"cats\" VALUES(2,'sunny');\n"+
+		"COMMIT;\n")
+
+	m := sqlitestdb.SchemaFile(fstest.MapFS{"dump.sql": {Data: []byte(dump.String())}}, "dump.sql")
+	copied := sqlitestdb.New(t, sqlitestdb.Config{Driver: "sqlite"}, m)
+	var again strings.Builder
+	assert.NilError(t, sqlitestdb.Dump(ctx, copied, &again))
+	assert.Equal(t, again.String(), dump.String())
+}
+
+func TestGlebarezMigrationsAreInterrupted(t *testing.T) {
+	t.Parallel()
+
+	nonce := make([]byte, 8)
+	_, err := rand.Read(nonce)
+	assert.NilError(t, err)
+	m := &sqlMigrator{migrations: []string{
+		"-- " + hex.EncodeToString(nonce) + "\n" +
+			"WITH RECURSIVE forever(n) AS (SELECT 1 UNION ALL SELECT n + 1 FROM forever) SELECT count(*) FROM forever",
+	}}
+
+	start := time.Now()
+	_, err = sqlitestdb.Prepare(context.Background(), sqlitestdb.Config{Driver: "sqlite"}, m, sqlitestdb.WithMigrateTimeout(100*time.Millisecond))
+	assert.ErrorContains(t, err, "timed out after 100ms")
+	assert.Assert(t, time.Since(start) < 10*time.Second, "migration was not interrupted")
+}
+
+func defaultMigrator() sqlitestdb.Migrator {
+	return &sqlMigrator{
+		migrations: []string{`
+			CREATE TABLE cats (
+				id INTEGER PRIMARY KEY,
+				name TEXT
+			);
+			INSERT INTO cats (name) VALUES ('daisy'), ('sunny');
+			PRAGMA user_version = 3;
+		`},
+	}
+}
+
+type sqlMigrator struct {
+	migrations []string
+}
+
+func (m *sqlMigrator) Hash() (string, error) {
+	h := hash.NewRecursiveHash()
+	for _, migration := range m.migrations {
+		h.Add([]byte(migration))
+	}
+	return h.String(), nil
+}
+
+func (m *sqlMigrator) Migrate(ctx context.Context, db *sql.DB, _ sqlitestdb.Config) error {
+	for _, migration := range m.migrations {
+		if err := sqlitestdb.ExecSplit(ctx, db, migration); err != nil {
+			return err
+		}
+	}
+	return nil
+}
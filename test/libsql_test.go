@@ -9,10 +9,12 @@ package libsql_test
 import (
 	"context"
 	"database/sql"
+	"os"
 	"testing"
+	"testing/fstest"
 
-	"github.com/peterldowns/pgtestdb/migrators/common"
 	"github.com/terinjokes/sqlitestdb"
+	"github.com/terinjokes/sqlitestdb/migrators/hash"
 	_ "github.com/tursodatabase/go-libsql"
 	"gotest.tools/v3/assert"
 )
@@ -47,12 +49,78 @@ func TestLibSQL(t *testing.T) {
 	assert.DeepEqual(t, names, []string{"daisy", "sunny"})
 }
 
+func TestLibSQLCloseInTest(t *testing.T) {
+	t.Parallel()
+
+	// Cleanups run in reverse order, so this runs after sqlitestdb's cleanup.
+	var path string
+	t.Cleanup(func() {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("instance database %q was not removed: %v", path, err)
+		}
+	})
+
+	db := New(t)
+	defer db.Close()
+
+	err := db.QueryRow("SELECT file FROM pragma_database_list WHERE name = 'main'").Scan(&path)
+	assert.NilError(t, err)
+}
+
+func TestLibSQLCloneBackupFallsBack(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// libsql doesn't expose the Online Backup API, so "VACUUM INTO" is used.
+	db := sqlitestdb.New(t, sqlitestdb.Config{Driver: "libsql"}, defaultMigrator(), sqlitestdb.WithCloneStrategy(sqlitestdb.CloneBackup))
+
+	var count int
+	assert.NilError(t, db.QueryRowContext(ctx, "SELECT COUNT(*) FROM cats").Scan(&count))
+	assert.Equal(t, 2, count)
+}
+
+func TestLibSQLSchemaFile(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// libsql only runs the first statement of an Exec, so the file is split.
+	schema := "PRAGMA foreign_keys=OFF;\n" +
+		"BEGIN TRANSACTION;\n" +
+		"CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT);\n" +
+		"INSERT INTO cats VALUES(1,'daisy');\n" +
+		"INSERT INTO cats VALUES(2,'sunny');\n" +
+		"COMMIT;\n"
+	m := sqlitestdb.SchemaFile(fstest.MapFS{"schema.sql": {Data: []byte(schema)}}, "schema.sql")
+	db := sqlitestdb.New(t, sqlitestdb.Config{Driver: "libsql"}, m)
+
+	var count int
+	assert.NilError(t, db.QueryRowContext(ctx, "SELECT COUNT(*) FROM cats").Scan(&count))
+	assert.Equal(t, 2, count)
+}
+
+func TestLibSQLURL(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The instance is a local file, but the configuration may be given as a
+	// URL, which is passed to libsql unmodified.
+	config := sqlitestdb.Custom(t, sqlitestdb.Config{Driver: "libsql"}, defaultMigrator())
+	url := sqlitestdb.Config{Driver: "libsql", Database: "file:" + config.Database}
+	assert.Equal(t, url.URI(), "file:"+config.Database)
+
+	db, err := url.Connect()
+	assert.NilError(t, err)
+	defer db.Close()
+
+	var count int
+	assert.NilError(t, db.QueryRowContext(ctx, "SELECT COUNT(*) FROM cats").Scan(&count))
+	assert.Equal(t, 2, count)
+}
+
 func defaultMigrator() sqlitestdb.Migrator {
-	// Separate the table creation and insertion into two separate steps
-	// as libsql has [a bug] where only the first statement in a
-	// [sql.DB.ExecContext] is ran.
-	//
-	// [a bug]: https://github.com/tursodatabase/go-libsql/issues/22
 	return &sqlMigrator{
 		migrations: []string{`
 			-- the "migration"
@@ -60,10 +128,8 @@ func defaultMigrator() sqlitestdb.Migrator {
 				id INTEGER PRIMARY KEY,
 				name TEXT
 			);
-        `, `
 			INSERT INTO cats (name) VALUES ('daisy'), ('sunny');
-        `,
-		},
+        `},
 	}
 }
 
@@ -72,16 +138,18 @@ type sqlMigrator struct {
 }
 
 func (m *sqlMigrator) Hash() (string, error) {
-	hash := common.NewRecursiveHash()
+	h := hash.NewRecursiveHash()
 	for _, migration := range m.migrations {
-		hash.Add([]byte(migration))
+		h.Add([]byte(migration))
 	}
-	return hash.String(), nil
+	return h.String(), nil
 }
 
 func (m *sqlMigrator) Migrate(ctx context.Context, db *sql.DB, _ sqlitestdb.Config) error {
+	// libsql only runs the first statement of an Exec, so the migrations are
+	// split.
 	for _, migration := range m.migrations {
-		if _, err := db.ExecContext(ctx, migration); err != nil {
+		if err := sqlitestdb.ExecSplit(ctx, db, migration); err != nil {
 			return err
 		}
 	}
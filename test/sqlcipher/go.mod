@@ -0,0 +1,18 @@
+module github.com/terinjokes/sqlitestdb/test/sqlcipher
+
+go 1.22.0
+
+replace github.com/terinjokes/sqlitestdb => ../..
+
+require (
+	github.com/mutecomm/go-sqlcipher/v4 v4.4.2
+	github.com/terinjokes/sqlitestdb v0.1.0
+	gotest.tools/v3 v3.5.1
+)
+
+require (
+	braces.dev/errtrace v0.3.0 // indirect
+	github.com/google/go-cmp v0.6.0 // indirect
+	golang.org/x/mod v0.22.0 // indirect
+	golang.org/x/sys v0.27.0 // indirect
+)
@@ -0,0 +1,136 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+// github.com/mutecomm/go-sqlcipher is a fork of mattn/go-sqlite3 built with
+// SQLCipher, which registers itself as "sqlite3", so it is being tested
+// separately here, in its own module.
+
+package sqlcipher_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+
+	_ "github.com/mutecomm/go-sqlcipher/v4"
+	"github.com/terinjokes/sqlitestdb"
+	"github.com/terinjokes/sqlitestdb/migrators/hash"
+	"gotest.tools/v3/assert"
+)
+
+const key = "correct horse battery staple"
+
+// assertEncrypted checks that the database at path doesn't start with the
+// header of plaintext SQLite databases, and can't be read without the key.
+func assertEncrypted(t *testing.T, path string) {
+	t.Helper()
+	f, err := os.ReadFile(path)
+	assert.NilError(t, err)
+	assert.Assert(t, !bytes.HasPrefix(f, []byte("SQLite format 3")), "%q is not encrypted", path)
+
+	plain, err := sqlitestdb.Config{Driver: "sqlite3", Database: path}.Connect()
+	assert.NilError(t, err)
+	defer plain.Close()
+	_, err = plain.Exec("SELECT count(*) FROM sqlite_master")
+	assert.ErrorContains(t, err, "file is not a database")
+}
+
+func TestSQLCipher(t *testing.T) {
+	t.Parallel()
+	config := sqlitestdb.Config{Driver: "sqlite3", Key: key}
+
+	for _, strategy := range []sqlitestdb.CloneStrategy{sqlitestdb.CloneVacuum, sqlitestdb.CloneCopy, sqlitestdb.CloneBackup} {
+		t.Run(string(strategy), func(t *testing.T) {
+			t.Parallel()
+			db := sqlitestdb.New(t, config, defaultMigrator(), sqlitestdb.WithCloneStrategy(strategy))
+
+			var count int
+			assert.NilError(t, db.QueryRow("SELECT COUNT(*) FROM cats").Scan(&count))
+			assert.Equal(t, 2, count)
+
+			instance := sqlitestdb.ConfigFor(db)
+			assert.Equal(t, key, instance.Key)
+			assertEncrypted(t, instance.Database)
+		})
+	}
+
+	tpl, err := sqlitestdb.Prepare(context.Background(), config, defaultMigrator())
+	assert.NilError(t, err)
+	assertEncrypted(t, tpl.Database)
+}
+
+func TestSQLCipherCustom(t *testing.T) {
+	t.Parallel()
+
+	// The returned config includes the key.
+	instance := sqlitestdb.Custom(t, sqlitestdb.Config{Driver: "sqlite3", Key: key}, defaultMigrator())
+	assert.Equal(t, key, instance.Key)
+	db, err := instance.Connect()
+	assert.NilError(t, err)
+	defer db.Close()
+	var count int
+	assert.NilError(t, db.QueryRow("SELECT COUNT(*) FROM cats").Scan(&count))
+	assert.Equal(t, 2, count)
+
+	wrong := *instance
+	wrong.Key = "incorrect horse"
+	other, err := wrong.Connect()
+	assert.NilError(t, err)
+	defer other.Close()
+	_, err = other.Exec("SELECT COUNT(*) FROM cats")
+	assert.ErrorContains(t, err, "file is not a database")
+}
+
+func TestSQLCipherKeepsTemplatesApart(t *testing.T) {
+	t.Parallel()
+
+	// The same migrator creates a template for each key, and one that isn't
+	// encrypted.
+	plain, err := sqlitestdb.Prepare(context.Background(), sqlitestdb.Config{Driver: "sqlite3"}, defaultMigrator())
+	assert.NilError(t, err)
+	keyed, err := sqlitestdb.Prepare(context.Background(), sqlitestdb.Config{Driver: "sqlite3", Key: key}, defaultMigrator())
+	assert.NilError(t, err)
+	other, err := sqlitestdb.Prepare(context.Background(), sqlitestdb.Config{Driver: "sqlite3", Key: "incorrect horse"}, defaultMigrator())
+	assert.NilError(t, err)
+
+	assert.Assert(t, plain.Database != keyed.Database)
+	assert.Assert(t, keyed.Database != other.Database)
+	meta, err := sqlitestdb.ReadMeta(plain.Database)
+	assert.NilError(t, err)
+	assert.Equal(t, "sqlite3", meta.Driver)
+}
+
+func defaultMigrator() sqlitestdb.Migrator {
+	return &sqlMigrator{
+		migrations: []string{`
+			CREATE TABLE cats (
+				id INTEGER PRIMARY KEY,
+				name TEXT
+			);
+			INSERT INTO cats (name) VALUES ('daisy'), ('sunny');
+		`},
+	}
+}
+
+type sqlMigrator struct {
+	migrations []string
+}
+
+func (m *sqlMigrator) Hash() (string, error) {
+	h := hash.NewRecursiveHash()
+	for _, migration := range m.migrations {
+		h.Add([]byte(migration))
+	}
+	return h.String(), nil
+}
+
+func (m *sqlMigrator) Migrate(ctx context.Context, db *sql.DB, _ sqlitestdb.Config) error {
+	for _, migration := range m.migrations {
+		if err := sqlitestdb.ExecSplit(ctx, db, migration); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+// zombiezen.com/go/sqlite doesn't implement database/sql, so it is being tested
+// separately here, in its own module, using modernc.org/sqlite, which it is
+// built on, to migrate and clone the templates.
+
+package zombiezen_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/terinjokes/sqlitestdb"
+	"gotest.tools/v3/assert"
+	_ "modernc.org/sqlite"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+// newPool returns a pool of connections to a new instance database, which is
+// closed before the database is removed.
+func newPool(t *testing.T, migrator sqlitestdb.Migrator) *sqlitex.Pool {
+	t.Helper()
+	path := sqlitestdb.NewPath(t, sqlitestdb.Config{Driver: "sqlite"}, migrator)
+
+	pool, err := sqlitex.NewPool(path, sqlitex.PoolOptions{})
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		assert.NilError(t, pool.Close())
+	})
+	return pool
+}
+
+func TestNewPath(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	pool := newPool(t, sqlitestdb.MigrateFunc("cats", func(ctx context.Context, db *sql.DB) error {
+		_, err := db.ExecContext(ctx, "CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT); INSERT INTO cats (name) VALUES ('daisy'), ('sunny');")
+		return err
+	}))
+
+	conn, err := pool.Take(ctx)
+	assert.NilError(t, err)
+	defer pool.Put(conn)
+
+	assert.DeepEqual(t, catNames(t, conn), []string{"daisy", "sunny"})
+	assert.NilError(t, sqlitex.Execute(conn, "INSERT INTO cats (name) VALUES ('bandit')", nil))
+	assert.DeepEqual(t, catNames(t, conn), []string{"bandit", "daisy", "sunny"})
+}
+
+func TestNewPathIsolated(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	m := &zombiezenMigrator{script: "CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT);"}
+
+	firstPool := newPool(t, m)
+	first, err := firstPool.Take(ctx)
+	assert.NilError(t, err)
+	defer firstPool.Put(first)
+	assert.NilError(t, sqlitex.Execute(first, "INSERT INTO cats (name) VALUES ('daisy')", nil))
+
+	// Each instance database is a clone of the template.
+	secondPool := newPool(t, m)
+	second, err := secondPool.Take(ctx)
+	assert.NilError(t, err)
+	defer secondPool.Put(second)
+	assert.DeepEqual(t, catNames(t, second), []string(nil))
+}
+
+func TestZombiezenMigrator(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	pool := newPool(t, &zombiezenMigrator{script: `
+		CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT);
+		INSERT INTO cats (name) VALUES ('daisy'), ('sunny');
+	`})
+
+	conn, err := pool.Take(ctx)
+	assert.NilError(t, err)
+	defer pool.Put(conn)
+	assert.DeepEqual(t, catNames(t, conn), []string{"daisy", "sunny"})
+}
+
+func TestDetectDriver(t *testing.T) {
+	t.Parallel()
+
+	// modernc.org/sqlite is the only driver registered, so it is used when
+	// Config.Driver is empty.
+	config := sqlitestdb.Custom(t, sqlitestdb.Config{}, sqlitestdb.MigrateFunc("cats", func(ctx context.Context, db *sql.DB) error {
+		_, err := db.ExecContext(ctx, "CREATE TABLE cats (id INTEGER PRIMARY KEY, name TEXT); INSERT INTO cats (name) VALUES ('daisy');")
+		return err
+	}))
+	assert.Equal(t, "sqlite", config.Driver)
+
+	meta, err := sqlitestdb.ReadMeta(config.Database)
+	assert.NilError(t, err)
+	assert.Equal(t, "sqlite", meta.Driver)
+}
+
+// zombiezenMigrator is a [sqlitestdb.Migrator] that migrates the template with
+// zombiezen.com/go/sqlite, instead of database/sql.
+type zombiezenMigrator struct {
+	script string
+}
+
+func (m *zombiezenMigrator) Hash() (string, error) {
+	return m.script, nil
+}
+
+func (m *zombiezenMigrator) Migrate(_ context.Context, _ *sql.DB, config sqlitestdb.Config) error {
+	conn, err := sqlite.OpenConn(config.Database)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := sqlitex.ExecuteScript(conn, m.script, nil); err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func catNames(t *testing.T, conn *sqlite.Conn) []string {
+	t.Helper()
+	var names []string
+	err := sqlitex.Execute(conn, "SELECT name FROM cats ORDER BY name", &sqlitex.ExecOptions{
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			names = append(names, stmt.ColumnText(0))
+			return nil
+		},
+	})
+	assert.NilError(t, err)
+	return names
+}
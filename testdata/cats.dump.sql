@@ -0,0 +1,7 @@
+PRAGMA foreign_keys=OFF;
+BEGIN TRANSACTION;
+CREATE TABLE cats (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT);
+INSERT INTO cats VALUES(1,'daisy');
+INSERT INTO cats VALUES(3,'o''malley; the third');
+INSERT INTO sqlite_sequence VALUES('cats',3);
+COMMIT;
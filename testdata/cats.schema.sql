@@ -0,0 +1,3 @@
+PRAGMA application_id = 0;
+PRAGMA user_version = 0;
+CREATE TABLE cats ( id INTEGER PRIMARY KEY, name TEXT );
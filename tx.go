@@ -0,0 +1,128 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package sqlitestdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+
+	"braces.dev/errtrace"
+	"github.com/terinjokes/sqlitestdb/once"
+)
+
+// NewTx is like [New], but instead of cloning a database for every test, it
+// clones a single instance database per template and hands each test a
+// transaction on it. The transaction is rolled back as part of the test cleanup
+// process, leaving the shared instance in the template state for the next test.
+//
+// Tests that request a transaction for the same template are serialized: NewTx
+// blocks until the previous test's transaction has been rolled back. As a
+// consequence, a single test must not call NewTx twice for the same template.
+//
+// The transaction must not be committed or rolled back by the test. If it was,
+// the test will be failed with [testing.TB.Fatalf] and the shared instance is
+// discarded, so that the next test starts from a fresh clone.
+//
+// The shared instance is kept for the lifetime of the test binary, and is not
+// removed when it exits.
+func NewTx(t testing.TB, config Config, migrator Migrator, opts ...Option) *sql.Tx {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mhash, err := TemplateHash(migrator)
+	if err != nil {
+		t.Fatalf("could not hash migrator: %+v", err)
+	}
+
+	o := newOptions(opts)
+	shared, _ := txInstances.Set(templateKey(config.templateDriver(), mhash, o), func() (*txInstance, error) {
+		return &txInstance{}, nil
+	})
+
+	shared.mu.Lock()
+	if err := shared.ensure(ctx, config, migrator, o); err != nil {
+		shared.mu.Unlock()
+		t.Fatalf("%+v", err)
+	}
+
+	logInstance(t, o, "sqlitestdb: "+shared.config.URI()+" (shared transaction)")
+
+	// The transaction must not be bound to ctx, as database/sql rolls back
+	// transactions when their context is cancelled.
+	tx, err := shared.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		shared.mu.Unlock()
+		t.Fatalf("could not begin transaction: %+v", err)
+	}
+
+	t.Cleanup(func() {
+		defer shared.mu.Unlock()
+
+		if err := tx.Rollback(); err != nil {
+			shared.discard()
+			if errors.Is(err, sql.ErrTxDone) {
+				t.Fatalf("sqlitestdb: transaction was committed or rolled back by the test, discarding shared instance")
+			}
+			t.Fatalf("could not roll back transaction: %+v", err)
+		}
+	})
+
+	return tx
+}
+
+// txInstance is the instance database shared by all [NewTx] callers of a
+// single template. The mutex is held by a test from the call to [NewTx] until
+// its transaction has been rolled back.
+type txInstance struct {
+	mu     sync.Mutex
+	config *Config
+	db     *sql.DB
+}
+
+var txInstances = once.NewMap[string, txInstance]()
+
+// ensure clones the shared instance from the template if it doesn't exist yet,
+// or was previously discarded. It must be called with the mutex held.
+func (ti *txInstance) ensure(ctx context.Context, config Config, migrator Migrator, o *options) error {
+	if ti.db != nil {
+		return nil
+	}
+
+	instance, err := instantiate(ctx, "tx", config, migrator, o)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	db, err := instance.Connect()
+	if err != nil {
+		discardInstance(instance.Database)
+		return errtrace.Errorf("could not connect to instance database: %w", err)
+	}
+
+	// Only one transaction is ever active, so a single connection is enough,
+	// and guarantees the instance isn't locked by an idle connection.
+	db.SetMaxOpenConns(1)
+
+	instances.Store(instance.Database, instanceShared)
+	ti.config = instance
+	ti.db = db
+	return nil
+}
+
+// discard closes and removes the shared instance, as its contents can no longer
+// be trusted to match the template. It must be called with the mutex held.
+func (ti *txInstance) discard() {
+	if ti.db == nil {
+		return
+	}
+
+	ti.db.Close()
+	discardInstance(ti.config.Database)
+	ti.config = nil
+	ti.db = nil
+}
@@ -0,0 +1,65 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package sqlitestdb
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"braces.dev/errtrace"
+)
+
+// migrateInTx runs the migrator inside a single "BEGIN IMMEDIATE" transaction,
+// for [WithTxMigrations], rolling it back if the migration fails.
+//
+// Migrators receive a *sql.DB, not a *sql.Tx, so the pool of db is limited to
+// the one connection the transaction was started on, and every statement of the
+// migrator runs inside it. SQLite doesn't nest transactions: if the migrator
+// starts its own, the transaction is rolled back, and the migration is run
+// again without one. Should the migrator commit the transaction itself, its
+// statements are kept as committed.
+func migrateInTx(ctx context.Context, db *sql.DB, config Config, migrator Migrator, o *options) error {
+	db.SetMaxOpenConns(1)
+	defer db.SetMaxOpenConns(0)
+
+	if _, err := db.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	err := migrator.Migrate(ctx, db, config)
+	if err == nil {
+		_, err = db.ExecContext(ctx, "COMMIT")
+		if isNoTransaction(err) {
+			return nil
+		}
+		return errtrace.Wrap(err)
+	}
+
+	// The context may have expired, see [WithMigrateTimeout], but the
+	// transaction is still rolled back.
+	if _, rbErr := db.ExecContext(withoutInterrupt(ctx), "ROLLBACK"); rbErr != nil && !isNoTransaction(rbErr) {
+		return errtrace.Errorf("%w (rollback failed: %v)", err, rbErr)
+	}
+
+	if !isNestedTransaction(err) {
+		return errtrace.Wrap(err)
+	}
+
+	o.warnf("sqlitestdb: migrator %T starts its own transactions, migrating without WithTxMigrations", migrator)
+	db.SetMaxOpenConns(0)
+	return errtrace.Wrap(migrator.Migrate(ctx, db, config))
+}
+
+// isNestedTransaction reports whether SQLite refused to start a transaction
+// inside another.
+func isNestedTransaction(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "cannot start a transaction within a transaction")
+}
+
+// isNoTransaction reports whether SQLite refused to commit or roll back, as no
+// transaction was active.
+func isNoTransaction(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "no transaction is active")
+}
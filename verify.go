@@ -0,0 +1,155 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package sqlitestdb
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+
+	"braces.dev/errtrace"
+)
+
+// instanceState is the state of an instance database tracked for
+// [VerifyShutdown].
+type instanceState int
+
+const (
+	// instanceLive is an instance that is in use by a test, or should have
+	// been removed when it passed.
+	instanceLive instanceState = iota
+	// instanceKept is an instance kept for inspection, as its test failed.
+	instanceKept
+	// instanceShared is an instance shared by the tests using [NewTx] or
+	// [NewShared].
+	instanceShared
+)
+
+// instances tracks the instance databases created by this program, until they
+// are removed.
+var instances sync.Map // map[string]instanceState
+
+// openDBs tracks the connections to instance databases handed out to tests,
+// until they are closed by [closeDB].
+var openDBs sync.Map // map[*sql.DB]*Config
+
+// trackDB records that db, a connection to the instance database, was handed
+// out to a test.
+func trackDB(db *sql.DB, instance *Config) {
+	openDBs.Store(db, instance)
+}
+
+// ConfigFor returns the configuration details of the instance database that db
+// is connected to, such as its path, or nil if db wasn't returned by [New] or
+// another constructor of this package. It remains available until the test
+// cleanup process, even if the test closes db itself.
+func ConfigFor(db *sql.DB) *Config {
+	instance, ok := openDBs.Load(db)
+	if !ok {
+		return nil
+	}
+
+	config := *instance.(*Config)
+	return &config
+}
+
+// removeInstance removes the instance database at path as part of the cleanup
+// of a test, unless the test failed, in which case it is kept for inspection.
+func removeInstance(t testing.TB, path string) {
+	if t.Failed() {
+		instances.Store(path, instanceKept)
+		return
+	}
+
+	if err := discardInstance(path); err != nil {
+		t.Logf("sqlitestdb: could not remove instance database %q: %v", path, err)
+	}
+}
+
+// discardInstance removes the instance database at path, along with its sidecar
+// files, whatever the state of the test using it. If it can't be removed, it
+// remains tracked, and is reported by [VerifyShutdown].
+func discardInstance(path string) error {
+	if err := removeDatabase(path); err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	instances.Delete(path)
+	return nil
+}
+
+// VerifyShutdown checks that sqlitestdb cleaned up after itself, intended to be
+// called from TestMain after all tests have run. It returns an error describing
+// each of these problems:
+//
+//   - an instance database of a passed test was not removed,
+//   - a connection to an instance database was not closed,
+//   - a template could not be created, even if it was created when retried,
+//   - a template that was created could not be rebuilt, such as after it was
+//     removed by a temporary directory reaper, even if it was when retried,
+//   - a template failed validation, or couldn't be cloned, and was rebuilt.
+//
+// Instance databases of failed tests, and those shared by [NewTx], are expected
+// to remain.
+//
+//	func TestMain(m *testing.M) {
+//		code := m.Run()
+//		if err := sqlitestdb.VerifyShutdown(); err != nil {
+//			fmt.Fprintln(os.Stderr, err)
+//			code = max(code, 1)
+//		}
+//		os.Exit(code)
+//	}
+func VerifyShutdown() error {
+	var errs []error
+	instances.Range(func(key, value any) bool {
+		path := key.(string)
+		if value.(instanceState) != instanceLive {
+			return true
+		}
+
+		if _, err := os.Stat(path); err == nil {
+			errs = append(errs, errtrace.Errorf("instance database %q of a passed test was not removed", path))
+		}
+		return true
+	})
+
+	openDBs.Range(func(_, value any) bool {
+		errs = append(errs, errtrace.Errorf("connection to instance database %q was not closed", value.(*Config).Database))
+		return true
+	})
+
+	templateCounters.Range(func(_, value any) bool {
+		counter := value.(*templateCounter)
+		err, created, lateErr, recovered := counter.failures()
+		switch {
+		case err != nil && created:
+			// The failure was cached, and returned to the callers until
+			// the template was retried, so that only some of them failed.
+			errs = append(errs, errtrace.Errorf("template %s could not be created, but was created when retried: %w", counter.hash, err))
+		case err != nil:
+			errs = append(errs, errtrace.Errorf("template %s could not be created: %w", counter.hash, err))
+		}
+		switch {
+		case lateErr != nil && recovered:
+			errs = append(errs, errtrace.Errorf("template %s failed after being created, but was rebuilt when retried: %w", counter.hash, lateErr))
+		case lateErr != nil:
+			errs = append(errs, errtrace.Errorf("template %s failed after being created: %w", counter.hash, lateErr))
+		}
+		if err := counter.rebuildReason(); err != nil {
+			errs = append(errs, errtrace.Errorf("template %s was rebuilt: %w", counter.hash, err))
+		}
+		return true
+	})
+
+	// The maps are iterated in an unspecified order.
+	sort.Slice(errs, func(i, j int) bool {
+		return errs[i].Error() < errs[j].Error()
+	})
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,69 @@
+// Copyright 2024 Terin Stock.
+// SPDX-License-Identifier: MIT
+
+package sqlitestdb
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"testing"
+
+	"braces.dev/errtrace"
+)
+
+// VersionedMigrator is a [Migrator] of a migration framework that can stop at
+// any version of its migrations, so that templates can be created at an older
+// version of the schema, such as to check that the data of that version
+// survives the later migrations. See [NewAtVersion].
+type VersionedMigrator interface {
+	Migrator
+
+	// MigrateTo applies the migrations up to, and including, version.
+	MigrateTo(ctx context.Context, db *sql.DB, config Config, version int64) error
+
+	// HashAt returns a hash of the migrations up to, and including, version,
+	// so that adding later migrations doesn't create a new template for it.
+	HashAt(version int64) (string, error)
+}
+
+// AtVersion returns a [Migrator] that creates templates at the version of the
+// migrations of vm, with its MigrateTo method. Its templates are kept apart from
+// those of vm itself, and from those at other versions, as the version is part
+// of their identity, see [TemplateHash].
+func AtVersion(vm VersionedMigrator, version int64) Migrator {
+	return &versionMigrator{vm: vm, version: version}
+}
+
+// NewAtVersion is like [New], but the instance database is cloned from a
+// template at the version of the migrations of vm, see [AtVersion].
+//
+// To test the upgrade path from that version, insert data shaped like that
+// version into the instance database, then apply the later migrations with the
+// Migrate method of vm, using the configuration returned by [ConfigFor].
+func NewAtVersion(t testing.TB, config Config, vm VersionedMigrator, version int64, opts ...Option) *sql.DB {
+	t.Helper()
+	return New(t, config, AtVersion(vm, version), opts...)
+}
+
+type versionMigrator struct {
+	vm      VersionedMigrator
+	version int64
+}
+
+func (m *versionMigrator) Hash() (string, error) {
+	return errtrace.Wrap2(m.vm.HashAt(m.version))
+}
+
+func (m *versionMigrator) Migrate(ctx context.Context, db *sql.DB, config Config) error {
+	if err := m.vm.MigrateTo(ctx, db, config, m.version); err != nil {
+		return errtrace.Errorf("migrating to version %d: %w", m.version, err)
+	}
+
+	return nil
+}
+
+// Name returns the name of the migrator at the version.
+func (m *versionMigrator) Name() string {
+	return migratorName(m.vm) + "@" + strconv.FormatInt(m.version, 10)
+}